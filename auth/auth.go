@@ -18,9 +18,13 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"firebase.google.com/go/internal"
 	"google.golang.org/api/transport"
@@ -40,12 +44,18 @@ var reservedClaims = []string{
 //
 // Client facilitates generating custom JWT tokens for Firebase clients, and verifying ID tokens issued
 // by Firebase backend services.
+//
+// A Client is safe for concurrent use by multiple goroutines, and should be reused rather than created
+// for each operation, so that the cached public keys used for verifying tokens are shared. The iterators
+// returned by a Client (e.g. UserIterator) are not safe for concurrent use.
 type Client struct {
 	*userManagementClient
 	*providerConfigClient
+	*projectConfigClient
 	idTokenVerifier *tokenVerifier
 	cookieVerifier  *tokenVerifier
 	signer          cryptoSigner
+	signerMutex     sync.RWMutex
 	clock           internal.Clock
 }
 
@@ -84,15 +94,53 @@ func NewClient(ctx context.Context, conf *internal.AuthConfig) (*Client, error)
 		}
 	}
 
+	if conf.RequireSigningAccount {
+		if _, err := signer.Email(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	logger := internal.LoggerOrNop(conf.Logger)
+	if isEmulated() {
+		logger.Warnf("%s is set; ID tokens and session cookies are accepted without signature verification",
+			emulatorHostEnvVar)
+	}
+
 	idTokenVerifier, err := newIDTokenVerifier(ctx, conf.ProjectID)
 	if err != nil {
 		return nil, err
 	}
+	if conf.IDTokenCertURL != "" && conf.IDTokenKeySource != nil {
+		return nil, errors.New("IDTokenCertURL and IDTokenKeySource must not both be set")
+	}
+	if conf.IDTokenCertURL != "" {
+		if err := idTokenVerifier.setCertURL(conf.IDTokenCertURL); err != nil {
+			return nil, err
+		}
+	}
+	if conf.IDTokenKeySource != nil {
+		idTokenVerifier.setKeySource(conf.IDTokenKeySource)
+	}
+
+	idTokenVerifier.setLogger(logger)
 
 	cookieVerifier, err := newSessionCookieVerifier(ctx, conf.ProjectID)
 	if err != nil {
 		return nil, err
 	}
+	if conf.SessionCookieCertURL != "" && conf.SessionCookieKeySource != nil {
+		return nil, errors.New("SessionCookieCertURL and SessionCookieKeySource must not both be set")
+	}
+	if conf.SessionCookieCertURL != "" {
+		if err := cookieVerifier.setCertURL(conf.SessionCookieCertURL); err != nil {
+			return nil, err
+		}
+	}
+	if conf.SessionCookieKeySource != nil {
+		cookieVerifier.setKeySource(conf.SessionCookieKeySource)
+	}
+
+	cookieVerifier.setLogger(logger)
 
 	hc, _, err := transport.NewHTTPClient(ctx, conf.Opts...)
 	if err != nil {
@@ -102,6 +150,7 @@ func NewClient(ctx context.Context, conf *internal.AuthConfig) (*Client, error)
 	return &Client{
 		userManagementClient: newUserManagementClient(hc, conf),
 		providerConfigClient: newProviderConfigClient(hc, conf),
+		projectConfigClient:  newProjectConfigClient(hc, conf),
 		idTokenVerifier:      idTokenVerifier,
 		cookieVerifier:       cookieVerifier,
 		signer:               signer,
@@ -109,6 +158,39 @@ func NewClient(ctx context.Context, conf *internal.AuthConfig) (*Client, error)
 	}, nil
 }
 
+// Close releases the idle HTTP connections held by the Client, including the connections used to
+// fetch the public keys for verifying ID tokens and session cookies.
+//
+// The Client does not run any background goroutines: public keys are refreshed on demand when a
+// token is verified, so there is no refresh to stop. Idle connections are only closed when the
+// underlying transport supports it, as *http.Transport does, including transports supplied via
+// option.WithHTTPClient. Transports created by the SDK itself close idle connections on their own
+// after a timeout. The Client remains usable after Close, and opens new connections as needed.
+// Close always returns nil.
+func (c *Client) Close() error {
+	if c.userManagementClient != nil {
+		c.userManagementClient.httpClient.CloseIdleConnections()
+	}
+	if c.providerConfigClient != nil {
+		c.providerConfigClient.httpClient.CloseIdleConnections()
+	}
+	if c.projectConfigClient != nil {
+		c.projectConfigClient.httpClient.CloseIdleConnections()
+	}
+	for _, tv := range []*tokenVerifier{c.idTokenVerifier, c.cookieVerifier} {
+		if tv == nil {
+			continue
+		}
+		if ks, ok := tv.keySource.(*httpKeySource); ok && ks.HTTPClient != nil {
+			internal.CloseIdleConnections(ks.HTTPClient)
+		}
+	}
+	if s, ok := c.currentSigner().(*iamSigner); ok {
+		s.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
 // CustomToken creates a signed custom authentication token with the specified user ID.
 //
 // The resulting JWT can be used in a Firebase client SDK to trigger an authentication flow. See
@@ -119,13 +201,16 @@ func NewClient(ctx context.Context, conf *internal.AuthConfig) (*Client, error)
 //   - If the SDK was initialized with service account credentials, uses the private key present in
 //     the credentials to sign tokens locally.
 //   - If a service account email was specified during initialization (via firebase.Config struct),
-//     calls the IAM service with that email to sign tokens remotely. See
-//     https://cloud.google.com/iam/reference/rest/v1/projects.serviceAccounts/signBlob.
+//     calls the IAM Credentials service with that email to sign tokens remotely. See
+//     https://cloud.google.com/iam/docs/reference/credentials/rest/v1/projects.serviceAccounts/signBlob.
 //   - If the code is deployed in the Google App Engine standard environment, uses the App Identity
 //     service to sign tokens. See https://cloud.google.com/appengine/docs/standard/go/reference#SignBytes.
 //   - If the code is deployed in a different GCP-managed environment (e.g. Google Compute Engine),
 //     uses the local Metadata server to auto discover a service account email. This is used in
-//     conjunction with the IAM service to sign tokens remotely.
+//     conjunction with the IAM Credentials service to sign tokens remotely.
+//
+// When signing tokens remotely, the credentials used to initialize the SDK must have the Service
+// Account Token Creator role (roles/iam.serviceAccountTokenCreator) on the signing service account.
 //
 // CustomToken returns an error the SDK fails to discover a viable mechanism for signing tokens.
 func (c *Client) CustomToken(ctx context.Context, uid string) (string, error) {
@@ -135,7 +220,33 @@ func (c *Client) CustomToken(ctx context.Context, uid string) (string, error) {
 // CustomTokenWithClaims is similar to CustomToken, but in addition to the user ID, it also encodes
 // all the key-value pairs in the provided map as claims in the resulting JWT.
 func (c *Client) CustomTokenWithClaims(ctx context.Context, uid string, devClaims map[string]interface{}) (string, error) {
-	iss, err := c.signer.Email(ctx)
+	return c.CustomTokenWithOptions(ctx, uid, &CustomTokenOptions{Claims: devClaims})
+}
+
+// CustomTokenOptions specifies additional options for the CustomTokenWithOptions function.
+type CustomTokenOptions struct {
+	// Claims are encoded as developer claims in the resulting JWT, as in CustomTokenWithClaims.
+	Claims map[string]interface{}
+
+	// ExpiresIn is the lifetime of the custom token, which is truncated to whole seconds. It must be
+	// between 1 second and 1 hour, the maximum lifetime accepted by Firebase Auth. Defaults to 1 hour
+	// when zero.
+	//
+	// This only bounds the time within which the custom token can be exchanged for an ID token by a
+	// client SDK. It does not affect the lifetime of the resulting ID token or the user's session.
+	ExpiresIn time.Duration
+}
+
+// CustomTokenWithOptions is similar to CustomToken, but additionally accepts the developer claims
+// and the lifetime of the resulting JWT.
+//
+// With nil or empty options, CustomTokenWithOptions behaves exactly like CustomToken.
+func (c *Client) CustomTokenWithOptions(ctx context.Context, uid string, opts *CustomTokenOptions) (string, error) {
+	if opts == nil {
+		opts = &CustomTokenOptions{}
+	}
+	signer := c.currentSigner()
+	iss, err := signer.Email(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -144,6 +255,15 @@ func (c *Client) CustomTokenWithClaims(ctx context.Context, uid string, devClaim
 		return "", errors.New("uid must be non-empty, and not longer than 128 characters")
 	}
 
+	expiresIn := int64(opts.ExpiresIn / time.Second)
+	if opts.ExpiresIn == 0 {
+		expiresIn = oneHourInSeconds
+	} else if expiresIn < 1 || expiresIn > oneHourInSeconds {
+		return "", fmt.Errorf("custom token expiry must be between 1 second and 1 hour; got %v", opts.ExpiresIn)
+	}
+
+	devClaims := opts.Claims
+
 	var disallowed []string
 	for _, k := range reservedClaims {
 		if _, contains := devClaims[k]; contains {
@@ -165,11 +285,55 @@ func (c *Client) CustomTokenWithClaims(ctx context.Context, uid string, devClaim
 			Aud:    firebaseAudience,
 			UID:    uid,
 			Iat:    now,
-			Exp:    now + oneHourInSeconds,
+			Exp:    now + expiresIn,
 			Claims: devClaims,
 		},
 	}
-	return info.Token(ctx, c.signer)
+	return info.Token(ctx, signer)
+}
+
+// SigningServiceAccount returns the email address of the service account used to sign custom
+// tokens.
+//
+// Depending on how the SDK was initialized, SigningServiceAccount may have to call the local
+// Metadata service to discover the service account email (see CustomToken for the full list of
+// signing mechanisms). Call this function immediately after creating the Client to confirm the
+// signing identity, and to detect environments that do not support signing custom tokens at
+// startup, rather than when the first custom token is minted. The returned error explains how
+// to configure a viable signing mechanism. Alternatively, set RequireSigningAccount in the
+// firebase.Config to make the creation of the Client fail in such environments.
+func (c *Client) SigningServiceAccount(ctx context.Context) (string, error) {
+	return c.currentSigner().Email(ctx)
+}
+
+// SetSigningCredentials replaces the credentials used to sign custom tokens with the given
+// service account JSON, as downloaded from the Google Cloud console.
+//
+// It is meant for rotating service account keys without recreating the App or the Client. Custom
+// tokens minted after SetSigningCredentials returns are signed with the new key, while tokens
+// minted earlier remain valid until they expire. Everything else about the Client, including the
+// cached public keys used to verify ID tokens and session cookies, is left unchanged. It is safe
+// to call SetSigningCredentials while other goroutines are using the Client. If the given JSON
+// is not a valid service account, an error is returned and the current credentials are kept.
+func (c *Client) SetSigningCredentials(creds []byte) error {
+	signer, err := signerFromCreds(creds)
+	if err == errNotAServiceAcct {
+		return errors.New("signing credentials must be a service account with a private key and client email")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse signing credentials: %v", err)
+	}
+
+	c.signerMutex.Lock()
+	defer c.signerMutex.Unlock()
+	c.signer = signer
+	return nil
+}
+
+func (c *Client) currentSigner() cryptoSigner {
+	c.signerMutex.RLock()
+	defer c.signerMutex.RUnlock()
+	return c.signer
 }
 
 // Token represents a decoded Firebase ID token.
@@ -187,6 +351,33 @@ type Token struct {
 	Claims   map[string]interface{} `json:"-"`
 }
 
+// DecodeTokenUnverified decodes the claims of the given JWT without verifying it.
+//
+// The signature, expiry, issuer and audience of the token are not checked. This is intended for
+// inspecting a token before it is verified elsewhere, for example to decide which project or
+// backend a request should be routed to. The result must never be used to make authentication or
+// authorization decisions, since anyone can create a token with arbitrary claims. Use VerifyIDToken
+// or VerifySessionCookie for that.
+func DecodeTokenUnverified(token string) (*Token, error) {
+	if token == "" {
+		return nil, errors.New("token must be a non-empty string")
+	}
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return nil, errors.New("token has an incorrect number of segments")
+	}
+
+	var header jwtHeader
+	if err := decode(segments[0], &header); err != nil {
+		return nil, fmt.Errorf("failed to decode token header: %v", err)
+	}
+	payload, err := decodePayload(segments[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token payload: %v", err)
+	}
+	return payload, nil
+}
+
 // VerifyIDToken verifies the signature	and payload of the provided ID token.
 //
 // VerifyIDToken accepts a signed JWT token string, and verifies that it is current, issued for the
@@ -201,10 +392,45 @@ type Token struct {
 //
 // This does not check whether or not the token has been revoked. Use `VerifyIDTokenAndCheckRevoked()`
 // when a revocation check is needed.
+//
+// If the FIREBASE_AUTH_EMULATOR_HOST environment variable is set when the Client is created, the unsigned
+// ID tokens issued by the Firebase Auth emulator are accepted. Their signatures are not verified, but all
+// the other checks still apply. This environment variable must never be set in production.
 func (c *Client) VerifyIDToken(ctx context.Context, idToken string) (*Token, error) {
 	return c.idTokenVerifier.VerifyToken(ctx, idToken)
 }
 
+// TokenVerifyOptions specifies additional options for the VerifyIDTokenWithOptions and
+// VerifySessionCookieWithOptions functions.
+type TokenVerifyOptions struct {
+	// IgnoreExpiration disables the checks on the exp (expiry) and iat (issued at) claims of the token. The
+	// signature, issuer, audience and subject of the token are still verified.
+	//
+	// This is intended for offline analysis and debugging of historical tokens only. It is unsafe to use the
+	// result of such a verification to make authentication or authorization decisions, since an expired token
+	// no longer proves that its holder is currently signed in.
+	IgnoreExpiration bool
+
+	// AllowedAudiences specifies the set of Firebase project IDs that are accepted in the aud (audience) claim
+	// of the token. The iss (issuer) claim must refer to the same project as the audience. When empty, only
+	// tokens issued for the project of the Client are accepted. The project of the Client is not implicitly
+	// included in a non-empty set.
+	//
+	// This is useful when migrating users between projects, where tokens issued for both the old and the new
+	// project must be accepted for a while. Tokens of every allowed project are still required to carry a
+	// valid signature, since all Firebase projects share the same set of public signing keys. The same holds
+	// for session cookies, which are all signed with the shared session cookie keys.
+	AllowedAudiences []string
+}
+
+// VerifyIDTokenWithOptions verifies the signature and payload of the provided ID token, using the given options.
+//
+// With nil or empty options, VerifyIDTokenWithOptions behaves exactly like VerifyIDToken.
+func (c *Client) VerifyIDTokenWithOptions(
+	ctx context.Context, idToken string, opts *TokenVerifyOptions) (*Token, error) {
+	return c.idTokenVerifier.VerifyTokenWithOptions(ctx, idToken, opts)
+}
+
 // VerifyIDTokenAndCheckRevoked verifies the provided ID token, and additionally checks that the
 // token has not been revoked.
 //
@@ -228,6 +454,55 @@ func (c *Client) VerifyIDTokenAndCheckRevoked(ctx context.Context, idToken strin
 	return p, nil
 }
 
+// VerifyIDTokenAndGetUser verifies the provided ID token, and returns it along with the current
+// UserRecord of the user it was issued to.
+//
+// The claims in an ID token reflect the user account at the time the token was minted, and may be
+// stale by up to an hour. The returned UserRecord reflects the current state of the account,
+// including any custom claims set since. Since the user account has to be fetched with an RPC call
+// anyway, VerifyIDTokenAndGetUser also checks that the token has not been revoked, at no extra cost,
+// and returns an error for which IsIDTokenRevoked is true if it has.
+func (c *Client) VerifyIDTokenAndGetUser(ctx context.Context, idToken string) (*Token, *UserRecord, error) {
+	p, err := c.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := c.GetUser(ctx, p.UID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isRevoked(p, user) {
+		return nil, nil, internal.Error(idTokenRevoked, "ID token has been revoked")
+	}
+	return p, user, nil
+}
+
+// VerifyIDTokenWithClaims verifies the provided ID token, and additionally checks that it was issued by
+// the given tenant, and that it carries all the required custom claims.
+//
+// When tenantID is not empty, the tenant of the ID token (the firebase.tenant claim) must be equal to it.
+// Tokens issued outside of a tenant carry no tenant, and are rejected. When tenantID is empty, the tenant
+// of the ID token is not checked.
+//
+// The value of each claim in the ID token must be equal to the value specified in the required map,
+// after both are converted to their JSON representation (so that, for example, an int matches the
+// float64 decoded from the token). An error naming the mismatched tenant, or a missing or mismatched
+// claim, is returned if any of the checks fail. Only the custom claims of the token (see Token.Claims)
+// are compared.
+func (c *Client) VerifyIDTokenWithClaims(
+	ctx context.Context, idToken, tenantID string, required map[string]interface{}) (*Token, error) {
+	p, err := c.VerifyIDToken(ctx, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkTokenClaims(p, tenantID, required); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
 // VerifySessionCookie verifies the signature and payload of the provided Firebase session cookie.
 //
 // VerifySessionCookie accepts a signed JWT token string, and verifies that it is current, issued for the
@@ -245,6 +520,17 @@ func (c *Client) VerifySessionCookie(ctx context.Context, sessionCookie string)
 	return c.cookieVerifier.VerifyToken(ctx, sessionCookie)
 }
 
+// VerifySessionCookieWithOptions verifies the signature and payload of the provided Firebase session cookie,
+// using the given options.
+//
+// Set AllowedAudiences in the options to accept session cookies issued for any of a set of projects, for
+// example while migrating users from one project to another. With nil or empty options,
+// VerifySessionCookieWithOptions behaves exactly like VerifySessionCookie.
+func (c *Client) VerifySessionCookieWithOptions(
+	ctx context.Context, sessionCookie string, opts *TokenVerifyOptions) (*Token, error) {
+	return c.cookieVerifier.VerifyTokenWithOptions(ctx, sessionCookie, opts)
+}
+
 // VerifySessionCookieAndCheckRevoked verifies the provided session cookie, and additionally checks that the
 // cookie has not been revoked.
 //
@@ -268,11 +554,125 @@ func (c *Client) VerifySessionCookieAndCheckRevoked(ctx context.Context, session
 	return p, nil
 }
 
+// IDTokenPublicKeys returns the public keys that are currently used to verify the signatures of ID tokens.
+//
+// The keys are shared with VerifyIDToken: they are fetched only if they are not already cached, and the
+// result reflects the cache of the Client. This lets other services verify ID tokens without fetching the
+// keys themselves. They should stop using the keys after the Expiry of the result, and call
+// IDTokenPublicKeys again. The returned set is a copy, so modifying it does not affect the Client.
+func (c *Client) IDTokenPublicKeys(ctx context.Context) (*PublicKeySet, error) {
+	return c.idTokenVerifier.publicKeySet(ctx)
+}
+
+// SessionCookiePublicKeys returns the public keys that are currently used to verify the signatures of
+// session cookies. It behaves like IDTokenPublicKeys, but returns the keys used by VerifySessionCookie.
+func (c *Client) SessionCookiePublicKeys(ctx context.Context) (*PublicKeySet, error) {
+	return c.cookieVerifier.publicKeySet(ctx)
+}
+
+// SessionCookieOptions specifies the preconditions that an ID token must satisfy before it can be
+// exchanged for a session cookie via SessionCookieWithOptions.
+type SessionCookieOptions struct {
+	// CheckRevoked additionally verifies that the ID token has not been revoked. This requires an
+	// extra RPC call.
+	CheckRevoked bool
+
+	// RequiredClaims contains the claims that must be present in the ID token. The value of each
+	// claim in the ID token must be equal to the value specified in this map.
+	RequiredClaims map[string]interface{}
+
+	// TenantID is the ID of the tenant that must have issued the ID token.
+	TenantID string
+}
+
+// SessionCookieWithOptions creates a new Firebase session cookie from the given ID token and
+// expiry duration, after verifying that the ID token satisfies the given preconditions.
+//
+// Unlike SessionCookie, which lets the Firebase Auth backend service validate the ID token,
+// SessionCookieWithOptions first verifies the ID token locally using `VerifyIDToken()`, and then
+// checks that it carries all the claims specified in the options. An error is returned without
+// minting a session cookie if any of these checks fail. This is useful as a defense-in-depth
+// measure for applications that only issue session cookies to a subset of their users.
+func (c *Client) SessionCookieWithOptions(
+	ctx context.Context,
+	idToken string,
+	expiresIn time.Duration,
+	opts *SessionCookieOptions,
+) (string, error) {
+	if opts != nil {
+		if err := c.checkSessionCookiePreconditions(ctx, idToken, opts); err != nil {
+			return "", err
+		}
+	}
+	return c.SessionCookie(ctx, idToken, expiresIn)
+}
+
+func (c *Client) checkSessionCookiePreconditions(
+	ctx context.Context, idToken string, opts *SessionCookieOptions) error {
+	var (
+		token *Token
+		err   error
+	)
+	if opts.CheckRevoked {
+		token, err = c.VerifyIDTokenAndCheckRevoked(ctx, idToken)
+	} else {
+		token, err = c.VerifyIDToken(ctx, idToken)
+	}
+	if err != nil {
+		return err
+	}
+
+	return checkTokenClaims(token, opts.TenantID, opts.RequiredClaims)
+}
+
+// checkTokenClaims checks that the given token was issued by the given tenant (if not empty), and
+// that it carries all the required claims.
+func checkTokenClaims(token *Token, tenantID string, requiredClaims map[string]interface{}) error {
+	if tenantID != "" {
+		var got string
+		if firebase, ok := token.Claims["firebase"].(map[string]interface{}); ok {
+			got, _ = firebase["tenant"].(string)
+		}
+		if got != tenantID {
+			return fmt.Errorf("ID token has invalid tenant; expected %q but got %q", tenantID, got)
+		}
+	}
+
+	if len(requiredClaims) == 0 {
+		return nil
+	}
+
+	// Round trip the required claims through JSON so they can be compared against the claims
+	// decoded from the ID token (e.g. integer values become float64).
+	b, err := json.Marshal(requiredClaims)
+	if err != nil {
+		return fmt.Errorf("required claims marshaling error: %v", err)
+	}
+	var required map[string]interface{}
+	if err := json.Unmarshal(b, &required); err != nil {
+		return err
+	}
+	for k, want := range required {
+		got, ok := token.Claims[k]
+		if !ok {
+			return fmt.Errorf("ID token is missing required claim %q", k)
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("ID token has invalid %q claim; expected %v but got %v", k, want, got)
+		}
+	}
+	return nil
+}
+
 func (c *Client) checkRevoked(ctx context.Context, token *Token) (bool, error) {
 	user, err := c.GetUser(ctx, token.UID)
 	if err != nil {
 		return false, err
 	}
 
-	return token.IssuedAt*1000 < user.TokensValidAfterMillis, nil
+	return isRevoked(token, user), nil
+}
+
+func isRevoked(token *Token, user *UserRecord) bool {
+	return token.IssuedAt*1000 < user.TokensValidAfterMillis
 }
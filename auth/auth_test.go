@@ -16,14 +16,17 @@ package auth
 
 import (
 	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -202,6 +205,160 @@ func TestNewClientWithUserCredentials(t *testing.T) {
 	}
 }
 
+func TestNewClientWithCertURLs(t *testing.T) {
+	conf := &internal.AuthConfig{
+		Opts:                 optsWithTokenSource,
+		ServiceAccountID:     "explicit-service-account",
+		IDTokenCertURL:       "https://mirror.example.com/id-token-certs",
+		SessionCookieCertURL: "http://mirror.example.com/session-cookie-certs",
+		Version:              testVersion,
+	}
+	client, err := NewClient(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := client.idTokenVerifier.keySource.(*httpKeySource).KeyURI; got != conf.IDTokenCertURL {
+		t.Errorf("NewClient().idTokenVerifier.KeyURI = %q; want = %q", got, conf.IDTokenCertURL)
+	}
+	if got := client.cookieVerifier.keySource.(*httpKeySource).KeyURI; got != conf.SessionCookieCertURL {
+		t.Errorf("NewClient().cookieVerifier.KeyURI = %q; want = %q", got, conf.SessionCookieCertURL)
+	}
+}
+
+func TestNewClientWithInvalidCertURLs(t *testing.T) {
+	cases := []*internal.AuthConfig{
+		{IDTokenCertURL: "not a url"},
+		{IDTokenCertURL: "ftp://mirror.example.com/certs"},
+		{SessionCookieCertURL: "/relative/certs"},
+	}
+	for idx, conf := range cases {
+		conf.Opts = optsWithTokenSource
+		conf.ServiceAccountID = "explicit-service-account"
+		if c, err := NewClient(context.Background(), conf); c != nil || err == nil {
+			t.Errorf("[%d] NewClient() = (%v,%v); want = (nil, error)", idx, c, err)
+		}
+	}
+}
+
+type staticKeySource struct {
+	keys map[string]*rsa.PublicKey
+	err  error
+}
+
+func (s *staticKeySource) PublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	return s.keys, s.err
+}
+
+func newStaticKeySource(t *testing.T) *staticKeySource {
+	b, err := ioutil.ReadFile("../testdata/public_certs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parsePublicKeys(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]*rsa.PublicKey{"nil-key": nil}
+	for _, k := range parsed {
+		keys[k.Kid] = k.Key
+	}
+	return &staticKeySource{keys: keys}
+}
+
+func TestNewClientWithKeySources(t *testing.T) {
+	src := newStaticKeySource(t)
+	conf := &internal.AuthConfig{
+		Opts:                   optsWithTokenSource,
+		ProjectID:              testProjectID,
+		ServiceAccountID:       "explicit-service-account",
+		IDTokenKeySource:       src,
+		SessionCookieKeySource: src,
+		Version:                testVersion,
+	}
+	client, err := NewClient(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tv := range []*tokenVerifier{client.idTokenVerifier, client.cookieVerifier} {
+		ks, ok := tv.keySource.(*customKeySource)
+		if !ok || ks.src != src {
+			t.Errorf("NewClient().%s.keySource = %#v; want = customKeySource", tv.shortName, tv.keySource)
+		}
+	}
+
+	keys, err := client.idTokenVerifier.keySource.Keys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("Keys() = %d keys; want = 3", len(keys))
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1].Kid >= keys[i].Kid {
+			t.Errorf("Keys() not ordered by kid: %q >= %q", keys[i-1].Kid, keys[i].Kid)
+		}
+	}
+
+	if ft, err := client.VerifyIDToken(context.Background(), testIDToken); err != nil || ft.UID != "1234567890" {
+		t.Errorf("VerifyIDToken() = (%v, %v); want = (token, nil)", ft, err)
+	}
+	if ft, err := client.VerifySessionCookie(context.Background(), testSessionCookie); err != nil || ft.UID != "1234567890" {
+		t.Errorf("VerifySessionCookie() = (%v, %v); want = (token, nil)", ft, err)
+	}
+
+	unknown := getIDTokenWithKid("unknown-key-id", nil)
+	if ft, err := client.VerifyIDToken(context.Background(), unknown); ft != nil || err == nil {
+		t.Errorf("VerifyIDToken(unknown kid) = (%v, %v); want = (nil, error)", ft, err)
+	}
+}
+
+func TestNewClientWithKeySourceError(t *testing.T) {
+	src := &staticKeySource{err: errors.New("key source error")}
+	conf := &internal.AuthConfig{
+		Opts:             optsWithTokenSource,
+		ProjectID:        testProjectID,
+		ServiceAccountID: "explicit-service-account",
+		IDTokenKeySource: src,
+		Version:          testVersion,
+	}
+	client, err := NewClient(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ft, err := client.VerifyIDToken(context.Background(), testIDToken)
+	if ft != nil || err == nil || !strings.Contains(err.Error(), "key source error") {
+		t.Errorf("VerifyIDToken() = (%v, %v); want = (nil, %q)", ft, err, "key source error")
+	}
+}
+
+func TestNewClientWithCertURLAndKeySource(t *testing.T) {
+	src := &staticKeySource{}
+	cases := []struct {
+		conf *internal.AuthConfig
+		want string
+	}{
+		{
+			&internal.AuthConfig{IDTokenCertURL: "https://mirror.example.com/certs", IDTokenKeySource: src},
+			"IDTokenCertURL and IDTokenKeySource must not both be set",
+		},
+		{
+			&internal.AuthConfig{SessionCookieCertURL: "https://mirror.example.com/certs", SessionCookieKeySource: src},
+			"SessionCookieCertURL and SessionCookieKeySource must not both be set",
+		},
+	}
+	for idx, tc := range cases {
+		tc.conf.Opts = optsWithTokenSource
+		tc.conf.ServiceAccountID = "explicit-service-account"
+		c, err := NewClient(context.Background(), tc.conf)
+		if c != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("[%d] NewClient() = (%v, %v); want = (nil, %q)", idx, c, err, tc.want)
+		}
+	}
+}
+
 func TestNewClientWithMalformedCredentials(t *testing.T) {
 	creds := &google.DefaultCredentials{
 		JSON: []byte("not json"),
@@ -240,6 +397,72 @@ func TestCustomToken(t *testing.T) {
 	verifyCustomToken(context.Background(), token, nil, t)
 }
 
+func TestClientClose(t *testing.T) {
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Opts:      optsWithTokenSource,
+		ProjectID: testProjectID,
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() = %v; want = nil", err)
+	}
+	if err := (&Client{}).Close(); err != nil {
+		t.Errorf("Close() = %v; want = nil", err)
+	}
+}
+
+func TestSetSigningCredentials(t *testing.T) {
+	client := &Client{
+		signer: &mockSigner{},
+		clock:  testClock,
+	}
+	creds, err := ioutil.ReadFile("../testdata/service_account.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetSigningCredentials(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := client.CustomToken(context.Background(), "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyCustomToken(context.Background(), token, nil, t)
+}
+
+func TestSetSigningCredentialsError(t *testing.T) {
+	signer := &mockSigner{}
+	client := &Client{
+		signer: signer,
+	}
+	cases := []struct {
+		creds string
+		want  string
+	}{
+		{
+			creds: "not json",
+			want:  "failed to parse signing credentials: invalid character 'o' in literal null (expecting 'u')",
+		},
+		{
+			creds: `{"type": "authorized_user", "client_id": "id", "refresh_token": "token"}`,
+			want:  "signing credentials must be a service account with a private key and client email",
+		},
+	}
+	for _, tc := range cases {
+		err := client.SetSigningCredentials([]byte(tc.creds))
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("SetSigningCredentials(%q) = %v; want = %q", tc.creds, err, tc.want)
+		}
+		if client.currentSigner() != signer {
+			t.Errorf("SetSigningCredentials(%q) replaced the signer; want = unchanged", tc.creds)
+		}
+	}
+}
+
 func TestCustomTokenWithClaims(t *testing.T) {
 	client := &Client{
 		signer: testSigner,
@@ -269,6 +492,63 @@ func TestCustomTokenWithNilClaims(t *testing.T) {
 	verifyCustomToken(context.Background(), token, nil, t)
 }
 
+func TestCustomTokenWithOptions(t *testing.T) {
+	client := &Client{
+		signer: testSigner,
+		clock:  testClock,
+	}
+	claims := map[string]interface{}{"premium": true}
+	cases := []struct {
+		expiresIn time.Duration
+		want      int64
+	}{
+		{0, 3600},
+		{time.Second, 1},
+		{5*time.Minute + 500*time.Millisecond, 300},
+		{time.Hour, 3600},
+	}
+	for _, tc := range cases {
+		opts := &CustomTokenOptions{Claims: claims, ExpiresIn: tc.expiresIn}
+		token, err := client.CustomTokenWithOptions(context.Background(), "user1", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var payload customToken
+		if err := decode(strings.Split(token, ".")[1], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if got := payload.Exp - payload.Iat; got != tc.want {
+			t.Errorf("CustomTokenWithOptions(%v) lifetime = %d; want = %d", tc.expiresIn, got, tc.want)
+		}
+		if payload.Claims["premium"] != true {
+			t.Errorf("CustomTokenWithOptions(%v) Claims = %v; want = %v", tc.expiresIn, payload.Claims, claims)
+		}
+	}
+
+	token, err := client.CustomTokenWithOptions(context.Background(), "user1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyCustomToken(context.Background(), token, nil, t)
+}
+
+func TestCustomTokenWithOptionsInvalidExpiry(t *testing.T) {
+	client := &Client{
+		signer: testSigner,
+		clock:  testClock,
+	}
+	cases := []time.Duration{-time.Second, 500 * time.Millisecond, time.Hour + time.Second, 24 * time.Hour}
+	for _, expiresIn := range cases {
+		token, err := client.CustomTokenWithOptions(
+			context.Background(), "user1", &CustomTokenOptions{ExpiresIn: expiresIn})
+		want := fmt.Sprintf("custom token expiry must be between 1 second and 1 hour; got %v", expiresIn)
+		if token != "" || err == nil || err.Error() != want {
+			t.Errorf("CustomTokenWithOptions(%v) = (%q, %v); want = (\"\", %q)", expiresIn, token, err, want)
+		}
+	}
+}
+
 func TestCustomTokenError(t *testing.T) {
 	cases := []struct {
 		name   string
@@ -295,6 +575,96 @@ func TestCustomTokenError(t *testing.T) {
 	}
 }
 
+func TestSigningServiceAccount(t *testing.T) {
+	creds, err := transport.Creds(context.Background(), optsWithServiceAcct...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Creds:     creds,
+		Opts:      optsWithServiceAcct,
+		ProjectID: creds.ProjectID,
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sa serviceAccount
+	if err := json.Unmarshal(creds.JSON, &sa); err != nil {
+		t.Fatal(err)
+	}
+	email, err := client.SigningServiceAccount(context.Background())
+	if email != sa.ClientEmail || err != nil {
+		t.Errorf("SigningServiceAccount() = (%q, %v); want = (%q, nil)", email, err, sa.ClientEmail)
+	}
+}
+
+func TestSigningServiceAccountWithServiceAccountID(t *testing.T) {
+	conf := &internal.AuthConfig{
+		Opts:             optsWithTokenSource,
+		ServiceAccountID: "explicit-service-account",
+		Version:          testVersion,
+	}
+	client, err := NewClient(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, err := client.SigningServiceAccount(context.Background())
+	if email != conf.ServiceAccountID || err != nil {
+		t.Errorf("SigningServiceAccount() = (%q, %v); want = (%q, nil)", email, err, conf.ServiceAccountID)
+	}
+}
+
+func TestSigningServiceAccountError(t *testing.T) {
+	conf := &internal.AuthConfig{
+		Opts: optsWithTokenSource,
+	}
+	client, err := NewClient(context.Background(), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.signer.(*iamSigner).metadataHost = "http://metadata.invalid"
+
+	email, err := client.SigningServiceAccount(context.Background())
+	if email != "" || err == nil || !strings.HasPrefix(err.Error(), "failed to determine service account") {
+		t.Errorf("SigningServiceAccount() = (%q, %v); want = (%q, error)", email, err, "")
+	}
+}
+
+func TestNewClientRequireSigningAccount(t *testing.T) {
+	conf := &internal.AuthConfig{
+		Opts:                  optsWithTokenSource,
+		ServiceAccountID:      "explicit-service-account",
+		RequireSigningAccount: true,
+		Version:               testVersion,
+	}
+	client, err := NewClient(context.Background(), conf)
+	if client == nil || err != nil {
+		t.Errorf("NewClient() = (%v, %v); want = (client, nil)", client, err)
+	}
+}
+
+func TestNewClientRequireSigningAccountError(t *testing.T) {
+	conf := &internal.AuthConfig{
+		Opts:                  optsWithTokenSource,
+		RequireSigningAccount: true,
+	}
+	// A canceled context makes the Metadata service lookup fail in any environment.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client, err := NewClient(ctx, conf)
+	if client != nil || err == nil || !strings.HasPrefix(err.Error(), "failed to determine service account") {
+		t.Errorf("NewClient() = (%v, %v); want = (nil, %q)", client, err, "failed to determine service account")
+	}
+
+	conf.RequireSigningAccount = false
+	if client, err := NewClient(ctx, conf); client == nil || err != nil {
+		t.Errorf("NewClient() = (%v, %v); want = (client, nil)", client, err)
+	}
+}
+
 func TestCustomTokenInvalidCredential(t *testing.T) {
 	ctx := context.Background()
 	conf := &internal.AuthConfig{
@@ -474,6 +844,264 @@ func TestVerifyIDTokenError(t *testing.T) {
 	}
 }
 
+func TestVerifyIDTokenWithOptions(t *testing.T) {
+	now := testClock.Now().Unix()
+	expired := getIDToken(mockIDTokenPayload{
+		"iat": now - 10000,
+		"exp": now - 5000,
+	})
+	future := getIDToken(mockIDTokenPayload{"iat": now + clockSkewSeconds + 1})
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+
+	for _, opts := range []*TokenVerifyOptions{nil, {}} {
+		if ft, err := client.VerifyIDTokenWithOptions(context.Background(), testIDToken, opts); err != nil {
+			t.Errorf("VerifyIDTokenWithOptions(%v) = (%v, %v); want = (token, nil)", opts, ft, err)
+		}
+		if _, err := client.VerifyIDTokenWithOptions(context.Background(), expired, opts); err == nil {
+			t.Errorf("VerifyIDTokenWithOptions(expired, %v) = nil; want = error", opts)
+		}
+	}
+
+	opts := &TokenVerifyOptions{IgnoreExpiration: true}
+	for _, token := range []string{testIDToken, expired, future} {
+		ft, err := client.VerifyIDTokenWithOptions(context.Background(), token, opts)
+		if err != nil {
+			t.Fatalf("VerifyIDTokenWithOptions(IgnoreExpiration) = (%v, %v); want = (token, nil)", ft, err)
+		}
+		if ft.UID != ft.Subject || ft.Claims["admin"] != true {
+			t.Errorf("VerifyIDTokenWithOptions(IgnoreExpiration) = %#v", ft)
+		}
+	}
+}
+
+func TestVerifyIDTokenWithOptionsIgnoreExpirationError(t *testing.T) {
+	parts := strings.Split(testIDToken, ".")
+	cases := []struct {
+		name, token string
+	}{
+		{"BadAudience", getIDToken(mockIDTokenPayload{"aud": "bad-audience"})},
+		{"BadIssuer", getIDToken(mockIDTokenPayload{"iss": "bad-issuer"})},
+		{"EmptySubject", getIDToken(mockIDTokenPayload{"sub": ""})},
+		{"InvalidSignature", fmt.Sprintf("%s.%s.invalidsignature", parts[0], parts[1])},
+	}
+
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+	opts := &TokenVerifyOptions{IgnoreExpiration: true}
+	for _, tc := range cases {
+		if ft, err := client.VerifyIDTokenWithOptions(context.Background(), tc.token, opts); ft != nil || err == nil {
+			t.Errorf("VerifyIDTokenWithOptions(%q) = (%v, %v); want = (nil, error)", tc.name, ft, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenWithAllowedAudiences(t *testing.T) {
+	other := getIDToken(mockIDTokenPayload{
+		"aud": "other-project",
+		"iss": "https://securetoken.google.com/other-project",
+	})
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+
+	if _, err := client.VerifyIDToken(context.Background(), other); err == nil {
+		t.Errorf("VerifyIDToken(other-project) = nil; want = error")
+	}
+
+	opts := &TokenVerifyOptions{AllowedAudiences: []string{testProjectID, "other-project"}}
+	for _, token := range []string{testIDToken, other} {
+		ft, err := client.VerifyIDTokenWithOptions(context.Background(), token, opts)
+		if err != nil {
+			t.Fatalf("VerifyIDTokenWithOptions(AllowedAudiences) = (%v, %v); want = (token, nil)", ft, err)
+		}
+		if ft.UID != ft.Subject || ft.Claims["admin"] != true {
+			t.Errorf("VerifyIDTokenWithOptions(AllowedAudiences) = %#v", ft)
+		}
+	}
+}
+
+func TestVerifyIDTokenWithAllowedAudiencesError(t *testing.T) {
+	cases := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{
+			name:  "ProjectNotAllowed",
+			token: testIDToken,
+			want: "ID token has invalid 'aud' (audience) claim; " +
+				"expected one of [\"other-project\"] but got \"mock-project-id\"",
+		},
+		{
+			name: "MismatchedIssuer",
+			token: getIDToken(mockIDTokenPayload{
+				"aud": "other-project",
+				"iss": "https://securetoken.google.com/" + testProjectID,
+			}),
+			want: "ID token has invalid 'iss' (issuer) claim; " +
+				"expected \"https://securetoken.google.com/other-project\"",
+		},
+	}
+
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+	opts := &TokenVerifyOptions{AllowedAudiences: []string{"other-project"}}
+	for _, tc := range cases {
+		ft, err := client.VerifyIDTokenWithOptions(context.Background(), tc.token, opts)
+		if ft != nil || err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("VerifyIDTokenWithOptions(%q) = (%v, %v); want = (nil, %q)", tc.name, ft, err, tc.want)
+		}
+	}
+}
+
+func TestVerifyIDTokenWithClaims(t *testing.T) {
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+	token := getIDToken(mockIDTokenPayload{"role": "editor", "level": 3})
+	tenantToken := getIDToken(mockIDTokenPayload{
+		"role":     "editor",
+		"firebase": map[string]interface{}{"tenant": "tenant-id"},
+	})
+
+	cases := []struct {
+		token    string
+		tenantID string
+		required map[string]interface{}
+	}{
+		{token, "", nil},
+		{token, "", map[string]interface{}{"role": "editor"}},
+		{token, "", map[string]interface{}{"role": "editor", "level": 3, "admin": true}},
+		{tenantToken, "", map[string]interface{}{"role": "editor"}},
+		{tenantToken, "tenant-id", nil},
+		{tenantToken, "tenant-id", map[string]interface{}{"role": "editor"}},
+	}
+	for _, tc := range cases {
+		ft, err := client.VerifyIDTokenWithClaims(context.Background(), tc.token, tc.tenantID, tc.required)
+		if err != nil || ft.Claims["role"] != "editor" {
+			t.Errorf("VerifyIDTokenWithClaims(%q, %v) = (%v, %v); want = (token, nil)", tc.tenantID, tc.required, ft, err)
+		}
+	}
+}
+
+func TestVerifyIDTokenWithClaimsError(t *testing.T) {
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+	token := getIDToken(mockIDTokenPayload{"role": "editor"})
+	tenantToken := getIDToken(mockIDTokenPayload{
+		"role":     "editor",
+		"firebase": map[string]interface{}{"tenant": "tenant-id"},
+	})
+
+	cases := []struct {
+		name     string
+		token    string
+		tenantID string
+		required map[string]interface{}
+		want     string
+	}{
+		{
+			name:     "WrongTenant",
+			token:    tenantToken,
+			tenantID: "other-tenant",
+			required: map[string]interface{}{"role": "editor"},
+			want:     `ID token has invalid tenant; expected "other-tenant" but got "tenant-id"`,
+		},
+		{
+			name:     "MissingTenant",
+			token:    token,
+			tenantID: "tenant-id",
+			want:     `ID token has invalid tenant; expected "tenant-id" but got ""`,
+		},
+		{
+			name:     "MismatchedClaimInTenant",
+			token:    tenantToken,
+			tenantID: "tenant-id",
+			required: map[string]interface{}{"role": "owner"},
+			want:     "ID token has invalid \"role\" claim; expected owner but got editor",
+		},
+		{
+			name:     "MissingClaim",
+			token:    token,
+			required: map[string]interface{}{"level": 3},
+			want:     "ID token is missing required claim \"level\"",
+		},
+		{
+			name:     "MismatchedClaim",
+			token:    token,
+			required: map[string]interface{}{"role": "owner"},
+			want:     "ID token has invalid \"role\" claim; expected owner but got editor",
+		},
+		{
+			name:     "InvalidToken",
+			token:    "",
+			required: map[string]interface{}{"role": "editor"},
+			want:     "ID token must be a non-empty string",
+		},
+	}
+	for _, tc := range cases {
+		ft, err := client.VerifyIDTokenWithClaims(context.Background(), tc.token, tc.tenantID, tc.required)
+		if ft != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("VerifyIDTokenWithClaims(%s) = (%v, %v); want = (nil, %q)", tc.name, ft, err, tc.want)
+		}
+	}
+}
+
+func TestDecodeTokenUnverified(t *testing.T) {
+	token := getIDToken(mockIDTokenPayload{
+		"aud": "other-project",
+		"iss": "https://securetoken.google.com/other-project",
+		"exp": 1,
+	})
+	// Tamper with the signature to ensure it is not checked.
+	segments := strings.Split(token, ".")
+	token = segments[0] + "." + segments[1] + ".invalid"
+
+	ft, err := DecodeTokenUnverified(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ft.Audience != "other-project" || ft.Issuer != "https://securetoken.google.com/other-project" {
+		t.Errorf("DecodeTokenUnverified() = {Audience: %q, Issuer: %q}", ft.Audience, ft.Issuer)
+	}
+	if ft.Expires != 1 {
+		t.Errorf("DecodeTokenUnverified().Expires = %d; want = 1", ft.Expires)
+	}
+	if ft.UID != ft.Subject || ft.UID == "" {
+		t.Errorf("DecodeTokenUnverified().UID = %q; want = %q", ft.UID, ft.Subject)
+	}
+	if ft.Claims["admin"] != true {
+		t.Errorf("DecodeTokenUnverified().Claims = %v; want admin claim", ft.Claims)
+	}
+	if _, ok := ft.Claims["aud"]; ok {
+		t.Errorf("DecodeTokenUnverified().Claims contains standard claim 'aud'")
+	}
+}
+
+func TestDecodeTokenUnverifiedError(t *testing.T) {
+	cases := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"Empty", "", "token must be a non-empty string"},
+		{"TwoSegments", "foo.bar", "token has an incorrect number of segments"},
+		{"InvalidHeader", "!!.e30.sig", "failed to decode token header: "},
+		{"InvalidPayload", "e30.!!.sig", "failed to decode token payload: "},
+	}
+	for _, tc := range cases {
+		ft, err := DecodeTokenUnverified(tc.token)
+		if ft != nil || err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("DecodeTokenUnverified(%s) = (%v, %v); want = (nil, %q)", tc.name, ft, err, tc.want)
+		}
+	}
+}
+
 func TestVerifyIDTokenInvalidAlgorithm(t *testing.T) {
 	var payload mockIDTokenPayload
 	segments := strings.Split(testIDToken, ".")
@@ -546,6 +1174,63 @@ func TestCertificateRequestError(t *testing.T) {
 	}
 }
 
+func TestClientConcurrentUse(t *testing.T) {
+	certs, err := ioutil.ReadFile("../testdata/public_certs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/certs" {
+			// Expire the cached keys immediately, so that they are refreshed concurrently.
+			w.Header().Set("Cache-Control", "public, max-age=0")
+			w.Write(certs)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(testGetUserResponse)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Opts:      optsWithTokenSource,
+		ProjectID: testProjectID,
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.userManagementClient.baseURL = ts.URL
+	if err := client.idTokenVerifier.setCertURL(ts.URL + "/certs"); err != nil {
+		t.Fatal(err)
+	}
+	client.idTokenVerifier.clock = testClock
+
+	const goroutines = 20
+	errs := make(chan error, goroutines*3)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := context.Background()
+			if _, err := client.VerifyIDToken(ctx, testIDToken); err != nil {
+				errs <- fmt.Errorf("VerifyIDToken() = %v", err)
+			}
+			if _, err := client.GetUser(ctx, "testuser"); err != nil {
+				errs <- fmt.Errorf("GetUser() = %v", err)
+			}
+			if _, err := client.VerifyIDTokenAndCheckRevoked(ctx, testIDToken); err != nil {
+				errs <- fmt.Errorf("VerifyIDTokenAndCheckRevoked() = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
 func TestVerifyIDTokenAndCheckRevoked(t *testing.T) {
 	s := echoServer(testGetUserResponse, t)
 	defer s.Close()
@@ -625,6 +1310,64 @@ func TestIDTokenRevocationCheckUserMgtError(t *testing.T) {
 	}
 }
 
+func TestVerifyIDTokenAndGetUser(t *testing.T) {
+	s := echoServer(testGetUserResponse, t)
+	defer s.Close()
+	s.Client.idTokenVerifier = testIDTokenVerifier
+
+	ft, user, err := s.Client.VerifyIDTokenAndGetUser(context.Background(), testIDToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ft.Claims["admin"] != true {
+		t.Errorf("Claims['admin'] = %v; want = true", ft.Claims["admin"])
+	}
+	if user == nil || user.UID != "testuser" {
+		t.Errorf("VerifyIDTokenAndGetUser() = %v; want = {UID: %q}", user, "testuser")
+	}
+}
+
+func TestVerifyIDTokenAndGetUserRevoked(t *testing.T) {
+	s := echoServer(testGetUserResponse, t)
+	defer s.Close()
+	revokedToken := getIDToken(mockIDTokenPayload{"uid": "uid", "iat": 1970})
+	s.Client.idTokenVerifier = testIDTokenVerifier
+
+	ft, user, err := s.Client.VerifyIDTokenAndGetUser(context.Background(), revokedToken)
+	if ft != nil || user != nil || !IsIDTokenRevoked(err) {
+		t.Errorf("VerifyIDTokenAndGetUser() = (%v, %v, %v); want = (nil, nil, id-token-revoked)", ft, user, err)
+	}
+}
+
+func TestVerifyIDTokenAndGetUserInvalidToken(t *testing.T) {
+	s := echoServer(testGetUserResponse, t)
+	defer s.Close()
+	s.Client.idTokenVerifier = testIDTokenVerifier
+
+	ft, user, err := s.Client.VerifyIDTokenAndGetUser(context.Background(), "")
+	if ft != nil || user != nil || err == nil {
+		t.Errorf("VerifyIDTokenAndGetUser('') = (%v, %v, %v); want = (nil, nil, error)", ft, user, err)
+	}
+	if s.Req != nil {
+		t.Errorf("VerifyIDTokenAndGetUser('') made %d requests; want = 0", len(s.Req))
+	}
+}
+
+func TestVerifyIDTokenAndGetUserNotFound(t *testing.T) {
+	resp := `{
+		"kind" : "identitytoolkit#GetAccountInfoResponse",
+		"users" : []
+	}`
+	s := echoServer([]byte(resp), t)
+	defer s.Close()
+	s.Client.idTokenVerifier = testIDTokenVerifier
+
+	ft, user, err := s.Client.VerifyIDTokenAndGetUser(context.Background(), testIDToken)
+	if ft != nil || user != nil || !IsUserNotFound(err) {
+		t.Errorf("VerifyIDTokenAndGetUser() = (%v, %v, %v); want = (nil, nil, user-not-found)", ft, user, err)
+	}
+}
+
 func TestVerifySessionCookie(t *testing.T) {
 	client := &Client{
 		cookieVerifier: testCookieVerifier,
@@ -728,6 +1471,105 @@ func TestVerifySessionCookieError(t *testing.T) {
 	}
 }
 
+func TestVerifySessionCookieWithAllowedAudiences(t *testing.T) {
+	other := getSessionCookie(mockIDTokenPayload{
+		"aud": "other-project",
+		"iss": "https://session.firebase.google.com/other-project",
+	})
+	client := &Client{
+		cookieVerifier: testCookieVerifier,
+	}
+
+	if _, err := client.VerifySessionCookie(context.Background(), other); err == nil {
+		t.Errorf("VerifySessionCookie(other-project) = nil; want = error")
+	}
+
+	opts := &TokenVerifyOptions{AllowedAudiences: []string{testProjectID, "other-project"}}
+	for _, cookie := range []string{testSessionCookie, other} {
+		ft, err := client.VerifySessionCookieWithOptions(context.Background(), cookie, opts)
+		if err != nil {
+			t.Fatalf("VerifySessionCookieWithOptions(AllowedAudiences) = (%v, %v); want = (token, nil)", ft, err)
+		}
+		if ft.UID != ft.Subject || ft.Claims["admin"] != true {
+			t.Errorf("VerifySessionCookieWithOptions(AllowedAudiences) = %#v", ft)
+		}
+	}
+}
+
+func TestVerifySessionCookieWithAllowedAudiencesError(t *testing.T) {
+	cases := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{
+			name:   "ProjectNotAllowed",
+			cookie: testSessionCookie,
+			want: "session cookie has invalid 'aud' (audience) claim; " +
+				"expected one of [\"other-project\"] but got \"mock-project-id\"",
+		},
+		{
+			name: "IDTokenIssuer",
+			cookie: getIDToken(mockIDTokenPayload{
+				"aud": "other-project",
+				"iss": "https://securetoken.google.com/other-project",
+			}),
+			want: "session cookie has invalid 'iss' (issuer) claim; " +
+				"expected \"https://session.firebase.google.com/other-project\"",
+		},
+	}
+
+	client := &Client{
+		cookieVerifier: testCookieVerifier,
+	}
+	opts := &TokenVerifyOptions{AllowedAudiences: []string{"other-project"}}
+	for _, tc := range cases {
+		ft, err := client.VerifySessionCookieWithOptions(context.Background(), tc.cookie, opts)
+		if ft != nil || err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("VerifySessionCookieWithOptions(%q) = (%v, %v); want = (nil, %q)", tc.name, ft, err, tc.want)
+		}
+	}
+}
+
+func TestPublicKeys(t *testing.T) {
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+		cookieVerifier:  testCookieVerifier,
+	}
+	fns := map[string]func(context.Context) (*PublicKeySet, error){
+		"IDTokenPublicKeys":       client.IDTokenPublicKeys,
+		"SessionCookiePublicKeys": client.SessionCookiePublicKeys,
+	}
+	for name, fn := range fns {
+		set, err := fn(context.Background())
+		if err != nil {
+			t.Fatalf("%s() = %v", name, err)
+		}
+		if len(set.Keys) != 3 || !set.Expiry.IsZero() {
+			t.Errorf("%s() = (%d keys, %v); want = (3 keys, zero expiry)", name, len(set.Keys), set.Expiry)
+		}
+		if set.Keys["mock-key-id-1"] == nil {
+			t.Errorf("%s() does not contain the key used to sign test tokens", name)
+		}
+	}
+}
+
+func TestPublicKeysError(t *testing.T) {
+	tv, err := idTokenVerifierForTests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv.keySource = &mockKeySource{nil, errors.New("mock error")}
+	client := &Client{
+		idTokenVerifier: tv,
+	}
+
+	set, err := client.IDTokenPublicKeys(context.Background())
+	if set != nil || err == nil || err.Error() != "mock error" {
+		t.Errorf("IDTokenPublicKeys() = (%v, %v); want = (nil, %q)", set, err, "mock error")
+	}
+}
+
 func TestVerifySessionCookieDoesNotCheckRevoked(t *testing.T) {
 	s := echoServer(testGetUserResponse, t)
 	defer s.Close()
@@ -807,6 +1649,105 @@ func TestCookieRevocationCheckUserMgtError(t *testing.T) {
 	}
 }
 
+func TestSessionCookieWithOptions(t *testing.T) {
+	// The same response is used for both the revocation check and the createSessionCookie call.
+	var resp map[string]interface{}
+	if err := json.Unmarshal(testGetUserResponse, &resp); err != nil {
+		t.Fatal(err)
+	}
+	resp["sessionCookie"] = "expectedCookie"
+	s := echoServer(resp, t)
+	defer s.Close()
+	s.Client.idTokenVerifier = testIDTokenVerifier
+
+	idToken := getIDToken(mockIDTokenPayload{
+		"level":    2,
+		"firebase": map[string]interface{}{"tenant": "tenant-id"},
+	})
+	cases := []*SessionCookieOptions{
+		nil,
+		{},
+		{RequiredClaims: map[string]interface{}{"admin": true}},
+		{RequiredClaims: map[string]interface{}{"admin": true, "level": 2}},
+		{TenantID: "tenant-id"},
+		{CheckRevoked: true},
+	}
+	for idx, opts := range cases {
+		s.Req = nil
+		cookie, err := s.Client.SessionCookieWithOptions(context.Background(), idToken, 10*time.Minute, opts)
+		if cookie != "expectedCookie" || err != nil {
+			t.Errorf("[%d] SessionCookieWithOptions() = (%q, %v); want = (%q, nil)", idx, cookie, err, "expectedCookie")
+		}
+
+		wantURL := "/projects/mock-project-id:createSessionCookie"
+		if last := s.Req[len(s.Req)-1]; last.URL.Path != wantURL {
+			t.Errorf("[%d] SessionCookieWithOptions() URL = %q; want = %q", idx, last.URL.Path, wantURL)
+		}
+	}
+}
+
+func TestSessionCookieWithOptionsError(t *testing.T) {
+	s := echoServer(testGetUserResponse, t)
+	defer s.Close()
+	s.Client.idTokenVerifier = testIDTokenVerifier
+
+	revokedToken := getIDToken(mockIDTokenPayload{"uid": "uid", "iat": 1970})
+	cases := []struct {
+		name    string
+		idToken string
+		opts    *SessionCookieOptions
+		want    string
+	}{
+		{
+			name:    "MissingClaim",
+			idToken: testIDToken,
+			opts:    &SessionCookieOptions{RequiredClaims: map[string]interface{}{"level": 2}},
+			want:    `ID token is missing required claim "level"`,
+		},
+		{
+			name:    "MismatchedClaim",
+			idToken: testIDToken,
+			opts:    &SessionCookieOptions{RequiredClaims: map[string]interface{}{"admin": false}},
+			want:    `ID token has invalid "admin" claim; expected false but got true`,
+		},
+		{
+			name:    "MismatchedTenant",
+			idToken: testIDToken,
+			opts:    &SessionCookieOptions{TenantID: "tenant-id"},
+			want:    `ID token has invalid tenant; expected "tenant-id" but got ""`,
+		},
+		{
+			name:    "RevokedToken",
+			idToken: revokedToken,
+			opts:    &SessionCookieOptions{CheckRevoked: true},
+			want:    "ID token has been revoked",
+		},
+	}
+	for _, tc := range cases {
+		s.Req = nil
+		cookie, err := s.Client.SessionCookieWithOptions(context.Background(), tc.idToken, 10*time.Minute, tc.opts)
+		if cookie != "" || err == nil || err.Error() != tc.want {
+			t.Errorf("SessionCookieWithOptions(%s) = (%q, %v); want = (%q, %q)", tc.name, cookie, err, "", tc.want)
+		}
+		for _, r := range s.Req {
+			if strings.HasSuffix(r.URL.Path, ":createSessionCookie") {
+				t.Errorf("SessionCookieWithOptions(%s) made a createSessionCookie call; want none", tc.name)
+			}
+		}
+	}
+}
+
+func TestSessionCookieWithOptionsInvalidIDToken(t *testing.T) {
+	client := &Client{
+		idTokenVerifier: testIDTokenVerifier,
+	}
+	opts := &SessionCookieOptions{}
+	cookie, err := client.SessionCookieWithOptions(context.Background(), "", 10*time.Minute, opts)
+	if cookie != "" || err == nil {
+		t.Errorf("SessionCookieWithOptions('') = (%q, %v); want = (%q, error)", cookie, err, "")
+	}
+}
+
 func signerForTests(ctx context.Context) (cryptoSigner, error) {
 	creds, err := transport.Creds(ctx, optsWithServiceAcct...)
 	if err != nil {
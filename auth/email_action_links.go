@@ -20,10 +20,18 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"strings"
 )
 
 // ActionCodeSettings specifies the required continue/state URL with optional Android and iOS settings. Used when
 // invoking the email action link generation APIs.
+//
+// LinkDomain optionally specifies the custom domain that handles the generated link, instead of the default
+// action handler domain of the project. It must be a bare host name (e.g. "auth.example.com") that has been
+// configured as an authorized domain of the project. Links for mobile apps are handled by the Firebase
+// Hosting domain of the project by default, and LinkDomain can point them at a custom Hosting domain. An
+// error for which IsInvalidHostingLinkDomain is true is returned if the domain is not configured for
+// Hosting.
 type ActionCodeSettings struct {
 	URL                   string `json:"continueUrl"`
 	HandleCodeInApp       bool   `json:"canHandleCodeInApp"`
@@ -31,25 +39,63 @@ type ActionCodeSettings struct {
 	AndroidPackageName    string `json:"androidPackageName,omitempty"`
 	AndroidMinimumVersion string `json:"androidMinimumVersion,omitempty"`
 	AndroidInstallApp     bool   `json:"androidInstallApp,omitempty"`
-	DynamicLinkDomain     string `json:"dynamicLinkDomain,omitempty"`
+
+	// DynamicLinkDomain is the Firebase Dynamic Links domain that handles the generated link.
+	//
+	// Deprecated: Use LinkDomain instead. Firebase Dynamic Links are shut down.
+	DynamicLinkDomain string `json:"dynamicLinkDomain,omitempty"`
+
+	LinkDomain string `json:"linkDomain,omitempty"`
 }
 
-func (settings *ActionCodeSettings) toMap() (map[string]interface{}, error) {
+// ActionCodeSettingsError is returned when an ActionCodeSettings value fails local validation.
+//
+// Field holds the name of the offending ActionCodeSettings field (e.g. "URL" or "AndroidPackageName"), and
+// Reason the error message, which is also returned by Error.
+type ActionCodeSettingsError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ActionCodeSettingsError) Error() string {
+	return e.Reason
+}
+
+func (settings *ActionCodeSettings) validate() error {
 	if settings.URL == "" {
-		return nil, errors.New("URL must not be empty")
+		return &ActionCodeSettingsError{"URL", "URL must not be empty"}
 	}
 
 	url, err := url.Parse(settings.URL)
 	if err != nil || url.Scheme == "" || url.Host == "" {
-		return nil, fmt.Errorf("malformed url string: %q", settings.URL)
+		return &ActionCodeSettingsError{"URL", fmt.Sprintf("malformed url string: %q", settings.URL)}
+	}
+
+	if settings.IOSBundleID != "" && !settings.HandleCodeInApp {
+		return &ActionCodeSettingsError{
+			"HandleCodeInApp", "HandleCodeInApp must be true when specifying an iOS bundle ID"}
 	}
 
 	if settings.AndroidMinimumVersion != "" || settings.AndroidInstallApp {
 		if settings.AndroidPackageName == "" {
-			return nil, errors.New("Android package name is required when specifying other Android settings")
+			return &ActionCodeSettingsError{
+				"AndroidPackageName", "Android package name is required when specifying other Android settings"}
 		}
 	}
 
+	if d := settings.LinkDomain; d != "" {
+		if u, err := url.Parse("https://" + d); err != nil || u.Host != d || strings.Contains(d, "/") {
+			return &ActionCodeSettingsError{"LinkDomain", fmt.Sprintf("link domain must be a valid host name: %q", d)}
+		}
+	}
+	return nil
+}
+
+func (settings *ActionCodeSettings) toMap() (map[string]interface{}, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+
 	b, err := json.Marshal(settings)
 	if err != nil {
 		return nil, err
@@ -102,6 +148,67 @@ func (c *userManagementClient) EmailSignInLink(
 	return c.generateEmailActionLink(ctx, emailLinkSignIn, email, settings)
 }
 
+// defaultEmailLinkConcurrency is the number of links generated concurrently by EmailSignInLinks when no limit is
+// specified.
+const defaultEmailLinkConcurrency = 10
+
+// EmailLinkResult is the outcome of generating an email action link for a single email address.
+type EmailLinkResult struct {
+	Email string
+	Link  string
+	Error error
+}
+
+// EmailLinksOptions specifies additional options for the EmailSignInLinks function.
+type EmailLinksOptions struct {
+	// MaxConcurrency is the maximum number of links generated at the same time. Defaults to 10 when not
+	// positive.
+	MaxConcurrency int
+}
+
+// EmailSignInLinks generates email link sign-in links for all the specified email addresses, using the action
+// code settings provided.
+//
+// Links are generated concurrently, with at most opts.MaxConcurrency requests in flight at a time. The returned
+// slice contains one EmailLinkResult per input email address, in the same order as the input. Failures to generate
+// individual links are reported in the corresponding EmailLinkResult and do not stop the other links from being
+// generated. Once ctx is done, no new requests are made, and the remaining results report the context error. A
+// non-nil error is only returned when the shared action code settings are invalid, in which case no requests are
+// made.
+func (c *userManagementClient) EmailSignInLinks(
+	ctx context.Context, emails []string, settings *ActionCodeSettings, opts *EmailLinksOptions) (
+	[]*EmailLinkResult, error) {
+
+	if settings == nil {
+		return nil, errors.New("ActionCodeSettings must not be nil when generating sign-in links")
+	}
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*EmailLinkResult, len(emails))
+	forEachConcurrently(len(emails), opts.concurrency(), func(i int) {
+		if err := ctx.Err(); err != nil {
+			results[i] = &EmailLinkResult{Email: emails[i], Error: err}
+			return
+		}
+		link, err := c.EmailSignInLink(ctx, emails[i], settings)
+		results[i] = &EmailLinkResult{
+			Email: emails[i],
+			Link:  link,
+			Error: err,
+		}
+	})
+	return results, nil
+}
+
+func (opts *EmailLinksOptions) concurrency() int {
+	if opts != nil && opts.MaxConcurrency > 0 {
+		return opts.MaxConcurrency
+	}
+	return defaultEmailLinkConcurrency
+}
+
 func (c *userManagementClient) generateEmailActionLink(
 	ctx context.Context, linkType linkType, email string, settings *ActionCodeSettings) (string, error) {
 
@@ -132,5 +239,5 @@ func (c *userManagementClient) generateEmailActionLink(
 		OOBLink string `json:"oobLink"`
 	}
 	_, err := c.post(ctx, "/accounts:sendOobCode", payload, &result)
-	return result.OOBLink, err
+	return result.OOBLink, withSubject(err, fmt.Sprintf("email: %q", email))
 }
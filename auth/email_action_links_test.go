@@ -19,8 +19,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"firebase.google.com/go/internal"
 )
 
 const (
@@ -51,11 +56,13 @@ var testActionCodeSettingsMap = map[string]interface{}{
 var invalidActionCodeSettings = []struct {
 	name     string
 	settings *ActionCodeSettings
+	field    string
 	want     string
 }{
 	{
 		"no-url",
 		&ActionCodeSettings{},
+		"URL",
 		"URL must not be empty",
 	},
 	{
@@ -63,14 +70,25 @@ var invalidActionCodeSettings = []struct {
 		&ActionCodeSettings{
 			URL: "not a url",
 		},
+		"URL",
 		`malformed url string: "not a url"`,
 	},
+	{
+		"ios-bundle-without-handle-code-in-app",
+		&ActionCodeSettings{
+			URL:         "https://example.dynamic.link",
+			IOSBundleID: "com.example.ios",
+		},
+		"HandleCodeInApp",
+		"HandleCodeInApp must be true when specifying an iOS bundle ID",
+	},
 	{
 		"no-android-package-1",
 		&ActionCodeSettings{
 			URL:               "https://example.dynamic.link",
 			AndroidInstallApp: true,
 		},
+		"AndroidPackageName",
 		"Android package name is required when specifying other Android settings",
 	},
 	{
@@ -79,8 +97,27 @@ var invalidActionCodeSettings = []struct {
 			URL:                   "https://example.dynamic.link",
 			AndroidMinimumVersion: "6",
 		},
+		"AndroidPackageName",
 		"Android package name is required when specifying other Android settings",
 	},
+	{
+		"link-domain-with-scheme",
+		&ActionCodeSettings{
+			URL:        "https://example.dynamic.link",
+			LinkDomain: "https://auth.example.com",
+		},
+		"LinkDomain",
+		`link domain must be a valid host name: "https://auth.example.com"`,
+	},
+	{
+		"link-domain-with-path",
+		&ActionCodeSettings{
+			URL:        "https://example.dynamic.link",
+			LinkDomain: "auth.example.com/links",
+		},
+		"LinkDomain",
+		`link domain must be a valid host name: "auth.example.com/links"`,
+	},
 }
 
 func TestEmailVerificationLink(t *testing.T) {
@@ -202,6 +239,57 @@ func TestEmailSignInLink(t *testing.T) {
 	}
 }
 
+func TestEmailSignInLinkWithLinkDomain(t *testing.T) {
+	s := echoServer(testActionLinkResponse, t)
+	defer s.Close()
+
+	settings := &ActionCodeSettings{
+		URL:             "https://example.dynamic.link",
+		HandleCodeInApp: true,
+		LinkDomain:      "auth.example.com",
+	}
+	if _, err := s.Client.EmailSignInLink(context.Background(), testEmail, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"requestType":        "EMAIL_SIGNIN",
+		"email":              testEmail,
+		"returnOobLink":      true,
+		"continueUrl":        "https://example.dynamic.link",
+		"canHandleCodeInApp": true,
+		"linkDomain":         "auth.example.com",
+	}
+	if err := checkActionLinkRequest(want, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmailVerificationLinkWithLinkDomain(t *testing.T) {
+	s := echoServer(testActionLinkResponse, t)
+	defer s.Close()
+
+	settings := &ActionCodeSettings{
+		URL:        "https://example.com/verified",
+		LinkDomain: "example.firebaseapp.com",
+	}
+	if _, err := s.Client.EmailVerificationLinkWithSettings(context.Background(), testEmail, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"requestType":        "VERIFY_EMAIL",
+		"email":              testEmail,
+		"returnOobLink":      true,
+		"continueUrl":        "https://example.com/verified",
+		"canHandleCodeInApp": false,
+		"linkDomain":         "example.firebaseapp.com",
+	}
+	if err := checkActionLinkRequest(want, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestEmailActionLinkNoEmail(t *testing.T) {
 	client := &Client{}
 	_, err := client.EmailVerificationLink(context.Background(), "")
@@ -250,6 +338,35 @@ func TestEmailSignInLinkInvalidSettings(t *testing.T) {
 	}
 }
 
+func TestActionCodeSettingsErrorField(t *testing.T) {
+	client := &Client{}
+	for _, tc := range invalidActionCodeSettings {
+		_, err := client.EmailVerificationLinkWithSettings(context.Background(), testEmail, tc.settings)
+		acsErr, ok := err.(*ActionCodeSettingsError)
+		if !ok {
+			t.Errorf("EmailVerificationLinkWithSettings(%q) = %#v; want = *ActionCodeSettingsError", tc.name, err)
+			continue
+		}
+		if acsErr.Field != tc.field {
+			t.Errorf("EmailVerificationLinkWithSettings(%q).Field = %q; want = %q", tc.name, acsErr.Field, tc.field)
+		}
+	}
+}
+
+func TestActionCodeSettingsAndroidMinimumVersion(t *testing.T) {
+	for _, v := range []string{"6", "1.2.0", "12-beta"} {
+		settings := &ActionCodeSettings{
+			URL:                   "https://example.dynamic.link",
+			AndroidPackageName:    "com.example.android",
+			AndroidMinimumVersion: v,
+		}
+		m, err := settings.toMap()
+		if err != nil || m["androidMinimumVersion"] != v {
+			t.Errorf("toMap(%q) = (%v, %v); want = (map, nil)", v, m, err)
+		}
+	}
+}
+
 func TestEmailSignInLinkNoSettings(t *testing.T) {
 	client := &Client{}
 	_, err := client.EmailSignInLink(context.Background(), testEmail, nil)
@@ -262,6 +379,7 @@ func TestEmailVerificationLinkError(t *testing.T) {
 	cases := map[string]func(error) bool{
 		"UNAUTHORIZED_DOMAIN":         IsUnauthorizedContinueURI,
 		"INVALID_DYNAMIC_LINK_DOMAIN": IsInvalidDynamicLinkDomain,
+		"INVALID_HOSTING_LINK_DOMAIN": IsInvalidHostingLinkDomain,
 	}
 	s := echoServer(testActionLinkResponse, t)
 	defer s.Close()
@@ -278,6 +396,112 @@ func TestEmailVerificationLinkError(t *testing.T) {
 	}
 }
 
+func TestEmailSignInLinks(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		inFlight int
+		maxSeen  int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxSeen {
+			maxSeen = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		email := req["email"].(string)
+		if email == "bad@domain.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "INVALID_EMAIL"}}`))
+			return
+		}
+		w.Write([]byte(fmt.Sprintf(testActionLinkFormat, "https://test.link/"+email)))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Opts:      optsWithTokenSource,
+		ProjectID: "mock-project-id",
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.userManagementClient.baseURL = ts.URL
+
+	emails := []string{"a@domain.com", "b@domain.com", "bad@domain.com", "c@domain.com", "d@domain.com"}
+	results, err := client.EmailSignInLinks(
+		context.Background(), emails, testActionCodeSettings, &EmailLinksOptions{MaxConcurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(emails) {
+		t.Fatalf("EmailSignInLinks() = %d results; want = %d", len(results), len(emails))
+	}
+	for i, r := range results {
+		if r.Email != emails[i] {
+			t.Errorf("EmailSignInLinks()[%d].Email = %q; want = %q", i, r.Email, emails[i])
+		}
+		if r.Email == "bad@domain.com" {
+			if r.Link != "" || r.Error == nil {
+				t.Errorf("EmailSignInLinks()[%d] = (%q, %v); want = (\"\", error)", i, r.Link, r.Error)
+			}
+			continue
+		}
+		if want := "https://test.link/" + r.Email; r.Link != want || r.Error != nil {
+			t.Errorf("EmailSignInLinks()[%d] = (%q, %v); want = (%q, nil)", i, r.Link, r.Error, want)
+		}
+	}
+	if maxSeen > 2 {
+		t.Errorf("EmailSignInLinks() concurrency = %d; want <= 2", maxSeen)
+	}
+}
+
+func TestEmailSignInLinksCanceled(t *testing.T) {
+	s := echoServer(testActionLinkResponse, t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	emails := []string{"a@domain.com", "b@domain.com", "c@domain.com"}
+	results, err := s.Client.EmailSignInLinks(ctx, emails, testActionCodeSettings, &EmailLinksOptions{MaxConcurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r.Email != emails[i] || r.Link != "" || r.Error != context.Canceled {
+			t.Errorf("EmailSignInLinks()[%d] = %#v; want = (%q, \"\", %v)", i, r, emails[i], context.Canceled)
+		}
+	}
+	if len(s.Req) != 0 {
+		t.Errorf("EmailSignInLinks() = %d requests; want = 0", len(s.Req))
+	}
+}
+
+func TestEmailSignInLinksInvalidSettings(t *testing.T) {
+	client := &Client{}
+	emails := []string{testEmail}
+	if _, err := client.EmailSignInLinks(context.Background(), emails, nil, nil); err == nil {
+		t.Errorf("EmailSignInLinks(nil settings) = nil; want = error")
+	}
+	for _, tc := range invalidActionCodeSettings {
+		results, err := client.EmailSignInLinks(context.Background(), emails, tc.settings, nil)
+		if results != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("EmailSignInLinks(%q) = (%v, %v); want = (nil, %q)", tc.name, results, err, tc.want)
+		}
+	}
+}
+
 func checkActionLinkRequest(want map[string]interface{}, s *mockAuthServer) error {
 	var got map[string]interface{}
 	if err := json.Unmarshal(s.Rbody, &got); err != nil {
@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"firebase.google.com/go/internal"
 	"google.golang.org/api/iterator"
@@ -32,10 +33,61 @@ const maxReturnedResults = 1000
 // If nextPageToken is empty, the iterator will start at the beginning.
 // If the nextPageToken is not empty, the iterator starts after the token.
 func (c *userManagementClient) Users(ctx context.Context, nextPageToken string) *UserIterator {
+	return c.UsersWithOptions(ctx, nextPageToken, nil)
+}
+
+// UserCount returns the number of user accounts in the project.
+//
+// UserCount makes a single request that returns the count only, without fetching any user records. Unlike
+// iterating over Users, this is cheap regardless of the number of users.
+func (c *userManagementClient) UserCount(ctx context.Context) (int64, error) {
+	payload := map[string]interface{}{
+		"returnUserInfo": false,
+	}
+	var parsed struct {
+		RecordsCount int64 `json:"recordsCount,string,omitempty"`
+	}
+	if _, err := c.post(ctx, "/accounts:query", payload, &parsed); err != nil {
+		return 0, err
+	}
+	return parsed.RecordsCount, nil
+}
+
+// UsersOptions specifies additional options for the UsersWithOptions function.
+type UsersOptions struct {
+	// Prefetch enables fetching the next page of users in the background while the current page is being
+	// consumed. At most one page is prefetched at a time. Errors encountered while prefetching a page are
+	// returned by the Next call that would have otherwise fetched that page. Cancelling the context passed to
+	// UsersWithOptions stops any in-flight prefetch.
+	Prefetch bool
+
+	// PageTimeout limits the time spent fetching each page of users, when positive. This allows a long-running
+	// iteration to use the context passed to UsersWithOptions for cancelling the whole job only, while still
+	// bounding the duration of each request. A page fetch that times out is retried up to PageRetries times,
+	// provided that the job context is not done yet. If all attempts time out, Next returns the error, and the
+	// iteration can be resumed later from PageInfo().Token.
+	PageTimeout time.Duration
+
+	// PageRetries is the number of times a page fetch that exceeded PageTimeout is retried. Ignored when
+	// PageTimeout is not set.
+	PageRetries int
+}
+
+// UsersWithOptions returns an iterator over Users, using the given options.
+//
+// The returned iterator behaves exactly like the one returned by Users. With nil or empty options,
+// UsersWithOptions is equivalent to Users.
+func (c *userManagementClient) UsersWithOptions(
+	ctx context.Context, nextPageToken string, opts *UsersOptions) *UserIterator {
 	it := &UserIterator{
 		ctx:    ctx,
 		client: c,
 	}
+	if opts != nil {
+		it.prefetch = opts.Prefetch
+		it.pageTimeout = opts.PageTimeout
+		it.pageRetries = opts.PageRetries
+	}
 	it.pageInfo, it.nextFunc = iterator.NewPageInfo(
 		it.fetch,
 		func() int { return len(it.users) },
@@ -47,17 +99,31 @@ func (c *userManagementClient) Users(ctx context.Context, nextPageToken string)
 
 // UserIterator is an iterator over Users.
 //
+// A UserIterator must not be used by multiple goroutines concurrently.
+//
 // Also see: https://github.com/GoogleCloudPlatform/google-cloud-go/wiki/Iterator-Guidelines
 type UserIterator struct {
-	client   *userManagementClient
-	ctx      context.Context
-	nextFunc func() error
-	pageInfo *iterator.PageInfo
-	users    []*ExportedUserRecord
+	client      *userManagementClient
+	ctx         context.Context
+	nextFunc    func() error
+	pageInfo    *iterator.PageInfo
+	users       []*ExportedUserRecord
+	prefetch    bool
+	prefetched  *pendingUserPage
+	pageTimeout time.Duration
+	pageRetries int
 }
 
 // PageInfo supports pagination. See the google.golang.org/api/iterator package for details.
-// Page size can be determined by the NewPager(...) function described there.
+// Page size can be determined by the NewPager(...) function described there. The page size
+// must not exceed 1000, which is the maximum number of users the backend returns in a single
+// page. Larger page sizes cause Next to return an error without making any requests.
+//
+// PageInfo().Token holds the token of the page that follows the most recently fetched page, and
+// is empty once the last page has been fetched. Together with PageInfo().Remaining() it can be used
+// to checkpoint a long-running iteration: when Remaining() returns 0, all users fetched so far have
+// been returned by Next, and the iteration can later be resumed from the next page by passing the
+// saved Token to Users.
 func (it *UserIterator) PageInfo() *iterator.PageInfo { return it.pageInfo }
 
 // Next returns the next result. Its second return value is [iterator.Done] if
@@ -72,7 +138,80 @@ func (it *UserIterator) Next() (*ExportedUserRecord, error) {
 	return user, nil
 }
 
+type userPage struct {
+	users         []*ExportedUserRecord
+	nextPageToken string
+	err           error
+}
+
+// pendingUserPage is a page of users being fetched in the background.
+type pendingUserPage struct {
+	pageSize  int
+	pageToken string
+	result    chan *userPage
+}
+
 func (it *UserIterator) fetch(pageSize int, pageToken string) (string, error) {
+	if pageSize > maxReturnedResults {
+		return "", fmt.Errorf("page size must not exceed %d; got %d", maxReturnedResults, pageSize)
+	}
+
+	var page *userPage
+	if p := it.prefetched; p != nil && p.pageSize == pageSize && p.pageToken == pageToken {
+		page = <-p.result
+	} else {
+		page = it.fetchPage(pageSize, pageToken)
+	}
+	it.prefetched = nil
+
+	it.users = append(it.users, page.users...)
+	if page.err != nil {
+		return "", page.err
+	}
+
+	it.pageInfo.Token = page.nextPageToken
+	if it.prefetch && page.nextPageToken != "" {
+		it.prefetched = it.startFetch(pageSize, page.nextPageToken)
+	}
+	return page.nextPageToken, nil
+}
+
+func (it *UserIterator) startFetch(pageSize int, pageToken string) *pendingUserPage {
+	p := &pendingUserPage{
+		pageSize:  pageSize,
+		pageToken: pageToken,
+		result:    make(chan *userPage, 1),
+	}
+	go func() {
+		p.result <- it.fetchPage(pageSize, pageToken)
+	}()
+	return p
+}
+
+func (it *UserIterator) fetchPage(pageSize int, pageToken string) *userPage {
+	for attempt := 0; ; attempt++ {
+		page, timedOut := it.fetchPageWithTimeout(pageSize, pageToken)
+		if !timedOut || attempt >= it.pageRetries || it.ctx.Err() != nil {
+			return page
+		}
+	}
+}
+
+// fetchPageWithTimeout fetches a single page of users, and reports whether the fetch failed due to the
+// per-page timeout of the iterator.
+func (it *UserIterator) fetchPageWithTimeout(pageSize int, pageToken string) (*userPage, bool) {
+	if it.pageTimeout <= 0 {
+		return it.fetchPageWithContext(it.ctx, pageSize, pageToken), false
+	}
+
+	ctx, cancel := context.WithTimeout(it.ctx, it.pageTimeout)
+	defer cancel()
+	page := it.fetchPageWithContext(ctx, pageSize, pageToken)
+	timedOut := page.err != nil && ctx.Err() == context.DeadlineExceeded && it.ctx.Err() == nil
+	return page, timedOut
+}
+
+func (it *UserIterator) fetchPageWithContext(ctx context.Context, pageSize int, pageToken string) *userPage {
 	query := make(url.Values)
 	query.Set("maxResults", strconv.Itoa(pageSize))
 	if pageToken != "" {
@@ -81,7 +220,7 @@ func (it *UserIterator) fetch(pageSize int, pageToken string) (string, error) {
 
 	url, err := it.client.makeUserMgtURL(fmt.Sprintf("/accounts:batchGet?%s", query.Encode()))
 	if err != nil {
-		return "", err
+		return &userPage{err: err}
 	}
 
 	req := &internal.Request{
@@ -92,20 +231,21 @@ func (it *UserIterator) fetch(pageSize int, pageToken string) (string, error) {
 		Users         []userQueryResponse `json:"users"`
 		NextPageToken string              `json:"nextPageToken"`
 	}
-	_, err = it.client.httpClient.DoAndUnmarshal(it.ctx, req, &parsed)
+	_, err = it.client.httpClient.DoAndUnmarshal(ctx, req, &parsed)
 	if err != nil {
-		return "", err
+		return &userPage{err: err}
 	}
 
+	page := &userPage{nextPageToken: parsed.NextPageToken}
 	for _, u := range parsed.Users {
-		eu, err := u.makeExportedUserRecord()
+		eu, err := u.makeExportedUserRecord(it.client.httpClient.Logger)
 		if err != nil {
-			return "", err
+			page.err = err
+			return page
 		}
-		it.users = append(it.users, eu)
+		page.users = append(page.users, eu)
 	}
-	it.pageInfo.Token = parsed.NextPageToken
-	return parsed.NextPageToken, nil
+	return page
 }
 
 // ExportedUserRecord is the returned user value used when listing all the users.
@@ -0,0 +1,357 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	maxGetUsersIdentifiers = 100
+
+	// defaultGetUsersConcurrency is the number of lookup requests made concurrently by
+	// GetUsersWithOptions when no limit is specified.
+	defaultGetUsersConcurrency = 10
+)
+
+// UserIdentifier identifies a user to be looked up by GetUsers.
+//
+// It is implemented by UIDIdentifier, EmailIdentifier, PhoneIdentifier and ProviderIdentifier.
+// All implementations are comparable, and can therefore be used as map keys.
+type UserIdentifier interface {
+	validate() error
+	matches(r *UserRecord) bool
+	populate(req *getAccountInfoRequest)
+}
+
+// UIDIdentifier identifies a user by user ID.
+type UIDIdentifier struct {
+	UID string
+}
+
+func (id UIDIdentifier) validate() error {
+	return validateUID(id.UID)
+}
+
+func (id UIDIdentifier) matches(r *UserRecord) bool {
+	return id.UID == r.UID
+}
+
+func (id UIDIdentifier) populate(req *getAccountInfoRequest) {
+	req.LocalID = append(req.LocalID, id.UID)
+}
+
+// EmailIdentifier identifies a user by email address. Email addresses are matched case-insensitively.
+type EmailIdentifier struct {
+	Email string
+}
+
+func (id EmailIdentifier) validate() error {
+	return validateEmail(id.Email)
+}
+
+func (id EmailIdentifier) matches(r *UserRecord) bool {
+	return strings.EqualFold(id.Email, r.Email)
+}
+
+func (id EmailIdentifier) populate(req *getAccountInfoRequest) {
+	req.Email = append(req.Email, id.Email)
+}
+
+// PhoneIdentifier identifies a user by phone number.
+type PhoneIdentifier struct {
+	PhoneNumber string
+}
+
+func (id PhoneIdentifier) validate() error {
+	return validatePhone(id.PhoneNumber)
+}
+
+func (id PhoneIdentifier) matches(r *UserRecord) bool {
+	return id.PhoneNumber == r.PhoneNumber
+}
+
+func (id PhoneIdentifier) populate(req *getAccountInfoRequest) {
+	req.PhoneNumber = append(req.PhoneNumber, id.PhoneNumber)
+}
+
+// ProviderIdentifier identifies a user by the ID of a federated identity provider (e.g. "google.com"), and
+// the user's ID at that provider.
+type ProviderIdentifier struct {
+	ProviderID  string
+	ProviderUID string
+}
+
+func (id ProviderIdentifier) validate() error {
+	if id.ProviderID == "" {
+		return errors.New("provider id must be a non-empty string")
+	}
+	if id.ProviderUID == "" {
+		return errors.New("provider uid must be a non-empty string")
+	}
+	return nil
+}
+
+func (id ProviderIdentifier) matches(r *UserRecord) bool {
+	for _, info := range r.ProviderUserInfo {
+		if info.ProviderID == id.ProviderID && info.UID == id.ProviderUID {
+			return true
+		}
+	}
+	return false
+}
+
+func (id ProviderIdentifier) populate(req *getAccountInfoRequest) {
+	req.FederatedUserID = append(req.FederatedUserID, federatedUserIdentifier{
+		ProviderID: id.ProviderID,
+		RawID:      id.ProviderUID,
+	})
+}
+
+// GetUsersResult represents the result of the GetUsers function.
+//
+// Users contains the users that were found, ordered by the position of the first identifier that
+// matched each of them in the input of GetUsers. A user matched by more than one identifier
+// appears only once. NotFound contains the identifiers that did not match any user, in input
+// order.
+//
+// Lookup and the Found map associate each input identifier with the user it matched, so that
+// results can be matched back to the input without scanning Users.
+type GetUsersResult struct {
+	Users    []*UserRecord
+	NotFound []UserIdentifier
+	Found    map[UserIdentifier]*UserRecord
+}
+
+// Lookup returns the user matched by the given identifier, and whether there was one. The identifier
+// must be equal to one of the identifiers passed into GetUsers.
+func (r *GetUsersResult) Lookup(id UserIdentifier) (*UserRecord, bool) {
+	user, ok := r.Found[id]
+	return user, ok
+}
+
+type federatedUserIdentifier struct {
+	ProviderID string `json:"providerId,omitempty"`
+	RawID      string `json:"rawId,omitempty"`
+}
+
+type getAccountInfoRequest struct {
+	LocalID         []string                  `json:"localId,omitempty"`
+	Email           []string                  `json:"email,omitempty"`
+	PhoneNumber     []string                  `json:"phoneNumber,omitempty"`
+	FederatedUserID []federatedUserIdentifier `json:"federatedUserId,omitempty"`
+}
+
+// GetUsers gets the users corresponding to the specified identifiers.
+//
+// There are no ordering guarantees from the backend; in particular, the nth entry in the users
+// returned by the backend is not necessarily the user matched by the nth identifier. GetUsers
+// therefore reorders the users to follow the input (see GetUsersResult for details). Identifiers
+// that do not match any user do not cause an error, and are reported in the NotFound field of the
+// result instead.
+//
+// A maximum of 100 identifiers may be specified. If more than 100 identifiers are specified, or
+// any of them is invalid, GetUsers returns an error without making any requests.
+func (c *userManagementClient) GetUsers(
+	ctx context.Context, identifiers []UserIdentifier) (*GetUsersResult, error) {
+	if len(identifiers) == 0 {
+		return &GetUsersResult{Found: make(map[UserIdentifier]*UserRecord)}, nil
+	}
+	if len(identifiers) > maxGetUsersIdentifiers {
+		return nil, fmt.Errorf(
+			"identifiers must not contain more than %d elements; got %d", maxGetUsersIdentifiers, len(identifiers))
+	}
+
+	if err := validateUserIdentifiers(identifiers); err != nil {
+		return nil, err
+	}
+
+	users, err := c.lookupUserBatch(ctx, identifiers)
+	if err != nil {
+		return nil, err
+	}
+	return newGetUsersResult(identifiers, users), nil
+}
+
+// GetUsersOptions specifies additional options for the GetUsersWithOptions function.
+type GetUsersOptions struct {
+	// MaxConcurrency is the maximum number of lookup requests in flight at the same time. Defaults to 10
+	// when not positive.
+	MaxConcurrency int
+}
+
+// GetUsersWithOptions gets the users corresponding to the specified identifiers, without limiting the
+// number of identifiers.
+//
+// The identifiers are split into batches of 100, which are looked up concurrently with at most
+// opts.MaxConcurrency requests in flight at a time. The results are merged into a single GetUsersResult
+// covering the whole input, as if GetUsers had been called with all the identifiers at once. A user
+// matched by identifiers in different batches appears only once in Users.
+//
+// If any of the identifiers is invalid, GetUsersWithOptions returns an error without making any requests.
+// If a lookup fails, or ctx is canceled, the remaining lookups are abandoned and the error is returned.
+func (c *userManagementClient) GetUsersWithOptions(
+	ctx context.Context, identifiers []UserIdentifier, opts *GetUsersOptions) (*GetUsersResult, error) {
+	if err := validateUserIdentifiers(identifiers); err != nil {
+		return nil, err
+	}
+
+	var batches [][]UserIdentifier
+	for start := 0; start < len(identifiers); start += maxGetUsersIdentifiers {
+		end := start + maxGetUsersIdentifiers
+		if end > len(identifiers) {
+			end = len(identifiers)
+		}
+		batches = append(batches, identifiers[start:end])
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	results := make([]*GetUsersResult, len(batches))
+	forEachConcurrently(len(batches), opts.concurrency(), func(i int) {
+		if ctx.Err() != nil {
+			return
+		}
+		users, err := c.lookupUserBatch(ctx, batches[i])
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			cancel()
+			return
+		}
+		results[i] = newGetUsersResult(batches[i], users)
+	})
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return mergeGetUsersResults(results), nil
+}
+
+func (opts *GetUsersOptions) concurrency() int {
+	if opts != nil && opts.MaxConcurrency > 0 {
+		return opts.MaxConcurrency
+	}
+	return defaultGetUsersConcurrency
+}
+
+func validateUserIdentifiers(identifiers []UserIdentifier) error {
+	for idx, id := range identifiers {
+		if id == nil {
+			return fmt.Errorf("identifier at index %d must not be nil", idx)
+		}
+		if err := id.validate(); err != nil {
+			return fmt.Errorf("invalid identifier at index %d: %v", idx, err)
+		}
+	}
+	return nil
+}
+
+// lookupUserBatch makes a single lookup request for the given identifiers, which must already be validated.
+func (c *userManagementClient) lookupUserBatch(
+	ctx context.Context, identifiers []UserIdentifier) ([]*UserRecord, error) {
+	var request getAccountInfoRequest
+	for _, id := range identifiers {
+		id.populate(&request)
+	}
+
+	var parsed struct {
+		Users []*userQueryResponse `json:"users"`
+	}
+	if _, err := c.post(ctx, "/accounts:lookup", request, &parsed); err != nil {
+		return nil, err
+	}
+
+	var users []*UserRecord
+	for _, u := range parsed.Users {
+		user, err := u.makeUserRecord(c.httpClient.Logger)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func newGetUsersResult(identifiers []UserIdentifier, users []*UserRecord) *GetUsersResult {
+	result := &GetUsersResult{
+		Found: make(map[UserIdentifier]*UserRecord),
+	}
+	added := make(map[*UserRecord]bool)
+	for _, id := range identifiers {
+		var match *UserRecord
+		for _, user := range users {
+			if id.matches(user) {
+				match = user
+				break
+			}
+		}
+		if match == nil {
+			result.NotFound = append(result.NotFound, id)
+			continue
+		}
+		result.Found[id] = match
+		if !added[match] {
+			added[match] = true
+			result.Users = append(result.Users, match)
+		}
+	}
+
+	// Users that do not match any identifier should not be returned by the backend, but are kept
+	// rather than silently dropped.
+	for _, user := range users {
+		if !added[user] {
+			result.Users = append(result.Users, user)
+		}
+	}
+	return result
+}
+
+// mergeGetUsersResults merges the results of consecutive batches of identifiers. Users matched in more
+// than one batch are identified by their UID, and only the record from the first batch is kept.
+func mergeGetUsersResults(results []*GetUsersResult) *GetUsersResult {
+	merged := &GetUsersResult{
+		Found: make(map[UserIdentifier]*UserRecord),
+	}
+	byUID := make(map[string]*UserRecord)
+	for _, r := range results {
+		for _, user := range r.Users {
+			if _, ok := byUID[user.UID]; !ok {
+				byUID[user.UID] = user
+				merged.Users = append(merged.Users, user)
+			}
+		}
+		for id, user := range r.Found {
+			merged.Found[id] = byUID[user.UID]
+		}
+		merged.NotFound = append(merged.NotFound, r.NotFound...)
+	}
+	return merged
+}
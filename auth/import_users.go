@@ -19,6 +19,8 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
 
 	"firebase.google.com/go/internal"
 )
@@ -49,7 +51,9 @@ type ErrorInfo struct {
 // ImportUsers imports an array of users to Firebase Auth.
 //
 // No more than 1000 users can be imported in a single call. If at least one user specifies a
-// password, a UserImportHash must be specified as an option.
+// password, a UserImportHash must be specified as an option. When the hash algorithm is salted
+// (scrypt, standard scrypt, PBKDF_SHA1 or PBKDF2_SHA256), every user with a password hash must also
+// specify a password salt; otherwise ImportUsers returns an error without importing any users.
 func (c *userManagementClient) ImportUsers(
 	ctx context.Context, users []*UserToImport, opts ...UserImportOption) (*UserImportResult, error) {
 
@@ -67,24 +71,16 @@ func (c *userManagementClient) ImportUsers(
 		if err != nil {
 			return nil, err
 		}
-		if pw, ok := vu["passwordHash"]; ok && pw != "" {
-			hashRequired = true
-		}
+		hashRequired = hashRequired || hasPasswordHash(vu)
 		validatedUsers = append(validatedUsers, vu)
 	}
 
-	req := map[string]interface{}{
-		"users": validatedUsers,
-	}
-	for _, opt := range opts {
-		if err := opt.applyTo(req); err != nil {
-			return nil, err
-		}
+	req, err := newImportRequest(validatedUsers, hashRequired, opts)
+	if err != nil {
+		return nil, err
 	}
-	if hashRequired {
-		if algo, ok := req["hashAlgorithm"]; !ok || algo == "" {
-			return nil, errors.New("hash algorithm option is required to import users with passwords")
-		}
+	if missing := usersMissingSalt(req); len(missing) > 0 {
+		return nil, fmt.Errorf("user at index %d: %v", missing[0], errMissingSalt(req))
 	}
 
 	var parsed struct {
@@ -93,8 +89,7 @@ func (c *userManagementClient) ImportUsers(
 			Message string `json:"message"`
 		} `json:"error,omitempty"`
 	}
-	_, err := c.post(ctx, "/accounts:batchCreate", req, &parsed)
-	if err != nil {
+	if _, err := c.post(ctx, "/accounts:batchCreate", req, &parsed); err != nil {
 		return nil, err
 	}
 
@@ -111,6 +106,200 @@ func (c *userManagementClient) ImportUsers(
 	return result, nil
 }
 
+// ValidateUsersToImport performs the same validations as ImportUsers on the given users and options, without
+// importing any users.
+//
+// This is useful for checking a large migration payload before importing it. Unlike ImportUsers,
+// ValidateUsersToImport accepts any number of users, and reports each invalid user in the returned
+// UserImportResult instead of failing on the first one. The Index of each ErrorInfo refers to the position of the
+// invalid user in the users array. An error is returned if the options are invalid, or if a user specifies a
+// password but no UserImportHash option is given.
+//
+// The backend API does not offer a validation-only mode. Therefore ValidateUsersToImport makes no requests, and
+// cannot detect failures that only the backend can determine, such as users with duplicate emails.
+func (c *userManagementClient) ValidateUsersToImport(
+	users []*UserToImport, opts ...UserImportOption) (*UserImportResult, error) {
+
+	if len(users) == 0 {
+		return nil, errors.New("users list must not be empty")
+	}
+
+	result := &UserImportResult{}
+	var validatedUsers []map[string]interface{}
+	var indices []int
+	hashRequired := false
+	for i, u := range users {
+		vu, err := u.validatedUserInfo()
+		if err != nil {
+			result.Errors = append(result.Errors, &ErrorInfo{
+				Index:  i,
+				Reason: err.Error(),
+			})
+			continue
+		}
+		hashRequired = hashRequired || hasPasswordHash(vu)
+		validatedUsers = append(validatedUsers, vu)
+		indices = append(indices, i)
+	}
+
+	req, err := newImportRequest(validatedUsers, hashRequired, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, idx := range usersMissingSalt(req) {
+		result.Errors = append(result.Errors, &ErrorInfo{
+			Index:  indices[idx],
+			Reason: errMissingSalt(req).Error(),
+		})
+	}
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Index < result.Errors[j].Index
+	})
+
+	result.FailureCount = len(result.Errors)
+	result.SuccessCount = len(users) - result.FailureCount
+	return result, nil
+}
+
+func hasPasswordHash(user map[string]interface{}) bool {
+	pw, ok := user["passwordHash"]
+	return ok && pw != ""
+}
+
+// saltedHashAlgorithms are the hash algorithms that require a salt for each user with a password hash.
+var saltedHashAlgorithms = map[string]bool{
+	"SCRYPT":          true,
+	"STANDARD_SCRYPT": true,
+	"PBKDF_SHA1":      true,
+	"PBKDF2_SHA256":   true,
+}
+
+// usersMissingSalt returns the indices of the users in the import request that have a password hash but no salt,
+// when the hash algorithm of the request requires one.
+func usersMissingSalt(req map[string]interface{}) []int {
+	algo, _ := req["hashAlgorithm"].(string)
+	if !saltedHashAlgorithms[algo] {
+		return nil
+	}
+
+	var missing []int
+	for i, user := range req["users"].([]map[string]interface{}) {
+		if salt, _ := user["salt"].(string); hasPasswordHash(user) && salt == "" {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+func errMissingSalt(req map[string]interface{}) error {
+	return fmt.Errorf("password salt is required for users with a password hash when using the %s hash algorithm",
+		req["hashAlgorithm"])
+}
+
+func newImportRequest(
+	users []map[string]interface{}, hashRequired bool, opts []UserImportOption) (map[string]interface{}, error) {
+
+	req := map[string]interface{}{
+		"users": users,
+	}
+	for _, opt := range opts {
+		if err := opt.applyTo(req); err != nil {
+			return nil, err
+		}
+	}
+	if hashRequired {
+		if algo, ok := req["hashAlgorithm"]; !ok || algo == "" {
+			return nil, errors.New("hash algorithm option is required to import users with passwords")
+		}
+	}
+	return req, nil
+}
+
+// BatchImportOptions specifies additional options for the ImportUsersInBatches function.
+type BatchImportOptions struct {
+	// ContinueOnError causes ImportUsersInBatches to proceed with the remaining batches when a batch fails.
+	// All the users of the failed batch are then reported as failures in the returned UserImportResult. By
+	// default ImportUsersInBatches stops at the first failed batch.
+	ContinueOnError bool
+
+	// BatchTimeout limits the time spent importing each batch of users, when positive. This allows a long-running
+	// import to use the context passed to ImportUsersInBatches for cancelling the whole job only, while still
+	// bounding the duration of each request. A batch that times out fails as a whole, and is handled according
+	// to ContinueOnError.
+	BatchTimeout time.Duration
+}
+
+// ImportUsersInBatches imports an arbitrary number of users to Firebase Auth.
+//
+// The users are imported in consecutive batches of at most 1000 users, by calling ImportUsers for each batch
+// with the given options. The results of all the batches are aggregated into a single UserImportResult, in which
+// the Index of each ErrorInfo refers to the position of the failed user in the users array passed to
+// ImportUsersInBatches.
+//
+// If a batch fails as a whole and batchOpts does not specify ContinueOnError, ImportUsersInBatches returns the
+// aggregated result of the preceding batches along with the error.
+func (c *userManagementClient) ImportUsersInBatches(
+	ctx context.Context, users []*UserToImport, batchOpts *BatchImportOptions,
+	opts ...UserImportOption) (*UserImportResult, error) {
+
+	if len(users) == 0 {
+		return nil, errors.New("users list must not be empty")
+	}
+
+	var (
+		continueOnError bool
+		batchTimeout    time.Duration
+	)
+	if batchOpts != nil {
+		continueOnError = batchOpts.ContinueOnError
+		batchTimeout = batchOpts.BatchTimeout
+	}
+	result := &UserImportResult{}
+	for start := 0; start < len(users); start += maxImportUsers {
+		end := start + maxImportUsers
+		if end > len(users) {
+			end = len(users)
+		}
+
+		batch, err := c.importBatch(ctx, batchTimeout, users[start:end], opts...)
+		if err != nil {
+			if !continueOnError {
+				return result, err
+			}
+			result.FailureCount += end - start
+			for i := start; i < end; i++ {
+				result.Errors = append(result.Errors, &ErrorInfo{
+					Index:  i,
+					Reason: err.Error(),
+				})
+			}
+			continue
+		}
+
+		result.SuccessCount += batch.SuccessCount
+		result.FailureCount += batch.FailureCount
+		for _, e := range batch.Errors {
+			result.Errors = append(result.Errors, &ErrorInfo{
+				Index:  start + e.Index,
+				Reason: e.Reason,
+			})
+		}
+	}
+	return result, nil
+}
+
+func (c *userManagementClient) importBatch(
+	ctx context.Context, timeout time.Duration, users []*UserToImport,
+	opts ...UserImportOption) (*UserImportResult, error) {
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return c.ImportUsers(ctx, users, opts...)
+}
+
 // UserToImport represents a user account that can be bulk imported into Firebase Auth.
 type UserToImport struct {
 	params map[string]interface{}
@@ -168,7 +357,13 @@ func (u *UserToImport) PasswordHash(password []byte) *UserToImport {
 	return u.set("passwordHash", base64.RawURLEncoding.EncodeToString(password))
 }
 
-// PasswordSalt setter.
+// PasswordSalt setter. A salt is required for every user with a password hash when the hash
+// algorithm is salted (see ImportUsers).
+//
+// The salt separator, which some algorithms (e.g. hash.Scrypt) insert between the password and the
+// salt, cannot be set per user. It is part of the hash configuration passed to WithHash, and applies
+// to all the users imported in the same call. Users with different salt separators must therefore be
+// imported in separate calls.
 func (u *UserToImport) PasswordSalt(salt []byte) *UserToImport {
 	return u.set("salt", base64.RawURLEncoding.EncodeToString(salt))
 }
@@ -208,7 +403,8 @@ func (u *UserToImport) validatedUserInfo() (map[string]interface{}, error) {
 		info[k] = v
 	}
 
-	if err := validateUID(info["localId"].(string)); err != nil {
+	uid, _ := info["localId"].(string)
+	if err := validateUID(uid); err != nil {
 		return nil, err
 	}
 	if email, ok := info["email"]; ok {
@@ -0,0 +1,428 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"firebase.google.com/go/internal"
+)
+
+const (
+	projectConfigEndpoint = "https://identitytoolkit.googleapis.com/v2"
+
+	passwordPolicyConfigKey       = "passwordPolicyConfig"
+	emailPrivacyConfigKey         = "emailPrivacyConfig"
+	enableImprovedEmailPrivacyKey = "emailPrivacyConfig.enableImprovedEmailPrivacy"
+	smsRegionConfigKey            = "smsRegionConfig"
+
+	defaultMinPasswordLength = 6
+	maxMinPasswordLength     = 30
+	defaultMaxPasswordLength = 4096
+)
+
+// PasswordPolicyEnforcementState is the enforcement state of a password policy.
+type PasswordPolicyEnforcementState string
+
+var regionCodePattern = regexp.MustCompile("^[A-Z]{2}$")
+
+const (
+	// EnforcePasswordPolicy rejects new passwords that do not satisfy the password policy.
+	EnforcePasswordPolicy PasswordPolicyEnforcementState = "ENFORCE"
+
+	// PasswordPolicyOff disables the password policy.
+	PasswordPolicyOff PasswordPolicyEnforcementState = "OFF"
+)
+
+// ProjectConfig represents the Firebase Auth settings of a project.
+type ProjectConfig struct {
+	PasswordPolicyConfig *PasswordPolicyConfig
+	EmailPrivacyConfig   *EmailPrivacyConfig
+	SMSRegionConfig      *SMSRegionConfig
+}
+
+// EmailPrivacyConfig contains the settings that protect the email addresses of users.
+//
+// When EnableImprovedEmailPrivacy is set, the backend no longer reveals whether an account exists for
+// a given email address: for example, fetching the sign-in methods of an email address returns an
+// empty result, and failed sign-ins report a generic invalid credential error. This protects against
+// email enumeration attacks.
+type EmailPrivacyConfig struct {
+	EnableImprovedEmailPrivacy bool
+}
+
+// PasswordPolicyConfig is the password policy applied to email/password users.
+//
+// When the policy is enforced, sign-ups and password updates with passwords that do not satisfy
+// Constraints are rejected. If ForceUpgradeOnSignin is set, existing users whose password does not
+// satisfy Constraints must also change it the next time they sign in.
+type PasswordPolicyConfig struct {
+	EnforcementState     PasswordPolicyEnforcementState
+	ForceUpgradeOnSignin bool
+	Constraints          *PasswordPolicyConstraints
+}
+
+// PasswordPolicyConstraints are the requirements a password must satisfy under a password policy.
+//
+// MinLength must be between 6 and 30, and defaults to 6 when zero. MaxLength must not be less than
+// MinLength or greater than 4096, and defaults to 4096 when zero.
+type PasswordPolicyConstraints struct {
+	MinLength              int
+	MaxLength              int
+	RequireUppercase       bool
+	RequireLowercase       bool
+	RequireNumeric         bool
+	RequireNonAlphanumeric bool
+}
+
+// SMSRegionConfig controls the regions to which SMS verification codes can be sent during phone
+// authentication, by the country code of the destination phone number.
+//
+// Exactly one of AllowByDefault and AllowlistOnly must be set. With AllowByDefault, SMS can be sent
+// to all regions except the listed ones. With AllowlistOnly, SMS can only be sent to the listed
+// regions. Regions are identified by their ISO 3166-1 alpha-2 codes in upper case, such as "US".
+// UpdateProjectConfig checks the format of the codes, while the backend rejects unassigned ones.
+type SMSRegionConfig struct {
+	AllowByDefault *AllowByDefault
+	AllowlistOnly  *AllowlistOnly
+}
+
+// AllowByDefault allows SMS to be sent to all regions except the DisallowedRegions.
+type AllowByDefault struct {
+	DisallowedRegions []string
+}
+
+// AllowlistOnly only allows SMS to be sent to the AllowedRegions.
+type AllowlistOnly struct {
+	AllowedRegions []string
+}
+
+// ProjectConfigToUpdate represents the options used to update the ProjectConfig.
+type ProjectConfigToUpdate struct {
+	params nestedMap
+}
+
+// PasswordPolicyConfig sets the password policy of the project. The new policy replaces the
+// existing one as a whole.
+func (config *ProjectConfigToUpdate) PasswordPolicyConfig(policy *PasswordPolicyConfig) *ProjectConfigToUpdate {
+	return config.set(passwordPolicyConfigKey, policy)
+}
+
+// EmailPrivacyConfig sets the email privacy settings of the project, including email enumeration
+// protection.
+func (config *ProjectConfigToUpdate) EmailPrivacyConfig(privacy *EmailPrivacyConfig) *ProjectConfigToUpdate {
+	return config.set(emailPrivacyConfigKey, privacy)
+}
+
+// SMSRegionConfig sets the regions to which SMS verification codes can be sent. The new config
+// replaces the existing one as a whole.
+func (config *ProjectConfigToUpdate) SMSRegionConfig(regions *SMSRegionConfig) *ProjectConfigToUpdate {
+	return config.set(smsRegionConfigKey, regions)
+}
+
+func (config *ProjectConfigToUpdate) set(key string, value interface{}) *ProjectConfigToUpdate {
+	if config.params == nil {
+		config.params = make(nestedMap)
+	}
+
+	config.params.Set(key, value)
+	return config
+}
+
+func (config *ProjectConfigToUpdate) buildRequest() (nestedMap, error) {
+	if len(config.params) == 0 {
+		return nil, errors.New("no parameters specified in the update request")
+	}
+
+	req := make(nestedMap)
+	if val, ok := config.params.Get(passwordPolicyConfigKey); ok {
+		policy, err := newPasswordPolicyConfigDAO(val.(*PasswordPolicyConfig))
+		if err != nil {
+			return nil, err
+		}
+		req.Set(passwordPolicyConfigKey, policy)
+	}
+
+	if val, ok := config.params.Get(emailPrivacyConfigKey); ok {
+		privacy := val.(*EmailPrivacyConfig)
+		if privacy == nil {
+			return nil, errors.New("EmailPrivacyConfig must not be nil")
+		}
+		req.Set(enableImprovedEmailPrivacyKey, privacy.EnableImprovedEmailPrivacy)
+	}
+
+	if val, ok := config.params.Get(smsRegionConfigKey); ok {
+		regions, err := newSMSRegionConfigDAO(val.(*SMSRegionConfig))
+		if err != nil {
+			return nil, err
+		}
+		req.Set(smsRegionConfigKey, regions)
+	}
+
+	return req, nil
+}
+
+type projectConfigClient struct {
+	endpoint   string
+	projectID  string
+	httpClient *internal.HTTPClient
+}
+
+func newProjectConfigClient(client *http.Client, conf *internal.AuthConfig) *projectConfigClient {
+	hc := internal.WithDefaultRetryConfig(client)
+	hc.CreateErrFn = handleHTTPError
+	hc.SuccessFn = internal.HasSuccessStatus
+	hc.Logger = conf.Logger
+	hc.RetryObserver = conf.RetryObserver
+	hc.Opts = []internal.HTTPOption{
+		internal.WithHeader("X-Client-Version", fmt.Sprintf("Go/Admin/%s", conf.Version)),
+	}
+
+	return &projectConfigClient{
+		endpoint:   projectConfigEndpoint,
+		projectID:  conf.ProjectID,
+		httpClient: hc,
+	}
+}
+
+// ProjectConfig returns the Firebase Auth settings of the project, such as its password policy.
+func (c *projectConfigClient) ProjectConfig(ctx context.Context) (*ProjectConfig, error) {
+	req := &internal.Request{
+		Method: http.MethodGet,
+	}
+	var result projectConfigDAO
+	if _, err := c.makeRequest(ctx, req, &result); err != nil {
+		return nil, err
+	}
+
+	return result.toProjectConfig(), nil
+}
+
+// UpdateProjectConfig updates the Firebase Auth settings of the project with the given parameters.
+func (c *projectConfigClient) UpdateProjectConfig(
+	ctx context.Context, config *ProjectConfigToUpdate) (*ProjectConfig, error) {
+	if config == nil {
+		return nil, errors.New("config must not be nil")
+	}
+
+	body, err := config.buildRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newUpdateRequest("", body)
+	if err != nil {
+		return nil, err
+	}
+	var result projectConfigDAO
+	if _, err := c.makeRequest(ctx, req, &result); err != nil {
+		return nil, err
+	}
+
+	return result.toProjectConfig(), nil
+}
+
+func (c *projectConfigClient) makeRequest(ctx context.Context, req *internal.Request, v interface{}) (*internal.Response, error) {
+	if c.projectID == "" {
+		return nil, errors.New("project id not available")
+	}
+
+	req.URL = fmt.Sprintf("%s/projects/%s/config", c.endpoint, c.projectID)
+	return c.httpClient.DoAndUnmarshal(ctx, req, v)
+}
+
+type customStrengthOptionsDAO struct {
+	MinPasswordLength                int  `json:"minPasswordLength,omitempty"`
+	MaxPasswordLength                int  `json:"maxPasswordLength,omitempty"`
+	ContainsUppercaseCharacter       bool `json:"containsUppercaseCharacter,omitempty"`
+	ContainsLowercaseCharacter       bool `json:"containsLowercaseCharacter,omitempty"`
+	ContainsNumericCharacter         bool `json:"containsNumericCharacter,omitempty"`
+	ContainsNonAlphanumericCharacter bool `json:"containsNonAlphanumericCharacter,omitempty"`
+}
+
+type passwordPolicyVersionDAO struct {
+	CustomStrengthOptions *customStrengthOptionsDAO `json:"customStrengthOptions,omitempty"`
+}
+
+type passwordPolicyConfigDAO struct {
+	EnforcementState     string                     `json:"passwordPolicyEnforcementState,omitempty"`
+	ForceUpgradeOnSignin bool                       `json:"forceUpgradeOnSignin,omitempty"`
+	Versions             []passwordPolicyVersionDAO `json:"passwordPolicyVersions,omitempty"`
+}
+
+func newPasswordPolicyConfigDAO(policy *PasswordPolicyConfig) (*passwordPolicyConfigDAO, error) {
+	if policy == nil {
+		return nil, errors.New("PasswordPolicyConfig must not be nil")
+	}
+	if policy.EnforcementState != EnforcePasswordPolicy && policy.EnforcementState != PasswordPolicyOff {
+		return nil, fmt.Errorf("invalid password policy enforcement state: %q", policy.EnforcementState)
+	}
+	if policy.EnforcementState == EnforcePasswordPolicy && policy.Constraints == nil {
+		return nil, errors.New("Constraints must be specified when the password policy is enforced")
+	}
+
+	dao := &passwordPolicyConfigDAO{
+		EnforcementState:     string(policy.EnforcementState),
+		ForceUpgradeOnSignin: policy.ForceUpgradeOnSignin,
+	}
+	if c := policy.Constraints; c != nil {
+		minLength := c.MinLength
+		if minLength == 0 {
+			minLength = defaultMinPasswordLength
+		}
+		if minLength < defaultMinPasswordLength || minLength > maxMinPasswordLength {
+			return nil, fmt.Errorf("MinLength must be between %d and %d; got %d",
+				defaultMinPasswordLength, maxMinPasswordLength, c.MinLength)
+		}
+		maxLength := c.MaxLength
+		if maxLength == 0 {
+			maxLength = defaultMaxPasswordLength
+		}
+		if maxLength < minLength || maxLength > defaultMaxPasswordLength {
+			return nil, fmt.Errorf("MaxLength must be between MinLength and %d; got %d",
+				defaultMaxPasswordLength, c.MaxLength)
+		}
+		dao.Versions = []passwordPolicyVersionDAO{
+			{
+				CustomStrengthOptions: &customStrengthOptionsDAO{
+					MinPasswordLength:                minLength,
+					MaxPasswordLength:                maxLength,
+					ContainsUppercaseCharacter:       c.RequireUppercase,
+					ContainsLowercaseCharacter:       c.RequireLowercase,
+					ContainsNumericCharacter:         c.RequireNumeric,
+					ContainsNonAlphanumericCharacter: c.RequireNonAlphanumeric,
+				},
+			},
+		}
+	}
+
+	return dao, nil
+}
+
+func (dao *passwordPolicyConfigDAO) toPasswordPolicyConfig() *PasswordPolicyConfig {
+	policy := &PasswordPolicyConfig{
+		EnforcementState:     PasswordPolicyEnforcementState(dao.EnforcementState),
+		ForceUpgradeOnSignin: dao.ForceUpgradeOnSignin,
+	}
+	if len(dao.Versions) > 0 && dao.Versions[0].CustomStrengthOptions != nil {
+		opts := dao.Versions[0].CustomStrengthOptions
+		policy.Constraints = &PasswordPolicyConstraints{
+			MinLength:              opts.MinPasswordLength,
+			MaxLength:              opts.MaxPasswordLength,
+			RequireUppercase:       opts.ContainsUppercaseCharacter,
+			RequireLowercase:       opts.ContainsLowercaseCharacter,
+			RequireNumeric:         opts.ContainsNumericCharacter,
+			RequireNonAlphanumeric: opts.ContainsNonAlphanumericCharacter,
+		}
+	}
+
+	return policy
+}
+
+type allowByDefaultDAO struct {
+	DisallowedRegions []string `json:"disallowedRegions,omitempty"`
+}
+
+type allowlistOnlyDAO struct {
+	AllowedRegions []string `json:"allowedRegions,omitempty"`
+}
+
+type smsRegionConfigDAO struct {
+	AllowByDefault *allowByDefaultDAO `json:"allowByDefault,omitempty"`
+	AllowlistOnly  *allowlistOnlyDAO  `json:"allowlistOnly,omitempty"`
+}
+
+func newSMSRegionConfigDAO(regions *SMSRegionConfig) (*smsRegionConfigDAO, error) {
+	if regions == nil {
+		return nil, errors.New("SMSRegionConfig must not be nil")
+	}
+	if (regions.AllowByDefault == nil) == (regions.AllowlistOnly == nil) {
+		return nil, errors.New("exactly one of AllowByDefault or AllowlistOnly must be specified")
+	}
+
+	dao := &smsRegionConfigDAO{}
+	if regions.AllowByDefault != nil {
+		if err := validateRegionCodes(regions.AllowByDefault.DisallowedRegions); err != nil {
+			return nil, err
+		}
+		dao.AllowByDefault = &allowByDefaultDAO{
+			DisallowedRegions: regions.AllowByDefault.DisallowedRegions,
+		}
+	} else {
+		if err := validateRegionCodes(regions.AllowlistOnly.AllowedRegions); err != nil {
+			return nil, err
+		}
+		dao.AllowlistOnly = &allowlistOnlyDAO{
+			AllowedRegions: regions.AllowlistOnly.AllowedRegions,
+		}
+	}
+
+	return dao, nil
+}
+
+func validateRegionCodes(codes []string) error {
+	for _, code := range codes {
+		if !regionCodePattern.MatchString(code) {
+			return fmt.Errorf("invalid region code: %q; must be an upper case ISO 3166-1 alpha-2 code", code)
+		}
+	}
+
+	return nil
+}
+
+func (dao *smsRegionConfigDAO) toSMSRegionConfig() *SMSRegionConfig {
+	regions := &SMSRegionConfig{}
+	if dao.AllowByDefault != nil {
+		regions.AllowByDefault = &AllowByDefault{
+			DisallowedRegions: dao.AllowByDefault.DisallowedRegions,
+		}
+	}
+	if dao.AllowlistOnly != nil {
+		regions.AllowlistOnly = &AllowlistOnly{
+			AllowedRegions: dao.AllowlistOnly.AllowedRegions,
+		}
+	}
+
+	return regions
+}
+
+type projectConfigDAO struct {
+	PasswordPolicyConfig *passwordPolicyConfigDAO `json:"passwordPolicyConfig"`
+	EmailPrivacyConfig   *struct {
+		EnableImprovedEmailPrivacy bool `json:"enableImprovedEmailPrivacy"`
+	} `json:"emailPrivacyConfig"`
+	SMSRegionConfig *smsRegionConfigDAO `json:"smsRegionConfig"`
+}
+
+func (dao *projectConfigDAO) toProjectConfig() *ProjectConfig {
+	config := &ProjectConfig{}
+	if dao.PasswordPolicyConfig != nil {
+		config.PasswordPolicyConfig = dao.PasswordPolicyConfig.toPasswordPolicyConfig()
+	}
+	if dao.EmailPrivacyConfig != nil {
+		config.EmailPrivacyConfig = &EmailPrivacyConfig{
+			EnableImprovedEmailPrivacy: dao.EmailPrivacyConfig.EnableImprovedEmailPrivacy,
+		}
+	}
+	if dao.SMSRegionConfig != nil {
+		config.SMSRegionConfig = dao.SMSRegionConfig.toSMSRegionConfig()
+	}
+
+	return config
+}
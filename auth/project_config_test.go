@@ -0,0 +1,494 @@
+// Copyright 2019 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const projectConfigResponse = `{
+    "name": "projects/mock-project-id/config",
+    "passwordPolicyConfig": {
+        "passwordPolicyEnforcementState": "ENFORCE",
+        "forceUpgradeOnSignin": true,
+        "passwordPolicyVersions": [
+            {
+                "customStrengthOptions": {
+                    "minPasswordLength": 8,
+                    "maxPasswordLength": 32,
+                    "containsUppercaseCharacter": true,
+                    "containsNumericCharacter": true
+                }
+            }
+        ]
+    },
+    "emailPrivacyConfig": {
+        "enableImprovedEmailPrivacy": true
+    },
+    "smsRegionConfig": {
+        "allowlistOnly": {
+            "allowedRegions": ["US", "CA"]
+        }
+    }
+}`
+
+var projectConfig = &ProjectConfig{
+	PasswordPolicyConfig: &PasswordPolicyConfig{
+		EnforcementState:     EnforcePasswordPolicy,
+		ForceUpgradeOnSignin: true,
+		Constraints: &PasswordPolicyConstraints{
+			MinLength:        8,
+			MaxLength:        32,
+			RequireUppercase: true,
+			RequireNumeric:   true,
+		},
+	},
+	EmailPrivacyConfig: &EmailPrivacyConfig{
+		EnableImprovedEmailPrivacy: true,
+	},
+	SMSRegionConfig: &SMSRegionConfig{
+		AllowlistOnly: &AllowlistOnly{
+			AllowedRegions: []string{"US", "CA"},
+		},
+	},
+}
+
+func TestProjectConfig(t *testing.T) {
+	s := echoServer([]byte(projectConfigResponse), t)
+	defer s.Close()
+
+	config, err := s.Client.ProjectConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config, projectConfig) {
+		t.Errorf("ProjectConfig() = %#v; want = %#v", config, projectConfig)
+	}
+
+	req := s.Req[0]
+	if req.Method != http.MethodGet {
+		t.Errorf("ProjectConfig() Method = %q; want = %q", req.Method, http.MethodGet)
+	}
+
+	wantURL := "/projects/mock-project-id/config"
+	if req.URL.Path != wantURL {
+		t.Errorf("ProjectConfig() URL = %q; want = %q", req.URL.Path, wantURL)
+	}
+}
+
+func TestProjectConfigEmpty(t *testing.T) {
+	s := echoServer([]byte(`{"name": "projects/mock-project-id/config"}`), t)
+	defer s.Close()
+
+	config, err := s.Client.ProjectConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config, &ProjectConfig{}) {
+		t.Errorf("ProjectConfig() = %#v; want = %#v", config, &ProjectConfig{})
+	}
+}
+
+func TestProjectConfigError(t *testing.T) {
+	s := echoServer([]byte(notFoundResponse), t)
+	defer s.Close()
+	s.Status = http.StatusNotFound
+
+	config, err := s.Client.ProjectConfig(context.Background())
+	if config != nil || err == nil || !IsConfigurationNotFound(err) {
+		t.Errorf("ProjectConfig() = (%v, %v); want = (nil, ConfigurationNotFound)", config, err)
+	}
+}
+
+func TestProjectConfigNoProjectID(t *testing.T) {
+	client := &projectConfigClient{}
+	want := "project id not available"
+	if _, err := client.ProjectConfig(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("ProjectConfig() = %v; want = %q", err, want)
+	}
+}
+
+func TestUpdateProjectConfig(t *testing.T) {
+	s := echoServer([]byte(projectConfigResponse), t)
+	defer s.Close()
+
+	options := (&ProjectConfigToUpdate{}).
+		PasswordPolicyConfig(&PasswordPolicyConfig{
+			EnforcementState:     EnforcePasswordPolicy,
+			ForceUpgradeOnSignin: true,
+			Constraints: &PasswordPolicyConstraints{
+				MinLength:        8,
+				MaxLength:        32,
+				RequireUppercase: true,
+				RequireNumeric:   true,
+			},
+		})
+	config, err := s.Client.UpdateProjectConfig(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(config, projectConfig) {
+		t.Errorf("UpdateProjectConfig() = %#v; want = %#v", config, projectConfig)
+	}
+
+	wantBody := map[string]interface{}{
+		"passwordPolicyConfig": map[string]interface{}{
+			"passwordPolicyEnforcementState": "ENFORCE",
+			"forceUpgradeOnSignin":           true,
+			"passwordPolicyVersions": []interface{}{
+				map[string]interface{}{
+					"customStrengthOptions": map[string]interface{}{
+						"minPasswordLength":          float64(8),
+						"maxPasswordLength":          float64(32),
+						"containsUppercaseCharacter": true,
+						"containsNumericCharacter":   true,
+					},
+				},
+			},
+		},
+	}
+	if err := checkUpdateProjectConfigRequest(s, wantBody, []string{"passwordPolicyConfig"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateProjectConfigPasswordPolicyOff(t *testing.T) {
+	s := echoServer([]byte(`{"passwordPolicyConfig": {"passwordPolicyEnforcementState": "OFF"}}`), t)
+	defer s.Close()
+
+	options := (&ProjectConfigToUpdate{}).
+		PasswordPolicyConfig(&PasswordPolicyConfig{
+			EnforcementState: PasswordPolicyOff,
+		})
+	config, err := s.Client.UpdateProjectConfig(context.Background(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &ProjectConfig{
+		PasswordPolicyConfig: &PasswordPolicyConfig{
+			EnforcementState: PasswordPolicyOff,
+		},
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("UpdateProjectConfig() = %#v; want = %#v", config, want)
+	}
+
+	wantBody := map[string]interface{}{
+		"passwordPolicyConfig": map[string]interface{}{
+			"passwordPolicyEnforcementState": "OFF",
+		},
+	}
+	if err := checkUpdateProjectConfigRequest(s, wantBody, []string{"passwordPolicyConfig"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateProjectConfigDefaultLengths(t *testing.T) {
+	s := echoServer([]byte(projectConfigResponse), t)
+	defer s.Close()
+
+	options := (&ProjectConfigToUpdate{}).
+		PasswordPolicyConfig(&PasswordPolicyConfig{
+			EnforcementState: EnforcePasswordPolicy,
+			Constraints:      &PasswordPolicyConstraints{},
+		})
+	if _, err := s.Client.UpdateProjectConfig(context.Background(), options); err != nil {
+		t.Fatal(err)
+	}
+
+	wantBody := map[string]interface{}{
+		"passwordPolicyConfig": map[string]interface{}{
+			"passwordPolicyEnforcementState": "ENFORCE",
+			"passwordPolicyVersions": []interface{}{
+				map[string]interface{}{
+					"customStrengthOptions": map[string]interface{}{
+						"minPasswordLength": float64(6),
+						"maxPasswordLength": float64(4096),
+					},
+				},
+			},
+		},
+	}
+	if err := checkUpdateProjectConfigRequest(s, wantBody, []string{"passwordPolicyConfig"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateProjectConfigEmailPrivacy(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		s := echoServer([]byte(projectConfigResponse), t)
+		defer s.Close()
+
+		options := (&ProjectConfigToUpdate{}).
+			EmailPrivacyConfig(&EmailPrivacyConfig{EnableImprovedEmailPrivacy: enable})
+		if _, err := s.Client.UpdateProjectConfig(context.Background(), options); err != nil {
+			t.Fatal(err)
+		}
+
+		wantBody := map[string]interface{}{
+			"emailPrivacyConfig": map[string]interface{}{
+				"enableImprovedEmailPrivacy": enable,
+			},
+		}
+		wantMask := []string{"emailPrivacyConfig.enableImprovedEmailPrivacy"}
+		if err := checkUpdateProjectConfigRequest(s, wantBody, wantMask); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUpdateProjectConfigSMSRegions(t *testing.T) {
+	cases := []struct {
+		name    string
+		regions *SMSRegionConfig
+		want    map[string]interface{}
+	}{
+		{
+			name: "AllowByDefault",
+			regions: &SMSRegionConfig{
+				AllowByDefault: &AllowByDefault{DisallowedRegions: []string{"AQ", "BV"}},
+			},
+			want: map[string]interface{}{
+				"allowByDefault": map[string]interface{}{
+					"disallowedRegions": []interface{}{"AQ", "BV"},
+				},
+			},
+		},
+		{
+			name: "AllowByDefaultAllRegions",
+			regions: &SMSRegionConfig{
+				AllowByDefault: &AllowByDefault{},
+			},
+			want: map[string]interface{}{
+				"allowByDefault": map[string]interface{}{},
+			},
+		},
+		{
+			name: "AllowlistOnly",
+			regions: &SMSRegionConfig{
+				AllowlistOnly: &AllowlistOnly{AllowedRegions: []string{"US"}},
+			},
+			want: map[string]interface{}{
+				"allowlistOnly": map[string]interface{}{
+					"allowedRegions": []interface{}{"US"},
+				},
+			},
+		},
+	}
+	for _, tc := range cases {
+		s := echoServer([]byte(projectConfigResponse), t)
+		defer s.Close()
+
+		options := (&ProjectConfigToUpdate{}).
+			SMSRegionConfig(tc.regions)
+		if _, err := s.Client.UpdateProjectConfig(context.Background(), options); err != nil {
+			t.Fatalf("UpdateProjectConfig(%s) = %v", tc.name, err)
+		}
+
+		wantBody := map[string]interface{}{"smsRegionConfig": tc.want}
+		if err := checkUpdateProjectConfigRequest(s, wantBody, []string{"smsRegionConfig"}); err != nil {
+			t.Errorf("UpdateProjectConfig(%s): %v", tc.name, err)
+		}
+	}
+}
+
+func TestUpdateProjectConfigMultipleSettings(t *testing.T) {
+	s := echoServer([]byte(projectConfigResponse), t)
+	defer s.Close()
+
+	options := (&ProjectConfigToUpdate{}).
+		PasswordPolicyConfig(&PasswordPolicyConfig{EnforcementState: PasswordPolicyOff}).
+		EmailPrivacyConfig(&EmailPrivacyConfig{EnableImprovedEmailPrivacy: true})
+	if _, err := s.Client.UpdateProjectConfig(context.Background(), options); err != nil {
+		t.Fatal(err)
+	}
+
+	wantBody := map[string]interface{}{
+		"passwordPolicyConfig": map[string]interface{}{
+			"passwordPolicyEnforcementState": "OFF",
+		},
+		"emailPrivacyConfig": map[string]interface{}{
+			"enableImprovedEmailPrivacy": true,
+		},
+	}
+	wantMask := []string{"emailPrivacyConfig.enableImprovedEmailPrivacy", "passwordPolicyConfig"}
+	if err := checkUpdateProjectConfigRequest(s, wantBody, wantMask); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateProjectConfigInvalidInput(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+		conf *ProjectConfigToUpdate
+	}{
+		{
+			name: "NilConfig",
+			want: "config must not be nil",
+			conf: nil,
+		},
+		{
+			name: "Empty",
+			want: "no parameters specified in the update request",
+			conf: &ProjectConfigToUpdate{},
+		},
+		{
+			name: "NilPasswordPolicy",
+			want: "PasswordPolicyConfig must not be nil",
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(nil),
+		},
+		{
+			name: "NilEmailPrivacy",
+			want: "EmailPrivacyConfig must not be nil",
+			conf: (&ProjectConfigToUpdate{}).
+				EmailPrivacyConfig(nil),
+		},
+		{
+			name: "NilSMSRegions",
+			want: "SMSRegionConfig must not be nil",
+			conf: (&ProjectConfigToUpdate{}).
+				SMSRegionConfig(nil),
+		},
+		{
+			name: "NoSMSRegionMode",
+			want: "exactly one of AllowByDefault or AllowlistOnly must be specified",
+			conf: (&ProjectConfigToUpdate{}).
+				SMSRegionConfig(&SMSRegionConfig{}),
+		},
+		{
+			name: "BothSMSRegionModes",
+			want: "exactly one of AllowByDefault or AllowlistOnly must be specified",
+			conf: (&ProjectConfigToUpdate{}).
+				SMSRegionConfig(&SMSRegionConfig{
+					AllowByDefault: &AllowByDefault{},
+					AllowlistOnly:  &AllowlistOnly{},
+				}),
+		},
+		{
+			name: "LowerCaseRegion",
+			want: `invalid region code: "us"; must be an upper case ISO 3166-1 alpha-2 code`,
+			conf: (&ProjectConfigToUpdate{}).
+				SMSRegionConfig(&SMSRegionConfig{
+					AllowlistOnly: &AllowlistOnly{AllowedRegions: []string{"US", "us"}},
+				}),
+		},
+		{
+			name: "Alpha3Region",
+			want: `invalid region code: "USA"; must be an upper case ISO 3166-1 alpha-2 code`,
+			conf: (&ProjectConfigToUpdate{}).
+				SMSRegionConfig(&SMSRegionConfig{
+					AllowByDefault: &AllowByDefault{DisallowedRegions: []string{"USA"}},
+				}),
+		},
+		{
+			name: "InvalidEnforcementState",
+			want: `invalid password policy enforcement state: "ENABLED"`,
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{EnforcementState: "ENABLED"}),
+		},
+		{
+			name: "EnforcedWithoutConstraints",
+			want: "Constraints must be specified when the password policy is enforced",
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{EnforcementState: EnforcePasswordPolicy}),
+		},
+		{
+			name: "MinLengthTooSmall",
+			want: "MinLength must be between 6 and 30; got 5",
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{
+					EnforcementState: EnforcePasswordPolicy,
+					Constraints:      &PasswordPolicyConstraints{MinLength: 5},
+				}),
+		},
+		{
+			name: "MinLengthTooLarge",
+			want: "MinLength must be between 6 and 30; got 31",
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{
+					EnforcementState: EnforcePasswordPolicy,
+					Constraints:      &PasswordPolicyConstraints{MinLength: 31},
+				}),
+		},
+		{
+			name: "MaxLengthLessThanMinLength",
+			want: "MaxLength must be between MinLength and 4096; got 8",
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{
+					EnforcementState: EnforcePasswordPolicy,
+					Constraints:      &PasswordPolicyConstraints{MinLength: 10, MaxLength: 8},
+				}),
+		},
+		{
+			name: "MaxLengthTooLarge",
+			want: "MaxLength must be between MinLength and 4096; got 4097",
+			conf: (&ProjectConfigToUpdate{}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{
+					EnforcementState: EnforcePasswordPolicy,
+					Constraints:      &PasswordPolicyConstraints{MaxLength: 4097},
+				}),
+		},
+	}
+
+	client := &projectConfigClient{}
+	for _, tc := range cases {
+		_, err := client.UpdateProjectConfig(context.Background(), tc.conf)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("UpdateProjectConfig(%q) = %v; want = %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func checkUpdateProjectConfigRequest(s *mockAuthServer, wantBody interface{}, wantMask []string) error {
+	req := s.Req[0]
+	if req.Method != http.MethodPatch {
+		return fmt.Errorf("UpdateProjectConfig() Method = %q; want = %q", req.Method, http.MethodPatch)
+	}
+
+	wantURL := "/projects/mock-project-id/config"
+	if req.URL.Path != wantURL {
+		return fmt.Errorf("UpdateProjectConfig() URL = %q; want = %q", req.URL.Path, wantURL)
+	}
+
+	queryParam := req.URL.Query().Get("updateMask")
+	mask := strings.Split(queryParam, ",")
+	sort.Strings(mask)
+	if !reflect.DeepEqual(mask, wantMask) {
+		return fmt.Errorf("UpdateProjectConfig() Query = %#v; want = %#v", mask, wantMask)
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(s.Rbody, &body); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(body, wantBody) {
+		return fmt.Errorf("UpdateProjectConfig() Body = %#v; want = %#v", body, wantBody)
+	}
+
+	return nil
+}
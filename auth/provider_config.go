@@ -16,12 +16,15 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"firebase.google.com/go/internal"
 	"google.golang.org/api/iterator"
@@ -32,6 +35,10 @@ const (
 
 	maxConfigs = 100
 
+	// defaultProviderConfigUpdateConcurrency is the number of configs updated concurrently by
+	// UpdateOIDCProviderConfigs and UpdateSAMLProviderConfigs when no limit is specified.
+	defaultProviderConfigUpdateConcurrency = 10
+
 	idpEntityIDKey = "idpConfig.idpEntityId"
 	ssoURLKey      = "idpConfig.ssoUrl"
 	signRequestKey = "idpConfig.signRequest"
@@ -92,8 +99,31 @@ func (nm nestedMap) Set(key string, value interface{}) {
 	}
 }
 
+// UpdateMask returns the paths of all the leaf values in the nestedMap, in sorted order. Nested maps
+// are traversed, while all other values (including structs and slices) are treated as leaves.
 func (nm nestedMap) UpdateMask() ([]string, error) {
-	return buildMask(nm), nil
+	mask := buildMask(nm)
+	sort.Strings(mask)
+	return mask, nil
+}
+
+// newUpdateRequest creates a PATCH request that updates the fields set in the given body, and no
+// others, of the resource at the given URL. It is shared by all the config update functions, so that
+// new config fields only need a key and a setter on the corresponding builder.
+func newUpdateRequest(url string, body nestedMap) (*internal.Request, error) {
+	mask, err := body.UpdateMask()
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct update mask: %v", err)
+	}
+
+	return &internal.Request{
+		Method: http.MethodPatch,
+		URL:    url,
+		Body:   internal.NewJSONEntity(body),
+		Opts: []internal.HTTPOption{
+			internal.WithQueryParam("updateMask", strings.Join(mask, ",")),
+		},
+	}, nil
 }
 
 func buildMask(data map[string]interface{}) []string {
@@ -114,12 +144,21 @@ func buildMask(data map[string]interface{}) []string {
 
 // OIDCProviderConfig is the OIDC auth provider configuration.
 // See https://openid.net/specs/openid-connect-core-1_0-final.html.
+//
+// The oauthIdpConfigs backend resource does not store OAuth scopes or custom authorization parameters. These are
+// specified by the client app when it starts the sign-in flow (e.g. with the addScope and setCustomParameters
+// methods of OAuthProvider in the Firebase JavaScript SDK), and therefore cannot be set on a provider config.
 type OIDCProviderConfig struct {
 	ID          string
 	DisplayName string
 	Enabled     bool
 	ClientID    string
 	Issuer      string
+
+	// RawJSON holds the JSON representation of the config, exactly as returned by the backend. It
+	// can be used to inspect attributes that are not yet modeled by this struct. It is not parsed
+	// by the SDK.
+	RawJSON json.RawMessage
 }
 
 // OIDCProviderConfigToCreate represents the options used to create a new OIDCProviderConfig.
@@ -255,6 +294,11 @@ type OIDCProviderConfigIterator struct {
 }
 
 // PageInfo supports pagination.
+//
+// The Token field of the returned PageInfo holds the token of the next page to be fetched, and its Remaining
+// method reports how many already fetched configs have not been returned by Next yet. The iterator can also be
+// passed to iterator.NewPager for cursor-based pagination. The total number of configs is not reported by the
+// backend, and therefore is not available.
 func (it *OIDCProviderConfigIterator) PageInfo() *iterator.PageInfo {
 	return it.pageInfo
 }
@@ -316,6 +360,11 @@ type SAMLProviderConfig struct {
 	X509Certificates      []string
 	RPEntityID            string
 	CallbackURL           string
+
+	// RawJSON holds the JSON representation of the config, exactly as returned by the backend. It
+	// can be used to inspect attributes that are not yet modeled by this struct. It is not parsed
+	// by the SDK.
+	RawJSON json.RawMessage
 }
 
 // SAMLProviderConfigToCreate represents the options used to create a new SAMLProviderConfig.
@@ -546,6 +595,11 @@ type SAMLProviderConfigIterator struct {
 }
 
 // PageInfo supports pagination.
+//
+// The Token field of the returned PageInfo holds the token of the next page to be fetched, and its Remaining
+// method reports how many already fetched configs have not been returned by Next yet. The iterator can also be
+// passed to iterator.NewPager for cursor-based pagination. The total number of configs is not reported by the
+// backend, and therefore is not available.
 func (it *SAMLProviderConfigIterator) PageInfo() *iterator.PageInfo {
 	return it.pageInfo
 }
@@ -605,6 +659,8 @@ func newProviderConfigClient(client *http.Client, conf *internal.AuthConfig) *pr
 	hc := internal.WithDefaultRetryConfig(client)
 	hc.CreateErrFn = handleHTTPError
 	hc.SuccessFn = internal.HasSuccessStatus
+	hc.Logger = conf.Logger
+	hc.RetryObserver = conf.RetryObserver
 	hc.Opts = []internal.HTTPOption{
 		internal.WithHeader("X-Client-Version", fmt.Sprintf("Go/Admin/%s", conf.Version)),
 	}
@@ -634,6 +690,21 @@ func (c *providerConfigClient) OIDCProviderConfig(ctx context.Context, id string
 	return result.toOIDCProviderConfig(), nil
 }
 
+// OIDCProviderConfigExists checks whether an OIDC provider config with the given ID exists.
+//
+// Unlike OIDCProviderConfig, OIDCProviderConfigExists reports a missing config as (false, nil) rather than as an
+// error. A non-nil error is only returned when the existence of the config could not be determined.
+func (c *providerConfigClient) OIDCProviderConfigExists(ctx context.Context, id string) (bool, error) {
+	if _, err := c.OIDCProviderConfig(ctx, id); err != nil {
+		if IsConfigurationNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 // CreateOIDCProviderConfig creates a new OIDC provider config from the given parameters.
 func (c *providerConfigClient) CreateOIDCProviderConfig(ctx context.Context, config *OIDCProviderConfigToCreate) (*OIDCProviderConfig, error) {
 	if config == nil {
@@ -661,6 +732,20 @@ func (c *providerConfigClient) CreateOIDCProviderConfig(ctx context.Context, con
 	return result.toOIDCProviderConfig(), nil
 }
 
+// CreateOrGetOIDCProviderConfig creates a new OIDC provider config with the given parameters, or returns the
+// existing config if one with the same ID already exists.
+//
+// This makes provisioning safe to retry after a failure in which it is unclear whether the config was created
+// (e.g. a network error). The existing config is returned as is, without comparing it with the given parameters.
+func (c *providerConfigClient) CreateOrGetOIDCProviderConfig(
+	ctx context.Context, config *OIDCProviderConfigToCreate) (*OIDCProviderConfig, error) {
+	created, err := c.CreateOIDCProviderConfig(ctx, config)
+	if IsConfigurationAlreadyExists(err) {
+		return c.OIDCProviderConfig(ctx, config.id)
+	}
+	return created, err
+}
+
 // UpdateOIDCProviderConfig updates an existing OIDC provider config with the given parameters.
 func (c *providerConfigClient) UpdateOIDCProviderConfig(ctx context.Context, id string, config *OIDCProviderConfigToUpdate) (*OIDCProviderConfig, error) {
 	if err := validateOIDCConfigID(id); err != nil {
@@ -675,18 +760,9 @@ func (c *providerConfigClient) UpdateOIDCProviderConfig(ctx context.Context, id
 		return nil, err
 	}
 
-	mask, err := body.UpdateMask()
+	req, err := newUpdateRequest(fmt.Sprintf("/oauthIdpConfigs/%s", id), body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct update mask: %v", err)
-	}
-
-	req := &internal.Request{
-		Method: http.MethodPatch,
-		URL:    fmt.Sprintf("/oauthIdpConfigs/%s", id),
-		Body:   internal.NewJSONEntity(body),
-		Opts: []internal.HTTPOption{
-			internal.WithQueryParam("updateMask", strings.Join(mask, ",")),
-		},
+		return nil, err
 	}
 	var result oidcProviderConfigDAO
 	if _, err := c.makeRequest(ctx, req, &result); err != nil {
@@ -696,6 +772,65 @@ func (c *providerConfigClient) UpdateOIDCProviderConfig(ctx context.Context, id
 	return result.toOIDCProviderConfig(), nil
 }
 
+// ProviderConfigUpdateOptions specifies additional options for the UpdateOIDCProviderConfigs and
+// UpdateSAMLProviderConfigs functions.
+type ProviderConfigUpdateOptions struct {
+	// MaxConcurrency is the maximum number of configs updated at the same time. Defaults to 10 when
+	// not positive.
+	MaxConcurrency int
+}
+
+// OIDCProviderConfigUpdateResult is the outcome of updating a single OIDC provider config.
+type OIDCProviderConfigUpdateResult struct {
+	ID     string
+	Config *OIDCProviderConfig
+	Error  error
+}
+
+// UpdateOIDCProviderConfigs applies the same update to all the OIDC provider configs with the given IDs.
+//
+// Configs are updated concurrently, with at most opts.MaxConcurrency requests in flight at a time. The
+// returned slice contains one OIDCProviderConfigUpdateResult per input ID, in the same order as the input.
+// Failures to update individual configs are reported in the corresponding result and do not stop the
+// other configs from being updated. A non-nil error is only returned when the shared update is invalid,
+// in which case no requests are made.
+func (c *providerConfigClient) UpdateOIDCProviderConfigs(
+	ctx context.Context, ids []string, config *OIDCProviderConfigToUpdate, opts *ProviderConfigUpdateOptions) (
+	[]*OIDCProviderConfigUpdateResult, error) {
+
+	if config == nil {
+		return nil, errors.New("config must not be nil")
+	}
+	if _, err := config.buildRequest(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*OIDCProviderConfigUpdateResult, len(ids))
+	forEachConcurrently(len(ids), opts.concurrency(), func(i int) {
+		updated, err := c.UpdateOIDCProviderConfig(ctx, ids[i], config)
+		results[i] = &OIDCProviderConfigUpdateResult{
+			ID:     ids[i],
+			Config: updated,
+			Error:  err,
+		}
+	})
+	return results, nil
+}
+
+// EnableOIDCProviderConfig enables the OIDCProviderConfig with the given ID.
+//
+// This is a shorthand for calling UpdateOIDCProviderConfig with only the Enabled field set.
+func (c *providerConfigClient) EnableOIDCProviderConfig(ctx context.Context, id string) (*OIDCProviderConfig, error) {
+	return c.UpdateOIDCProviderConfig(ctx, id, (&OIDCProviderConfigToUpdate{}).Enabled(true))
+}
+
+// DisableOIDCProviderConfig disables the OIDCProviderConfig with the given ID.
+//
+// This is a shorthand for calling UpdateOIDCProviderConfig with only the Enabled field set.
+func (c *providerConfigClient) DisableOIDCProviderConfig(ctx context.Context, id string) (*OIDCProviderConfig, error) {
+	return c.UpdateOIDCProviderConfig(ctx, id, (&OIDCProviderConfigToUpdate{}).Enabled(false))
+}
+
 // DeleteOIDCProviderConfig deletes the OIDCProviderConfig with the given ID.
 func (c *providerConfigClient) DeleteOIDCProviderConfig(ctx context.Context, id string) error {
 	if err := validateOIDCConfigID(id); err != nil {
@@ -746,6 +881,21 @@ func (c *providerConfigClient) SAMLProviderConfig(ctx context.Context, id string
 	return result.toSAMLProviderConfig(), nil
 }
 
+// SAMLProviderConfigExists checks whether a SAML provider config with the given ID exists.
+//
+// Unlike SAMLProviderConfig, SAMLProviderConfigExists reports a missing config as (false, nil) rather than as an
+// error. A non-nil error is only returned when the existence of the config could not be determined.
+func (c *providerConfigClient) SAMLProviderConfigExists(ctx context.Context, id string) (bool, error) {
+	if _, err := c.SAMLProviderConfig(ctx, id); err != nil {
+		if IsConfigurationNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
 // CreateSAMLProviderConfig creates a new SAML provider config from the given parameters.
 func (c *providerConfigClient) CreateSAMLProviderConfig(ctx context.Context, config *SAMLProviderConfigToCreate) (*SAMLProviderConfig, error) {
 	if config == nil {
@@ -773,6 +923,20 @@ func (c *providerConfigClient) CreateSAMLProviderConfig(ctx context.Context, con
 	return result.toSAMLProviderConfig(), nil
 }
 
+// CreateOrGetSAMLProviderConfig creates a new SAML provider config with the given parameters, or returns the
+// existing config if one with the same ID already exists.
+//
+// This makes provisioning safe to retry after a failure in which it is unclear whether the config was created
+// (e.g. a network error). The existing config is returned as is, without comparing it with the given parameters.
+func (c *providerConfigClient) CreateOrGetSAMLProviderConfig(
+	ctx context.Context, config *SAMLProviderConfigToCreate) (*SAMLProviderConfig, error) {
+	created, err := c.CreateSAMLProviderConfig(ctx, config)
+	if IsConfigurationAlreadyExists(err) {
+		return c.SAMLProviderConfig(ctx, config.id)
+	}
+	return created, err
+}
+
 // UpdateSAMLProviderConfig updates an existing SAML provider config with the given parameters.
 func (c *providerConfigClient) UpdateSAMLProviderConfig(ctx context.Context, id string, config *SAMLProviderConfigToUpdate) (*SAMLProviderConfig, error) {
 	if err := validateSAMLConfigID(id); err != nil {
@@ -787,18 +951,9 @@ func (c *providerConfigClient) UpdateSAMLProviderConfig(ctx context.Context, id
 		return nil, err
 	}
 
-	mask, err := body.UpdateMask()
+	req, err := newUpdateRequest(fmt.Sprintf("/inboundSamlConfigs/%s", id), body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to construct update mask: %v", err)
-	}
-
-	req := &internal.Request{
-		Method: http.MethodPatch,
-		URL:    fmt.Sprintf("/inboundSamlConfigs/%s", id),
-		Body:   internal.NewJSONEntity(body),
-		Opts: []internal.HTTPOption{
-			internal.WithQueryParam("updateMask", strings.Join(mask, ",")),
-		},
+		return nil, err
 	}
 	var result samlProviderConfigDAO
 	if _, err := c.makeRequest(ctx, req, &result); err != nil {
@@ -808,6 +963,58 @@ func (c *providerConfigClient) UpdateSAMLProviderConfig(ctx context.Context, id
 	return result.toSAMLProviderConfig(), nil
 }
 
+// SAMLProviderConfigUpdateResult is the outcome of updating a single SAML provider config.
+type SAMLProviderConfigUpdateResult struct {
+	ID     string
+	Config *SAMLProviderConfig
+	Error  error
+}
+
+// UpdateSAMLProviderConfigs applies the same update to all the SAML provider configs with the given IDs.
+// This can be used, for example, to roll out a rotated IdP certificate to several providers at once.
+//
+// Configs are updated concurrently, with at most opts.MaxConcurrency requests in flight at a time. The
+// returned slice contains one SAMLProviderConfigUpdateResult per input ID, in the same order as the input.
+// Failures to update individual configs are reported in the corresponding result and do not stop the
+// other configs from being updated. A non-nil error is only returned when the shared update is invalid,
+// in which case no requests are made.
+func (c *providerConfigClient) UpdateSAMLProviderConfigs(
+	ctx context.Context, ids []string, config *SAMLProviderConfigToUpdate, opts *ProviderConfigUpdateOptions) (
+	[]*SAMLProviderConfigUpdateResult, error) {
+
+	if config == nil {
+		return nil, errors.New("config must not be nil")
+	}
+	if _, err := config.buildRequest(); err != nil {
+		return nil, err
+	}
+
+	results := make([]*SAMLProviderConfigUpdateResult, len(ids))
+	forEachConcurrently(len(ids), opts.concurrency(), func(i int) {
+		updated, err := c.UpdateSAMLProviderConfig(ctx, ids[i], config)
+		results[i] = &SAMLProviderConfigUpdateResult{
+			ID:     ids[i],
+			Config: updated,
+			Error:  err,
+		}
+	})
+	return results, nil
+}
+
+// EnableSAMLProviderConfig enables the SAMLProviderConfig with the given ID.
+//
+// This is a shorthand for calling UpdateSAMLProviderConfig with only the Enabled field set.
+func (c *providerConfigClient) EnableSAMLProviderConfig(ctx context.Context, id string) (*SAMLProviderConfig, error) {
+	return c.UpdateSAMLProviderConfig(ctx, id, (&SAMLProviderConfigToUpdate{}).Enabled(true))
+}
+
+// DisableSAMLProviderConfig disables the SAMLProviderConfig with the given ID.
+//
+// This is a shorthand for calling UpdateSAMLProviderConfig with only the Enabled field set.
+func (c *providerConfigClient) DisableSAMLProviderConfig(ctx context.Context, id string) (*SAMLProviderConfig, error) {
+	return c.UpdateSAMLProviderConfig(ctx, id, (&SAMLProviderConfigToUpdate{}).Enabled(false))
+}
+
 // DeleteSAMLProviderConfig deletes the SAMLProviderConfig with the given ID.
 func (c *providerConfigClient) DeleteSAMLProviderConfig(ctx context.Context, id string) error {
 	if err := validateSAMLConfigID(id); err != nil {
@@ -840,6 +1047,92 @@ func (c *providerConfigClient) SAMLProviderConfigs(ctx context.Context, nextPage
 	return it
 }
 
+// ProviderConfigExport is a snapshot of all the OIDC and SAML provider configs of a project.
+//
+// It can be serialized to JSON with the encoding/json package (e.g. to keep it under version control),
+// and later passed to ImportProviderConfigs to re-create the configs. The RawJSON field of each config
+// is included in the serialized form, but is not used when the configs are re-created.
+type ProviderConfigExport struct {
+	OIDCProviderConfigs []*OIDCProviderConfig `json:"oidcProviderConfigs,omitempty"`
+	SAMLProviderConfigs []*SAMLProviderConfig `json:"samlProviderConfigs,omitempty"`
+}
+
+// ExportProviderConfigs retrieves all the OIDC and SAML provider configs of the project.
+func (c *providerConfigClient) ExportProviderConfigs(ctx context.Context) (*ProviderConfigExport, error) {
+	var export ProviderConfigExport
+	oidcIter := c.OIDCProviderConfigs(ctx, "")
+	for {
+		config, err := oidcIter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		export.OIDCProviderConfigs = append(export.OIDCProviderConfigs, config)
+	}
+
+	samlIter := c.SAMLProviderConfigs(ctx, "")
+	for {
+		config, err := samlIter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		export.SAMLProviderConfigs = append(export.SAMLProviderConfigs, config)
+	}
+	return &export, nil
+}
+
+// ImportProviderConfigs creates the OIDC and SAML provider configs contained in the given export.
+//
+// Configs are created one at a time using CreateOIDCProviderConfig and CreateSAMLProviderConfig, OIDC
+// configs first. None of the configs may already exist in the project. ImportProviderConfigs stops at
+// the first config that cannot be created, and returns an error naming it. The configs created before
+// the failure are not rolled back.
+func (c *providerConfigClient) ImportProviderConfigs(ctx context.Context, export *ProviderConfigExport) error {
+	if export == nil {
+		return errors.New("export must not be nil")
+	}
+
+	for _, config := range export.OIDCProviderConfigs {
+		if config == nil {
+			return errors.New("OIDC provider config must not be nil")
+		}
+		create := (&OIDCProviderConfigToCreate{}).
+			ID(config.ID).
+			DisplayName(config.DisplayName).
+			Enabled(config.Enabled).
+			ClientID(config.ClientID).
+			Issuer(config.Issuer)
+		if _, err := c.CreateOIDCProviderConfig(ctx, create); err != nil {
+			return fmt.Errorf("failed to import OIDC provider config %q: %v", config.ID, err)
+		}
+	}
+
+	for _, config := range export.SAMLProviderConfigs {
+		if config == nil {
+			return errors.New("SAML provider config must not be nil")
+		}
+		create := (&SAMLProviderConfigToCreate{}).
+			ID(config.ID).
+			DisplayName(config.DisplayName).
+			Enabled(config.Enabled).
+			IDPEntityID(config.IDPEntityID).
+			SSOURL(config.SSOURL).
+			RequestSigningEnabled(config.RequestSigningEnabled).
+			X509Certificates(config.X509Certificates).
+			RPEntityID(config.RPEntityID).
+			CallbackURL(config.CallbackURL)
+		if _, err := c.CreateSAMLProviderConfig(ctx, create); err != nil {
+			return fmt.Errorf("failed to import SAML provider config %q: %v", config.ID, err)
+		}
+	}
+	return nil
+}
+
 func (c *providerConfigClient) makeRequest(ctx context.Context, req *internal.Request, v interface{}) (*internal.Response, error) {
 	if c.projectID == "" {
 		return nil, errors.New("project id not available")
@@ -855,6 +1148,18 @@ type oidcProviderConfigDAO struct {
 	Issuer      string `json:"issuer"`
 	DisplayName string `json:"displayName"`
 	Enabled     bool   `json:"enabled"`
+
+	raw json.RawMessage
+}
+
+func (dao *oidcProviderConfigDAO) UnmarshalJSON(b []byte) error {
+	type oidcProviderConfigAlias oidcProviderConfigDAO
+	if err := json.Unmarshal(b, (*oidcProviderConfigAlias)(dao)); err != nil {
+		return err
+	}
+
+	dao.raw = copyRawJSON(b)
+	return nil
 }
 
 func (dao *oidcProviderConfigDAO) toOIDCProviderConfig() *OIDCProviderConfig {
@@ -864,6 +1169,7 @@ func (dao *oidcProviderConfigDAO) toOIDCProviderConfig() *OIDCProviderConfig {
 		Enabled:     dao.Enabled,
 		ClientID:    dao.ClientID,
 		Issuer:      dao.Issuer,
+		RawJSON:     dao.raw,
 	}
 }
 
@@ -885,6 +1191,18 @@ type samlProviderConfigDAO struct {
 	} `json:"spConfig"`
 	DisplayName string `json:"displayName"`
 	Enabled     bool   `json:"enabled"`
+
+	raw json.RawMessage
+}
+
+func (dao *samlProviderConfigDAO) UnmarshalJSON(b []byte) error {
+	type samlProviderConfigAlias samlProviderConfigDAO
+	if err := json.Unmarshal(b, (*samlProviderConfigAlias)(dao)); err != nil {
+		return err
+	}
+
+	dao.raw = copyRawJSON(b)
+	return nil
 }
 
 func (dao *samlProviderConfigDAO) toSAMLProviderConfig() *SAMLProviderConfig {
@@ -903,9 +1221,16 @@ func (dao *samlProviderConfigDAO) toSAMLProviderConfig() *SAMLProviderConfig {
 		X509Certificates:      certs,
 		RPEntityID:            dao.SPConfig.SPEntityID,
 		CallbackURL:           dao.SPConfig.CallbackURI,
+		RawJSON:               dao.raw,
 	}
 }
 
+// copyRawJSON copies the bytes passed into an UnmarshalJSON method, since the decoder may reuse the
+// underlying buffer once the method returns.
+func copyRawJSON(b []byte) json.RawMessage {
+	return append(json.RawMessage(nil), b...)
+}
+
 func validateOIDCConfigID(id string) error {
 	if !strings.HasPrefix(id, "oidc.") {
 		return fmt.Errorf("invalid OIDC provider id: %q", id)
@@ -922,6 +1247,32 @@ func validateSAMLConfigID(id string) error {
 	return nil
 }
 
+func (opts *ProviderConfigUpdateOptions) concurrency() int {
+	if opts != nil && opts.MaxConcurrency > 0 {
+		return opts.MaxConcurrency
+	}
+	return defaultProviderConfigUpdateConcurrency
+}
+
+// forEachConcurrently calls fn for each index in [0, n), running at most concurrency calls at the same
+// time, and returns once all calls have completed.
+func forEachConcurrently(n, concurrency int, fn func(i int)) {
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
 func extractResourceID(name string) string {
 	// name format: "projects/project-id/resource/resource-id"
 	segments := strings.Split(name, "/")
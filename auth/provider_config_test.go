@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
 	"strings"
@@ -60,6 +61,14 @@ const notFoundResponse = `{
 	}
 }`
 
+const notFoundWithDetailsResponse = `{
+	"error": {
+		"code": 404,
+		"message": "CONFIGURATION_NOT_FOUND : No provider configuration found for the given ID.",
+		"status": "NOT_FOUND"
+	}
+}`
+
 var idpCertsMap = []interface{}{
 	map[string]interface{}{"x509Certificate": "CERT1"},
 	map[string]interface{}{"x509Certificate": "CERT2"},
@@ -71,6 +80,7 @@ var oidcProviderConfig = &OIDCProviderConfig{
 	Enabled:     true,
 	ClientID:    "CLIENT_ID",
 	Issuer:      "https://oidc.com/issuer",
+	RawJSON:     json.RawMessage(oidcConfigResponse),
 }
 
 var samlProviderConfig = &SAMLProviderConfig{
@@ -83,6 +93,7 @@ var samlProviderConfig = &SAMLProviderConfig{
 	X509Certificates:      []string{"CERT1", "CERT2"},
 	RPEntityID:            "RP_ENTITY_ID",
 	CallbackURL:           "https://projectId.firebaseapp.com/__/auth/handler",
+	RawJSON:               json.RawMessage(samlConfigResponse),
 }
 
 var invalidOIDCConfigIDs = []string{
@@ -146,6 +157,69 @@ func TestOIDCProviderConfigError(t *testing.T) {
 	}
 }
 
+func TestProviderConfigExists(t *testing.T) {
+	cases := []struct {
+		resp   string
+		status int
+		want   bool
+	}{
+		{oidcConfigResponse, http.StatusOK, true},
+		{notFoundResponse, http.StatusNotFound, false},
+	}
+	for _, tc := range cases {
+		s := echoServer([]byte(tc.resp), t)
+		s.Status = tc.status
+
+		exists, err := s.Client.OIDCProviderConfigExists(context.Background(), "oidc.provider")
+		if exists != tc.want || err != nil {
+			t.Errorf("OIDCProviderConfigExists() = (%v, %v); want = (%v, nil)", exists, err, tc.want)
+		}
+		exists, err = s.Client.SAMLProviderConfigExists(context.Background(), "saml.provider")
+		if exists != tc.want || err != nil {
+			t.Errorf("SAMLProviderConfigExists() = (%v, %v); want = (%v, nil)", exists, err, tc.want)
+		}
+		s.Close()
+	}
+}
+
+func TestProviderConfigExistsError(t *testing.T) {
+	s := echoServer([]byte(`{"error": {"message": "INTERNAL_ERROR"}}`), t)
+	defer s.Close()
+	s.Client.providerConfigClient.httpClient.RetryConfig = nil
+	s.Status = http.StatusInternalServerError
+
+	exists, err := s.Client.OIDCProviderConfigExists(context.Background(), "oidc.provider")
+	if exists || err == nil || IsConfigurationNotFound(err) {
+		t.Errorf("OIDCProviderConfigExists() = (%v, %v); want = (false, error)", exists, err)
+	}
+	exists, err = s.Client.SAMLProviderConfigExists(context.Background(), "saml.provider")
+	if exists || err == nil || IsConfigurationNotFound(err) {
+		t.Errorf("SAMLProviderConfigExists() = (%v, %v); want = (false, error)", exists, err)
+	}
+
+	exists, err = s.Client.OIDCProviderConfigExists(context.Background(), "saml.provider")
+	if exists || err == nil {
+		t.Errorf("OIDCProviderConfigExists(invalid) = (%v, %v); want = (false, error)", exists, err)
+	}
+}
+
+func TestProviderConfigNotFoundWithDetails(t *testing.T) {
+	s := echoServer([]byte(notFoundWithDetailsResponse), t)
+	defer s.Close()
+	s.Status = http.StatusNotFound
+
+	client := s.Client
+	oidc, err := client.OIDCProviderConfig(context.Background(), "oidc.provider")
+	if oidc != nil || !IsConfigurationNotFound(err) {
+		t.Errorf("OIDCProviderConfig() = (%v, %v); want = (nil, ConfigurationNotFound)", oidc, err)
+	}
+
+	saml, err := client.SAMLProviderConfig(context.Background(), "saml.provider")
+	if saml != nil || !IsConfigurationNotFound(err) {
+		t.Errorf("SAMLProviderConfig() = (%v, %v); want = (nil, ConfigurationNotFound)", saml, err)
+	}
+}
+
 func TestCreateOIDCProviderConfig(t *testing.T) {
 	s := echoServer([]byte(oidcConfigResponse), t)
 	defer s.Close()
@@ -313,6 +387,79 @@ func TestCreateOIDCProviderConfigInvalidInput(t *testing.T) {
 	}
 }
 
+// duplicateConfigServer returns a mock server that fails all create requests as duplicates, and responds to
+// all other requests with the given response.
+func duplicateConfigServer(resp string, t *testing.T) *mockAuthServer {
+	s := echoServer([]byte(resp), t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Req = append(s.Req, r)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error": {"message": "DUPLICATE_IDP_CONFIG"}}`))
+			return
+		}
+		w.Write([]byte(resp))
+	}))
+	s.Srv.Close()
+	s.Srv = ts
+	s.Client.providerConfigClient.endpoint = ts.URL
+	return s
+}
+
+func TestCreateOrGetOIDCProviderConfig(t *testing.T) {
+	s := echoServer([]byte(oidcConfigResponse), t)
+	defer s.Close()
+
+	options := (&OIDCProviderConfigToCreate{}).
+		ID(oidcProviderConfig.ID).
+		ClientID(oidcProviderConfig.ClientID).
+		Issuer(oidcProviderConfig.Issuer)
+	oidc, err := s.Client.CreateOrGetOIDCProviderConfig(context.Background(), options)
+	if err != nil || !reflect.DeepEqual(oidc, oidcProviderConfig) {
+		t.Errorf("CreateOrGetOIDCProviderConfig() = (%#v, %v); want = (%#v, nil)", oidc, err, oidcProviderConfig)
+	}
+	if len(s.Req) != 1 || s.Req[0].Method != http.MethodPost {
+		t.Errorf("CreateOrGetOIDCProviderConfig() = %d requests; want = 1 POST", len(s.Req))
+	}
+}
+
+func TestCreateOrGetOIDCProviderConfigExisting(t *testing.T) {
+	s := duplicateConfigServer(oidcConfigResponse, t)
+	defer s.Close()
+
+	options := (&OIDCProviderConfigToCreate{}).
+		ID(oidcProviderConfig.ID).
+		ClientID(oidcProviderConfig.ClientID).
+		Issuer(oidcProviderConfig.Issuer)
+	if _, err := s.Client.CreateOIDCProviderConfig(context.Background(), options); !IsConfigurationAlreadyExists(err) {
+		t.Fatalf("CreateOIDCProviderConfig() = %v; want = %q", err, configurationExists)
+	}
+
+	s.Req = nil
+	oidc, err := s.Client.CreateOrGetOIDCProviderConfig(context.Background(), options)
+	if err != nil || !reflect.DeepEqual(oidc, oidcProviderConfig) {
+		t.Errorf("CreateOrGetOIDCProviderConfig() = (%#v, %v); want = (%#v, nil)", oidc, err, oidcProviderConfig)
+	}
+
+	wantPath := "/projects/mock-project-id/oauthIdpConfigs/oidc.provider"
+	if len(s.Req) != 2 || s.Req[1].Method != http.MethodGet || s.Req[1].URL.Path != wantPath {
+		t.Errorf("CreateOrGetOIDCProviderConfig() did not get %q after create", wantPath)
+	}
+}
+
+func TestCreateOrGetOIDCProviderConfigInvalidInput(t *testing.T) {
+	client := &providerConfigClient{}
+	options := (&OIDCProviderConfigToCreate{}).ID("invalid.id")
+	oidc, err := client.CreateOrGetOIDCProviderConfig(context.Background(), options)
+	if oidc != nil || err == nil {
+		t.Errorf("CreateOrGetOIDCProviderConfig() = (%v, %v); want = (nil, error)", oidc, err)
+	}
+	if oidc, err := client.CreateOrGetOIDCProviderConfig(context.Background(), nil); oidc != nil || err == nil {
+		t.Errorf("CreateOrGetOIDCProviderConfig(nil) = (%v, %v); want = (nil, error)", oidc, err)
+	}
+}
+
 func TestUpdateOIDCProviderConfig(t *testing.T) {
 	s := echoServer([]byte(oidcConfigResponse), t)
 	defer s.Close()
@@ -406,6 +553,45 @@ func TestUpdateOIDCProviderConfigZeroValues(t *testing.T) {
 	}
 }
 
+func TestEnableDisableOIDCProviderConfig(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		s := echoServer([]byte(oidcConfigResponse), t)
+		defer s.Close()
+
+		fn := s.Client.DisableOIDCProviderConfig
+		if enabled {
+			fn = s.Client.EnableOIDCProviderConfig
+		}
+		oidc, err := fn(context.Background(), "oidc.provider")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(oidc, oidcProviderConfig) {
+			t.Errorf("EnableOIDCProviderConfig(%v) = %#v; want = %#v", enabled, oidc, oidcProviderConfig)
+		}
+
+		wantBody := map[string]interface{}{
+			"enabled": enabled,
+		}
+		if err := checkUpdateOIDCConfigRequest(s, wantBody, []string{"enabled"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEnableDisableOIDCProviderConfigInvalidID(t *testing.T) {
+	client := &providerConfigClient{}
+	want := "invalid OIDC provider id: "
+	if _, err := client.EnableOIDCProviderConfig(context.Background(), "saml.config"); err == nil ||
+		!strings.HasPrefix(err.Error(), want) {
+		t.Errorf("EnableOIDCProviderConfig() = %v; want = %q", err, want)
+	}
+	if _, err := client.DisableOIDCProviderConfig(context.Background(), "saml.config"); err == nil ||
+		!strings.HasPrefix(err.Error(), want) {
+		t.Errorf("DisableOIDCProviderConfig() = %v; want = %q", err, want)
+	}
+}
+
 func TestUpdateOIDCProviderConfigInvalidID(t *testing.T) {
 	cases := []string{"", "saml.config"}
 	client := &providerConfigClient{}
@@ -465,6 +651,67 @@ func TestUpdateOIDCProviderConfigInvalidInput(t *testing.T) {
 	}
 }
 
+func TestUpdateOIDCProviderConfigs(t *testing.T) {
+	s := echoServer([]byte(oidcConfigResponse), t)
+	defer s.Close()
+
+	ids := []string{"oidc.provider1", "saml.provider", "oidc.provider2"}
+	options := (&OIDCProviderConfigToUpdate{}).
+		Enabled(true)
+	results, err := s.Client.UpdateOIDCProviderConfigs(
+		context.Background(), ids, options, &ProviderConfigUpdateOptions{MaxConcurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("UpdateOIDCProviderConfigs() = %d results; want = %d", len(results), len(ids))
+	}
+
+	for i, r := range results {
+		if r.ID != ids[i] {
+			t.Errorf("UpdateOIDCProviderConfigs()[%d].ID = %q; want = %q", i, r.ID, ids[i])
+		}
+		if ids[i] == "saml.provider" {
+			if r.Config != nil || r.Error == nil {
+				t.Errorf("UpdateOIDCProviderConfigs()[%d] = (%v, %v); want = (nil, error)", i, r.Config, r.Error)
+			}
+			continue
+		}
+		if r.Error != nil || !reflect.DeepEqual(r.Config, oidcProviderConfig) {
+			t.Errorf("UpdateOIDCProviderConfigs()[%d] = (%#v, %v); want = (%#v, nil)",
+				i, r.Config, r.Error, oidcProviderConfig)
+		}
+	}
+
+	wantPaths := []string{
+		"/projects/mock-project-id/oauthIdpConfigs/oidc.provider1",
+		"/projects/mock-project-id/oauthIdpConfigs/oidc.provider2",
+	}
+	if len(s.Req) != len(wantPaths) {
+		t.Fatalf("UpdateOIDCProviderConfigs() = %d requests; want = %d", len(s.Req), len(wantPaths))
+	}
+	for i, req := range s.Req {
+		if req.Method != http.MethodPatch || req.URL.Path != wantPaths[i] {
+			t.Errorf("UpdateOIDCProviderConfigs() Request[%d] = %s %q; want = %s %q",
+				i, req.Method, req.URL.Path, http.MethodPatch, wantPaths[i])
+		}
+	}
+}
+
+func TestUpdateOIDCProviderConfigsInvalidInput(t *testing.T) {
+	client := &providerConfigClient{}
+	ids := []string{"oidc.provider"}
+	if _, err := client.UpdateOIDCProviderConfigs(context.Background(), ids, nil, nil); err == nil {
+		t.Errorf("UpdateOIDCProviderConfigs(nil) = nil; want = error")
+	}
+
+	want := "no parameters specified in the update request"
+	results, err := client.UpdateOIDCProviderConfigs(context.Background(), ids, &OIDCProviderConfigToUpdate{}, nil)
+	if results != nil || err == nil || err.Error() != want {
+		t.Errorf("UpdateOIDCProviderConfigs() = (%v, %v); want = (nil, %q)", results, err, want)
+	}
+}
+
 func TestDeleteOIDCProviderConfig(t *testing.T) {
 	s := echoServer([]byte("{}"), t)
 	defer s.Close()
@@ -497,6 +744,30 @@ func TestDeleteOIDCProviderConfigInvalidID(t *testing.T) {
 	}
 }
 
+func TestOIDCProviderConfigRawJSON(t *testing.T) {
+	resp := `{
+		"name": "projects/mock-project-id/oauthIdpConfigs/oidc.provider",
+		"clientId": "CLIENT_ID",
+		"responseType": {"code": true}
+	}`
+	s := echoServer([]byte(resp), t)
+	defer s.Close()
+
+	oidc, err := s.Client.OIDCProviderConfig(context.Background(), "oidc.provider")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(oidc.RawJSON, &raw); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"code": true}
+	if !reflect.DeepEqual(raw["responseType"], want) {
+		t.Errorf("RawJSON[responseType] = %v; want = %v", raw["responseType"], want)
+	}
+}
+
 func TestDeleteOIDCProviderConfigError(t *testing.T) {
 	s := echoServer([]byte(notFoundResponse), t)
 	defer s.Close()
@@ -574,6 +845,49 @@ func TestOIDCProviderConfigs(t *testing.T) {
 		"pageSize=100&pageToken=pageToken")
 }
 
+func TestOIDCProviderConfigsPageInfo(t *testing.T) {
+	template := `{
+                "oauthIdpConfigs": [
+                    %s,
+                    %s,
+                    %s
+                ],
+                "nextPageToken": "nextPage"
+        }`
+	response := fmt.Sprintf(template, oidcConfigResponse, oidcConfigResponse, oidcConfigResponse)
+	s := echoServer([]byte(response), t)
+	defer s.Close()
+
+	it := s.Client.OIDCProviderConfigs(context.Background(), "")
+	if _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := it.PageInfo().Token; got != "nextPage" {
+		t.Errorf("PageInfo().Token = %q; want = %q", got, "nextPage")
+	}
+	if got := it.PageInfo().Remaining(); got != 2 {
+		t.Errorf("PageInfo().Remaining() = %d; want = %d", got, 2)
+	}
+
+	var configs []*OIDCProviderConfig
+	pager := iterator.NewPager(s.Client.OIDCProviderConfigs(context.Background(), ""), 3, "pageToken")
+	token, err := pager.NextPage(&configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "nextPage" {
+		t.Errorf("NextPage() token = %q; want = %q", token, "nextPage")
+	}
+	if len(configs) != 3 || !reflect.DeepEqual(configs[0], oidcProviderConfig) {
+		t.Errorf("NextPage() = %#v; want = 3 configs", configs)
+	}
+
+	wantReq := "pageSize=3&pageToken=pageToken"
+	if got := s.Req[len(s.Req)-1].URL.Query().Encode(); got != wantReq {
+		t.Errorf("NextPage() query = %q; want = %q", got, wantReq)
+	}
+}
+
 func TestOIDCProviderConfigsError(t *testing.T) {
 	s := echoServer([]byte("{}"), t)
 	defer s.Close()
@@ -888,6 +1202,28 @@ func TestCreateSAMLProviderConfigInvalidInput(t *testing.T) {
 	}
 }
 
+func TestCreateOrGetSAMLProviderConfigExisting(t *testing.T) {
+	s := duplicateConfigServer(samlConfigResponse, t)
+	defer s.Close()
+
+	options := (&SAMLProviderConfigToCreate{}).
+		ID(samlProviderConfig.ID).
+		IDPEntityID(samlProviderConfig.IDPEntityID).
+		SSOURL(samlProviderConfig.SSOURL).
+		X509Certificates(samlProviderConfig.X509Certificates).
+		RPEntityID(samlProviderConfig.RPEntityID).
+		CallbackURL(samlProviderConfig.CallbackURL)
+	saml, err := s.Client.CreateOrGetSAMLProviderConfig(context.Background(), options)
+	if err != nil || !reflect.DeepEqual(saml, samlProviderConfig) {
+		t.Errorf("CreateOrGetSAMLProviderConfig() = (%#v, %v); want = (%#v, nil)", saml, err, samlProviderConfig)
+	}
+
+	wantPath := "/projects/mock-project-id/inboundSamlConfigs/saml.provider"
+	if len(s.Req) != 2 || s.Req[1].Method != http.MethodGet || s.Req[1].URL.Path != wantPath {
+		t.Errorf("CreateOrGetSAMLProviderConfig() did not get %q after create", wantPath)
+	}
+}
+
 func TestUpdateSAMLProviderConfig(t *testing.T) {
 	s := echoServer([]byte(samlConfigResponse), t)
 	defer s.Close()
@@ -1002,6 +1338,45 @@ func TestUpdateSAMLProviderConfigZeroValues(t *testing.T) {
 	}
 }
 
+func TestEnableDisableSAMLProviderConfig(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		s := echoServer([]byte(samlConfigResponse), t)
+		defer s.Close()
+
+		fn := s.Client.DisableSAMLProviderConfig
+		if enabled {
+			fn = s.Client.EnableSAMLProviderConfig
+		}
+		saml, err := fn(context.Background(), "saml.provider")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(saml, samlProviderConfig) {
+			t.Errorf("EnableSAMLProviderConfig(%v) = %#v; want = %#v", enabled, saml, samlProviderConfig)
+		}
+
+		wantBody := map[string]interface{}{
+			"enabled": enabled,
+		}
+		if err := checkUpdateSAMLConfigRequest(s, wantBody, []string{"enabled"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEnableDisableSAMLProviderConfigInvalidID(t *testing.T) {
+	client := &providerConfigClient{}
+	want := "invalid SAML provider id: "
+	if _, err := client.EnableSAMLProviderConfig(context.Background(), "oidc.config"); err == nil ||
+		!strings.HasPrefix(err.Error(), want) {
+		t.Errorf("EnableSAMLProviderConfig() = %v; want = %q", err, want)
+	}
+	if _, err := client.DisableSAMLProviderConfig(context.Background(), "oidc.config"); err == nil ||
+		!strings.HasPrefix(err.Error(), want) {
+		t.Errorf("DisableSAMLProviderConfig() = %v; want = %q", err, want)
+	}
+}
+
 func TestUpdateSAMLProviderConfigInvalidID(t *testing.T) {
 	cases := []string{"", "oidc.config"}
 	client := &providerConfigClient{}
@@ -1093,6 +1468,69 @@ func TestUpdateSAMLProviderConfigInvalidInput(t *testing.T) {
 	}
 }
 
+func TestUpdateSAMLProviderConfigs(t *testing.T) {
+	s := echoServer([]byte(samlConfigResponse), t)
+	defer s.Close()
+
+	ids := []string{"saml.provider1", "oidc.provider", "saml.provider2"}
+	options := (&SAMLProviderConfigToUpdate{}).
+		X509Certificates([]string{"CERT1", "CERT2"})
+	results, err := s.Client.UpdateSAMLProviderConfigs(
+		context.Background(), ids, options, &ProviderConfigUpdateOptions{MaxConcurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("UpdateSAMLProviderConfigs() = %d results; want = %d", len(results), len(ids))
+	}
+
+	for i, r := range results {
+		if r.ID != ids[i] {
+			t.Errorf("UpdateSAMLProviderConfigs()[%d].ID = %q; want = %q", i, r.ID, ids[i])
+		}
+		if ids[i] == "oidc.provider" {
+			if r.Config != nil || r.Error == nil {
+				t.Errorf("UpdateSAMLProviderConfigs()[%d] = (%v, %v); want = (nil, error)", i, r.Config, r.Error)
+			}
+			continue
+		}
+		if r.Error != nil || !reflect.DeepEqual(r.Config, samlProviderConfig) {
+			t.Errorf("UpdateSAMLProviderConfigs()[%d] = (%#v, %v); want = (%#v, nil)",
+				i, r.Config, r.Error, samlProviderConfig)
+		}
+	}
+
+	wantPaths := []string{
+		"/projects/mock-project-id/inboundSamlConfigs/saml.provider1",
+		"/projects/mock-project-id/inboundSamlConfigs/saml.provider2",
+	}
+	if len(s.Req) != len(wantPaths) {
+		t.Fatalf("UpdateSAMLProviderConfigs() = %d requests; want = %d", len(s.Req), len(wantPaths))
+	}
+	for i, req := range s.Req {
+		if req.Method != http.MethodPatch || req.URL.Path != wantPaths[i] {
+			t.Errorf("UpdateSAMLProviderConfigs() Request[%d] = %s %q; want = %s %q",
+				i, req.Method, req.URL.Path, http.MethodPatch, wantPaths[i])
+		}
+	}
+}
+
+func TestUpdateSAMLProviderConfigsInvalidInput(t *testing.T) {
+	client := &providerConfigClient{}
+	ids := []string{"saml.provider"}
+	if _, err := client.UpdateSAMLProviderConfigs(context.Background(), ids, nil, nil); err == nil {
+		t.Errorf("UpdateSAMLProviderConfigs(nil) = nil; want = error")
+	}
+
+	want := "X509Certificates must not be empty"
+	options := (&SAMLProviderConfigToUpdate{}).
+		X509Certificates([]string{})
+	results, err := client.UpdateSAMLProviderConfigs(context.Background(), ids, options, nil)
+	if results != nil || err == nil || err.Error() != want {
+		t.Errorf("UpdateSAMLProviderConfigs() = (%v, %v); want = (nil, %q)", results, err, want)
+	}
+}
+
 func TestDeleteSAMLProviderConfig(t *testing.T) {
 	s := echoServer([]byte("{}"), t)
 	defer s.Close()
@@ -1202,6 +1640,49 @@ func TestSAMLProviderConfigs(t *testing.T) {
 		"pageSize=100&pageToken=pageToken")
 }
 
+func TestSAMLProviderConfigsPageInfo(t *testing.T) {
+	template := `{
+                "inboundSamlConfigs": [
+                    %s,
+                    %s,
+                    %s
+                ],
+                "nextPageToken": "nextPage"
+        }`
+	response := fmt.Sprintf(template, samlConfigResponse, samlConfigResponse, samlConfigResponse)
+	s := echoServer([]byte(response), t)
+	defer s.Close()
+
+	it := s.Client.SAMLProviderConfigs(context.Background(), "")
+	if _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if got := it.PageInfo().Token; got != "nextPage" {
+		t.Errorf("PageInfo().Token = %q; want = %q", got, "nextPage")
+	}
+	if got := it.PageInfo().Remaining(); got != 2 {
+		t.Errorf("PageInfo().Remaining() = %d; want = %d", got, 2)
+	}
+
+	var configs []*SAMLProviderConfig
+	pager := iterator.NewPager(s.Client.SAMLProviderConfigs(context.Background(), ""), 3, "pageToken")
+	token, err := pager.NextPage(&configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "nextPage" {
+		t.Errorf("NextPage() token = %q; want = %q", token, "nextPage")
+	}
+	if len(configs) != 3 || !reflect.DeepEqual(configs[0], samlProviderConfig) {
+		t.Errorf("NextPage() = %#v; want = 3 configs", configs)
+	}
+
+	wantReq := "pageSize=3&pageToken=pageToken"
+	if got := s.Req[len(s.Req)-1].URL.Query().Encode(); got != wantReq {
+		t.Errorf("NextPage() query = %q; want = %q", got, wantReq)
+	}
+}
+
 func TestSAMLProviderConfigsError(t *testing.T) {
 	s := echoServer([]byte("{}"), t)
 	defer s.Close()
@@ -1224,6 +1705,150 @@ func TestSAMLProviderConfigNoProjectID(t *testing.T) {
 	}
 }
 
+func TestExportProviderConfigs(t *testing.T) {
+	response := fmt.Sprintf(`{
+                "oauthIdpConfigs": [%s],
+                "inboundSamlConfigs": [%s, %s],
+                "nextPageToken": ""
+        }`, oidcConfigResponse, samlConfigResponse, samlConfigResponse)
+	s := echoServer([]byte(response), t)
+	defer s.Close()
+
+	export, err := s.Client.ExportProviderConfigs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &ProviderConfigExport{
+		OIDCProviderConfigs: []*OIDCProviderConfig{oidcProviderConfig},
+		SAMLProviderConfigs: []*SAMLProviderConfig{samlProviderConfig, samlProviderConfig},
+	}
+	if !reflect.DeepEqual(export, want) {
+		t.Errorf("ExportProviderConfigs() = %#v; want = %#v", export, want)
+	}
+
+	wantPaths := []string{
+		"/projects/mock-project-id/oauthIdpConfigs",
+		"/projects/mock-project-id/inboundSamlConfigs",
+	}
+	if len(s.Req) != len(wantPaths) {
+		t.Fatalf("ExportProviderConfigs() = %d requests; want = %d", len(s.Req), len(wantPaths))
+	}
+	for i, req := range s.Req {
+		if req.Method != http.MethodGet || req.URL.Path != wantPaths[i] {
+			t.Errorf("ExportProviderConfigs() Request[%d] = %s %q; want = %s %q",
+				i, req.Method, req.URL.Path, http.MethodGet, wantPaths[i])
+		}
+	}
+}
+
+func TestExportProviderConfigsError(t *testing.T) {
+	s := echoServer([]byte(notFoundResponse), t)
+	defer s.Close()
+	s.Status = http.StatusNotFound
+
+	export, err := s.Client.ExportProviderConfigs(context.Background())
+	if export != nil || !IsConfigurationNotFound(err) {
+		t.Errorf("ExportProviderConfigs() = (%v, %v); want = (nil, error)", export, err)
+	}
+}
+
+func TestImportProviderConfigs(t *testing.T) {
+	s := echoServer([]byte(oidcConfigResponse), t)
+	defer s.Close()
+
+	// Round trip the export through JSON, as a backup would.
+	b, err := json.Marshal(&ProviderConfigExport{
+		OIDCProviderConfigs: []*OIDCProviderConfig{oidcProviderConfig},
+		SAMLProviderConfigs: []*SAMLProviderConfig{samlProviderConfig},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export ProviderConfigExport
+	if err := json.Unmarshal(b, &export); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Client.ImportProviderConfigs(context.Background(), &export); err != nil {
+		t.Fatal(err)
+	}
+
+	wantReqs := []string{
+		"/projects/mock-project-id/oauthIdpConfigs?oauthIdpConfigId=oidc.provider",
+		"/projects/mock-project-id/inboundSamlConfigs?inboundSamlConfigId=saml.provider",
+	}
+	if len(s.Req) != len(wantReqs) {
+		t.Fatalf("ImportProviderConfigs() = %d requests; want = %d", len(s.Req), len(wantReqs))
+	}
+	for i, req := range s.Req {
+		if req.Method != http.MethodPost || req.URL.RequestURI() != wantReqs[i] {
+			t.Errorf("ImportProviderConfigs() Request[%d] = %s %q; want = %s %q",
+				i, req.Method, req.URL.RequestURI(), http.MethodPost, wantReqs[i])
+		}
+	}
+
+	wantBody := map[string]interface{}{
+		"displayName": samlProviderConfig.DisplayName,
+		"enabled":     samlProviderConfig.Enabled,
+		"idpConfig": map[string]interface{}{
+			"idpEntityId":     samlProviderConfig.IDPEntityID,
+			"ssoUrl":          samlProviderConfig.SSOURL,
+			"signRequest":     samlProviderConfig.RequestSigningEnabled,
+			"idpCertificates": idpCertsMap,
+		},
+		"spConfig": map[string]interface{}{
+			"spEntityId":  samlProviderConfig.RPEntityID,
+			"callbackUri": samlProviderConfig.CallbackURL,
+		},
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(s.Rbody, &body); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(body, wantBody) {
+		t.Errorf("ImportProviderConfigs() Body = %#v; want = %#v", body, wantBody)
+	}
+}
+
+func TestImportProviderConfigsError(t *testing.T) {
+	s := echoServer([]byte(oidcConfigResponse), t)
+	defer s.Close()
+
+	invalid := *oidcProviderConfig
+	invalid.ID = "invalid.id"
+	cases := []struct {
+		name   string
+		export *ProviderConfigExport
+		want   string
+	}{
+		{
+			name:   "NilExport",
+			export: nil,
+			want:   "export must not be nil",
+		},
+		{
+			name:   "NilConfig",
+			export: &ProviderConfigExport{SAMLProviderConfigs: []*SAMLProviderConfig{nil}},
+			want:   "SAML provider config must not be nil",
+		},
+		{
+			name:   "InvalidConfig",
+			export: &ProviderConfigExport{OIDCProviderConfigs: []*OIDCProviderConfig{&invalid}},
+			want:   "failed to import OIDC provider config \"invalid.id\": invalid OIDC provider id: \"invalid.id\"",
+		},
+	}
+	for _, tc := range cases {
+		err := s.Client.ImportProviderConfigs(context.Background(), tc.export)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("ImportProviderConfigs(%s) = %v; want = %q", tc.name, err, tc.want)
+		}
+	}
+	if len(s.Req) != 0 {
+		t.Errorf("ImportProviderConfigs() = %d requests; want = 0", len(s.Req))
+	}
+}
+
 func checkCreateOIDCConfigRequest(s *mockAuthServer, wantBody interface{}) error {
 	req := s.Req[0]
 	if req.Method != http.MethodPost {
@@ -1339,3 +1964,96 @@ func checkUpdateSAMLConfigRequest(s *mockAuthServer, wantBody interface{}, wantM
 
 	return nil
 }
+func TestUpdateMask(t *testing.T) {
+	cases := []struct {
+		name  string
+		build func() (nestedMap, error)
+		want  []string
+	}{
+		{
+			name: "OIDCProviderConfigToUpdate",
+			build: (&OIDCProviderConfigToUpdate{}).
+				Enabled(true).
+				Issuer("https://oidc.com/issuer").
+				DisplayName("name").
+				ClientID("CLIENT_ID").
+				buildRequest,
+			want: []string{"clientId", "displayName", "enabled", "issuer"},
+		},
+		{
+			name: "SAMLProviderConfigToUpdate",
+			build: (&SAMLProviderConfigToUpdate{}).
+				CallbackURL("https://example.com/callback").
+				RPEntityID("RP_ENTITY_ID").
+				X509Certificates([]string{"CERT"}).
+				RequestSigningEnabled(true).
+				SSOURL("https://example.com/login").
+				IDPEntityID("IDP_ENTITY_ID").
+				Enabled(false).
+				DisplayName("name").
+				buildRequest,
+			want: []string{
+				"displayName",
+				"enabled",
+				"idpConfig.idpCertificates",
+				"idpConfig.idpEntityId",
+				"idpConfig.signRequest",
+				"idpConfig.ssoUrl",
+				"spConfig.callbackUri",
+				"spConfig.spEntityId",
+			},
+		},
+		{
+			name: "ProjectConfigToUpdate",
+			build: (&ProjectConfigToUpdate{}).
+				SMSRegionConfig(&SMSRegionConfig{AllowlistOnly: &AllowlistOnly{AllowedRegions: []string{"US"}}}).
+				EmailPrivacyConfig(&EmailPrivacyConfig{EnableImprovedEmailPrivacy: true}).
+				PasswordPolicyConfig(&PasswordPolicyConfig{EnforcementState: PasswordPolicyOff}).
+				buildRequest,
+			want: []string{
+				"emailPrivacyConfig.enableImprovedEmailPrivacy",
+				"passwordPolicyConfig",
+				"smsRegionConfig",
+			},
+		},
+	}
+	for _, tc := range cases {
+		body, err := tc.build()
+		if err != nil {
+			t.Fatalf("%s.buildRequest() = %v", tc.name, err)
+		}
+		// Repeat to make sure the order does not depend on map iteration order.
+		for i := 0; i < 10; i++ {
+			mask, err := body.UpdateMask()
+			if err != nil || !reflect.DeepEqual(mask, tc.want) {
+				t.Fatalf("%s.UpdateMask() = (%v, %v); want = (%v, nil)", tc.name, mask, err, tc.want)
+			}
+		}
+	}
+}
+
+func TestNewUpdateRequest(t *testing.T) {
+	body := make(nestedMap)
+	body.Set("b.y", 1)
+	body.Set("b.x", 2)
+	body.Set("a", 3)
+	req, err := newUpdateRequest("/resource", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != http.MethodPatch || req.URL != "/resource" {
+		t.Errorf("newUpdateRequest() = %s %q; want = %s %q", req.Method, req.URL, http.MethodPatch, "/resource")
+	}
+
+	hr, err := http.NewRequest(http.MethodPatch, "https://example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opt := range req.Opts {
+		opt(hr)
+	}
+	want := "a,b.x,b.y"
+	if got := hr.URL.Query().Get("updateMask"); got != want {
+		t.Errorf("newUpdateRequest() updateMask = %q; want = %q", got, want)
+	}
+}
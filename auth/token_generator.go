@@ -143,13 +143,15 @@ func (s serviceAccountSigner) Email(ctx context.Context) (string, error) {
 	return s.clientEmail, nil
 }
 
-// iamSigner is a cryptoSigner that signs data by sending them to the remote IAM service. See
-// https://cloud.google.com/iam/reference/rest/v1/projects.serviceAccounts/signBlob for details
-// regarding the REST API.
+// iamSigner is a cryptoSigner that signs data by sending them to the remote IAM Credentials
+// service. See https://cloud.google.com/iam/docs/reference/credentials/rest/v1/projects.serviceAccounts/signBlob
+// for details regarding the REST API.
 //
-// The IAM service requires the identity of a service account. This can be specified explicitly
-// at initialization. If not specified iamSigner attempts to discover a service account identity by
-// calling the local metadata service (works in environments like Google Compute Engine).
+// The IAM Credentials service requires the identity of a service account. This can be specified
+// explicitly at initialization. If not specified iamSigner attempts to discover a service account
+// identity by calling the local metadata service (works in environments like Google Compute Engine
+// and Cloud Run). In either case the credentials used to initialize the SDK must have the Service
+// Account Token Creator role on the service account.
 type iamSigner struct {
 	mutex        *sync.Mutex
 	httpClient   *internal.HTTPClient
@@ -168,18 +170,18 @@ func newIAMSigner(ctx context.Context, config *internal.AuthConfig) (*iamSigner,
 		httpClient:   &internal.HTTPClient{Client: hc},
 		serviceAcct:  config.ServiceAccountID,
 		metadataHost: "http://metadata.google.internal",
-		iamHost:      "https://iam.googleapis.com",
+		iamHost:      "https://iamcredentials.googleapis.com",
 	}, nil
 }
 
-func (s iamSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
+func (s *iamSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
 	account, err := s.Email(ctx)
 	if err != nil {
 		return nil, err
 	}
 	url := fmt.Sprintf("%s/v1/projects/-/serviceAccounts/%s:signBlob", s.iamHost, account)
 	body := map[string]interface{}{
-		"bytesToSign": base64.StdEncoding.EncodeToString(b),
+		"payload": base64.StdEncoding.EncodeToString(b),
 	}
 	req := &internal.Request{
 		Method: "POST",
@@ -191,12 +193,12 @@ func (s iamSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
 		return nil, err
 	} else if resp.Status == http.StatusOK {
 		var signResponse struct {
-			Signature string `json:"signature"`
+			SignedBlob string `json:"signedBlob"`
 		}
 		if err := json.Unmarshal(resp.Body, &signResponse); err != nil {
 			return nil, err
 		}
-		return base64.StdEncoding.DecodeString(signResponse.Signature)
+		return base64.StdEncoding.DecodeString(signResponse.SignedBlob)
 	}
 	var signError struct {
 		Error struct {
@@ -217,15 +219,23 @@ func (s iamSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
 	if msg == "" {
 		msg = fmt.Sprintf("client encountered an unknown error; response: %s", string(resp.Body))
 	}
+	if clientCode == insufficientPermission {
+		msg += fmt.Sprintf("; make sure the service account %q has the Service Account Token Creator "+
+			"role (roles/iam.serviceAccountTokenCreator); refer to "+
+			"https://firebase.google.com/docs/auth/admin/create-custom-tokens for more details", account)
+	}
 	return nil, internal.Errorf(clientCode, "http error status: %d; reason: %s", resp.Status, msg)
 }
 
-func (s iamSigner) Email(ctx context.Context) (string, error) {
+// Email returns the service account email used to sign data. If a service account was not
+// specified at initialization, Email discovers it from the local metadata service, and caches
+// the result for subsequent calls.
+func (s *iamSigner) Email(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	if s.serviceAcct != "" {
 		return s.serviceAcct, nil
 	}
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
 	result, err := s.callMetadataService(ctx)
 	if err != nil {
 		msg := "failed to determine service account: %v; initialize the SDK with service " +
@@ -237,7 +247,7 @@ func (s iamSigner) Email(ctx context.Context) (string, error) {
 	return result, nil
 }
 
-func (s iamSigner) callMetadataService(ctx context.Context) (string, error) {
+func (s *iamSigner) callMetadataService(ctx context.Context) (string, error) {
 	url := fmt.Sprintf("%s/computeMetadata/v1/instance/service-accounts/default/email", s.metadataHost)
 	req := &internal.Request{
 		Method: "GET",
@@ -161,7 +161,10 @@ func TestIAMSignerHTTPError(t *testing.T) {
 	defer server.Close()
 	signer.iamHost = server.URL
 
-	want := "http error status: 403; reason: test reason"
+	want := "http error status: 403; reason: test reason; make sure the service account " +
+		"\"test-service-account\" has the Service Account Token Creator role " +
+		"(roles/iam.serviceAccountTokenCreator); refer to " +
+		"https://firebase.google.com/docs/auth/admin/create-custom-tokens for more details"
 	_, err = signer.Sign(context.Background(), []byte("input"))
 	if err == nil || !IsInsufficientPermission(err) || err.Error() != want {
 		t.Errorf("Sign() = %v; want = %q", err, want)
@@ -218,13 +221,19 @@ func TestIAMSignerWithMetadataService(t *testing.T) {
 		w.Write([]byte(serviceAcct))
 	})
 	metadata := httptest.NewServer(handler)
-	defer metadata.Close()
 	signer.metadataHost = metadata.URL
 	email, err := signer.Email(ctx)
 	if email != serviceAcct || err != nil {
 		t.Errorf("Email() = (%q, %v); want = (%q, nil)", email, err, serviceAcct)
 	}
 
+	// discovered service account must be cached
+	metadata.Close()
+	email, err = signer.Email(ctx)
+	if email != serviceAcct || err != nil {
+		t.Errorf("Email() = (%q, %v); want = (%q, nil)", email, err, serviceAcct)
+	}
+
 	// start mock IAM service and test Sign()
 	wantSignature := "test-signature"
 	server := iamServer(t, email, wantSignature)
@@ -276,7 +285,8 @@ func (s *mockSigner) Sign(ctx context.Context, b []byte) ([]byte, error) {
 
 func iamServer(t *testing.T, serviceAcct, signature string) *httptest.Server {
 	resp := map[string]interface{}{
-		"signature": base64.StdEncoding.EncodeToString([]byte(signature)),
+		"keyId":      "test-key-id",
+		"signedBlob": base64.StdEncoding.EncodeToString([]byte(signature)),
 	}
 	wantPath := fmt.Sprintf("/v1/projects/-/serviceAccounts/%s:signBlob", serviceAcct)
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -289,8 +299,8 @@ func iamServer(t *testing.T, serviceAcct, signature string) *httptest.Server {
 		if err := json.Unmarshal(reqBody, &m); err != nil {
 			t.Fatal(err)
 		}
-		if m["bytesToSign"] == "" {
-			t.Fatal("BytesToSign = empty; want = non-empty")
+		if m["payload"] == "" {
+			t.Fatal("Payload = empty; want = non-empty")
 		}
 		if r.URL.Path != wantPath {
 			t.Errorf("Path = %q; want = %q", r.URL.Path, wantPath)
@@ -27,7 +27,11 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -44,6 +48,7 @@ const (
 	sessionCookieCertURL      = "https://www.googleapis.com/identitytoolkit/v3/relyingparty/publicKeys"
 	sessionCookieIssuerPrefix = "https://session.firebase.google.com/"
 	clockSkewSeconds          = 300
+	emulatorHostEnvVar        = "FIREBASE_AUTH_EMULATOR_HOST"
 )
 
 // tokenVerifier verifies different types of Firebase token strings, including ID tokens and
@@ -56,6 +61,7 @@ type tokenVerifier struct {
 	issuerPrefix      string
 	keySource         keySource
 	clock             internal.Clock
+	emulated          bool
 }
 
 func newIDTokenVerifier(ctx context.Context, projectID string) (*tokenVerifier, error) {
@@ -71,6 +77,7 @@ func newIDTokenVerifier(ctx context.Context, projectID string) (*tokenVerifier,
 		issuerPrefix:      idTokenIssuerPrefix,
 		keySource:         newHTTPKeySource(idTokenCertURL, noAuthHTTPClient),
 		clock:             internal.SystemClock,
+		emulated:          isEmulated(),
 	}, nil
 }
 
@@ -87,9 +94,41 @@ func newSessionCookieVerifier(ctx context.Context, projectID string) (*tokenVeri
 		issuerPrefix:      sessionCookieIssuerPrefix,
 		keySource:         newHTTPKeySource(sessionCookieCertURL, noAuthHTTPClient),
 		clock:             internal.SystemClock,
+		emulated:          isEmulated(),
 	}, nil
 }
 
+// setCertURL points the tokenVerifier at a different URL to fetch public keys from.
+func (tv *tokenVerifier) setCertURL(certURL string) error {
+	u, err := url.Parse(certURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s cert URL must be a valid http or https URL: %q", tv.shortName, certURL)
+	}
+	ks, ok := tv.keySource.(*httpKeySource)
+	if !ok {
+		return fmt.Errorf("%s cert URL cannot be set on a non-HTTP key source", tv.shortName)
+	}
+	ks.KeyURI = certURL
+	return nil
+}
+
+// setKeySource replaces the public keys fetched from Google with the keys provided by src.
+func (tv *tokenVerifier) setKeySource(src internal.PublicKeySource) {
+	tv.keySource = &customKeySource{src: src}
+}
+
+// setLogger sets the Logger that receives messages about public key refreshes.
+func (tv *tokenVerifier) setLogger(logger internal.Logger) {
+	if ks, ok := tv.keySource.(*httpKeySource); ok {
+		ks.Logger = logger
+	}
+}
+
+// isEmulated reports whether the SDK is configured to talk to the Firebase Auth emulator.
+func isEmulated() bool {
+	return os.Getenv(emulatorHostEnvVar) != ""
+}
+
 // VerifyToken Verifies that the given token string is a valid Firebase JWT.
 //
 // VerifyToken considers a token string to be valid if all the following conditions are met:
@@ -101,10 +140,31 @@ func newSessionCookieVerifier(ctx context.Context, projectID string) (*tokenVeri
 //   - The JWT is not expired, and it has been issued some time in the past.
 //   - The JWT is signed by a Firebase Auth backend server as determined by the keySource.
 //
+// If the FIREBASE_AUTH_EMULATOR_HOST environment variable was set when the tokenVerifier was
+// created, the token is assumed to be issued by the Firebase Auth emulator. In that case
+// unsigned tokens (alg "none") without a key ID are accepted, and the signature is not verified.
+// All the other conditions still apply.
+//
 // If any of the above conditions are not met, an error is returned. Otherwise a pointer to a
 // decoded Token is returned.
 func (tv *tokenVerifier) VerifyToken(ctx context.Context, token string) (*Token, error) {
-	if tv.projectID == "" {
+	return tv.verifyToken(ctx, token, false, nil)
+}
+
+// VerifyTokenWithOptions verifies the given token like VerifyToken, using the given options. With
+// IgnoreExpiration set, the exp and iat claims are not checked. With AllowedAudiences set, the aud
+// claim must match one of the allowed audiences instead of the projectID of the tokenVerifier.
+func (tv *tokenVerifier) VerifyTokenWithOptions(
+	ctx context.Context, token string, opts *TokenVerifyOptions) (*Token, error) {
+	if opts == nil {
+		opts = &TokenVerifyOptions{}
+	}
+	return tv.verifyToken(ctx, token, opts.IgnoreExpiration, opts.AllowedAudiences)
+}
+
+func (tv *tokenVerifier) verifyToken(
+	ctx context.Context, token string, ignoreTimestamps bool, audiences []string) (*Token, error) {
+	if tv.projectID == "" && len(audiences) == 0 {
 		return nil, errors.New("project id not available")
 	}
 	if token == "" {
@@ -112,14 +172,21 @@ func (tv *tokenVerifier) VerifyToken(ctx context.Context, token string) (*Token,
 	}
 
 	// Validate the token content first. This is fast and cheap.
-	payload, err := tv.verifyContent(token)
+	payload, err := tv.verifyContent(token, audiences)
 	if err != nil {
 		return nil, fmt.Errorf("%s; see %s for details on how to retrieve a valid %s",
 			err.Error(), tv.docURL, tv.shortName)
 	}
 
-	if err := tv.verifyTimestamps(payload); err != nil {
-		return nil, err
+	if !ignoreTimestamps {
+		if err := tv.verifyTimestamps(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	// Tokens issued by the emulator are not signed.
+	if tv.emulated {
+		return payload, nil
 	}
 
 	// Verifying the signature requires syncronized access to a key cache and
@@ -130,12 +197,8 @@ func (tv *tokenVerifier) VerifyToken(ctx context.Context, token string) (*Token,
 	return payload, nil
 }
 
-func (tv *tokenVerifier) verifyContent(token string) (*Token, error) {
-	var (
-		header  jwtHeader
-		payload Token
-	)
-
+func (tv *tokenVerifier) verifyContent(token string, audiences []string) (*Token, error) {
+	var header jwtHeader
 	segments := strings.Split(token, ".")
 	if len(segments) != 3 {
 		return nil, errors.New("incorrect number of segments")
@@ -145,24 +208,37 @@ func (tv *tokenVerifier) verifyContent(token string) (*Token, error) {
 		return nil, err
 	}
 
-	if err := decode(segments[1], &payload); err != nil {
+	payload, err := decodePayload(segments[1])
+	if err != nil {
 		return nil, err
 	}
 
-	issuer := tv.issuerPrefix + tv.projectID
 	if header.KeyID == "" {
 		if payload.Audience == firebaseAudience {
 			return nil, fmt.Errorf("expected %s but got a custom token", tv.articledShortName)
 		}
-		return nil, fmt.Errorf("%s has no 'kid' header", tv.shortName)
+		if !tv.emulated {
+			return nil, fmt.Errorf("%s has no 'kid' header", tv.shortName)
+		}
 	}
-	if header.Algorithm != "RS256" {
+	if header.Algorithm != "RS256" && !(tv.emulated && header.Algorithm == "none") {
 		return nil, fmt.Errorf("%s has invalid algorithm; expected 'RS256' but got %q",
 			tv.shortName, header.Algorithm)
 	}
-	if payload.Audience != tv.projectID {
-		return nil, fmt.Errorf("%s has invalid 'aud' (audience) claim; expected %q but got %q; %s",
-			tv.shortName, tv.projectID, payload.Audience, tv.getProjectIDMatchMessage())
+	// The issuer of a Firebase token always ends with the same project ID as its audience.
+	var issuer string
+	if len(audiences) > 0 {
+		if !containsString(audiences, payload.Audience) {
+			return nil, fmt.Errorf("%s has invalid 'aud' (audience) claim; expected one of %q but got %q",
+				tv.shortName, audiences, payload.Audience)
+		}
+		issuer = tv.issuerPrefix + payload.Audience
+	} else {
+		if payload.Audience != tv.projectID {
+			return nil, fmt.Errorf("%s has invalid 'aud' (audience) claim; expected %q but got %q; %s",
+				tv.shortName, tv.projectID, payload.Audience, tv.getProjectIDMatchMessage())
+		}
+		issuer = tv.issuerPrefix + tv.projectID
 	}
 	if payload.Issuer != issuer {
 		return nil, fmt.Errorf("%s has invalid 'iss' (issuer) claim; expected %q but got %q; %s",
@@ -176,20 +252,38 @@ func (tv *tokenVerifier) verifyContent(token string) (*Token, error) {
 			tv.shortName)
 	}
 
+	return payload, nil
+}
+
+// decodePayload decodes the payload segment of a JWT into a Token, without verifying any of its
+// claims.
+func decodePayload(segment string) (*Token, error) {
+	var payload Token
+	if err := decode(segment, &payload); err != nil {
+		return nil, err
+	}
 	payload.UID = payload.Subject
 
 	var customClaims map[string]interface{}
-	if err := decode(segments[1], &customClaims); err != nil {
+	if err := decode(segment, &customClaims); err != nil {
 		return nil, err
 	}
 	for _, standardClaim := range []string{"iss", "aud", "exp", "iat", "sub", "uid"} {
 		delete(customClaims, standardClaim)
 	}
 	payload.Claims = customClaims
-
 	return &payload, nil
 }
 
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (tv *tokenVerifier) verifyTimestamps(payload *Token) error {
 	if (payload.IssuedAt - clockSkewSeconds) > tv.clock.Now().Unix() {
 		return fmt.Errorf("%s issued at future timestamp: %d", tv.shortName, payload.IssuedAt)
@@ -260,6 +354,44 @@ type publicKey struct {
 	Key *rsa.PublicKey
 }
 
+// PublicKeySet is a snapshot of the public keys that are used to verify the signatures of ID tokens or
+// session cookies.
+//
+// Keys maps each key ID (the kid header of a token) to its RSA public key. Expiry is the time until which
+// the keys may be cached, as advertised by the server they were fetched from. Expiry is zero when the keys
+// are not fetched from a server.
+type PublicKeySet struct {
+	Keys   map[string]*rsa.PublicKey
+	Expiry time.Time
+}
+
+// publicKeySet returns a copy of the public keys of the tokenVerifier, fetching them if they are not
+// cached yet. The copy shares no state with the cache.
+func (tv *tokenVerifier) publicKeySet(ctx context.Context) (*PublicKeySet, error) {
+	var (
+		keys   []*publicKey
+		expiry time.Time
+		err    error
+	)
+	if ks, ok := tv.keySource.(*httpKeySource); ok {
+		keys, expiry, err = ks.keysWithExpiry(ctx)
+	} else {
+		keys, err = tv.keySource.Keys(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	set := &PublicKeySet{
+		Keys:   make(map[string]*rsa.PublicKey, len(keys)),
+		Expiry: expiry,
+	}
+	for _, k := range keys {
+		set.Keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).Set(k.Key.N), E: k.Key.E}
+	}
+	return set, nil
+}
+
 // keySource is used to obtain a set of public keys, which can be used to verify cryptographic
 // signatures.
 type keySource interface {
@@ -276,6 +408,7 @@ type httpKeySource struct {
 	ExpiryTime time.Time
 	Clock      internal.Clock
 	Mutex      *sync.Mutex
+	Logger     internal.Logger
 }
 
 func newHTTPKeySource(uri string, hc *http.Client) *httpKeySource {
@@ -284,21 +417,31 @@ func newHTTPKeySource(uri string, hc *http.Client) *httpKeySource {
 		HTTPClient: hc,
 		Clock:      internal.SystemClock,
 		Mutex:      &sync.Mutex{},
+		Logger:     internal.NopLogger,
 	}
 }
 
 // Keys returns the RSA Public Keys hosted at this key source's URI. Refreshes the data if
 // the cache is stale.
 func (k *httpKeySource) Keys(ctx context.Context) ([]*publicKey, error) {
+	keys, _, err := k.keysWithExpiry(ctx)
+	return keys, err
+}
+
+// keysWithExpiry returns the same keys as Keys, along with the time until which they are cached.
+func (k *httpKeySource) keysWithExpiry(ctx context.Context) ([]*publicKey, time.Time, error) {
 	k.Mutex.Lock()
 	defer k.Mutex.Unlock()
 	if len(k.CachedKeys) == 0 || k.hasExpired() {
 		err := k.refreshKeys(ctx)
+		if err != nil {
+			internal.LoggerOrNop(k.Logger).Warnf("failed to refresh public keys from %q: %v", k.KeyURI, err)
+		}
 		if err != nil && len(k.CachedKeys) == 0 {
-			return nil, err
+			return nil, time.Time{}, err
 		}
 	}
-	return k.CachedKeys, nil
+	return k.CachedKeys, k.ExpiryTime, nil
 }
 
 // hasExpired indicates whether the cache has expired.
@@ -329,7 +472,10 @@ func (k *httpKeySource) refreshKeys(ctx context.Context) error {
 	}
 	newKeys, err := parsePublicKeys(contents)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to parse public keys from %q: %v", k.KeyURI, err)
+	}
+	if len(newKeys) == 0 {
+		return fmt.Errorf("no public keys found in the response from %q", k.KeyURI)
 	}
 	maxAge, err := findMaxAge(resp)
 	if err != nil {
@@ -337,9 +483,34 @@ func (k *httpKeySource) refreshKeys(ctx context.Context) error {
 	}
 	k.CachedKeys = append([]*publicKey(nil), newKeys...)
 	k.ExpiryTime = k.Clock.Now().Add(*maxAge)
+	internal.LoggerOrNop(k.Logger).Debugf(
+		"refreshed %d public keys from %q; cached until %v", len(newKeys), k.KeyURI, k.ExpiryTime)
 	return nil
 }
 
+// customKeySource adapts a PublicKeySource supplied by the developer to the keySource interface.
+// Keys are not cached, since the source is expected to serve them from memory.
+type customKeySource struct {
+	src internal.PublicKeySource
+}
+
+// Keys returns the keys of the underlying source, ordered by key ID. Nil keys are ignored.
+func (k *customKeySource) Keys(ctx context.Context) ([]*publicKey, error) {
+	keys, err := k.src.PublicKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*publicKey
+	for kid, key := range keys {
+		if key != nil {
+			result = append(result, &publicKey{Kid: kid, Key: key})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Kid < result[j].Kid })
+	return result, nil
+}
+
 func parsePublicKeys(keys []byte) ([]*publicKey, error) {
 	m := make(map[string]string)
 	err := json.Unmarshal(keys, &m)
@@ -16,11 +16,17 @@ package auth
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -50,6 +56,110 @@ func TestNewIDTokenVerifier(t *testing.T) {
 	}
 }
 
+func TestNewTokenVerifierEmulated(t *testing.T) {
+	os.Setenv(emulatorHostEnvVar, "localhost:9099")
+	defer os.Unsetenv(emulatorHostEnvVar)
+
+	idTokenVerifier, err := newIDTokenVerifier(context.Background(), testProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !idTokenVerifier.emulated {
+		t.Errorf("idTokenVerifier.emulated = false; want = true")
+	}
+	cookieVerifier, err := newSessionCookieVerifier(context.Background(), testProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cookieVerifier.emulated {
+		t.Errorf("cookieVerifier.emulated = false; want = true")
+	}
+
+	os.Unsetenv(emulatorHostEnvVar)
+	tv, err := newIDTokenVerifier(context.Background(), testProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tv.emulated {
+		t.Errorf("tokenVerifier.emulated = true; want = false")
+	}
+}
+
+func TestVerifyTokenEmulated(t *testing.T) {
+	os.Setenv(emulatorHostEnvVar, "localhost:9099")
+	defer os.Unsetenv(emulatorHostEnvVar)
+	tv, err := idTokenVerifierForTests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{getEmulatedIDToken(nil), testIDToken} {
+		ft, err := tv.VerifyToken(context.Background(), token)
+		if err != nil {
+			t.Fatalf("VerifyToken() = %v; want = nil", err)
+		}
+		if ft.UID != "1234567890" || ft.Claims["admin"] != true {
+			t.Errorf("VerifyToken() = %#v; want = {UID: 1234567890, admin: true}", ft)
+		}
+	}
+
+	now := testClock.Now().Unix()
+	cases := []struct {
+		name, token, want string
+	}{
+		{"BadAudience", getEmulatedIDToken(mockIDTokenPayload{"aud": "bad-audience"}), "ID token has invalid 'aud'"},
+		{"BadIssuer", getEmulatedIDToken(mockIDTokenPayload{"iss": "bad-issuer"}), "ID token has invalid 'iss'"},
+		{"EmptySubject", getEmulatedIDToken(mockIDTokenPayload{"sub": ""}), "ID token has empty 'sub'"},
+		{
+			"ExpiredToken",
+			getEmulatedIDToken(mockIDTokenPayload{"iat": now - 10000, "exp": now - 5000}),
+			"ID token has expired",
+		},
+	}
+	for _, tc := range cases {
+		if _, err := tv.VerifyToken(context.Background(), tc.token); err == nil ||
+			!strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("VerifyToken(%q) = %v; want = %q", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestVerifyTokenNotEmulated(t *testing.T) {
+	os.Unsetenv(emulatorHostEnvVar)
+	tv, err := idTokenVerifierForTests(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ID token has no 'kid' header"
+	if _, err := tv.VerifyToken(context.Background(), getEmulatedIDToken(nil)); err == nil ||
+		!strings.HasPrefix(err.Error(), want) {
+		t.Errorf("VerifyToken() = %v; want = %q", err, want)
+	}
+}
+
+// getEmulatedIDToken returns an unsigned ID token like the ones issued by the Auth emulator.
+func getEmulatedIDToken(p mockIDTokenPayload) string {
+	pCopy := mockIDTokenPayload{
+		"aud":   testProjectID,
+		"iss":   "https://securetoken.google.com/" + testProjectID,
+		"iat":   testClock.Now().Unix() - 100,
+		"exp":   testClock.Now().Unix() + 3600,
+		"sub":   "1234567890",
+		"admin": true,
+	}
+	for k, v := range p {
+		pCopy[k] = v
+	}
+
+	encode := func(i interface{}) string {
+		b, err := json.Marshal(i)
+		logFatal(err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return encode(jwtHeader{Algorithm: "none", Type: "JWT"}) + "." + encode(pCopy) + "."
+}
+
 func TestHTTPKeySource(t *testing.T) {
 	data, err := ioutil.ReadFile("../testdata/public_certs.json")
 	if err != nil {
@@ -83,6 +193,52 @@ func TestHTTPKeySourceWithClient(t *testing.T) {
 	}
 }
 
+func TestPublicKeySet(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/public_certs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc, rc := newTestHTTPClient(data)
+	ks := newHTTPKeySource("http://mock.url", hc)
+	ks.Clock = &internal.MockClock{Timestamp: time.Unix(0, 0)}
+	tv := &tokenVerifier{keySource: ks}
+
+	set, err := tv.publicKeySet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 3 {
+		t.Errorf("Keys = %d; want = 3", len(set.Keys))
+	}
+	if want := time.Unix(100, 0); !set.Expiry.Equal(want) {
+		t.Errorf("Expiry = %v; want = %v", set.Expiry, want)
+	}
+	for _, k := range ks.CachedKeys {
+		if got := set.Keys[k.Kid]; got == nil || got == k.Key || !reflect.DeepEqual(got, k.Key) {
+			t.Errorf("Keys[%q] = %v; want a copy of %v", k.Kid, got, k.Key)
+		}
+	}
+
+	// Modifying the returned set must not affect the cache, and the cached keys must be reused.
+	for kid, k := range set.Keys {
+		k.E = 0
+		delete(set.Keys, kid)
+	}
+	set, err = tv.publicKeySet(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range set.Keys {
+		if k.E == 0 {
+			t.Errorf("publicKeySet() returned a modified key")
+		}
+	}
+	if len(set.Keys) != 3 || rc.closeCount != 1 {
+		t.Errorf("publicKeySet() = %d keys, %d HTTP calls; want = 3 keys, 1 HTTP call", len(set.Keys), rc.closeCount)
+	}
+}
+
 func TestHTTPKeySourceEmptyResponse(t *testing.T) {
 	hc, _ := newTestHTTPClient([]byte(""))
 	ks := newHTTPKeySource("http://mock.url", hc)
@@ -99,6 +255,40 @@ func TestHTTPKeySourceIncorrectResponse(t *testing.T) {
 	}
 }
 
+func TestHTTPKeySourceNoKeys(t *testing.T) {
+	hc, _ := newTestHTTPClient([]byte("{}"))
+	ks := newHTTPKeySource("http://mock.url", hc)
+	want := `no public keys found in the response from "http://mock.url"`
+	if keys, err := ks.Keys(context.Background()); keys != nil || err == nil || err.Error() != want {
+		t.Errorf("Keys() = (%v, %v); want = (nil, %q)", keys, err, want)
+	}
+}
+
+func TestVerifyTokenWithCertURL(t *testing.T) {
+	certs, err := ioutil.ReadFile("../testdata/public_certs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=100")
+		w.Write(certs)
+	}))
+	defer ts.Close()
+
+	tv, err := newIDTokenVerifier(context.Background(), testProjectID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tv.setCertURL(ts.URL); err != nil {
+		t.Fatal(err)
+	}
+	tv.clock = testClock
+
+	if _, err := tv.VerifyToken(context.Background(), testIDToken); err != nil {
+		t.Errorf("VerifyToken() = %v; want = nil", err)
+	}
+}
+
 func TestHTTPKeySourceHTTPError(t *testing.T) {
 	rc := &mockReadCloser{
 		data:       string(""),
@@ -132,6 +322,51 @@ func TestHTTPKeySourceTransportError(t *testing.T) {
 	}
 }
 
+type recordingLogger struct {
+	debug []string
+	warn  []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warn = append(l.warn, fmt.Sprintf(format, args...))
+}
+
+func TestHTTPKeySourceLogging(t *testing.T) {
+	data, err := ioutil.ReadFile("../testdata/public_certs.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hc, _ := newTestHTTPClient(data)
+	ks := newHTTPKeySource("http://mock.url", hc)
+	logger := &recordingLogger{}
+	ks.Logger = logger
+	if _, err := ks.Keys(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.debug) != 1 || !strings.HasPrefix(logger.debug[0], `refreshed 3 public keys from "http://mock.url"`) {
+		t.Errorf("Debugf() = %v; want = [refreshed 3 public keys ...]", logger.debug)
+	}
+
+	hc = &http.Client{
+		Transport: &mockHTTPResponse{
+			Err: errors.New("transport error"),
+		},
+	}
+	ks = newHTTPKeySource("http://mock.url", hc)
+	ks.Logger = logger
+	if _, err := ks.Keys(context.Background()); err == nil {
+		t.Fatal("Keys() = nil; want = error")
+	}
+	if len(logger.warn) != 1 || !strings.HasPrefix(logger.warn[0], `failed to refresh public keys from "http://mock.url"`) {
+		t.Errorf("Warnf() = %v; want = [failed to refresh public keys ...]", logger.warn)
+	}
+}
+
 func TestFindMaxAge(t *testing.T) {
 	cases := []struct {
 		cc   string
@@ -53,24 +53,109 @@ type UserInfo struct {
 }
 
 // UserMetadata contains additional metadata associated with a user account.
-// Timestamps are in milliseconds since epoch.
+//
+// Timestamps are in milliseconds since the Unix epoch, and are therefore independent of any time
+// zone. A timestamp of 0 indicates that the corresponding event has not occurred, or is not known.
+// Use the CreationTime, LastLogInTime and LastRefreshTime methods to obtain the timestamps as UTC
+// time.Time values.
+//
+// LastRefreshTimestamp is the last time the user's ID token was refreshed. It is set by the backend,
+// and is ignored when importing users with ImportUsers. It is left at 0 if the backend returns a
+// malformed value.
 type UserMetadata struct {
-	CreationTimestamp  int64
-	LastLogInTimestamp int64
+	CreationTimestamp    int64
+	LastLogInTimestamp   int64
+	LastRefreshTimestamp int64
+}
+
+// CreationTime returns the time the user account was created, in UTC.
+//
+// Returns the zero time.Time if the creation time is not known.
+func (m *UserMetadata) CreationTime() time.Time {
+	return millisToUTC(m.CreationTimestamp)
+}
+
+// LastLogInTime returns the time the user last signed in, in UTC.
+//
+// Returns the zero time.Time if the user has never signed in.
+func (m *UserMetadata) LastLogInTime() time.Time {
+	return millisToUTC(m.LastLogInTimestamp)
+}
+
+// LastRefreshTime returns the time the user's ID token was last refreshed, in UTC.
+//
+// Returns the zero time.Time if the user's ID token has never been refreshed.
+func (m *UserMetadata) LastRefreshTime() time.Time {
+	return millisToUTC(m.LastRefreshTimestamp)
+}
+
+// LastActivityTime returns the time of the most recent activity of the user, in UTC. This is the
+// later of the last sign-in time and the last ID token refresh time, since a signed-in user may stay
+// active for a long time without signing in again. If neither is known, the creation time is used.
+//
+// Returns the zero time.Time if none of the timestamps is known.
+func (m *UserMetadata) LastActivityTime() time.Time {
+	millis := m.LastLogInTimestamp
+	if m.LastRefreshTimestamp > millis {
+		millis = m.LastRefreshTimestamp
+	}
+	if millis == 0 {
+		millis = m.CreationTimestamp
+	}
+	return millisToUTC(millis)
+}
+
+func millisToUTC(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
 }
 
 // UserRecord contains metadata associated with a Firebase user account.
+//
+// TenantID is the ID of the tenant the user belongs to, or empty if the user does not belong to a tenant.
+//
+// TokensValidAfterMillis is parsed from the validSince field of the user account, and is updated by
+// RevokeRefreshTokens. ID tokens and session cookies issued (iat) before this time are considered revoked by
+// VerifyIDTokenAndCheckRevoked and VerifySessionCookieAndCheckRevoked. Applications implementing their own
+// revocation checks should compare the IssuedAt field of a Token, in milliseconds, against this value.
 type UserRecord struct {
 	*UserInfo
 	CustomClaims           map[string]interface{}
 	Disabled               bool
 	EmailVerified          bool
 	ProviderUserInfo       []*UserInfo
+	TenantID               string
 	TokensValidAfterMillis int64 // milliseconds since epoch.
 	UserMetadata           *UserMetadata
 }
 
+// DaysSinceLastActivity returns the number of full days (24-hour periods) between the last activity
+// of the user, as reported by UserMetadata.LastActivityTime, and the given time. It returns 0 if the
+// last activity is after the given time.
+//
+// The second return value is false if the user record carries no timestamps to compute the last
+// activity from, which is never the case for records returned by the backend.
+func (r *UserRecord) DaysSinceLastActivity(now time.Time) (int, bool) {
+	if r.UserMetadata == nil {
+		return 0, false
+	}
+	last := r.UserMetadata.LastActivityTime()
+	if last.IsZero() {
+		return 0, false
+	}
+	if now.Before(last) {
+		return 0, true
+	}
+	return int(now.Sub(last) / (24 * time.Hour)), true
+}
+
 // UserToCreate is the parameter struct for the CreateUser function.
+//
+// The backend API used by CreateUser does not accept custom claims. To create a user account with custom claims
+// in a single call, use ImportUsers with UserToImport.CustomClaims instead. Otherwise, set the claims with
+// SetCustomUserClaims after the user has been created.
 type UserToCreate struct {
 	params map[string]interface{}
 }
@@ -123,40 +208,46 @@ func (u *UserToCreate) set(key string, value interface{}) *UserToCreate {
 	return u
 }
 
+// UserToCreateError is returned by CreateUser when a UserToCreate value fails local validation, before any
+// request is made.
+//
+// Field holds the name of the setter of the offending field (e.g. "Email" or "PhoneNumber").
+type UserToCreateError struct {
+	Field  string
+	Reason string
+}
+
+func (e *UserToCreateError) Error() string {
+	return fmt.Sprintf("invalid UserToCreate.%s: %s", e.Field, e.Reason)
+}
+
+// userToCreateValidators lists the validated fields of UserToCreate in the order they are checked.
+var userToCreateValidators = []struct {
+	key       string
+	field     string
+	validator func(string) error
+}{
+	{"localId", "UID", validateUID},
+	{"displayName", "DisplayName", validateDisplayName},
+	{"email", "Email", validateEmail},
+	{"phoneNumber", "PhoneNumber", validatePhone},
+	{"photoUrl", "PhotoURL", validatePhotoURL},
+	{"password", "Password", validatePassword},
+}
+
 func (u *UserToCreate) validatedRequest() (map[string]interface{}, error) {
 	req := make(map[string]interface{})
 	for k, v := range u.params {
 		req[k] = v
 	}
 
-	if uid, ok := req["localId"]; ok {
-		if err := validateUID(uid.(string)); err != nil {
-			return nil, err
+	for _, v := range userToCreateValidators {
+		val, ok := req[v.key]
+		if !ok {
+			continue
 		}
-	}
-	if name, ok := req["displayName"]; ok {
-		if err := validateDisplayName(name.(string)); err != nil {
-			return nil, err
-		}
-	}
-	if email, ok := req["email"]; ok {
-		if err := validateEmail(email.(string)); err != nil {
-			return nil, err
-		}
-	}
-	if phone, ok := req["phoneNumber"]; ok {
-		if err := validatePhone(phone.(string)); err != nil {
-			return nil, err
-		}
-	}
-	if url, ok := req["photoUrl"]; ok {
-		if err := validatePhotoURL(url.(string)); err != nil {
-			return nil, err
-		}
-	}
-	if pw, ok := req["password"]; ok {
-		if err := validatePassword(pw.(string)); err != nil {
-			return nil, err
+		if err := v.validator(val.(string)); err != nil {
+			return nil, &UserToCreateError{Field: v.field, Reason: err.Error()}
 		}
 	}
 
@@ -209,6 +300,18 @@ func (u *UserToUpdate) PhotoURL(url string) *UserToUpdate {
 	return u.set("photoUrl", url)
 }
 
+// RemoveDisplayName removes the display name from the user account. This is equivalent to setting the
+// display name to an empty string, and overrides any display name set on the same UserToUpdate.
+func (u *UserToUpdate) RemoveDisplayName() *UserToUpdate {
+	return u.set("displayName", "")
+}
+
+// RemovePhotoURL removes the photo URL from the user account. This is equivalent to setting the photo URL
+// to an empty string, and overrides any photo URL set on the same UserToUpdate.
+func (u *UserToUpdate) RemovePhotoURL() *UserToUpdate {
+	return u.set("photoUrl", "")
+}
+
 // revokeRefreshTokens revokes all refresh tokens for a user by setting the validSince property
 // to the present in epoch seconds.
 func (u *UserToUpdate) revokeRefreshTokens() *UserToUpdate {
@@ -315,11 +418,13 @@ func marshalCustomClaims(claims map[string]interface{}) (string, error) {
 // Error handlers.
 
 const (
+	configurationExists      = "configuration-already-exists"
 	configurationNotFound    = "configuration-not-found"
 	emailAlreadyExists       = "email-already-exists"
 	idTokenRevoked           = "id-token-revoked"
 	insufficientPermission   = "insufficient-permission"
 	invalidDynamicLinkDomain = "invalid-dynamic-link-domain"
+	invalidHostingLinkDomain = "invalid-hosting-link-domain"
 	phoneNumberAlreadyExists = "phone-number-already-exists"
 	projectNotFound          = "project-not-found"
 	sessionCookieRevoked     = "session-cookie-revoked"
@@ -329,6 +434,11 @@ const (
 	userNotFound             = "user-not-found"
 )
 
+// IsConfigurationAlreadyExists checks if the given error was due to a duplicate IdP configuration.
+func IsConfigurationAlreadyExists(err error) bool {
+	return internal.HasErrorCode(err, configurationExists)
+}
+
 // IsConfigurationNotFound checks if the given error was due to a non-existing IdP configuration.
 func IsConfigurationNotFound(err error) bool {
 	return internal.HasErrorCode(err, configurationNotFound)
@@ -354,6 +464,11 @@ func IsInvalidDynamicLinkDomain(err error) bool {
 	return internal.HasErrorCode(err, invalidDynamicLinkDomain)
 }
 
+// IsInvalidHostingLinkDomain checks if the given error was due to an invalid Firebase Hosting link domain.
+func IsInvalidHostingLinkDomain(err error) bool {
+	return internal.HasErrorCode(err, invalidHostingLinkDomain)
+}
+
 // IsPhoneNumberAlreadyExists checks if the given error was due to a duplicate phone number.
 func IsPhoneNumberAlreadyExists(err error) bool {
 	return internal.HasErrorCode(err, phoneNumberAlreadyExists)
@@ -392,10 +507,12 @@ func IsUserNotFound(err error) bool {
 var serverError = map[string]string{
 	"CONFIGURATION_NOT_FOUND":     configurationNotFound,
 	"DUPLICATE_EMAIL":             emailAlreadyExists,
+	"DUPLICATE_IDP_CONFIG":        configurationExists,
 	"DUPLICATE_LOCAL_ID":          uidAlreadyExists,
 	"EMAIL_EXISTS":                emailAlreadyExists,
 	"INSUFFICIENT_PERMISSION":     insufficientPermission,
 	"INVALID_DYNAMIC_LINK_DOMAIN": invalidDynamicLinkDomain,
+	"INVALID_HOSTING_LINK_DOMAIN": invalidHostingLinkDomain,
 	"PERMISSION_DENIED":           insufficientPermission,
 	"PHONE_NUMBER_EXISTS":         phoneNumberAlreadyExists,
 	"PROJECT_NOT_FOUND":           projectNotFound,
@@ -483,6 +600,8 @@ func newUserManagementClient(client *http.Client, conf *internal.AuthConfig) *us
 	hc := internal.WithDefaultRetryConfig(client)
 	hc.CreateErrFn = handleHTTPError
 	hc.SuccessFn = internal.HasSuccessStatus
+	hc.Logger = conf.Logger
+	hc.RetryObserver = conf.RetryObserver
 	hc.Opts = []internal.HTTPOption{
 		internal.WithHeader("X-Client-Version", fmt.Sprintf("Go/Admin/%s", conf.Version)),
 	}
@@ -503,6 +622,27 @@ func (c *userManagementClient) GetUser(ctx context.Context, uid string) (*UserRe
 	})
 }
 
+// UserExists checks whether a user account with the specified user ID exists.
+//
+// Unlike GetUser, UserExists reports a missing user as (false, nil) rather than as an error. A non-nil error is
+// only returned when the existence of the user could not be determined.
+func (c *userManagementClient) UserExists(ctx context.Context, uid string) (bool, error) {
+	if err := validateUID(uid); err != nil {
+		return false, err
+	}
+
+	users, err := c.lookupUsers(ctx, &userQuery{
+		field: "localId",
+		value: uid,
+		label: "uid",
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return len(users) > 0, nil
+}
+
 // GetUserByEmail gets the user data corresponding to the specified email.
 func (c *userManagementClient) GetUserByEmail(ctx context.Context, email string) (*UserRecord, error) {
 	if err := validateEmail(email); err != nil {
@@ -514,6 +654,26 @@ func (c *userManagementClient) GetUserByEmail(ctx context.Context, email string)
 	})
 }
 
+// FindUserByEmail gets the user data corresponding to the specified email, if such a user exists.
+//
+// Unlike GetUserByEmail, FindUserByEmail reports a missing user as (nil, nil) rather than as an error. A non-nil
+// error is only returned when the user could not be looked up.
+func (c *userManagementClient) FindUserByEmail(ctx context.Context, email string) (*UserRecord, error) {
+	if err := validateEmail(email); err != nil {
+		return nil, err
+	}
+
+	users, err := c.lookupUsers(ctx, &userQuery{
+		field: "email",
+		value: email,
+	})
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+
+	return users[0].makeUserRecord(c.httpClient.Logger)
+}
+
 // GetUserByPhoneNumber gets the user data corresponding to the specified user phone number.
 func (c *userManagementClient) GetUserByPhoneNumber(ctx context.Context, phone string) (*UserRecord, error) {
 	if err := validatePhone(phone); err != nil {
@@ -547,19 +707,27 @@ func (q *userQuery) build() map[string]interface{} {
 }
 
 func (c *userManagementClient) getUser(ctx context.Context, query *userQuery) (*UserRecord, error) {
-	var parsed struct {
-		Users []*userQueryResponse `json:"users"`
-	}
-	_, err := c.post(ctx, "/accounts:lookup", query.build(), &parsed)
+	users, err := c.lookupUsers(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(parsed.Users) == 0 {
+	if len(users) == 0 {
 		return nil, internal.Errorf(userNotFound, "cannot find user from %s", query.description())
 	}
 
-	return parsed.Users[0].makeUserRecord()
+	return users[0].makeUserRecord(c.httpClient.Logger)
+}
+
+func (c *userManagementClient) lookupUsers(ctx context.Context, query *userQuery) ([]*userQueryResponse, error) {
+	var parsed struct {
+		Users []*userQueryResponse `json:"users"`
+	}
+	if _, err := c.post(ctx, "/accounts:lookup", query.build(), &parsed); err != nil {
+		return nil, withSubject(err, query.description())
+	}
+
+	return parsed.Users, nil
 }
 
 type userQueryResponse struct {
@@ -570,6 +738,7 @@ type userQueryResponse struct {
 	PhotoURL           string      `json:"photoUrl,omitempty"`
 	CreationTimestamp  int64       `json:"createdAt,string,omitempty"`
 	LastLogInTimestamp int64       `json:"lastLoginAt,string,omitempty"`
+	LastRefreshAt      string      `json:"lastRefreshAt,omitempty"`
 	ProviderID         string      `json:"providerId,omitempty"`
 	CustomAttributes   string      `json:"customAttributes,omitempty"`
 	Disabled           bool        `json:"disabled,omitempty"`
@@ -578,10 +747,13 @@ type userQueryResponse struct {
 	PasswordHash       string      `json:"passwordHash,omitempty"`
 	PasswordSalt       string      `json:"salt,omitempty"`
 	ValidSinceSeconds  int64       `json:"validSince,string,omitempty"`
+	TenantID           string      `json:"tenantId,omitempty"`
 }
 
-func (r *userQueryResponse) makeUserRecord() (*UserRecord, error) {
-	exported, err := r.makeExportedUserRecord()
+// makeUserRecord converts the response into a UserRecord. Problems with optional fields that do not
+// prevent the user from being returned are reported to the given logger, which may be nil.
+func (r *userQueryResponse) makeUserRecord(logger internal.Logger) (*UserRecord, error) {
+	exported, err := r.makeExportedUserRecord(logger)
 	if err != nil {
 		return nil, err
 	}
@@ -589,7 +761,7 @@ func (r *userQueryResponse) makeUserRecord() (*UserRecord, error) {
 	return exported.UserRecord, nil
 }
 
-func (r *userQueryResponse) makeExportedUserRecord() (*ExportedUserRecord, error) {
+func (r *userQueryResponse) makeExportedUserRecord(logger internal.Logger) (*ExportedUserRecord, error) {
 	var customClaims map[string]interface{}
 	if r.CustomAttributes != "" {
 		err := json.Unmarshal([]byte(r.CustomAttributes), &customClaims)
@@ -609,6 +781,18 @@ func (r *userQueryResponse) makeExportedUserRecord() (*ExportedUserRecord, error
 		hash = ""
 	}
 
+	var lastRefreshTimestamp int64
+	if r.LastRefreshAt != "" {
+		// The last refresh time is informational, and a malformed value should not make the user
+		// unavailable.
+		if t, err := time.Parse(time.RFC3339, r.LastRefreshAt); err == nil {
+			lastRefreshTimestamp = t.UnixNano() / int64(time.Millisecond)
+		} else {
+			internal.LoggerOrNop(logger).Warnf(
+				"ignoring malformed last refresh time %q of user %q: %v", r.LastRefreshAt, r.UID, err)
+		}
+	}
+
 	return &ExportedUserRecord{
 		UserRecord: &UserRecord{
 			UserInfo: &UserInfo{
@@ -623,10 +807,12 @@ func (r *userQueryResponse) makeExportedUserRecord() (*ExportedUserRecord, error
 			Disabled:               r.Disabled,
 			EmailVerified:          r.EmailVerified,
 			ProviderUserInfo:       r.ProviderUserInfo,
+			TenantID:               r.TenantID,
 			TokensValidAfterMillis: r.ValidSinceSeconds * 1000,
 			UserMetadata: &UserMetadata{
-				LastLogInTimestamp: r.LastLogInTimestamp,
-				CreationTimestamp:  r.CreationTimestamp,
+				LastLogInTimestamp:   r.LastLogInTimestamp,
+				CreationTimestamp:    r.CreationTimestamp,
+				LastRefreshTimestamp: lastRefreshTimestamp,
 			},
 		},
 		PasswordHash: hash,
@@ -635,6 +821,9 @@ func (r *userQueryResponse) makeExportedUserRecord() (*ExportedUserRecord, error
 }
 
 // CreateUser creates a new user with the specified properties.
+//
+// The properties are validated locally before the user is created. If any of them is invalid, CreateUser returns
+// a *UserToCreateError naming the first invalid field, without making any requests.
 func (c *userManagementClient) CreateUser(ctx context.Context, user *UserToCreate) (*UserRecord, error) {
 	uid, err := c.createUser(ctx, user)
 	if err != nil {
@@ -669,6 +858,23 @@ func (c *userManagementClient) UpdateUser(
 	return c.GetUser(ctx, uid)
 }
 
+// DisableUser disables the user account with the specified user ID, and returns the updated
+// UserRecord.
+//
+// Disabled users cannot sign in, and cannot refresh their ID tokens. This is a shortcut for
+// calling UpdateUser with the Disabled field set to true.
+func (c *userManagementClient) DisableUser(ctx context.Context, uid string) (*UserRecord, error) {
+	return c.UpdateUser(ctx, uid, (&UserToUpdate{}).Disabled(true))
+}
+
+// EnableUser enables the previously disabled user account with the specified user ID, and returns
+// the updated UserRecord.
+//
+// This is a shortcut for calling UpdateUser with the Disabled field set to false.
+func (c *userManagementClient) EnableUser(ctx context.Context, uid string) (*UserRecord, error) {
+	return c.UpdateUser(ctx, uid, (&UserToUpdate{}).Disabled(false))
+}
+
 // RevokeRefreshTokens revokes all refresh tokens issued to a user.
 //
 // RevokeRefreshTokens updates the user's TokensValidAfterMillis to the current UTC second.
@@ -711,7 +917,7 @@ func (c *userManagementClient) updateUser(ctx context.Context, uid string, user
 	request["localId"] = uid
 
 	_, err = c.post(ctx, "/accounts:update", request, nil)
-	return err
+	return withSubject(err, fmt.Sprintf("uid: %q", uid))
 }
 
 // DeleteUser deletes the user by the given UID.
@@ -724,7 +930,7 @@ func (c *userManagementClient) DeleteUser(ctx context.Context, uid string) error
 		"localId": uid,
 	}
 	_, err := c.post(ctx, "/accounts:delete", payload, nil)
-	return err
+	return withSubject(err, fmt.Sprintf("uid: %q", uid))
 }
 
 // SessionCookie creates a new Firebase session cookie from the given ID token and expiry
@@ -739,6 +945,9 @@ func (c *userManagementClient) SessionCookie(
 	if idToken == "" {
 		return "", errors.New("id token must not be empty")
 	}
+	if segments := strings.Count(idToken, ".") + 1; segments != 3 {
+		return "", fmt.Errorf("id token must be a JWT with 3 dot-separated segments; got %d segments", segments)
+	}
 
 	if expiresIn < 5*time.Minute || expiresIn > 14*24*time.Hour {
 		return "", errors.New("expiry duration must be between 5 minutes and 14 days")
@@ -783,6 +992,17 @@ func (c *userManagementClient) makeUserMgtURL(path string) (string, error) {
 	return url, nil
 }
 
+// withSubject appends a description of the user targeted by a failed operation (e.g. `uid: "user1"`) to an error
+// returned by the backend, so that the error identifies the subject of the call. The error code is retained.
+// Other errors, including nil, are returned unchanged.
+func withSubject(err error, subject string) error {
+	fe, ok := err.(*internal.FirebaseError)
+	if !ok {
+		return err
+	}
+	return internal.Errorf(fe.Code, "%s; %s", fe.String, subject)
+}
+
 func handleHTTPError(resp *internal.Response) error {
 	var httpErr struct {
 		Error struct {
@@ -790,7 +1010,11 @@ func handleHTTPError(resp *internal.Response) error {
 		} `json:"error"`
 	}
 	json.Unmarshal(resp.Body, &httpErr) // ignore any json parse errors at this level
+	// The backend may append a description to the error code (e.g. "CONFIGURATION_NOT_FOUND : details").
 	serverCode := httpErr.Error.Message
+	if idx := strings.Index(serverCode, ":"); idx != -1 {
+		serverCode = strings.TrimSpace(serverCode[:idx])
+	}
 	clientCode, ok := serverError[serverCode]
 	if !ok {
 		clientCode = unknown
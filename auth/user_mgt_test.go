@@ -26,9 +26,12 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"firebase.google.com/go/auth/hash"
 	"firebase.google.com/go/internal"
 	"google.golang.org/api/iterator"
 )
@@ -60,8 +63,9 @@ var testUser = &UserRecord{
 	},
 	TokensValidAfterMillis: 1494364393000,
 	UserMetadata: &UserMetadata{
-		CreationTimestamp:  1234567890000,
-		LastLogInTimestamp: 1233211232000,
+		CreationTimestamp:    1234567890000,
+		LastLogInTimestamp:   1233211232000,
+		LastRefreshTimestamp: 1234161632123,
 	},
 	CustomClaims: map[string]interface{}{"admin": true, "package": "gold"},
 }
@@ -85,6 +89,134 @@ func TestGetUser(t *testing.T) {
 	}
 }
 
+func TestUserMetadataTimes(t *testing.T) {
+	metadata := testUser.UserMetadata
+	cases := []struct {
+		name string
+		got  time.Time
+		want time.Time
+	}{
+		{"CreationTime", metadata.CreationTime(), time.Date(2009, 2, 13, 23, 31, 30, 0, time.UTC)},
+		{"LastLogInTime", metadata.LastLogInTime(), time.Date(2009, 1, 29, 6, 40, 32, 0, time.UTC)},
+		{"LastRefreshTime", metadata.LastRefreshTime(), time.Date(2009, 2, 9, 6, 40, 32, 123000000, time.UTC)},
+	}
+	for _, tc := range cases {
+		if !tc.got.Equal(tc.want) || tc.got.Location() != time.UTC {
+			t.Errorf("%s() = %v; want = %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	empty := &UserMetadata{}
+	if !empty.CreationTime().IsZero() || !empty.LastLogInTime().IsZero() || !empty.LastRefreshTime().IsZero() {
+		t.Errorf("UserMetadata{} times = (%v, %v, %v); want = zero",
+			empty.CreationTime(), empty.LastLogInTime(), empty.LastRefreshTime())
+	}
+}
+
+func TestLastActivity(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	login := created.Add(24 * time.Hour)
+	refresh := created.Add(72 * time.Hour)
+	millis := func(t time.Time) int64 {
+		return t.UnixNano() / int64(time.Millisecond)
+	}
+	now := refresh.Add(10*24*time.Hour - time.Second)
+
+	cases := []struct {
+		name     string
+		metadata *UserMetadata
+		want     time.Time
+		wantDays int
+	}{
+		{
+			name: "RefreshAfterLogIn",
+			metadata: &UserMetadata{
+				CreationTimestamp:    millis(created),
+				LastLogInTimestamp:   millis(login),
+				LastRefreshTimestamp: millis(refresh),
+			},
+			want:     refresh,
+			wantDays: 9,
+		},
+		{
+			name: "LogInOnly",
+			metadata: &UserMetadata{
+				CreationTimestamp:  millis(created),
+				LastLogInTimestamp: millis(login),
+			},
+			want:     login,
+			wantDays: 11,
+		},
+		{
+			name: "CreationOnly",
+			metadata: &UserMetadata{
+				CreationTimestamp: millis(created),
+			},
+			want:     created,
+			wantDays: 12,
+		},
+	}
+	for _, tc := range cases {
+		if got := tc.metadata.LastActivityTime(); !got.Equal(tc.want) {
+			t.Errorf("LastActivityTime(%s) = %v; want = %v", tc.name, got, tc.want)
+		}
+		user := &ExportedUserRecord{UserRecord: &UserRecord{UserMetadata: tc.metadata}}
+		if days, ok := user.DaysSinceLastActivity(now); days != tc.wantDays || !ok {
+			t.Errorf("DaysSinceLastActivity(%s) = (%d, %v); want = (%d, true)", tc.name, days, ok, tc.wantDays)
+		}
+	}
+
+	future := &UserRecord{UserMetadata: &UserMetadata{LastLogInTimestamp: millis(now.Add(time.Hour))}}
+	if days, ok := future.DaysSinceLastActivity(now); days != 0 || !ok {
+		t.Errorf("DaysSinceLastActivity(future) = (%d, %v); want = (0, true)", days, ok)
+	}
+	for _, user := range []*UserRecord{{}, {UserMetadata: &UserMetadata{}}} {
+		if days, ok := user.DaysSinceLastActivity(now); days != 0 || ok {
+			t.Errorf("DaysSinceLastActivity(%v) = (%d, %v); want = (0, false)", user.UserMetadata, days, ok)
+		}
+	}
+}
+
+func TestGetUserMalformedLastRefreshTime(t *testing.T) {
+	resp := `{
+		"kind": "identitytoolkit#GetAccountInfoResponse",
+		"users": [{"localId": "testuser", "lastRefreshAt": "not a time"}]
+	}`
+	s := echoServer([]byte(resp), t)
+	defer s.Close()
+	logger := &recordingLogger{}
+	s.Client.userManagementClient.httpClient.Logger = logger
+
+	user, err := s.Client.GetUser(context.Background(), "testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UID != "testuser" || user.UserMetadata.LastRefreshTimestamp != 0 {
+		t.Errorf("GetUser() = (%q, %d); want = (%q, 0)", user.UID, user.UserMetadata.LastRefreshTimestamp, "testuser")
+	}
+	want := `ignoring malformed last refresh time "not a time" of user "testuser": `
+	if len(logger.warn) != 1 || !strings.HasPrefix(logger.warn[0], want) {
+		t.Errorf("Warnf() = %v; want = [%q...]", logger.warn, want)
+	}
+}
+
+func TestGetUserWithTenant(t *testing.T) {
+	resp := `{
+		"kind": "identitytoolkit#GetAccountInfoResponse",
+		"users": [{"localId": "testuser", "tenantId": "tenant-1"}]
+	}`
+	s := echoServer([]byte(resp), t)
+	defer s.Close()
+
+	user, err := s.Client.GetUser(context.Background(), "testuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.UID != "testuser" || user.TenantID != "tenant-1" {
+		t.Errorf("GetUser() = (%q, %q); want = (%q, %q)", user.UID, user.TenantID, "testuser", "tenant-1")
+	}
+}
+
 func TestGetUserByEmail(t *testing.T) {
 	s := echoServer(testGetUserResponse, t)
 	defer s.Close()
@@ -123,6 +255,403 @@ func TestGetUserByPhoneNumber(t *testing.T) {
 	}
 }
 
+func TestFindUserByEmail(t *testing.T) {
+	s := echoServer(testGetUserResponse, t)
+	defer s.Close()
+
+	user, err := s.Client.FindUserByEmail(context.Background(), "test@email.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(user, testUser) {
+		t.Errorf("FindUserByEmail() = %#v; want = %#v", user, testUser)
+	}
+
+	want := `{"email":["test@email.com"]}`
+	if got := string(s.Rbody); got != want {
+		t.Errorf("FindUserByEmail() Req = %v; want = %v", got, want)
+	}
+}
+
+func TestFindUserByEmailNotFound(t *testing.T) {
+	cases := []interface{}{
+		[]byte(`{"kind": "identitytoolkit#GetAccountInfoResponse"}`),
+		[]byte(`{"users": []}`),
+	}
+	for idx, resp := range cases {
+		s := echoServer(resp, t)
+		user, err := s.Client.FindUserByEmail(context.Background(), "test@email.com")
+		if user != nil || err != nil {
+			t.Errorf("[%d] FindUserByEmail() = (%v, %v); want = (nil, nil)", idx, user, err)
+		}
+		s.Close()
+	}
+}
+
+func TestFindUserByEmailError(t *testing.T) {
+	s := echoServer([]byte(`{"error": {"message": "INTERNAL_ERROR"}}`), t)
+	defer s.Close()
+	s.Client.userManagementClient.httpClient.RetryConfig = nil
+	s.Status = http.StatusInternalServerError
+
+	user, err := s.Client.FindUserByEmail(context.Background(), "test@email.com")
+	if user != nil || err == nil || IsUserNotFound(err) {
+		t.Errorf("FindUserByEmail() = (%v, %v); want = (nil, error)", user, err)
+	}
+}
+
+func TestInvalidFindUserByEmail(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	user, err := client.FindUserByEmail(context.Background(), "not-an-email")
+	if user != nil || err == nil {
+		t.Errorf("FindUserByEmail('not-an-email') = (%v, %v); want = (nil, error)", user, err)
+	}
+}
+
+func TestUserExists(t *testing.T) {
+	cases := []struct {
+		resp interface{}
+		want bool
+	}{
+		{testGetUserResponse, true},
+		{[]byte(`{"kind": "identitytoolkit#GetAccountInfoResponse"}`), false},
+		{[]byte(`{"users": []}`), false},
+	}
+	for idx, tc := range cases {
+		s := echoServer(tc.resp, t)
+		exists, err := s.Client.UserExists(context.Background(), "uid")
+		if exists != tc.want || err != nil {
+			t.Errorf("[%d] UserExists() = (%v, %v); want = (%v, nil)", idx, exists, err, tc.want)
+		}
+
+		want := `{"localId":["uid"]}`
+		if got := string(s.Rbody); got != want {
+			t.Errorf("[%d] UserExists() Req = %v; want = %v", idx, got, want)
+		}
+		s.Close()
+	}
+}
+
+func TestUserExistsError(t *testing.T) {
+	s := echoServer([]byte(`{"error": {"message": "INTERNAL_ERROR"}}`), t)
+	defer s.Close()
+	s.Client.userManagementClient.httpClient.RetryConfig = nil
+	s.Status = http.StatusInternalServerError
+
+	exists, err := s.Client.UserExists(context.Background(), "uid")
+	if exists || err == nil || IsUserNotFound(err) {
+		t.Errorf("UserExists() = (%v, %v); want = (false, error)", exists, err)
+	}
+}
+
+func TestInvalidUserExists(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	exists, err := client.UserExists(context.Background(), "")
+	if exists || err == nil {
+		t.Errorf("UserExists('') = (%v, %v); want = (false, error)", exists, err)
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	var identifiers []UserIdentifier
+	var users []map[string]interface{}
+	var wantNotFound []UserIdentifier
+	for i := 0; i < 100; i++ {
+		var id UserIdentifier
+		user := map[string]interface{}{"localId": fmt.Sprintf("uid%d", i)}
+		switch i % 4 {
+		case 0:
+			id = UIDIdentifier{UID: fmt.Sprintf("uid%d", i)}
+		case 1:
+			id = EmailIdentifier{Email: fmt.Sprintf("User%d@Example.com", i)}
+			user["email"] = fmt.Sprintf("user%d@example.com", i)
+		case 2:
+			id = PhoneIdentifier{PhoneNumber: fmt.Sprintf("+1555000%04d", i)}
+			user["phoneNumber"] = fmt.Sprintf("+1555000%04d", i)
+		case 3:
+			id = ProviderIdentifier{ProviderID: "google.com", ProviderUID: fmt.Sprintf("google%d", i)}
+			user["providerUserInfo"] = []map[string]interface{}{
+				{"providerId": "google.com", "rawId": fmt.Sprintf("google%d", i)},
+			}
+		}
+		identifiers = append(identifiers, id)
+		if i%5 == 0 {
+			wantNotFound = append(wantNotFound, id)
+			continue
+		}
+		// The backend returns users in no particular order.
+		users = append([]map[string]interface{}{user}, users...)
+	}
+
+	resp, err := json.Marshal(map[string]interface{}{"users": users})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := echoServer(resp, t)
+	defer s.Close()
+
+	result, err := s.Client.GetUsers(context.Background(), identifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Users) != 80 {
+		t.Fatalf("GetUsers() = %d users; want = 80", len(result.Users))
+	}
+	if !reflect.DeepEqual(result.NotFound, wantNotFound) {
+		t.Errorf("GetUsers().NotFound = %v; want = %v", result.NotFound, wantNotFound)
+	}
+	idx := 0
+	for i, id := range identifiers {
+		user, ok := result.Lookup(id)
+		if i%5 == 0 {
+			if ok || user != nil {
+				t.Errorf("Lookup(%v) = (%v, %v); want = (nil, false)", id, user, ok)
+			}
+			continue
+		}
+		wantUID := fmt.Sprintf("uid%d", i)
+		if !ok || user.UID != wantUID {
+			t.Errorf("Lookup(%v) = (%v, %v); want = (%q, true)", id, user, ok, wantUID)
+		}
+		if result.Users[idx] != user {
+			t.Errorf("GetUsers().Users[%d] = %q; want = %q", idx, result.Users[idx].UID, wantUID)
+		}
+		idx++
+	}
+
+	var req getAccountInfoRequest
+	if err := json.Unmarshal(s.Rbody, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.LocalID) != 25 || len(req.Email) != 25 || len(req.PhoneNumber) != 25 || len(req.FederatedUserID) != 25 {
+		t.Errorf("GetUsers() Req = %s; want = 25 identifiers of each type", string(s.Rbody))
+	}
+	wantFederated := federatedUserIdentifier{ProviderID: "google.com", RawID: "google3"}
+	if req.FederatedUserID[0] != wantFederated {
+		t.Errorf("GetUsers() Req federatedUserId[0] = %v; want = %v", req.FederatedUserID[0], wantFederated)
+	}
+}
+
+func TestGetUsersDuplicateIdentifiers(t *testing.T) {
+	s := echoServer([]byte(`{"users": [{"localId": "uid1", "email": "user@example.com"}]}`), t)
+	defer s.Close()
+
+	identifiers := []UserIdentifier{
+		EmailIdentifier{Email: "user@example.com"},
+		UIDIdentifier{UID: "uid1"},
+	}
+	result, err := s.Client.GetUsers(context.Background(), identifiers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Users) != 1 || len(result.NotFound) != 0 || len(result.Found) != 2 {
+		t.Errorf("GetUsers() = %#v; want = 1 user matched by 2 identifiers", result)
+	}
+}
+
+func TestGetUsersEmpty(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	result, err := client.GetUsers(context.Background(), nil)
+	if err != nil || len(result.Users) != 0 || len(result.NotFound) != 0 {
+		t.Errorf("GetUsers(nil) = (%v, %v); want = (empty, nil)", result, err)
+	}
+}
+
+func TestInvalidGetUsers(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	var tooMany []UserIdentifier
+	for i := 0; i < 101; i++ {
+		tooMany = append(tooMany, UIDIdentifier{UID: fmt.Sprintf("uid%d", i)})
+	}
+	cases := []struct {
+		ids  []UserIdentifier
+		want string
+	}{
+		{tooMany, "identifiers must not contain more than 100 elements; got 101"},
+		{[]UserIdentifier{nil}, "identifier at index 0 must not be nil"},
+		{[]UserIdentifier{UIDIdentifier{}}, "invalid identifier at index 0: uid must be a non-empty string"},
+		{
+			[]UserIdentifier{UIDIdentifier{UID: "uid"}, EmailIdentifier{Email: "not-an-email"}},
+			"invalid identifier at index 1: malformed email string: \"not-an-email\"",
+		},
+		{[]UserIdentifier{PhoneIdentifier{PhoneNumber: "123"}}, "invalid identifier at index 0: phone number must be a valid, E.164 compliant identifier"},
+		{[]UserIdentifier{ProviderIdentifier{ProviderUID: "id"}}, "invalid identifier at index 0: provider id must be a non-empty string"},
+		{[]UserIdentifier{ProviderIdentifier{ProviderID: "google.com"}}, "invalid identifier at index 0: provider uid must be a non-empty string"},
+	}
+	for _, tc := range cases {
+		result, err := client.GetUsers(context.Background(), tc.ids)
+		if result != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("GetUsers() = (%v, %v); want = (nil, %q)", result, err, tc.want)
+		}
+	}
+}
+
+// lookupServer returns a mock server that responds to account lookup requests with a user for each
+// requested UID accepted by exists, and for each requested email of the form "<uid>@example.com".
+func lookupServer(exists func(uid string) bool, t *testing.T) (*mockAuthServer, *int32) {
+	var count int32
+	s := concurrentServer(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		var req getAccountInfoRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var users []map[string]interface{}
+		for _, uid := range req.LocalID {
+			if exists(uid) {
+				users = append(users, map[string]interface{}{"localId": uid, "email": uid + "@example.com"})
+			}
+		}
+		for _, email := range req.Email {
+			uid := strings.TrimSuffix(email, "@example.com")
+			if exists(uid) {
+				users = append(users, map[string]interface{}{"localId": uid, "email": email})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"users": users})
+	}, t)
+	return s, &count
+}
+
+// concurrentServer returns a mockAuthServer backed by the given handler. Unlike echoServer, it does
+// not record the requests in the mockAuthServer, and can therefore serve concurrent requests.
+func concurrentServer(handler http.HandlerFunc, t *testing.T) *mockAuthServer {
+	s := echoServer(nil, t)
+	ts := httptest.NewServer(handler)
+	s.Srv.Close()
+	s.Srv = ts
+	s.Client.userManagementClient.baseURL = ts.URL
+	return s
+}
+
+func TestGetUsersWithOptions(t *testing.T) {
+	exists := func(uid string) bool {
+		return !strings.HasSuffix(uid, "7")
+	}
+	s, count := lookupServer(exists, t)
+	defer s.Close()
+
+	var identifiers []UserIdentifier
+	var wantNotFound []UserIdentifier
+	for i := 0; i < 250; i++ {
+		id := UIDIdentifier{UID: fmt.Sprintf("uid%d", i)}
+		identifiers = append(identifiers, id)
+		if !exists(id.UID) {
+			wantNotFound = append(wantNotFound, id)
+		}
+	}
+	// Matches the same user as the first identifier, from a different batch.
+	duplicate := EmailIdentifier{Email: "uid0@example.com"}
+	identifiers = append(identifiers, duplicate)
+
+	result, err := s.Client.GetUsersWithOptions(
+		context.Background(), identifiers, &GetUsersOptions{MaxConcurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := atomic.LoadInt32(count); got != 3 {
+		t.Errorf("GetUsersWithOptions() = %d requests; want = 3", got)
+	}
+	if len(result.Users) != 250-len(wantNotFound) {
+		t.Errorf("GetUsersWithOptions() = %d users; want = %d", len(result.Users), 250-len(wantNotFound))
+	}
+	if !reflect.DeepEqual(result.NotFound, wantNotFound) {
+		t.Errorf("GetUsersWithOptions().NotFound = %v; want = %v", result.NotFound, wantNotFound)
+	}
+	idx := 0
+	for _, id := range identifiers[:250] {
+		user, ok := result.Lookup(id)
+		if !exists(id.(UIDIdentifier).UID) {
+			if ok {
+				t.Errorf("Lookup(%v) = (%v, true); want = (nil, false)", id, user)
+			}
+			continue
+		}
+		if !ok || result.Users[idx] != user {
+			t.Errorf("Lookup(%v) = (%v, %v); want = (Users[%d], true)", id, user, ok, idx)
+		}
+		idx++
+	}
+	if user, ok := result.Lookup(duplicate); !ok || user != result.Users[0] {
+		t.Errorf("Lookup(%v) = (%v, %v); want = (Users[0], true)", duplicate, user, ok)
+	}
+}
+
+func TestGetUsersWithOptionsEmpty(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	result, err := client.GetUsersWithOptions(context.Background(), nil, nil)
+	if err != nil || len(result.Users) != 0 || len(result.NotFound) != 0 || result.Found == nil {
+		t.Errorf("GetUsersWithOptions(nil) = (%v, %v); want = (empty, nil)", result, err)
+	}
+}
+
+func TestGetUsersWithOptionsError(t *testing.T) {
+	s := concurrentServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"message":"INSUFFICIENT_PERMISSION"}}`))
+	}, t)
+	defer s.Close()
+	s.Client.userManagementClient.httpClient.RetryConfig = nil
+
+	var identifiers []UserIdentifier
+	for i := 0; i < 150; i++ {
+		identifiers = append(identifiers, UIDIdentifier{UID: fmt.Sprintf("uid%d", i)})
+	}
+	result, err := s.Client.GetUsersWithOptions(context.Background(), identifiers, nil)
+	if result != nil || !IsInsufficientPermission(err) {
+		t.Errorf("GetUsersWithOptions() = (%v, %v); want = (nil, %q)", result, err, insufficientPermission)
+	}
+}
+
+func TestGetUsersWithOptionsInvalidIdentifier(t *testing.T) {
+	s := echoServer(nil, t)
+	defer s.Close()
+
+	var identifiers []UserIdentifier
+	for i := 0; i < 150; i++ {
+		identifiers = append(identifiers, UIDIdentifier{UID: fmt.Sprintf("uid%d", i)})
+	}
+	identifiers[120] = UIDIdentifier{}
+	want := "invalid identifier at index 120: uid must be a non-empty string"
+	result, err := s.Client.GetUsersWithOptions(context.Background(), identifiers, nil)
+	if result != nil || err == nil || err.Error() != want {
+		t.Errorf("GetUsersWithOptions() = (%v, %v); want = (nil, %q)", result, err, want)
+	}
+	if len(s.Req) != 0 {
+		t.Errorf("GetUsersWithOptions() = %d requests; want = 0", len(s.Req))
+	}
+}
+
+func TestGetUsersWithOptionsCanceled(t *testing.T) {
+	s, count := lookupServer(func(string) bool { return true }, t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ids := []UserIdentifier{UIDIdentifier{UID: "uid1"}}
+	result, err := s.Client.GetUsersWithOptions(ctx, ids, nil)
+	if result != nil || err != context.Canceled {
+		t.Errorf("GetUsersWithOptions() = (%v, %v); want = (nil, %v)", result, err, context.Canceled)
+	}
+	if got := atomic.LoadInt32(count); got != 0 {
+		t.Errorf("GetUsersWithOptions() = %d requests; want = 0", got)
+	}
+}
+
 func TestInvalidGetUser(t *testing.T) {
 	client := &Client{
 		userManagementClient: &userManagementClient{},
@@ -154,132 +683,434 @@ func TestGetNonExistingUser(t *testing.T) {
 	if user != nil || err == nil || err.Error() != we || !IsUserNotFound(err) {
 		t.Errorf("GetUser(non-existing) = (%v, %q); want = (nil, %q)", user, err, we)
 	}
-
-	we = `cannot find user from email: "foo@bar.nonexisting"`
-	user, err = s.Client.GetUserByEmail(context.Background(), "foo@bar.nonexisting")
-	if user != nil || err == nil || err.Error() != we || !IsUserNotFound(err) {
-		t.Errorf("GetUserByEmail(non-existing) = (%v, %q); want = (nil, %q)", user, err, we)
+
+	we = `cannot find user from email: "foo@bar.nonexisting"`
+	user, err = s.Client.GetUserByEmail(context.Background(), "foo@bar.nonexisting")
+	if user != nil || err == nil || err.Error() != we || !IsUserNotFound(err) {
+		t.Errorf("GetUserByEmail(non-existing) = (%v, %q); want = (nil, %q)", user, err, we)
+	}
+
+	we = `cannot find user from phone number: "+12345678901"`
+	user, err = s.Client.GetUserByPhoneNumber(context.Background(), "+12345678901")
+	if user != nil || err == nil || err.Error() != we || !IsUserNotFound(err) {
+		t.Errorf("GetUserPhoneNumber(non-existing) = (%v, %q); want = (nil, %q)", user, err, we)
+	}
+}
+
+func TestUserCount(t *testing.T) {
+	cases := []struct {
+		resp string
+		want int64
+	}{
+		{`{"recordsCount": "42"}`, 42},
+		{`{}`, 0},
+	}
+	for _, tc := range cases {
+		s := echoServer([]byte(tc.resp), t)
+		count, err := s.Client.UserCount(context.Background())
+		if count != tc.want || err != nil {
+			t.Errorf("UserCount() = (%d, %v); want = (%d, nil)", count, err, tc.want)
+		}
+
+		want := `{"returnUserInfo":false}`
+		if got := string(s.Rbody); got != want {
+			t.Errorf("UserCount() Req = %v; want = %v", got, want)
+		}
+		wantPath := "/projects/mock-project-id/accounts:query"
+		if s.Req[0].RequestURI != wantPath {
+			t.Errorf("UserCount() URL = %q; want = %q", s.Req[0].RequestURI, wantPath)
+		}
+		s.Close()
+	}
+}
+
+func TestUserCountError(t *testing.T) {
+	s := echoServer([]byte(`{"error": {"message": "PROJECT_NOT_FOUND"}}`), t)
+	defer s.Close()
+	s.Status = http.StatusNotFound
+
+	count, err := s.Client.UserCount(context.Background())
+	if count != 0 || !IsProjectNotFound(err) {
+		t.Errorf("UserCount() = (%d, %v); want = (0, ProjectNotFound)", count, err)
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	testListUsersResponse, err := ioutil.ReadFile("../testdata/list_users.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := echoServer(testListUsersResponse, t)
+	defer s.Close()
+
+	want := []*ExportedUserRecord{
+		{UserRecord: testUser, PasswordHash: "passwordhash1", PasswordSalt: "salt1"},
+		{UserRecord: testUser, PasswordHash: "passwordhash2", PasswordSalt: "salt2"},
+		{UserRecord: testUser, PasswordHash: "passwordhash3", PasswordSalt: "salt3"},
+	}
+
+	testIterator := func(iter *UserIterator, token string, req string) {
+		count := 0
+		for i := 0; i < len(want); i++ {
+			user, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(user.UserRecord, want[i].UserRecord) {
+				t.Errorf("Users(%q) = %#v; want = %#v", token, user, want[i])
+			}
+			if user.PasswordHash != want[i].PasswordHash {
+				t.Errorf("Users(%q) PasswordHash = %q; want = %q", token, user.PasswordHash, want[i].PasswordHash)
+			}
+			if user.PasswordSalt != want[i].PasswordSalt {
+				t.Errorf("Users(%q) PasswordSalt = %q; want = %q", token, user.PasswordSalt, want[i].PasswordSalt)
+			}
+			count++
+		}
+		if count != len(want) {
+			t.Errorf("Users(%q) = %d; want = %d", token, count, len(want))
+		}
+		if _, err := iter.Next(); err != iterator.Done {
+			t.Errorf("Users(%q) = %v, want = %v", token, err, iterator.Done)
+		}
+
+		// Check the query string of the last HTTP request made.
+		gotReq := s.Req[len(s.Req)-1].URL.Query().Encode()
+		if gotReq != req {
+			t.Errorf("Users(%q) = %q, want = %v", token, gotReq, req)
+		}
+	}
+	testIterator(
+		s.Client.Users(context.Background(), ""),
+		"",
+		"maxResults=1000")
+	testIterator(
+		s.Client.Users(context.Background(), "pageToken"),
+		"pageToken",
+		"maxResults=1000&nextPageToken=pageToken")
+}
+
+// pagedUsersServer serves three single-user pages, and reports the page token of each request on the requests
+// channel. Pages listed in failures are answered with an internal server error.
+func pagedUsersServer(t *testing.T, failures ...string) (*httptest.Server, *Client, chan string) {
+	pages := map[string]string{
+		"":      `{"users": [{"localId": "user1"}], "nextPageToken": "page2"}`,
+		"page2": `{"users": [{"localId": "user2"}], "nextPageToken": "page3"}`,
+		"page3": `{"users": [{"localId": "user3"}]}`,
+	}
+	requests := make(chan string, len(pages))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("nextPageToken")
+		requests <- token
+		w.Header().Set("Content-Type", "application/json")
+		for _, f := range failures {
+			if f == token {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error": {"message": "INTERNAL_ERROR"}}`))
+				return
+			}
+		}
+		w.Write([]byte(pages[token]))
+	}))
+
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Opts:      optsWithTokenSource,
+		ProjectID: "mock-project-id",
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.userManagementClient.baseURL = ts.URL
+	client.userManagementClient.httpClient.RetryConfig = nil
+	return ts, client, requests
+}
+
+func TestListUsersPageSizeTooLarge(t *testing.T) {
+	ts, client, requests := pagedUsersServer(t)
+	defer ts.Close()
+
+	iter := client.Users(context.Background(), "")
+	pager := iterator.NewPager(iter, maxReturnedResults+1, "")
+	var users []*ExportedUserRecord
+	_, err := pager.NextPage(&users)
+	want := "page size must not exceed 1000; got 1001"
+	if err == nil || err.Error() != want {
+		t.Errorf("NextPage() = %v; want = %q", err, want)
+	}
+	if len(requests) != 0 {
+		t.Errorf("Requests = %d; want = 0", len(requests))
+	}
+
+	iter = client.Users(context.Background(), "")
+	iter.PageInfo().MaxSize = maxReturnedResults + 1
+	if _, err := iter.Next(); err == nil || err.Error() != want {
+		t.Errorf("Next() = %v; want = %q", err, want)
+	}
+}
+
+func TestListUsersCheckpoint(t *testing.T) {
+	ts, client, _ := pagedUsersServer(t)
+	defer ts.Close()
+
+	ctx := context.Background()
+	iter := client.Users(ctx, "")
+	user, err := iter.Next()
+	if err != nil || user.UID != "user1" {
+		t.Fatalf("Next() = (%v, %v); want = (user1, nil)", user, err)
+	}
+	pi := iter.PageInfo()
+	if pi.Remaining() != 0 || pi.Token != "page2" {
+		t.Fatalf("PageInfo() = (%d, %q); want = (0, %q)", pi.Remaining(), pi.Token, "page2")
+	}
+
+	// Resume from the checkpointed token with a new iterator.
+	iter = client.Users(ctx, pi.Token)
+	var uids []string
+	for {
+		user, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, user.UID)
+	}
+	if want := []string{"user2", "user3"}; !reflect.DeepEqual(uids, want) {
+		t.Errorf("Next() = %v; want = %v", uids, want)
+	}
+	if token := iter.PageInfo().Token; token != "" {
+		t.Errorf("PageInfo().Token = %q; want = %q", token, "")
+	}
+}
+
+func TestListUsersPrefetch(t *testing.T) {
+	ts, client, requests := pagedUsersServer(t)
+	defer ts.Close()
+
+	iter := client.UsersWithOptions(context.Background(), "", &UsersOptions{Prefetch: true})
+	user, err := iter.Next()
+	if err != nil || user.UID != "user1" {
+		t.Fatalf("Next() = (%v, %v); want = (user1, nil)", user, err)
+	}
+	for _, want := range []string{"", "page2"} {
+		select {
+		case got := <-requests:
+			if got != want {
+				t.Errorf("Request page = %q; want = %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Request page %q was not prefetched", want)
+		}
+	}
+
+	var uids []string
+	for {
+		user, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		uids = append(uids, user.UID)
+	}
+	if want := []string{"user2", "user3"}; !reflect.DeepEqual(uids, want) {
+		t.Errorf("Next() = %v; want = %v", uids, want)
+	}
+	if got := <-requests; got != "page3" {
+		t.Errorf("Request page = %q; want = %q", got, "page3")
+	}
+	if len(requests) != 0 {
+		t.Errorf("Requests = %d; want = 3", 3+len(requests))
 	}
+}
 
-	we = `cannot find user from phone number: "+12345678901"`
-	user, err = s.Client.GetUserByPhoneNumber(context.Background(), "+12345678901")
-	if user != nil || err == nil || err.Error() != we || !IsUserNotFound(err) {
-		t.Errorf("GetUserPhoneNumber(non-existing) = (%v, %q); want = (nil, %q)", user, err, we)
+func TestListUsersPrefetchError(t *testing.T) {
+	ts, client, _ := pagedUsersServer(t, "page2")
+	defer ts.Close()
+
+	iter := client.UsersWithOptions(context.Background(), "", &UsersOptions{Prefetch: true})
+	if user, err := iter.Next(); err != nil || user.UID != "user1" {
+		t.Fatalf("Next() = (%v, %v); want = (user1, nil)", user, err)
+	}
+	if user, err := iter.Next(); user != nil || !IsUnknown(err) {
+		t.Errorf("Next() = (%v, %v); want = (nil, %q)", user, err, "unknown-error")
 	}
 }
 
-func TestListUsers(t *testing.T) {
-	testListUsersResponse, err := ioutil.ReadFile("../testdata/list_users.json")
-	if err != nil {
-		t.Fatal(err)
-	}
-	s := echoServer(testListUsersResponse, t)
-	defer s.Close()
+func TestListUsersPrefetchContextCancelled(t *testing.T) {
+	ts, client, _ := pagedUsersServer(t)
+	defer ts.Close()
 
-	want := []*ExportedUserRecord{
-		{UserRecord: testUser, PasswordHash: "passwordhash1", PasswordSalt: "salt1"},
-		{UserRecord: testUser, PasswordHash: "passwordhash2", PasswordSalt: "salt2"},
-		{UserRecord: testUser, PasswordHash: "passwordhash3", PasswordSalt: "salt3"},
+	ctx, cancel := context.WithCancel(context.Background())
+	iter := client.UsersWithOptions(ctx, "", &UsersOptions{Prefetch: true})
+	if user, err := iter.Next(); err != nil || user.UID != "user1" {
+		t.Fatalf("Next() = (%v, %v); want = (user1, nil)", user, err)
 	}
+	cancel()
 
-	testIterator := func(iter *UserIterator, token string, req string) {
-		count := 0
-		for i := 0; i < len(want); i++ {
-			user, err := iter.Next()
-			if err == iterator.Done {
-				break
+	// The prefetch of the second page may or may not complete before the cancellation. Either way the
+	// cancellation must surface from Next before the iteration completes.
+	for {
+		user, err := iter.Next()
+		if err == iterator.Done {
+			t.Fatalf("Next() = %v; want = context canceled", err)
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), "context canceled") {
+				t.Errorf("Next() = %v; want = context canceled", err)
 			}
+			break
+		}
+		if user == nil {
+			t.Fatal("Next() = nil; want = user")
+		}
+	}
+}
+
+func TestListUsersPageTimeout(t *testing.T) {
+	cases := []struct {
+		name    string
+		retries int
+		wantErr bool
+	}{
+		{"Retried", 1, false},
+		{"NotRetried", 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := 0
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				attempts++
+				slow := attempts == 1
+				mu.Unlock()
+				if slow {
+					time.Sleep(500 * time.Millisecond)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"users": [{"localId": "user1"}]}`))
+			}))
+			defer ts.Close()
+
+			client, err := NewClient(context.Background(), &internal.AuthConfig{
+				Opts:      optsWithTokenSource,
+				ProjectID: "mock-project-id",
+				Version:   testVersion,
+			})
 			if err != nil {
 				t.Fatal(err)
 			}
-			if !reflect.DeepEqual(user.UserRecord, want[i].UserRecord) {
-				t.Errorf("Users(%q) = %#v; want = %#v", token, user, want[i])
+			client.userManagementClient.baseURL = ts.URL
+			client.userManagementClient.httpClient.RetryConfig = nil
+
+			opts := &UsersOptions{
+				PageTimeout: 50 * time.Millisecond,
+				PageRetries: tc.retries,
 			}
-			if user.PasswordHash != want[i].PasswordHash {
-				t.Errorf("Users(%q) PasswordHash = %q; want = %q", token, user.PasswordHash, want[i].PasswordHash)
+			iter := client.UsersWithOptions(context.Background(), "", opts)
+			user, err := iter.Next()
+			if tc.wantErr {
+				if user != nil || err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+					t.Errorf("Next() = (%v, %v); want = (nil, deadline exceeded)", user, err)
+				}
+				return
 			}
-			if user.PasswordSalt != want[i].PasswordSalt {
-				t.Errorf("Users(%q) PasswordSalt = %q; want = %q", token, user.PasswordSalt, want[i].PasswordSalt)
+			if err != nil || user.UID != "user1" {
+				t.Errorf("Next() = (%v, %v); want = (user1, nil)", user, err)
 			}
-			count++
-		}
-		if count != len(want) {
-			t.Errorf("Users(%q) = %d; want = %d", token, count, len(want))
-		}
-		if _, err := iter.Next(); err != iterator.Done {
-			t.Errorf("Users(%q) = %v, want = %v", token, err, iterator.Done)
-		}
-
-		// Check the query string of the last HTTP request made.
-		gotReq := s.Req[len(s.Req)-1].URL.Query().Encode()
-		if gotReq != req {
-			t.Errorf("Users(%q) = %q, want = %v", token, gotReq, req)
-		}
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != 2 {
+				t.Errorf("Attempts = %d; want = 2", attempts)
+			}
+		})
 	}
-	testIterator(
-		s.Client.Users(context.Background(), ""),
-		"",
-		"maxResults=1000")
-	testIterator(
-		s.Client.Users(context.Background(), "pageToken"),
-		"pageToken",
-		"maxResults=1000&nextPageToken=pageToken")
 }
 
 func TestInvalidCreateUser(t *testing.T) {
 	cases := []struct {
 		params *UserToCreate
+		field  string
 		want   string
 	}{
 		{
 			(&UserToCreate{}).Password("short"),
+			"Password",
 			"password must be a string at least 6 characters long",
 		}, {
 			(&UserToCreate{}).PhoneNumber(""),
+			"PhoneNumber",
 			"phone number must be a non-empty string",
 		}, {
 			(&UserToCreate{}).PhoneNumber("1234"),
+			"PhoneNumber",
 			"phone number must be a valid, E.164 compliant identifier",
 		}, {
 			(&UserToCreate{}).PhoneNumber("+_!@#$"),
+			"PhoneNumber",
 			"phone number must be a valid, E.164 compliant identifier",
 		}, {
 			(&UserToCreate{}).UID(""),
+			"UID",
 			"uid must be a non-empty string",
 		}, {
 			(&UserToCreate{}).UID(strings.Repeat("a", 129)),
+			"UID",
 			"uid string must not be longer than 128 characters",
 		}, {
 			(&UserToCreate{}).DisplayName(""),
+			"DisplayName",
 			"display name must be a non-empty string",
 		}, {
 			(&UserToCreate{}).PhotoURL(""),
+			"PhotoURL",
 			"photo url must be a non-empty string",
 		}, {
 			(&UserToCreate{}).Email(""),
+			"Email",
 			"email must be a non-empty string",
 		}, {
 			(&UserToCreate{}).Email("a"),
+			"Email",
 			`malformed email string: "a"`,
 		}, {
 			(&UserToCreate{}).Email("a@"),
+			"Email",
 			`malformed email string: "a@"`,
 		}, {
 			(&UserToCreate{}).Email("@a"),
+			"Email",
 			`malformed email string: "@a"`,
 		}, {
 			(&UserToCreate{}).Email("a@a@a"),
+			"Email",
 			`malformed email string: "a@a@a"`,
+		}, {
+			(&UserToCreate{}).UID("uid").Email("a").Password("short"),
+			"Email",
+			`malformed email string: "a"`,
 		},
 	}
 	client := &Client{}
 	for i, tc := range cases {
 		user, err := client.CreateUser(context.Background(), tc.params)
 		if user != nil || err == nil {
-			t.Errorf("[%d] CreateUser() = (%v, %v); want = (nil, error)", i, user, err)
+			t.Fatalf("[%d] CreateUser() = (%v, %v); want = (nil, error)", i, user, err)
 		}
-		if err.Error() != tc.want {
-			t.Errorf("[%d] CreateUser() = %v; want = %v", i, err.Error(), tc.want)
+		want := fmt.Sprintf("invalid UserToCreate.%s: %s", tc.field, tc.want)
+		if err.Error() != want {
+			t.Errorf("[%d] CreateUser() = %v; want = %v", i, err.Error(), want)
+		}
+		ucErr, ok := err.(*UserToCreateError)
+		if !ok || ucErr.Field != tc.field || ucErr.Reason != tc.want {
+			t.Errorf("[%d] CreateUser() = %#v; want = &UserToCreateError{%q, %q}", i, err, tc.field, tc.want)
 		}
 	}
 }
@@ -485,6 +1316,22 @@ func TestUpdateUser(t *testing.T) {
 			(&UserToUpdate{}).PhotoURL(""),
 			map[string]interface{}{"deleteAttribute": []string{"PHOTO_URL"}},
 		},
+		{
+			(&UserToUpdate{}).RemoveDisplayName(),
+			map[string]interface{}{"deleteAttribute": []string{"DISPLAY_NAME"}},
+		},
+		{
+			(&UserToUpdate{}).RemovePhotoURL(),
+			map[string]interface{}{"deleteAttribute": []string{"PHOTO_URL"}},
+		},
+		{
+			(&UserToUpdate{}).PhotoURL("http://some.url").RemovePhotoURL().DisplayName("a").RemoveDisplayName(),
+			map[string]interface{}{"deleteAttribute": []string{"DISPLAY_NAME", "PHOTO_URL"}},
+		},
+		{
+			(&UserToUpdate{}).RemovePhotoURL().PhotoURL("http://some.url"),
+			map[string]interface{}{"photoUrl": "http://some.url"},
+		},
 		{
 			(&UserToUpdate{}).PhotoURL("").PhoneNumber("").DisplayName(""),
 			map[string]interface{}{
@@ -521,6 +1368,49 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
+func TestDisableEnableUser(t *testing.T) {
+	s := echoServer(testGetUserResponse, t)
+	defer s.Close()
+
+	cases := []struct {
+		name string
+		fn   func(context.Context, string) (*UserRecord, error)
+	}{
+		{"DisableUser", s.Client.DisableUser},
+		{"EnableUser", s.Client.EnableUser},
+	}
+	for _, tc := range cases {
+		s.Req = nil
+		user, err := tc.fn(context.Background(), "uid")
+		if err != nil {
+			t.Fatalf("%s() = %v; want = nil", tc.name, err)
+		}
+		if !reflect.DeepEqual(user, testUser) {
+			t.Errorf("%s() = %#v; want = %#v", tc.name, user, testUser)
+		}
+		if len(s.Req) != 2 {
+			t.Fatalf("%s() requests = %d; want = 2", tc.name, len(s.Req))
+		}
+
+		wantURL := "/projects/mock-project-id/accounts:update"
+		if s.Req[0].URL.Path != wantURL {
+			t.Errorf("%s() URL = %q; want = %q", tc.name, s.Req[0].URL.Path, wantURL)
+		}
+	}
+}
+
+func TestDisableUserEmptyUID(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	if user, err := client.DisableUser(context.Background(), ""); user != nil || err == nil {
+		t.Errorf("DisableUser('') = (%v, %v); want = (nil, error)", user, err)
+	}
+	if user, err := client.EnableUser(context.Background(), ""); user != nil || err == nil {
+		t.Errorf("EnableUser('') = (%v, %v); want = (nil, error)", user, err)
+	}
+}
+
 func TestRevokeRefreshTokens(t *testing.T) {
 	resp := `{
 		"kind": "identitytoolkit#SetAccountInfoResponse",
@@ -910,6 +1800,158 @@ func TestImportUsersError(t *testing.T) {
 	}
 }
 
+func importUsersBatchServer(t *testing.T, failedBatches ...int) (*httptest.Server, *Client, *[]int) {
+	var batchSizes []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Users []map[string]interface{} `json:"users"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		batch := len(batchSizes)
+		batchSizes = append(batchSizes, len(req.Users))
+		w.Header().Set("Content-Type", "application/json")
+		for _, f := range failedBatches {
+			if f == batch {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(`{"error": {"message": "INTERNAL_ERROR"}}`))
+				return
+			}
+		}
+		// Report the second user of every batch as failed.
+		w.Write([]byte(`{"error": [{"index": 1, "message": "failed"}]}`))
+	}))
+
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Opts:      optsWithTokenSource,
+		ProjectID: "mock-project-id",
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.userManagementClient.baseURL = ts.URL
+	client.userManagementClient.httpClient.RetryConfig = nil
+	return ts, client, &batchSizes
+}
+
+func TestImportUsersInBatchesTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(context.Background(), &internal.AuthConfig{
+		Opts:      optsWithTokenSource,
+		ProjectID: "mock-project-id",
+		Version:   testVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.userManagementClient.baseURL = ts.URL
+	client.userManagementClient.httpClient.RetryConfig = nil
+
+	batchOpts := &BatchImportOptions{
+		ContinueOnError: true,
+		BatchTimeout:    50 * time.Millisecond,
+	}
+	result, err := client.ImportUsersInBatches(context.Background(), usersToImport(2), batchOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 0 || result.FailureCount != 2 || len(result.Errors) != 2 {
+		t.Fatalf("ImportUsersInBatches() = %#v; want = 2 failures", result)
+	}
+	if reason := result.Errors[0].Reason; !strings.Contains(reason, "deadline exceeded") {
+		t.Errorf("ImportUsersInBatches() reason = %q; want = deadline exceeded", reason)
+	}
+}
+
+func usersToImport(n int) []*UserToImport {
+	var users []*UserToImport
+	for i := 0; i < n; i++ {
+		users = append(users, (&UserToImport{}).UID(fmt.Sprintf("user%d", i)))
+	}
+	return users
+}
+
+func TestImportUsersInBatches(t *testing.T) {
+	ts, client, batchSizes := importUsersBatchServer(t)
+	defer ts.Close()
+
+	result, err := client.ImportUsersInBatches(context.Background(), usersToImport(2500), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1000, 1000, 500}; !reflect.DeepEqual(*batchSizes, want) {
+		t.Errorf("ImportUsersInBatches() batches = %v; want = %v", *batchSizes, want)
+	}
+	if result.SuccessCount != 2497 || result.FailureCount != 3 {
+		t.Errorf("ImportUsersInBatches() = %#v; want = {SuccessCount: 2497, FailureCount: 3}", result)
+	}
+	var indices []int
+	for _, e := range result.Errors {
+		indices = append(indices, e.Index)
+	}
+	if want := []int{1, 1001, 2001}; !reflect.DeepEqual(indices, want) {
+		t.Errorf("ImportUsersInBatches() error indices = %v; want = %v", indices, want)
+	}
+}
+
+func TestImportUsersInBatchesStopOnError(t *testing.T) {
+	ts, client, batchSizes := importUsersBatchServer(t, 1)
+	defer ts.Close()
+
+	result, err := client.ImportUsersInBatches(context.Background(), usersToImport(2500), nil)
+	if err == nil || !IsUnknown(err) {
+		t.Errorf("ImportUsersInBatches() = %v; want = %q", err, "unknown-error")
+	}
+	if len(*batchSizes) != 2 {
+		t.Errorf("ImportUsersInBatches() batches = %d; want = 2", len(*batchSizes))
+	}
+	if result == nil || result.SuccessCount != 999 || result.FailureCount != 1 {
+		t.Errorf("ImportUsersInBatches() = %#v; want = {SuccessCount: 999, FailureCount: 1}", result)
+	}
+}
+
+func TestImportUsersInBatchesContinueOnError(t *testing.T) {
+	ts, client, batchSizes := importUsersBatchServer(t, 1)
+	defer ts.Close()
+
+	opts := &BatchImportOptions{ContinueOnError: true}
+	result, err := client.ImportUsersInBatches(context.Background(), usersToImport(2500), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*batchSizes) != 3 {
+		t.Errorf("ImportUsersInBatches() batches = %d; want = 3", len(*batchSizes))
+	}
+	if result.SuccessCount != 1498 || result.FailureCount != 1002 || len(result.Errors) != 1002 {
+		t.Errorf("ImportUsersInBatches() = {SuccessCount: %d, FailureCount: %d, Errors: %d}; "+
+			"want = {SuccessCount: 1498, FailureCount: 1002, Errors: 1002}",
+			result.SuccessCount, result.FailureCount, len(result.Errors))
+	}
+	if first, last := result.Errors[1].Index, result.Errors[1000].Index; first != 1000 || last != 1999 {
+		t.Errorf("ImportUsersInBatches() failed batch indices = [%d, %d]; want = [1000, 1999]", first, last)
+	}
+	if got := result.Errors[1001].Index; got != 2001 {
+		t.Errorf("ImportUsersInBatches() last error index = %d; want = 2001", got)
+	}
+}
+
+func TestImportUsersInBatchesEmpty(t *testing.T) {
+	client := &Client{
+		userManagementClient: &userManagementClient{},
+	}
+	if result, err := client.ImportUsersInBatches(context.Background(), nil, nil); result != nil || err == nil {
+		t.Errorf("ImportUsersInBatches(nil) = (%v, %v); want = (nil, error)", result, err)
+	}
+}
+
 type mockHash struct {
 	key, saltSep       string
 	rounds, memoryCost int64
@@ -976,6 +2018,87 @@ func TestImportUsersMissingRequiredHash(t *testing.T) {
 	}
 }
 
+func TestImportUsersMissingSalt(t *testing.T) {
+	s := echoServer([]byte("{}"), t)
+	defer s.Close()
+	users := []*UserToImport{
+		(&UserToImport{}).UID("user1").PasswordHash([]byte("password")).PasswordSalt([]byte("salt")),
+		(&UserToImport{}).UID("user2"),
+		(&UserToImport{}).UID("user3").PasswordHash([]byte("password")),
+	}
+	result, err := s.Client.ImportUsers(context.Background(), users, WithHash(hash.PBKDF2SHA256{Rounds: 10}))
+	want := "user at index 2: password salt is required for users with a password hash when using the " +
+		"PBKDF2_SHA256 hash algorithm"
+	if result != nil || err == nil || err.Error() != want {
+		t.Errorf("ImportUsers() = (%v, %v); want = (nil, %q)", result, err, want)
+	}
+	if len(s.Req) != 0 {
+		t.Errorf("ImportUsers() made %d requests; want = 0", len(s.Req))
+	}
+
+	// Unsalted algorithms do not require salts.
+	result, err = s.Client.ImportUsers(context.Background(), users, WithHash(hash.SHA256{Rounds: 1}))
+	if err != nil || result.SuccessCount != 3 {
+		t.Errorf("ImportUsers() = (%v, %v); want = (SuccessCount: 3, nil)", result, err)
+	}
+}
+
+func TestValidateUsersToImportMissingSalt(t *testing.T) {
+	users := usersToImport(10)
+	users[2] = (&UserToImport{}).UID("user2").Email("not-an-email")
+	users[5] = (&UserToImport{}).UID("user5").PasswordHash([]byte("password"))
+	users[7] = (&UserToImport{}).UID("user7").PasswordHash([]byte("password")).PasswordSalt([]byte("salt"))
+
+	client := &Client{userManagementClient: &userManagementClient{}}
+	result, err := client.ValidateUsersToImport(users, WithHash(hash.Scrypt{Key: []byte("key"), Rounds: 8, MemoryCost: 14}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 8 || result.FailureCount != 2 || len(result.Errors) != 2 {
+		t.Fatalf("ValidateUsersToImport() = %#v; want = {SuccessCount: 8, FailureCount: 2}", result)
+	}
+	want := "password salt is required for users with a password hash when using the SCRYPT hash algorithm"
+	if result.Errors[0].Index != 2 || result.Errors[1].Index != 5 || result.Errors[1].Reason != want {
+		t.Errorf("ValidateUsersToImport() Errors = [%#v, %#v]; want = [Index: 2, Index: 5]", result.Errors[0], result.Errors[1])
+	}
+}
+
+func TestValidateUsersToImport(t *testing.T) {
+	users := usersToImport(1500)
+	users[3] = (&UserToImport{}).Email("user3@example.com")
+	users[1200] = (&UserToImport{}).UID("user1200").Email("not-an-email")
+	users[1300] = (&UserToImport{}).UID("user1300").PasswordHash([]byte("password"))
+
+	client := &Client{userManagementClient: &userManagementClient{}}
+	result, err := client.ValidateUsersToImport(users, WithHash(mockHash{key: "key"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.SuccessCount != 1498 || result.FailureCount != 2 || len(result.Errors) != 2 {
+		t.Fatalf("ValidateUsersToImport() = %#v; want = {SuccessCount: 1498, FailureCount: 2}", result)
+	}
+	for i, want := range []int{3, 1200} {
+		if result.Errors[i].Index != want || result.Errors[i].Reason == "" {
+			t.Errorf("ValidateUsersToImport() Errors[%d] = %#v; want = Index: %d", i, result.Errors[i], want)
+		}
+	}
+}
+
+func TestValidateUsersToImportError(t *testing.T) {
+	client := &Client{userManagementClient: &userManagementClient{}}
+	if result, err := client.ValidateUsersToImport(nil); result != nil || err == nil {
+		t.Errorf("ValidateUsersToImport(nil) = (%v, %v); want = (nil, error)", result, err)
+	}
+
+	users := []*UserToImport{
+		(&UserToImport{}).UID("user1").PasswordHash([]byte("password")),
+	}
+	want := "hash algorithm option is required to import users with passwords"
+	if result, err := client.ValidateUsersToImport(users); result != nil || err == nil || err.Error() != want {
+		t.Errorf("ValidateUsersToImport() = (%v, %v); want = (nil, %q)", result, err, want)
+	}
+}
+
 func TestDeleteUser(t *testing.T) {
 	resp := `{
 		"kind": "identitytoolkit#SignupNewUserResponse",
@@ -1010,6 +2133,7 @@ func TestMakeExportedUser(t *testing.T) {
 		Disabled:           false,
 		CreationTimestamp:  1234567890000,
 		LastLogInTimestamp: 1233211232000,
+		LastRefreshAt:      "2009-02-09T06:40:32.123Z",
 		CustomAttributes:   `{"admin": true, "package": "gold"}`,
 		ProviderUserInfo: []*UserInfo{
 			{
@@ -1030,7 +2154,7 @@ func TestMakeExportedUser(t *testing.T) {
 		PasswordHash: "passwordhash",
 		PasswordSalt: "salt",
 	}
-	exported, err := queryResponse.makeExportedUserRecord()
+	exported, err := queryResponse.makeExportedUserRecord(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1053,7 +2177,7 @@ func TestExportedUserRecordShouldClearRedacted(t *testing.T) {
 		PasswordHash: base64.StdEncoding.EncodeToString([]byte("REDACTED")),
 	}
 
-	exported, err := queryResponse.makeExportedUserRecord()
+	exported, err := queryResponse.makeExportedUserRecord(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1084,7 +2208,7 @@ func TestSessionCookie(t *testing.T) {
 	}
 
 	for _, tc := range cases {
-		cookie, err := s.Client.SessionCookie(context.Background(), "idToken", tc.expiresIn)
+		cookie, err := s.Client.SessionCookie(context.Background(), testIDToken, tc.expiresIn)
 		if cookie != "expectedCookie" || err != nil {
 			t.Errorf("SessionCookie() = (%q, %v); want = (%q, nil)", cookie, err, "expectedCookie")
 		}
@@ -1099,7 +2223,7 @@ func TestSessionCookie(t *testing.T) {
 			t.Fatal(err)
 		}
 		want := map[string]interface{}{
-			"idToken":       "idToken",
+			"idToken":       testIDToken,
 			"validDuration": tc.want,
 		}
 		if !reflect.DeepEqual(got, want) {
@@ -1118,7 +2242,7 @@ func TestSessionCookieError(t *testing.T) {
 	defer s.Close()
 	s.Status = http.StatusForbidden
 
-	cookie, err := s.Client.SessionCookie(context.Background(), "idToken", 10*time.Minute)
+	cookie, err := s.Client.SessionCookie(context.Background(), testIDToken, 10*time.Minute)
 	if cookie != "" || err == nil {
 		t.Fatalf("SessionCookie() = (%q, %v); want = (%q, error)", cookie, err, "")
 	}
@@ -1133,7 +2257,7 @@ func TestSessionCookieWithoutProjectID(t *testing.T) {
 	client := &Client{
 		userManagementClient: &userManagementClient{},
 	}
-	_, err := client.SessionCookie(context.Background(), "idToken", 10*time.Minute)
+	_, err := client.SessionCookie(context.Background(), testIDToken, 10*time.Minute)
 	want := "project id not available"
 	if err == nil || err.Error() != want {
 		t.Errorf("SessionCookie() = %v; want = %q", err, want)
@@ -1148,10 +2272,28 @@ func TestSessionCookieWithoutIDToken(t *testing.T) {
 	}
 }
 
+func TestSessionCookieMalformedIDToken(t *testing.T) {
+	client := &Client{}
+	cases := []struct {
+		idToken string
+		want    string
+	}{
+		{"idToken", "id token must be a JWT with 3 dot-separated segments; got 1 segments"},
+		{"header.payload", "id token must be a JWT with 3 dot-separated segments; got 2 segments"},
+		{"a.b.c.d", "id token must be a JWT with 3 dot-separated segments; got 4 segments"},
+	}
+	for _, tc := range cases {
+		_, err := client.SessionCookie(context.Background(), tc.idToken, 10*time.Minute)
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("SessionCookie(%q) = %v; want = %q", tc.idToken, err, tc.want)
+		}
+	}
+}
+
 func TestSessionCookieShortExpiresIn(t *testing.T) {
 	client := &Client{}
 	lessThanFiveMins := 5*time.Minute - time.Second
-	_, err := client.SessionCookie(context.Background(), "idToken", lessThanFiveMins)
+	_, err := client.SessionCookie(context.Background(), testIDToken, lessThanFiveMins)
 	if err == nil {
 		t.Errorf("SessionCookie(< 5 mins) = nil; want error")
 	}
@@ -1160,7 +2302,7 @@ func TestSessionCookieShortExpiresIn(t *testing.T) {
 func TestSessionCookieLongExpiresIn(t *testing.T) {
 	client := &Client{}
 	moreThanTwoWeeks := 14*24*time.Hour + time.Second
-	_, err := client.SessionCookie(context.Background(), "idToken", moreThanTwoWeeks)
+	_, err := client.SessionCookie(context.Background(), testIDToken, moreThanTwoWeeks)
 	if err == nil {
 		t.Errorf("SessionCookie(> 14 days) = nil; want error")
 	}
@@ -1177,7 +2319,7 @@ func TestHTTPError(t *testing.T) {
 		t.Fatalf("GetUser() = (%v, %v); want = (nil, error)", u, err)
 	}
 
-	want := `http error status: 500; body: {"error":"test"}`
+	want := `http error status: 500; body: {"error":"test"}; uid: "some uid"`
 	if err.Error() != want || !IsUnknown(err) {
 		t.Errorf("GetUser() = %v; want = %q", err, want)
 	}
@@ -1187,6 +2329,7 @@ func TestHTTPErrorWithCode(t *testing.T) {
 	errorCodes := map[string]func(error) bool{
 		"CONFIGURATION_NOT_FOUND": IsConfigurationNotFound,
 		"DUPLICATE_EMAIL":         IsEmailAlreadyExists,
+		"DUPLICATE_IDP_CONFIG":    IsConfigurationAlreadyExists,
 		"DUPLICATE_LOCAL_ID":      IsUIDAlreadyExists,
 		"EMAIL_EXISTS":            IsEmailAlreadyExists,
 		"INSUFFICIENT_PERMISSION": IsInsufficientPermission,
@@ -1205,13 +2348,71 @@ func TestHTTPErrorWithCode(t *testing.T) {
 			t.Fatalf("GetUser() = (%v, %v); want = (nil, error)", u, err)
 		}
 
-		want := fmt.Sprintf(`http error status: 500; body: {"error":{"message":"%s"}}`, code)
+		want := fmt.Sprintf(`http error status: 500; body: {"error":{"message":"%s"}}; uid: "some uid"`, code)
 		if err.Error() != want || !check(err) {
 			t.Errorf("GetUser() = %v; want = %q", err, want)
 		}
 	}
 }
 
+func TestHTTPErrorSubject(t *testing.T) {
+	s := echoServer([]byte(`{"error":{"message":"USER_NOT_FOUND"}}`), t)
+	defer s.Close()
+	s.Client.userManagementClient.httpClient.RetryConfig = nil
+	s.Status = http.StatusBadRequest
+
+	cases := []struct {
+		name string
+		call func() error
+		want string
+	}{
+		{
+			name: "UpdateUser",
+			call: func() error {
+				_, err := s.Client.UpdateUser(context.Background(), "uid1", (&UserToUpdate{}).Disabled(true))
+				return err
+			},
+			want: `uid: "uid1"`,
+		},
+		{
+			name: "DeleteUser",
+			call: func() error {
+				return s.Client.DeleteUser(context.Background(), "uid2")
+			},
+			want: `uid: "uid2"`,
+		},
+		{
+			name: "SetCustomUserClaims",
+			call: func() error {
+				return s.Client.SetCustomUserClaims(context.Background(), "uid3", map[string]interface{}{"admin": true})
+			},
+			want: `uid: "uid3"`,
+		},
+		{
+			name: "GetUserByEmail",
+			call: func() error {
+				_, err := s.Client.GetUserByEmail(context.Background(), "user@example.com")
+				return err
+			},
+			want: `email: "user@example.com"`,
+		},
+		{
+			name: "PasswordResetLink",
+			call: func() error {
+				_, err := s.Client.PasswordResetLink(context.Background(), "user@example.com")
+				return err
+			},
+			want: `email: "user@example.com"`,
+		},
+	}
+	for _, tc := range cases {
+		err := tc.call()
+		if err == nil || !strings.HasSuffix(err.Error(), "; "+tc.want) || !IsUserNotFound(err) {
+			t.Errorf("%s() = %v; want = error ending with %q", tc.name, err, tc.want)
+		}
+	}
+}
+
 type mockAuthServer struct {
 	Resp   []byte
 	Header map[string]string
@@ -1292,6 +2493,7 @@ func echoServer(resp interface{}, t *testing.T) *mockAuthServer {
 
 	authClient.userManagementClient.baseURL = s.Srv.URL
 	authClient.providerConfigClient.endpoint = s.Srv.URL
+	authClient.projectConfigClient.endpoint = s.Srv.URL
 	s.Client = authClient
 	return &s
 }
@@ -78,6 +78,8 @@ func NewClient(ctx context.Context, c *internal.DatabaseConfig) (*Client, error)
 		return p.Error
 	}
 	hc.ErrParser = ep
+	hc.Logger = c.Logger
+	hc.RetryObserver = c.RetryObserver
 
 	return &Client{
 		hc:           hc,
@@ -19,10 +19,12 @@ package firebase // import "firebase.google.com/go"
 
 import (
 	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"os"
+	"sync"
 
 	"cloud.google.com/go/firestore"
 	"firebase.google.com/go/auth"
@@ -45,33 +47,139 @@ const Version = "3.10.0"
 const firebaseEnvName = "FIREBASE_CONFIG"
 
 // An App holds configuration and state common to all Firebase services that are exposed from the SDK.
+//
+// An App does not hold any network connections or run any background goroutines itself. Connections are
+// owned by the service clients created from the App, such as auth.Client and messaging.Client, which
+// provide Close methods for releasing them.
 type App struct {
-	authOverride     map[string]interface{}
-	creds            *google.DefaultCredentials
-	dbURL            string
-	projectID        string
-	serviceAccountID string
-	storageBucket    string
-	opts             []option.ClientOption
+	authOverride         map[string]interface{}
+	creds                *google.DefaultCredentials
+	dbURL                string
+	projectID            string
+	serviceAccountID     string
+	storageBucket        string
+	idTokenCertURL       string
+	sessionCookieCertURL string
+	idTokenKeySource     PublicKeySource
+	cookieKeySource      PublicKeySource
+	requireSigningAcct   bool
+	logger               Logger
+	retryObserver        RetryObserver
+	opts                 []option.ClientOption
+}
+
+// Logger receives diagnostic messages from the SDK, such as retried HTTP requests, public key
+// refreshes and warnings about emulator use. Implementations must be safe for concurrent use.
+//
+// Debugf and Warnf take arguments in the manner of fmt.Printf. Implementations may forward them
+// to a structured logging library of choice.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// PublicKeySource provides the public keys used to verify the signatures of ID tokens or session cookies,
+// mapped by key ID (the kid header of a token). Implementations must be safe for concurrent use.
+type PublicKeySource interface {
+	PublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error)
+}
+
+// RetryObserver is notified of every HTTP request retried by the SDK, for example to export metrics about
+// retries. Implementations must be safe for concurrent use, and should return quickly, since OnRetry is
+// called before waiting for the retry.
+//
+// attempt is the number of the retry, starting at 1 for the first retry of a request. statusCode is the
+// HTTP status of the failed attempt, or 0 when it failed with the network error err.
+type RetryObserver interface {
+	OnRetry(attempt int, statusCode int, err error)
+}
+
+// RetryCounter is a RetryObserver that counts the retried requests. It is safe for concurrent use.
+type RetryCounter struct {
+	mu       sync.Mutex
+	total    int64
+	byStatus map[int]int64
+}
+
+// OnRetry counts a retry.
+func (c *RetryCounter) OnRetry(attempt int, statusCode int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byStatus == nil {
+		c.byStatus = make(map[int]int64)
+	}
+	c.total++
+	c.byStatus[statusCode]++
+}
+
+// Total returns the number of retries counted so far.
+func (c *RetryCounter) Total() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.total
+}
+
+// ByStatus returns the number of retries counted so far for each HTTP status code. Retries of requests
+// that failed with a network error are counted under status code 0. The returned map is a copy.
+func (c *RetryCounter) ByStatus() map[int]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := make(map[int]int64, len(c.byStatus))
+	for status, n := range c.byStatus {
+		result[status] = n
+	}
+	return result
 }
 
 // Config represents the configuration used to initialize an App.
+//
+// IDTokenCertURL and SessionCookieCertURL optionally override the URLs from which the public keys used to
+// verify ID tokens and session cookies are fetched. They are meant for environments that cannot reach
+// googleapis.com directly, and must point at mirrors that serve the keys in the same format as the
+// original endpoints.
+//
+// IDTokenKeySource and SessionCookieKeySource optionally replace the public keys fetched from Google with
+// the keys of the given sources. This lets tests verify tokens signed with a test key, without network
+// access. A key source cannot be combined with the corresponding cert URL. They must not be set in
+// production, where tokens are always signed by Google.
+//
+// RequireSigningAccount makes App.Auth fail when the service account used to sign custom tokens cannot
+// be determined, instead of deferring the error to the first CustomToken call. Depending on how the App
+// was initialized, this may require a call to the local Metadata service when the Auth client is created.
+//
+// Logger optionally receives diagnostic messages from the services created from the App. When it
+// is not set, these messages are discarded. Similarly, RetryObserver is optionally notified of the
+// HTTP requests retried by the Auth, Database, Instance ID and Messaging services.
 type Config struct {
-	AuthOverride     *map[string]interface{} `json:"databaseAuthVariableOverride"`
-	DatabaseURL      string                  `json:"databaseURL"`
-	ProjectID        string                  `json:"projectId"`
-	ServiceAccountID string                  `json:"serviceAccountId"`
-	StorageBucket    string                  `json:"storageBucket"`
+	AuthOverride           *map[string]interface{} `json:"databaseAuthVariableOverride"`
+	DatabaseURL            string                  `json:"databaseURL"`
+	ProjectID              string                  `json:"projectId"`
+	ServiceAccountID       string                  `json:"serviceAccountId"`
+	StorageBucket          string                  `json:"storageBucket"`
+	IDTokenCertURL         string                  `json:"idTokenCertUrl"`
+	SessionCookieCertURL   string                  `json:"sessionCookieCertUrl"`
+	IDTokenKeySource       PublicKeySource         `json:"-"`
+	SessionCookieKeySource PublicKeySource         `json:"-"`
+	RequireSigningAccount  bool                    `json:"-"`
+	Logger                 Logger                  `json:"-"`
+	RetryObserver          RetryObserver           `json:"-"`
 }
 
 // Auth returns an instance of auth.Client.
 func (a *App) Auth(ctx context.Context) (*auth.Client, error) {
 	conf := &internal.AuthConfig{
-		Creds:            a.creds,
-		ProjectID:        a.projectID,
-		Opts:             a.opts,
-		ServiceAccountID: a.serviceAccountID,
-		Version:          Version,
+		Creds:                  a.creds,
+		ProjectID:              a.projectID,
+		Opts:                   a.opts,
+		ServiceAccountID:       a.serviceAccountID,
+		IDTokenCertURL:         a.idTokenCertURL,
+		SessionCookieCertURL:   a.sessionCookieCertURL,
+		IDTokenKeySource:       a.idTokenKeySource,
+		SessionCookieKeySource: a.cookieKeySource,
+		RequireSigningAccount:  a.requireSigningAcct,
+		Version:                Version,
+		Logger:                 a.logger,
+		RetryObserver:          a.retryObserver,
 	}
 	return auth.NewClient(ctx, conf)
 }
@@ -86,10 +194,12 @@ func (a *App) Database(ctx context.Context) (*db.Client, error) {
 // identified by the given URL.
 func (a *App) DatabaseWithURL(ctx context.Context, url string) (*db.Client, error) {
 	conf := &internal.DatabaseConfig{
-		AuthOverride: a.authOverride,
-		URL:          url,
-		Opts:         a.opts,
-		Version:      Version,
+		AuthOverride:  a.authOverride,
+		URL:           url,
+		Opts:          a.opts,
+		Version:       Version,
+		Logger:        a.logger,
+		RetryObserver: a.retryObserver,
 	}
 	return db.NewClient(ctx, conf)
 }
@@ -115,8 +225,10 @@ func (a *App) Firestore(ctx context.Context) (*firestore.Client, error) {
 // InstanceID returns an instance of iid.Client.
 func (a *App) InstanceID(ctx context.Context) (*iid.Client, error) {
 	conf := &internal.InstanceIDConfig{
-		ProjectID: a.projectID,
-		Opts:      a.opts,
+		ProjectID:     a.projectID,
+		Opts:          a.opts,
+		Logger:        a.logger,
+		RetryObserver: a.retryObserver,
 	}
 	return iid.NewClient(ctx, conf)
 }
@@ -124,9 +236,11 @@ func (a *App) InstanceID(ctx context.Context) (*iid.Client, error) {
 // Messaging returns an instance of messaging.Client.
 func (a *App) Messaging(ctx context.Context) (*messaging.Client, error) {
 	conf := &internal.MessagingConfig{
-		ProjectID: a.projectID,
-		Opts:      a.opts,
-		Version:   Version,
+		ProjectID:     a.projectID,
+		Opts:          a.opts,
+		Version:       Version,
+		Logger:        a.logger,
+		RetryObserver: a.retryObserver,
 	}
 	return messaging.NewClient(ctx, conf)
 }
@@ -170,13 +284,20 @@ func NewApp(ctx context.Context, config *Config, opts ...option.ClientOption) (*
 	}
 
 	return &App{
-		authOverride:     ao,
-		creds:            creds,
-		dbURL:            config.DatabaseURL,
-		projectID:        pid,
-		serviceAccountID: config.ServiceAccountID,
-		storageBucket:    config.StorageBucket,
-		opts:             o,
+		authOverride:         ao,
+		creds:                creds,
+		dbURL:                config.DatabaseURL,
+		projectID:            pid,
+		serviceAccountID:     config.ServiceAccountID,
+		storageBucket:        config.StorageBucket,
+		idTokenCertURL:       config.IDTokenCertURL,
+		sessionCookieCertURL: config.SessionCookieCertURL,
+		idTokenKeySource:     config.IDTokenKeySource,
+		cookieKeySource:      config.SessionCookieKeySource,
+		requireSigningAcct:   config.RequireSigningAccount,
+		logger:               config.Logger,
+		retryObserver:        config.RetryObserver,
+		opts:                 o,
 	}, nil
 }
 
@@ -16,7 +16,9 @@ package firebase
 
 import (
 	"context"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -26,6 +28,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -233,6 +236,147 @@ func TestAuth(t *testing.T) {
 	}
 }
 
+type testLogger struct{}
+
+func (testLogger) Debugf(format string, args ...interface{}) {}
+
+func (testLogger) Warnf(format string, args ...interface{}) {}
+
+func TestAuthWithLogger(t *testing.T) {
+	ctx := context.Background()
+	logger := testLogger{}
+	app, err := NewApp(ctx, &Config{Logger: logger}, option.WithCredentialsFile("testdata/service_account.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if app.logger != logger {
+		t.Errorf("app.logger = %v; want = %v", app.logger, logger)
+	}
+	if c, err := app.Auth(ctx); c == nil || err != nil {
+		t.Errorf("Auth() = (%v, %v); want (auth, nil)", c, err)
+	}
+	if c, err := app.Messaging(ctx); c == nil || err != nil {
+		t.Errorf("Messaging() = (%v, %v); want (messaging, nil)", c, err)
+	}
+}
+
+func TestAppWithRetryObserver(t *testing.T) {
+	ctx := context.Background()
+	counter := &RetryCounter{}
+	app, err := NewApp(ctx, &Config{RetryObserver: counter}, option.WithCredentialsFile("testdata/service_account.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if app.retryObserver != counter {
+		t.Errorf("app.retryObserver = %v; want = %v", app.retryObserver, counter)
+	}
+	if c, err := app.Auth(ctx); c == nil || err != nil {
+		t.Errorf("Auth() = (%v, %v); want (auth, nil)", c, err)
+	}
+	if c, err := app.Messaging(ctx); c == nil || err != nil {
+		t.Errorf("Messaging() = (%v, %v); want (messaging, nil)", c, err)
+	}
+}
+
+type testKeySource struct{}
+
+func (s *testKeySource) PublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	return nil, nil
+}
+
+func TestAppWithKeySources(t *testing.T) {
+	ctx := context.Background()
+	idTokenSrc := &testKeySource{}
+	cookieSrc := &testKeySource{}
+	config := &Config{
+		IDTokenKeySource:       idTokenSrc,
+		SessionCookieKeySource: cookieSrc,
+	}
+	app, err := NewApp(ctx, config, option.WithCredentialsFile("testdata/service_account.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if app.idTokenKeySource != idTokenSrc {
+		t.Errorf("app.idTokenKeySource = %v; want = %v", app.idTokenKeySource, idTokenSrc)
+	}
+	if app.cookieKeySource != cookieSrc {
+		t.Errorf("app.cookieKeySource = %v; want = %v", app.cookieKeySource, cookieSrc)
+	}
+	if c, err := app.Auth(ctx); c == nil || err != nil {
+		t.Errorf("Auth() = (%v, %v); want (auth, nil)", c, err)
+	}
+}
+
+func TestAppWithCertURLAndKeySource(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{
+		IDTokenCertURL:   "https://mirror.example.com/id-token-certs",
+		IDTokenKeySource: &testKeySource{},
+	}
+	app, err := NewApp(ctx, config, option.WithCredentialsFile("testdata/service_account.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := app.Auth(ctx); c != nil || err == nil {
+		t.Errorf("Auth() = (%v, %v); want (nil, error)", c, err)
+	}
+}
+
+func TestAppRequireSigningAccount(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{RequireSigningAccount: true}
+	app, err := NewApp(ctx, config, option.WithCredentialsFile("testdata/service_account.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !app.requireSigningAcct {
+		t.Errorf("app.requireSigningAcct = false; want = true")
+	}
+	if c, err := app.Auth(ctx); c == nil || err != nil {
+		t.Errorf("Auth() = (%v, %v); want (auth, nil)", c, err)
+	}
+}
+
+func TestRetryCounter(t *testing.T) {
+	counter := &RetryCounter{}
+	if counter.Total() != 0 || len(counter.ByStatus()) != 0 {
+		t.Errorf("RetryCounter = (%d, %v); want = (0, empty)", counter.Total(), counter.ByStatus())
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				counter.OnRetry(1, http.StatusServiceUnavailable, nil)
+			} else {
+				counter.OnRetry(2, 0, errors.New("network error"))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if counter.Total() != 10 {
+		t.Errorf("Total() = %d; want = 10", counter.Total())
+	}
+	want := map[int]int64{http.StatusServiceUnavailable: 5, 0: 5}
+	byStatus := counter.ByStatus()
+	if !reflect.DeepEqual(byStatus, want) {
+		t.Errorf("ByStatus() = %v; want = %v", byStatus, want)
+	}
+
+	byStatus[0] = 100
+	if counter.ByStatus()[0] != 5 {
+		t.Errorf("ByStatus() is not a copy")
+	}
+}
+
 func TestDatabase(t *testing.T) {
 	ctx := context.Background()
 	conf := &Config{DatabaseURL: "https://mock-db.firebaseio.com"}
@@ -462,6 +606,20 @@ func TestAutoInit(t *testing.T) {
 				StorageBucket: "auto-init.storage.bucket",
 			},
 		},
+		{
+			"<env=string_with_cert_urls,opts=nil>",
+			`{
+				"projectId": "auto-init-project-id",
+				"idTokenCertUrl": "https://mirror.example.com/id-token-certs",
+				"sessionCookieCertUrl": "https://mirror.example.com/session-cookie-certs"
+			  }`,
+			nil,
+			&Config{
+				ProjectID:            "auto-init-project-id",
+				IDTokenCertURL:       "https://mirror.example.com/id-token-certs",
+				SessionCookieCertURL: "https://mirror.example.com/session-cookie-certs",
+			},
+		},
 		{
 			"<env=file_missing_fields,opts=nil>",
 			"testdata/firebase_config_partial.json",
@@ -635,6 +793,12 @@ func compareConfig(got *App, want *Config, t *testing.T) {
 	if got.storageBucket != want.StorageBucket {
 		t.Errorf("app.storageBucket = %q; want = %q", got.storageBucket, want.StorageBucket)
 	}
+	if got.idTokenCertURL != want.IDTokenCertURL {
+		t.Errorf("app.idTokenCertURL = %q; want = %q", got.idTokenCertURL, want.IDTokenCertURL)
+	}
+	if got.sessionCookieCertURL != want.SessionCookieCertURL {
+		t.Errorf("app.sessionCookieCertURL = %q; want = %q", got.sessionCookieCertURL, want.SessionCookieCertURL)
+	}
 }
 
 // mockServiceAcct generates a service account configuration with the provided URL as the
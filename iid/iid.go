@@ -120,6 +120,8 @@ func NewClient(ctx context.Context, c *internal.InstanceIDConfig) (*Client, erro
 	if err != nil {
 		return nil, err
 	}
+	hc.Logger = c.Logger
+	hc.RetryObserver = c.RetryObserver
 
 	return &Client{
 		endpoint: iidEndpoint,
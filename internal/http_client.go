@@ -39,12 +39,14 @@ import (
 //
 // HTTPClient also handles automatically retrying failed HTTP requests.
 type HTTPClient struct {
-	Client      *http.Client
-	RetryConfig *RetryConfig
-	ErrParser   ErrorParser // Deprecated. Use CreateErrFn instead.
-	CreateErrFn CreateErrFn
-	SuccessFn   SuccessFn
-	Opts        []HTTPOption
+	Client        *http.Client
+	RetryConfig   *RetryConfig
+	ErrParser     ErrorParser // Deprecated. Use CreateErrFn instead.
+	CreateErrFn   CreateErrFn
+	SuccessFn     SuccessFn
+	Opts          []HTTPOption
+	Logger        Logger        // Receives a debug message for each retried request. Optional.
+	RetryObserver RetryObserver // Notified of each retried request. Optional.
 }
 
 // SuccessFn is a function that checks if a Response indicates success.
@@ -127,6 +129,8 @@ func (c *HTTPClient) Do(ctx context.Context, req *Request) (*Response, error) {
 		if !result.Retry {
 			break
 		}
+		c.logRetry(req, result, retries)
+		c.notifyRetry(result, retries)
 		if err = result.waitForRetry(ctx); err != nil {
 			return nil, err
 		}
@@ -134,6 +138,34 @@ func (c *HTTPClient) Do(ctx context.Context, req *Request) (*Response, error) {
 	return c.handleResult(req, result)
 }
 
+// CloseIdleConnections closes any idle connections kept open by the underlying http.Client. It is a
+// no-op when the HTTPClient is nil, or when the transport of the http.Client does not support closing
+// idle connections.
+func (c *HTTPClient) CloseIdleConnections() {
+	if c != nil {
+		CloseIdleConnections(c.Client)
+	}
+}
+
+// CloseIdleConnections closes any idle connections kept open by the transport of the given http.Client,
+// or by http.DefaultTransport when the client does not specify a transport. It is a no-op when hc is nil,
+// or when the transport does not support closing idle connections.
+//
+// The transport is inspected directly, since http.Client.CloseIdleConnections is not available before
+// Go 1.12.
+func CloseIdleConnections(hc *http.Client) {
+	if hc == nil {
+		return
+	}
+	transport := hc.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if t, ok := transport.(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+}
+
 // DoAndUnmarshal behaves similar to Do, but additionally unmarshals the response payload into
 // the given pointer.
 //
@@ -184,6 +216,28 @@ func (c *HTTPClient) attempt(ctx context.Context, req *Request, retries int) (*a
 	return result, nil
 }
 
+func (c *HTTPClient) logRetry(req *Request, result *attemptResult, retries int) {
+	var cause string
+	if result.Err != nil {
+		cause = result.Err.Error()
+	} else {
+		cause = fmt.Sprintf("status %d", result.Resp.Status)
+	}
+	LoggerOrNop(c.Logger).Debugf(
+		"retrying %s %s after %v (retry %d): %s", req.Method, req.URL, result.RetryAfter, retries+1, cause)
+}
+
+func (c *HTTPClient) notifyRetry(result *attemptResult, retries int) {
+	if c.RetryObserver == nil {
+		return
+	}
+	var status int
+	if result.Resp != nil {
+		status = result.Resp.Status
+	}
+	c.RetryObserver.OnRetry(retries+1, status, result.Err)
+}
+
 func (c *HTTPClient) handleResult(req *Request, result *attemptResult) (*Response, error) {
 	if result.Err != nil {
 		return nil, fmt.Errorf("error while making http call: %v", result.Err)
@@ -466,7 +520,14 @@ func parseRetryAfterHeader(resp *http.Response) time.Duration {
 	if resp == nil {
 		return 0
 	}
-	retryAfterHeader := resp.Header.Get("retry-after")
+	return ParseRetryAfter(resp.Header)
+}
+
+// ParseRetryAfter returns the delay indicated by the Retry-After header in the given HTTP headers. The header
+// may specify either a number of seconds or an HTTP date. Returns 0 if the header is not present or malformed.
+// The result is negative if the header specifies a date in the past.
+func ParseRetryAfter(header http.Header) time.Duration {
+	retryAfterHeader := header.Get("retry-after")
 	if retryAfterHeader == "" {
 		return 0
 	}
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -867,6 +868,168 @@ func TestNewHTTPClientRetryOnHTTPErrors(t *testing.T) {
 	}
 }
 
+func TestCloseIdleConnections(t *testing.T) {
+	closed := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			close(closed)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := &HTTPClient{Client: &http.Client{Transport: &http.Transport{}}}
+	req := &Request{Method: http.MethodGet, URL: server.URL}
+	if _, err := client.Do(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	client.CloseIdleConnections()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Errorf("CloseIdleConnections() did not close the idle connection")
+	}
+
+	var nilClient *HTTPClient
+	nilClient.CloseIdleConnections()
+	(&HTTPClient{}).CloseIdleConnections()
+	CloseIdleConnections(nil)
+	CloseIdleConnections(&http.Client{})
+}
+
+type nopRoundTripper struct{}
+
+func (nopRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestCloseIdleConnectionsUnsupportedTransport(t *testing.T) {
+	client := &HTTPClient{Client: &http.Client{Transport: nopRoundTripper{}}}
+	client.CloseIdleConnections()
+}
+
+type recordingLogger struct {
+	debug []string
+	warn  []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.debug = append(l.debug, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.warn = append(l.warn, fmt.Sprintf(format, args...))
+}
+
+func TestRetryLogging(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("{}"))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, _, err := NewHTTPClient(context.Background(), tokenSourceOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RetryConfig.ExpBackoffFactor = 0
+	logger := &recordingLogger{}
+	client.Logger = logger
+
+	req := &Request{Method: http.MethodGet, URL: server.URL}
+	if _, err := client.Do(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if len(logger.debug) != defaultMaxRetries {
+		t.Fatalf("Debugf() calls = %d; want = %d", len(logger.debug), defaultMaxRetries)
+	}
+	want := fmt.Sprintf("retrying GET %s after 0s (retry 1): status 503", server.URL)
+	if logger.debug[0] != want {
+		t.Errorf("Debugf() = %q; want = %q", logger.debug[0], want)
+	}
+	if len(logger.warn) != 0 {
+		t.Errorf("Warnf() = %v; want = none", logger.warn)
+	}
+}
+
+type retryEvent struct {
+	attempt int
+	status  int
+	err     error
+}
+
+type recordingRetryObserver struct {
+	events []retryEvent
+}
+
+func (o *recordingRetryObserver) OnRetry(attempt int, statusCode int, err error) {
+	o.events = append(o.events, retryEvent{attempt, statusCode, err})
+}
+
+func TestRetryObserver(t *testing.T) {
+	requests := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else if requests == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte("{}"))
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, _, err := NewHTTPClient(context.Background(), tokenSourceOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RetryConfig.ExpBackoffFactor = 0
+	observer := &recordingRetryObserver{}
+	client.RetryObserver = observer
+
+	req := &Request{Method: http.MethodGet, URL: server.URL}
+	if _, err := client.Do(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	want := []retryEvent{
+		{1, http.StatusServiceUnavailable, nil},
+		{2, http.StatusInternalServerError, nil},
+	}
+	if !reflect.DeepEqual(observer.events, want) {
+		t.Errorf("OnRetry() calls = %v; want = %v", observer.events, want)
+	}
+}
+
+func TestRetryObserverNetworkError(t *testing.T) {
+	client := &HTTPClient{
+		Client: &http.Client{Transport: &faultyTransport{}},
+		RetryConfig: &RetryConfig{
+			MaxRetries: 2,
+		},
+	}
+	observer := &recordingRetryObserver{}
+	client.RetryObserver = observer
+
+	req := &Request{Method: http.MethodGet, URL: "https://example.com"}
+	if _, err := client.Do(context.Background(), req); err == nil {
+		t.Fatal("Do() = nil; want = error")
+	}
+	if len(observer.events) != 2 {
+		t.Fatalf("OnRetry() calls = %d; want = 2", len(observer.events))
+	}
+	for i, e := range observer.events {
+		if e.attempt != i+1 || e.status != 0 || e.err == nil {
+			t.Errorf("OnRetry() = %v; want = {%d 0 error}", e, i+1)
+		}
+	}
+}
+
 func TestNewHttpClientNoRetryOnNotFound(t *testing.T) {
 	requests := 0
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
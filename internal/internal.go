@@ -16,6 +16,8 @@
 package internal // import "firebase.google.com/go/internal"
 
 import (
+	"context"
+	"crypto/rsa"
 	"fmt"
 	"time"
 
@@ -37,13 +39,53 @@ var FirebaseScopes = []string{
 // SystemClock is a clock that returns local time of the system.
 var SystemClock = &systemClock{}
 
+// Logger receives diagnostic messages from the SDK.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// NopLogger is a Logger that discards all messages.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+
+func (nopLogger) Warnf(format string, args ...interface{}) {}
+
+// LoggerOrNop returns l, or NopLogger if l is nil.
+func LoggerOrNop(l Logger) Logger {
+	if l == nil {
+		return NopLogger
+	}
+	return l
+}
+
+// PublicKeySource provides the public keys used to verify the signatures of tokens, mapped by key ID.
+type PublicKeySource interface {
+	PublicKeys(ctx context.Context) (map[string]*rsa.PublicKey, error)
+}
+
+// RetryObserver is notified of the HTTP requests retried by the SDK.
+type RetryObserver interface {
+	OnRetry(attempt int, statusCode int, err error)
+}
+
 // AuthConfig represents the configuration of Firebase Auth service.
 type AuthConfig struct {
-	Opts             []option.ClientOption
-	Creds            *google.DefaultCredentials
-	ProjectID        string
-	ServiceAccountID string
-	Version          string
+	Opts                   []option.ClientOption
+	Creds                  *google.DefaultCredentials
+	ProjectID              string
+	ServiceAccountID       string
+	IDTokenCertURL         string
+	SessionCookieCertURL   string
+	IDTokenKeySource       PublicKeySource
+	SessionCookieKeySource PublicKeySource
+	RequireSigningAccount  bool
+	Version                string
+	Logger                 Logger
+	RetryObserver          RetryObserver
 }
 
 // HashConfig represents a hash algorithm configuration used to generate password hashes.
@@ -51,16 +93,20 @@ type HashConfig map[string]interface{}
 
 // InstanceIDConfig represents the configuration of Firebase Instance ID service.
 type InstanceIDConfig struct {
-	Opts      []option.ClientOption
-	ProjectID string
+	Opts          []option.ClientOption
+	ProjectID     string
+	Logger        Logger
+	RetryObserver RetryObserver
 }
 
 // DatabaseConfig represents the configuration of Firebase Database service.
 type DatabaseConfig struct {
-	Opts         []option.ClientOption
-	URL          string
-	Version      string
-	AuthOverride map[string]interface{}
+	Opts          []option.ClientOption
+	URL           string
+	Version       string
+	AuthOverride  map[string]interface{}
+	Logger        Logger
+	RetryObserver RetryObserver
 }
 
 // StorageConfig represents the configuration of Google Cloud Storage service.
@@ -71,9 +117,11 @@ type StorageConfig struct {
 
 // MessagingConfig represents the configuration of Firebase Cloud Messaging service.
 type MessagingConfig struct {
-	Opts      []option.ClientOption
-	ProjectID string
-	Version   string
+	Opts          []option.ClientOption
+	ProjectID     string
+	Version       string
+	Logger        Logger
+	RetryObserver RetryObserver
 }
 
 // FirebaseError is an error type containing an error code string.
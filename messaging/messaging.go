@@ -21,11 +21,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"firebase.google.com/go/internal"
 	"google.golang.org/api/transport"
@@ -51,8 +54,6 @@ const (
 )
 
 var (
-	topicNamePattern = regexp.MustCompile("^(/topics/)?(private/)?[a-zA-Z0-9-_.~%]+$")
-
 	fcmErrorCodes = map[string]struct{ Code, Msg string }{
 		// FCM v1 canonical error codes
 		"NOT_FOUND": {
@@ -107,10 +108,33 @@ var (
 // Message to be sent via Firebase Cloud Messaging.
 //
 // Message contains payload data, recipient information and platform-specific configuration
-// options. A Message must specify exactly one of Token, Topic or Condition fields. Apart from
-// that a Message may specify any combination of Data, Notification, Android, Webpush and APNS
+// options. A Message must specify exactly one of Token, Tokens, Topic or Condition fields. Apart
+// from that a Message may specify any combination of Data, Notification, Android, Webpush and APNS
 // fields. See https://firebase.google.com/docs/reference/fcm/rest/v1/projects.messages for more
 // details on how the backend FCM servers handle different message parameters.
+//
+// Tokens is a convenience for targeting a small number of devices (up to 100) with the same
+// message. A Message with a single element in Tokens is sent exactly like one that sets Token.
+// A Message with more than one element in Tokens must be sent with SendToTokens, which reports
+// the outcome for each token.
+//
+// Condition is a boolean expression over topics, such as "'TopicA' in topics && !('TopicB' in topics)",
+// using the &&, || and ! operators and parentheses. It is checked for well-formedness, and may refer to at
+// most 5 topics. FCM does not report how many devices matched a condition.
+//
+// TTL is the maximum time the message is kept for delivery while the target device is offline, on all
+// platforms that support it. It is sent to Android as the TTL of the AndroidConfig, and to APNS as an
+// absolute apns-expiration header computed when the message is serialized. Platform-specific settings take
+// precedence: the TTL of AndroidConfig overrides it for Android, and the apns-expiration header or the TTL
+// of APNSConfig override it for APNS, in that order. A TTL of zero asks both services to attempt delivery
+// only once, and to drop the message if the device is not reachable.
+//
+// CollapseID identifies a group of messages that represent updates of the same logical content, so that
+// devices only display the latest of them. It is sent to Android as the CollapseKey of the AndroidConfig, to
+// APNS as the apns-collapse-id header, and to Webpush as the Topic header. Platform-specific settings take
+// precedence, in which case CollapseID is not sent to that platform. Unless overridden, CollapseID must not be
+// longer than 64 bytes (the APNS limit), and must consist of at most 32 characters from the URL-safe base64
+// alphabet (the Webpush limit).
 type Message struct {
 	Data         map[string]string `json:"data,omitempty"`
 	Notification *Notification     `json:"notification,omitempty"`
@@ -119,8 +143,11 @@ type Message struct {
 	APNS         *APNSConfig       `json:"apns,omitempty"`
 	FCMOptions   *FCMOptions       `json:"fcm_options,omitempty"`
 	Token        string            `json:"token,omitempty"`
+	Tokens       []string          `json:"-"`
 	Topic        string            `json:"-"`
 	Condition    string            `json:"condition,omitempty"`
+	TTL          *time.Duration    `json:"-"`
+	CollapseID   string            `json:"-"`
 }
 
 // MarshalJSON marshals a Message into JSON (for internal use only).
@@ -135,9 +162,121 @@ func (m *Message) MarshalJSON() ([]byte, error) {
 		BareTopic:       strings.TrimPrefix(m.Topic, "/topics/"),
 		messageInternal: (*messageInternal)(m),
 	}
+	android := m.effectiveAndroid()
+	webpush := m.effectiveWebpush()
+	apns := m.effectiveAPNS()
+	singleToken := m.Token == "" && len(m.Tokens) == 1
+	if android != m.Android || webpush != m.Webpush || apns != m.APNS || singleToken {
+		mi := *temp.messageInternal
+		mi.Android = android
+		mi.Webpush = webpush
+		mi.APNS = apns
+		if singleToken {
+			mi.Token = m.Tokens[0]
+		}
+		temp.messageInternal = &mi
+	}
 	return json.Marshal(temp)
 }
 
+// effectiveAndroid returns the AndroidConfig to be sent for the Message, with the Message-level TTL and
+// CollapseID filled in unless the AndroidConfig sets its own. The original AndroidConfig is returned when there
+// is nothing to fill in. It is never modified.
+func (m *Message) effectiveAndroid() *AndroidConfig {
+	fillTTL := m.TTL != nil && (m.Android == nil || m.Android.TTL == nil)
+	fillCollapseKey := m.CollapseID != "" && (m.Android == nil || m.Android.CollapseKey == "")
+	if !fillTTL && !fillCollapseKey {
+		return m.Android
+	}
+	var android AndroidConfig
+	if m.Android != nil {
+		android = *m.Android
+	}
+	if fillTTL {
+		android.TTL = m.TTL
+	}
+	if fillCollapseKey {
+		android.CollapseKey = m.CollapseID
+	}
+	return &android
+}
+
+// effectiveWebpush returns the WebpushConfig to be sent for the Message, with the Topic header filled in from
+// the Message-level CollapseID unless the WebpushConfig sets its own. The original WebpushConfig is returned
+// when there is nothing to fill in. It is never modified.
+func (m *Message) effectiveWebpush() *WebpushConfig {
+	if m.CollapseID == "" || (m.Webpush != nil && hasHeader(m.Webpush.Headers, webpushTopicHeader)) {
+		return m.Webpush
+	}
+	var webpush WebpushConfig
+	if m.Webpush != nil {
+		webpush = *m.Webpush
+	}
+	webpush.Headers = withHeader(webpush.Headers, webpushTopicHeader, m.CollapseID)
+	return &webpush
+}
+
+// effectiveAPNS returns the APNSConfig to be sent for the Message, with the values that depend on other fields
+// of the Message filled in. The original APNSConfig is returned when there is nothing to fill in. It is never
+// modified.
+func (m *Message) effectiveAPNS() *APNSConfig {
+	apns := m.APNS
+	if m.TTL != nil && (apns == nil || apns.TTL == nil) {
+		// The apns-expiration header, if set explicitly, still takes precedence when the headers are computed.
+		var c APNSConfig
+		if apns != nil {
+			c = *apns
+		}
+		c.TTL = m.TTL
+		apns = &c
+	}
+	if m.CollapseID != "" && (apns == nil || !hasHeader(apns.Headers, apnsCollapseIDHeader)) {
+		var c APNSConfig
+		if apns != nil {
+			c = *apns
+		}
+		c.Headers = withHeader(c.Headers, apnsCollapseIDHeader, m.CollapseID)
+		apns = &c
+	}
+	if m.Notification != nil && m.Notification.ImageURL != "" && !apns.hasImageURL() {
+		// The notification service extension reads the image from the fcm_options of the APNS payload.
+		apns = apns.withImageURL(m.Notification.ImageURL)
+	}
+	if m.hasImage() && !apns.hasMutableContent() {
+		// iOS only downloads notification images in a notification service extension, which requires
+		// mutable-content to be set.
+		apns = apns.withMutableContent()
+	}
+	if apns != nil && m.Notification != nil {
+		// The APNS push type depends on the top-level notification, which APNSConfig cannot see on its own.
+		if apns == m.APNS {
+			c := *apns
+			apns = &c
+		}
+		apns.Headers = apns.computeHeaders(true)
+	}
+	return apns
+}
+
+func (m *Message) hasImage() bool {
+	if m.Notification != nil && m.Notification.ImageURL != "" {
+		return true
+	}
+	return m.APNS.hasImageURL()
+}
+
+// registrationToken returns the registration token targeted by the Message, or an empty string if the
+// Message targets a topic or a condition.
+func (m *Message) registrationToken() string {
+	if m == nil {
+		return ""
+	}
+	if m.Token == "" && len(m.Tokens) == 1 {
+		return m.Tokens[0]
+	}
+	return m.Token
+}
+
 // UnmarshalJSON unmarshals a JSON string into a Message (for internal use only).
 func (m *Message) UnmarshalJSON(b []byte) error {
 	type messageInternal Message
@@ -155,6 +294,11 @@ func (m *Message) UnmarshalJSON(b []byte) error {
 }
 
 // Notification is the basic notification template to use across all platforms.
+//
+// When ImageURL is specified, mutable-content is automatically set in the aps dictionary of the APNS payload, so
+// that a notification service extension on iOS devices can download and attach the image. The same applies to the
+// ImageURL of APNSFCMOptions. ImageURL is also copied into the APNSFCMOptions, where the notification service
+// extension looks for it, unless the APNSConfig specifies an image of its own.
 type Notification struct {
 	Title    string `json:"title,omitempty"`
 	Body     string `json:"body,omitempty"`
@@ -176,13 +320,7 @@ type AndroidConfig struct {
 func (a *AndroidConfig) MarshalJSON() ([]byte, error) {
 	var ttl string
 	if a.TTL != nil {
-		seconds := int64(*a.TTL / time.Second)
-		nanos := int64((*a.TTL - time.Duration(seconds)*time.Second) / time.Nanosecond)
-		if nanos > 0 {
-			ttl = fmt.Sprintf("%d.%09ds", seconds, nanos)
-		} else {
-			ttl = fmt.Sprintf("%ds", seconds)
-		}
+		ttl = durationToString(*a.TTL)
 	}
 
 	type androidInternal AndroidConfig
@@ -209,42 +347,116 @@ func (a *AndroidConfig) UnmarshalJSON(b []byte) error {
 		return err
 	}
 	if temp.TTL != "" {
-		segments := strings.Split(strings.TrimSuffix(temp.TTL, "s"), ".")
-		if len(segments) != 1 && len(segments) != 2 {
-			return fmt.Errorf("incorrect number of segments in ttl: %q", temp.TTL)
-		}
-		seconds, err := strconv.ParseInt(segments[0], 10, 64)
+		ttl, err := stringToDuration(temp.TTL)
 		if err != nil {
-			return err
-		}
-		ttl := time.Duration(seconds) * time.Second
-		if len(segments) == 2 {
-			nanos, err := strconv.ParseInt(strings.TrimLeft(segments[1], "0"), 10, 64)
-			if err != nil {
-				return err
-			}
-			ttl += time.Duration(nanos) * time.Nanosecond
+			return fmt.Errorf("invalid ttl: %v", err)
 		}
 		a.TTL = &ttl
 	}
 	return nil
 }
 
+// durationToString formats a duration in the JSON representation of protobuf durations, which is the
+// number of seconds with an "s" suffix (e.g. "3.5s" is sent as "3.500000000s").
+func durationToString(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	nanos := int64((d - time.Duration(seconds)*time.Second) / time.Nanosecond)
+	if nanos > 0 {
+		return fmt.Sprintf("%d.%09ds", seconds, nanos)
+	}
+	return fmt.Sprintf("%ds", seconds)
+}
+
+// stringToDuration parses a duration formatted by durationToString.
+func stringToDuration(s string) (time.Duration, error) {
+	segments := strings.Split(strings.TrimSuffix(s, "s"), ".")
+	if len(segments) != 1 && len(segments) != 2 {
+		return 0, fmt.Errorf("incorrect number of segments in duration: %q", s)
+	}
+	seconds, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	d := time.Duration(seconds) * time.Second
+	if len(segments) == 2 {
+		nanos, err := strconv.ParseInt(strings.TrimLeft(segments[1], "0"), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(nanos) * time.Nanosecond
+	}
+	return d, nil
+}
+
 // AndroidNotification is a notification to send to Android devices.
+//
+// VibrateTimings specifies the vibration pattern to use, as alternating durations to keep the vibrator off
+// and on, starting with an off period. Set DefaultVibrateTimings to use the default pattern of the device
+// instead, in which case VibrateTimings is ignored. Similarly, DefaultLightSettings makes the notification
+// use the default LED light settings of the device. Ticker is the text announced by accessibility services
+// when the notification is first posted.
 type AndroidNotification struct {
-	Title        string   `json:"title,omitempty"` // if specified, overrides the Title field of the Notification type
-	Body         string   `json:"body,omitempty"`  // if specified, overrides the Body field of the Notification type
-	Icon         string   `json:"icon,omitempty"`
-	Color        string   `json:"color,omitempty"` // notification color in #RRGGBB format
-	Sound        string   `json:"sound,omitempty"`
-	Tag          string   `json:"tag,omitempty"`
-	ClickAction  string   `json:"click_action,omitempty"`
-	BodyLocKey   string   `json:"body_loc_key,omitempty"`
-	BodyLocArgs  []string `json:"body_loc_args,omitempty"`
-	TitleLocKey  string   `json:"title_loc_key,omitempty"`
-	TitleLocArgs []string `json:"title_loc_args,omitempty"`
-	ChannelID    string   `json:"channel_id,omitempty"`
-	ImageURL     string   `json:"image,omitempty"`
+	Title                 string          `json:"title,omitempty"` // if specified, overrides the Title field of the Notification type
+	Body                  string          `json:"body,omitempty"`  // if specified, overrides the Body field of the Notification type
+	Icon                  string          `json:"icon,omitempty"`
+	Color                 string          `json:"color,omitempty"` // notification color in #RRGGBB format
+	Sound                 string          `json:"sound,omitempty"`
+	Tag                   string          `json:"tag,omitempty"`
+	ClickAction           string          `json:"click_action,omitempty"`
+	BodyLocKey            string          `json:"body_loc_key,omitempty"`
+	BodyLocArgs           []string        `json:"body_loc_args,omitempty"`
+	TitleLocKey           string          `json:"title_loc_key,omitempty"`
+	TitleLocArgs          []string        `json:"title_loc_args,omitempty"`
+	ChannelID             string          `json:"channel_id,omitempty"`
+	ImageURL              string          `json:"image,omitempty"`
+	Ticker                string          `json:"ticker,omitempty"`
+	VibrateTimings        []time.Duration `json:"-"`
+	DefaultVibrateTimings bool            `json:"default_vibrate_timings,omitempty"`
+	DefaultLightSettings  bool            `json:"default_light_settings,omitempty"`
+}
+
+// MarshalJSON marshals an AndroidNotification into JSON (for internal use only).
+func (a *AndroidNotification) MarshalJSON() ([]byte, error) {
+	var timings []string
+	for _, t := range a.VibrateTimings {
+		timings = append(timings, durationToString(t))
+	}
+
+	type androidInternal AndroidNotification
+	temp := &struct {
+		VibrateTimings []string `json:"vibrate_timings,omitempty"`
+		*androidInternal
+	}{
+		VibrateTimings:  timings,
+		androidInternal: (*androidInternal)(a),
+	}
+	return json.Marshal(temp)
+}
+
+// UnmarshalJSON unmarshals a JSON string into an AndroidNotification (for internal use only).
+func (a *AndroidNotification) UnmarshalJSON(b []byte) error {
+	type androidInternal AndroidNotification
+	temp := struct {
+		VibrateTimings []string `json:"vibrate_timings,omitempty"`
+		*androidInternal
+	}{
+		androidInternal: (*androidInternal)(a),
+	}
+	if err := json.Unmarshal(b, &temp); err != nil {
+		return err
+	}
+	if len(temp.VibrateTimings) > 0 {
+		timings := make([]time.Duration, len(temp.VibrateTimings))
+		for i, t := range temp.VibrateTimings {
+			d, err := stringToDuration(t)
+			if err != nil {
+				return fmt.Errorf("invalid vibrate timing: %v", err)
+			}
+			timings[i] = d
+		}
+		a.VibrateTimings = timings
+	}
+	return nil
 }
 
 // AndroidFCMOptions contains additional options for features provided by the FCM Android SDK.
@@ -254,8 +466,10 @@ type AndroidFCMOptions struct {
 
 // WebpushConfig contains messaging options specific to the WebPush protocol.
 //
-// See https://tools.ietf.org/html/rfc8030#section-5 for additional details, and supported
-// headers.
+// Headers are sent to the push service as WebPush headers, such as Urgency (e.g. "high" for
+// time-sensitive alerts), TTL (in seconds) and Topic. Data, if specified, overrides the Data field
+// on the Message type for WebPush. See https://tools.ietf.org/html/rfc8030#section-5 for additional
+// details, and supported headers.
 type WebpushConfig struct {
 	Headers      map[string]string    `json:"headers,omitempty"`
 	Data         map[string]string    `json:"data,omitempty"`
@@ -375,10 +589,150 @@ type WebpushFcmOptions struct {
 //
 // See https://developer.apple.com/library/content/documentation/NetworkingInternet/Conceptual/RemoteNotificationsPG/CommunicatingwithAPNs.html
 // for more details on supported headers and payload keys.
+//
+// If TTL is set, the apns-expiration header is computed from it at the time the message is serialized. A TTL of
+// zero results in an apns-expiration of 0, which instructs APNS not to store the notification.
+//
+// If Payload contains an Aps dictionary, the apns-push-type header is also set automatically: to "background" when
+// the Aps dictionary requests a content-available (silent) notification without an alert, and to "alert"
+// otherwise. When LiveActivityToken is set, the header is set to "liveactivity" instead.
+//
+// LiveActivityToken is the push token of an iOS Live Activity, obtained from ActivityKit on the device. Set it,
+// along with the Event, ContentState and Timestamp fields of the Aps dictionary, to update or end a Live Activity
+// through FCM. The Message must still target the FCM registration token of the same device.
+//
+// Headers set explicitly by the caller always take precedence over computed ones. For example, if Headers
+// already contains apns-expiration, TTL is ignored.
 type APNSConfig struct {
-	Headers    map[string]string `json:"headers,omitempty"`
-	Payload    *APNSPayload      `json:"payload,omitempty"`
-	FCMOptions *APNSFCMOptions   `json:"fcm_options,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Payload           *APNSPayload      `json:"payload,omitempty"`
+	FCMOptions        *APNSFCMOptions   `json:"fcm_options,omitempty"`
+	LiveActivityToken string            `json:"live_activity_token,omitempty"`
+	TTL               *time.Duration    `json:"-"`
+}
+
+const (
+	apnsCollapseIDHeader = "apns-collapse-id"
+	apnsExpirationHeader = "apns-expiration"
+	apnsPushTypeHeader   = "apns-push-type"
+	webpushTopicHeader   = "Topic"
+)
+
+var apnsClock internal.Clock = internal.SystemClock
+
+// MarshalJSON marshals an APNSConfig into JSON (for internal use only).
+func (a *APNSConfig) MarshalJSON() ([]byte, error) {
+	type apnsInternal APNSConfig
+	temp := *(*apnsInternal)(a)
+	temp.Headers = a.computeHeaders(false)
+	return json.Marshal(&temp)
+}
+
+// computeHeaders returns the headers of the APNSConfig along with any computed headers not explicitly set by the
+// caller. hasNotification indicates that the enclosing Message specifies a Notification, which FCM delivers to
+// APNS as an alert. The caller's Headers map is never modified.
+func (a *APNSConfig) computeHeaders(hasNotification bool) map[string]string {
+	computed := make(map[string]string)
+	if a.TTL != nil && !hasHeader(a.Headers, apnsExpirationHeader) {
+		computed[apnsExpirationHeader] = apnsExpiration(*a.TTL)
+	}
+	if pushType := a.pushType(hasNotification); pushType != "" && !hasHeader(a.Headers, apnsPushTypeHeader) {
+		computed[apnsPushTypeHeader] = pushType
+	}
+	if len(computed) == 0 {
+		return a.Headers
+	}
+
+	for k, v := range a.Headers {
+		computed[k] = v
+	}
+	return computed
+}
+
+func (a *APNSConfig) pushType(hasNotification bool) string {
+	if a.LiveActivityToken != "" {
+		return "liveactivity"
+	}
+	if a.Payload == nil || a.Payload.Aps == nil {
+		return ""
+	}
+	aps := a.Payload.Aps
+	if aps.ContentAvailable && aps.Alert == nil && aps.AlertString == "" && !hasNotification {
+		return "background"
+	}
+	return "alert"
+}
+
+func (a *APNSConfig) hasMutableContent() bool {
+	return a != nil && a.Payload != nil && a.Payload.Aps != nil && a.Payload.Aps.MutableContent
+}
+
+func (a *APNSConfig) hasImageURL() bool {
+	return a != nil && a.FCMOptions != nil && a.FCMOptions.ImageURL != ""
+}
+
+// withImageURL returns a copy of the APNSConfig with the ImageURL of its FCMOptions set, creating the FCMOptions
+// if needed. It may be called on a nil APNSConfig.
+func (a *APNSConfig) withImageURL(imageURL string) *APNSConfig {
+	var result APNSConfig
+	if a != nil {
+		result = *a
+	}
+	var fcmOptions APNSFCMOptions
+	if result.FCMOptions != nil {
+		fcmOptions = *result.FCMOptions
+	}
+	fcmOptions.ImageURL = imageURL
+	result.FCMOptions = &fcmOptions
+	return &result
+}
+
+// withMutableContent returns a copy of the APNSConfig with mutable-content set, creating the payload and the aps
+// dictionary if needed. It may be called on a nil APNSConfig.
+func (a *APNSConfig) withMutableContent() *APNSConfig {
+	var result APNSConfig
+	if a != nil {
+		result = *a
+	}
+	var payload APNSPayload
+	if result.Payload != nil {
+		payload = *result.Payload
+	}
+	var aps Aps
+	if payload.Aps != nil {
+		aps = *payload.Aps
+	}
+	aps.MutableContent = true
+	payload.Aps = &aps
+	result.Payload = &payload
+	return &result
+}
+
+func apnsExpiration(ttl time.Duration) string {
+	if ttl == 0 {
+		return "0"
+	}
+	return strconv.FormatInt(apnsClock.Now().Add(ttl).Unix(), 10)
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	for k := range headers {
+		if strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withHeader returns a copy of the given headers with the given header added. The original map is never
+// modified.
+func withHeader(headers map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		result[k] = v
+	}
+	result[name] = value
+	return result
 }
 
 // APNSPayload is the payload that can be included in an APNS message.
@@ -431,17 +785,41 @@ func (p *APNSPayload) UnmarshalJSON(b []byte) error {
 //
 // Alert may be specified as a string (via the AlertString field), or as a struct (via the Alert
 // field).
+//
+// Badge sets the absolute number displayed on the app icon. A nil Badge leaves the current badge
+// unchanged, while a pointer to 0 removes the badge (see ClearBadge). APNs has no notion of relative
+// badge updates, so incrementing or decrementing the badge requires the app to track the count.
+//
+// InterruptionLevel, if specified, must be one of "passive", "active", "time-sensitive" or "critical". A
+// time-sensitive notification may be delivered immediately even when a Focus mode is active on the device.
+//
+// Event, ContentState, Timestamp and DismissalDate are used to start, update and end iOS Live Activities (see
+// APNSConfig.LiveActivityToken). Event, if specified, must be one of "start", "update" or "end". Timestamp and
+// DismissalDate are in seconds since the epoch.
 type Aps struct {
-	AlertString      string                 `json:"-"`
-	Alert            *ApsAlert              `json:"-"`
-	Badge            *int                   `json:"badge,omitempty"`
-	Sound            string                 `json:"-"`
-	CriticalSound    *CriticalSound         `json:"-"`
-	ContentAvailable bool                   `json:"-"`
-	MutableContent   bool                   `json:"-"`
-	Category         string                 `json:"category,omitempty"`
-	ThreadID         string                 `json:"thread-id,omitempty"`
-	CustomData       map[string]interface{} `json:"-"`
+	AlertString       string                 `json:"-"`
+	Alert             *ApsAlert              `json:"-"`
+	Badge             *int                   `json:"badge,omitempty"`
+	Sound             string                 `json:"-"`
+	CriticalSound     *CriticalSound         `json:"-"`
+	ContentAvailable  bool                   `json:"-"`
+	MutableContent    bool                   `json:"-"`
+	Category          string                 `json:"category,omitempty"`
+	ThreadID          string                 `json:"thread-id,omitempty"`
+	TargetContentID   string                 `json:"target-content-id,omitempty"`
+	InterruptionLevel string                 `json:"interruption-level,omitempty"`
+	Event             string                 `json:"event,omitempty"`
+	ContentState      map[string]interface{} `json:"content-state,omitempty"`
+	Timestamp         int64                  `json:"timestamp,omitempty"`
+	DismissalDate     int64                  `json:"dismissal-date,omitempty"`
+	CustomData        map[string]interface{} `json:"-"`
+}
+
+// ClearBadge sets the Badge of the Aps to 0, which removes the badge from the app icon when the
+// notification is delivered.
+func (a *Aps) ClearBadge() {
+	zero := 0
+	a.Badge = &zero
 }
 
 // standardFields creates a map containing all the fields except the custom data.
@@ -472,6 +850,24 @@ func (a *Aps) standardFields() map[string]interface{} {
 	if a.ThreadID != "" {
 		m["thread-id"] = a.ThreadID
 	}
+	if a.TargetContentID != "" {
+		m["target-content-id"] = a.TargetContentID
+	}
+	if a.InterruptionLevel != "" {
+		m["interruption-level"] = a.InterruptionLevel
+	}
+	if a.Event != "" {
+		m["event"] = a.Event
+	}
+	if a.ContentState != nil {
+		m["content-state"] = a.ContentState
+	}
+	if a.Timestamp != 0 {
+		m["timestamp"] = a.Timestamp
+	}
+	if a.DismissalDate != 0 {
+		m["dismissal-date"] = a.DismissalDate
+	}
 	return m
 }
 
@@ -532,6 +928,9 @@ func (a *Aps) UnmarshalJSON(b []byte) error {
 }
 
 // CriticalSound is the sound payload that can be included in an Aps.
+//
+// A CriticalSound that only specifies a Name is serialized as a plain sound name string, which is
+// understood by all iOS versions. The sound dictionary is only sent when Critical or Volume is set.
 type CriticalSound struct {
 	Critical bool    `json:"-"`
 	Name     string  `json:"name,omitempty"`
@@ -540,6 +939,10 @@ type CriticalSound struct {
 
 // MarshalJSON marshals a CriticalSound into JSON (for internal use only).
 func (cs *CriticalSound) MarshalJSON() ([]byte, error) {
+	if !cs.Critical && cs.Volume == 0 && cs.Name != "" {
+		return json.Marshal(cs.Name)
+	}
+
 	type criticalSoundInternal CriticalSound
 	temp := struct {
 		CriticalInt int `json:"critical,omitempty"`
@@ -587,6 +990,193 @@ type ApsAlert struct {
 	LaunchImage     string   `json:"launch-image,omitempty"`
 }
 
+// Localization specifies localization keys and arguments for the title and body of a notification.
+//
+// Android and APNS expose the same localization concepts under different field names. Apply populates both
+// platform configurations of a Message from a single Localization, so that the keys and arguments sent to each
+// platform cannot drift apart.
+type Localization struct {
+	TitleLocKey  string
+	TitleLocArgs []string
+	BodyLocKey   string
+	BodyLocArgs  []string
+}
+
+// Apply sets the localization keys and arguments on the AndroidNotification and the ApsAlert of the given
+// Message.
+//
+// Any missing AndroidConfig, AndroidNotification, APNSConfig, APNSPayload, Aps or ApsAlert values are created as
+// needed. Other fields of the Message are left untouched, except that an Aps.AlertString is converted into the
+// body of an equivalent ApsAlert.
+func (l *Localization) Apply(m *Message) {
+	if m.Android == nil {
+		m.Android = &AndroidConfig{}
+	}
+	if m.Android.Notification == nil {
+		m.Android.Notification = &AndroidNotification{}
+	}
+	an := m.Android.Notification
+	an.TitleLocKey = l.TitleLocKey
+	an.TitleLocArgs = copyStrings(l.TitleLocArgs)
+	an.BodyLocKey = l.BodyLocKey
+	an.BodyLocArgs = copyStrings(l.BodyLocArgs)
+
+	if m.APNS == nil {
+		m.APNS = &APNSConfig{}
+	}
+	if m.APNS.Payload == nil {
+		m.APNS.Payload = &APNSPayload{}
+	}
+	if m.APNS.Payload.Aps == nil {
+		m.APNS.Payload.Aps = &Aps{}
+	}
+	aps := m.APNS.Payload.Aps
+	if aps.Alert == nil {
+		aps.Alert = &ApsAlert{Body: aps.AlertString}
+		aps.AlertString = ""
+	}
+	aps.Alert.TitleLocKey = l.TitleLocKey
+	aps.Alert.TitleLocArgs = copyStrings(l.TitleLocArgs)
+	aps.Alert.LocKey = l.BodyLocKey
+	aps.Alert.LocArgs = copyStrings(l.BodyLocArgs)
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
+
+const apnsPriorityHeader = "apns-priority"
+
+// HighPriorityDataMessage returns a copy of the given Message, configured for the delivery of a data-only
+// message that wakes up the receiving app in the background.
+//
+// The returned Message sets the Android priority to "high", and marks the APNS payload as content-available
+// with the apns-push-type header set to "background" and the apns-priority header set to "5", as required by
+// APNS for background notifications. The Data, target and any other settings of the given Message are
+// preserved. The given Message is not modified.
+//
+// An error is returned if the given Message specifies any notification fields (on the Message itself, or on
+// the Android, Webpush or APNS configurations), or APNS headers that contradict a background delivery.
+func HighPriorityDataMessage(m *Message) (*Message, error) {
+	if m == nil {
+		return nil, fmt.Errorf("message must not be nil")
+	}
+	if m.Notification != nil {
+		return nil, fmt.Errorf("data-only message must not specify a notification")
+	}
+	if m.Android != nil && m.Android.Notification != nil {
+		return nil, fmt.Errorf("data-only message must not specify an android notification")
+	}
+	if m.Webpush != nil && m.Webpush.Notification != nil {
+		return nil, fmt.Errorf("data-only message must not specify a webpush notification")
+	}
+
+	result := *m
+	android := AndroidConfig{}
+	if m.Android != nil {
+		android = *m.Android
+	}
+	android.Priority = "high"
+	result.Android = &android
+
+	apns := APNSConfig{}
+	if m.APNS != nil {
+		apns = *m.APNS
+	}
+	headers := map[string]string{
+		apnsPushTypeHeader: "background",
+		apnsPriorityHeader: "5",
+	}
+	for k, v := range apns.Headers {
+		for name, want := range headers {
+			if strings.EqualFold(k, name) && v != want {
+				return nil, fmt.Errorf("data-only message must not specify %s header other than %q", name, want)
+			}
+		}
+		if !hasHeader(headers, k) {
+			headers[k] = v
+		}
+	}
+	apns.Headers = headers
+
+	payload := APNSPayload{}
+	if apns.Payload != nil {
+		payload = *apns.Payload
+	}
+	aps := Aps{}
+	if payload.Aps != nil {
+		aps = *payload.Aps
+	}
+	if aps.Alert != nil || aps.AlertString != "" || aps.Sound != "" || aps.CriticalSound != nil || aps.Badge != nil {
+		return nil, fmt.Errorf("data-only message must not specify an alert, sound or badge in the aps dictionary")
+	}
+	aps.ContentAvailable = true
+	payload.Aps = &aps
+	apns.Payload = &payload
+	result.APNS = &apns
+	return &result, nil
+}
+
+// APNSNotification is a simple notification for iOS devices, from which NewAPNSConfig builds an APNSConfig.
+//
+// Badge, if not nil, sets the badge of the app icon, and 0 removes it. Sound is the name of a sound file in the
+// app bundle, or "default" for the system sound. ContentAvailable wakes up the app in the background when the
+// notification is delivered.
+type APNSNotification struct {
+	Title            string
+	Body             string
+	Badge            *int
+	Sound            string
+	ContentAvailable bool
+}
+
+// NewAPNSConfig returns an APNSConfig that delivers the given notification.
+//
+// The returned APNSConfig carries the alert, badge and sound in its aps dictionary, and sets the apns-push-type
+// and apns-priority headers accordingly: "alert" and "10" when the notification is shown to the user, and
+// "background" and "5" for a content-available notification without an alert, badge or sound. It can be
+// customized further before it is sent. Use APNSConfig directly for anything not covered by APNSNotification.
+//
+// An error is returned if the notification specifies none of a title, body, badge, sound or content-available.
+func NewAPNSConfig(n *APNSNotification) (*APNSConfig, error) {
+	if n == nil {
+		return nil, fmt.Errorf("apns notification must not be nil")
+	}
+
+	aps := &Aps{
+		Sound:            n.Sound,
+		ContentAvailable: n.ContentAvailable,
+	}
+	if n.Title != "" || n.Body != "" {
+		aps.Alert = &ApsAlert{Title: n.Title, Body: n.Body}
+	}
+	if n.Badge != nil {
+		badge := *n.Badge
+		aps.Badge = &badge
+	}
+
+	headers := map[string]string{
+		apnsPushTypeHeader: "alert",
+		apnsPriorityHeader: "10",
+	}
+	if aps.Alert == nil && aps.Badge == nil && aps.Sound == "" {
+		if !aps.ContentAvailable {
+			return nil, fmt.Errorf(
+				"apns notification must specify at least one of title, body, badge, sound or content-available")
+		}
+		headers[apnsPushTypeHeader] = "background"
+		headers[apnsPriorityHeader] = "5"
+	}
+
+	return &APNSConfig{
+		Headers: headers,
+		Payload: &APNSPayload{Aps: aps},
+	}, nil
+}
+
 // APNSFCMOptions contains additional options for features provided by the FCM Aps SDK.
 type APNSFCMOptions struct {
 	AnalyticsLabel string `json:"analytics_label,omitempty"`
@@ -605,11 +1195,30 @@ type ErrorInfo struct {
 }
 
 // Client is the interface for the Firebase Cloud Messaging (FCM) service.
+//
+// A Client is safe for concurrent use by multiple goroutines.
 type Client struct {
 	*fcmClient
 	*iidClient
 }
 
+// Close releases the idle HTTP connections held by the Client.
+//
+// The Client does not run any background goroutines, so there is nothing else to release. Idle
+// connections are only closed when the underlying transport supports it, as *http.Transport does,
+// including transports supplied via option.WithHTTPClient. Transports created by the SDK itself
+// close idle connections on their own after a timeout. The Client remains usable after Close, and
+// opens new connections as needed. Close always returns nil.
+func (c *Client) Close() error {
+	if c.fcmClient != nil {
+		c.fcmClient.httpClient.CloseIdleConnections()
+	}
+	if c.iidClient != nil {
+		c.iidClient.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
+
 // NewClient creates a new instance of the Firebase Cloud Messaging Client.
 //
 // This function can only be invoked from within the SDK. Client applications should access the
@@ -626,7 +1235,7 @@ func NewClient(ctx context.Context, c *internal.MessagingConfig) (*Client, error
 
 	return &Client{
 		fcmClient: newFCMClient(hc, c),
-		iidClient: newIIDClient(hc),
+		iidClient: newIIDClient(hc, c),
 	}, nil
 }
 
@@ -642,6 +1251,8 @@ func newFCMClient(hc *http.Client, conf *internal.MessagingConfig) *fcmClient {
 	client := internal.WithDefaultRetryConfig(hc)
 	client.CreateErrFn = handleFCMError
 	client.SuccessFn = internal.HasSuccessStatus
+	client.Logger = conf.Logger
+	client.RetryObserver = conf.RetryObserver
 
 	version := fmt.Sprintf("fire-admin-go/%s", conf.Version)
 	client.Opts = []internal.HTTPOption{
@@ -658,16 +1269,216 @@ func newFCMClient(hc *http.Client, conf *internal.MessagingConfig) *fcmClient {
 	}
 }
 
+// ProjectID returns the ID of the Firebase project the Client sends messages through.
+//
+// Logging this value at startup helps catching mismatches between the configured project and the
+// credentials used to authorize the requests.
+func (c *fcmClient) ProjectID() string {
+	return c.project
+}
+
+// SendURL returns the URL of the FCM endpoint the Client sends messages to.
+func (c *fcmClient) SendURL() string {
+	return fmt.Sprintf("%s/projects/%s/messages:send", c.fcmEndpoint, c.project)
+}
+
 // Send sends a Message to Firebase Cloud Messaging.
 //
-// The Message must specify exactly one of Token, Topic and Condition fields. FCM will
+// The Message must specify exactly one of Token, Tokens, Topic and Condition fields. FCM will
 // customize the message for each target platform based on the arguments specified in the
-// Message.
+// Message. If Tokens is specified, it must contain exactly one token; use SendToTokens to send
+// a Message to more than one token.
+//
+// Send returns the full resource name of the sent message, in the format
+// projects/{project_id}/messages/{message_id}. Use ShortMessageID to extract the message ID
+// portion from it.
 func (c *fcmClient) Send(ctx context.Context, message *Message) (string, error) {
 	payload := &fcmRequest{
 		Message: message,
 	}
-	return c.makeSendRequest(ctx, payload)
+	return c.makeSendRequest(ctx, payload, c.httpClient, nil)
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a copy of ctx that carries the given request metadata, merged with any metadata already
+// present in ctx.
+//
+// Metadata is never sent to FCM. It is meant for correlating requests in the caller's own systems: the context
+// passed to Send, SendAll and the other messaging functions is attached to every outgoing HTTP request, so a
+// custom transport (see option.WithHTTPClient) can read the metadata back with MetadataFromContext and include it
+// in logs or tracing spans.
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range MetadataFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, metadataKey{}, merged)
+}
+
+// MetadataFromContext returns the request metadata attached to ctx by WithMetadata, or nil if there is none.
+//
+// The returned map must not be modified.
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return md
+}
+
+// SendOptions specifies how messages should be sent by the SendWithOptions, SendAllWithOptions and
+// SendMulticastWithOptions functions.
+//
+// FCM does not support idempotency keys. Therefore the SDK cannot detect whether a request that
+// timed out was delivered by the backend servers or not. Retrying such a request provides
+// at-least-once delivery semantics, but may result in the same message getting delivered to the
+// target devices more than once. Not retrying it provides at-most-once delivery semantics, but may
+// result in the message not getting delivered at all. Developers should choose the behavior most
+// appropriate for each type of message they send.
+type SendOptions struct {
+	// AllowRetryOnTimeout enables retrying requests that failed due to a network timeout (i.e.
+	// at-least-once delivery). When false, timed out requests are never retried (i.e. at-most-once
+	// delivery). Requests that fail with a retryable HTTP error response are retried regardless
+	// of this setting.
+	AllowRetryOnTimeout bool
+
+	// AnalyticsLabel is used as the FCMOptions.AnalyticsLabel of every message sent, so that all of
+	// them are attributed to the same campaign in the BigQuery export. Messages that specify their
+	// own analytics label keep it. The label must consist of 1 to 50 characters from
+	// [a-zA-Z0-9-_.~%]. The messages passed in are never modified.
+	AnalyticsLabel string
+
+	// SeparateDroppableTokens makes SendAllWithOptions and SendMulticastWithOptions report messages that
+	// failed because their registration token is no longer registered separately from other failures.
+	// Such messages are counted in BatchResponse.DroppedCount instead of FailureCount, and their tokens are
+	// listed in BatchResponse.DroppableTokens. They usually call for removing the token rather than for
+	// retrying or alerting. The individual SendResponse still carries the error. Invalid argument errors
+	// are not treated as droppable, since FCM also reports them for malformed payloads. Messages sent to
+	// topics or conditions are never dropped. SendWithOptions ignores this setting.
+	SeparateDroppableTokens bool
+
+	// RateLimiter paces the messages sent, so that a large campaign stays under the FCM quota instead of
+	// failing with quota exceeded errors. A single RateLimiter is typically shared by all the sends of a
+	// campaign, including concurrent ones. SendAllWithOptions and SendMulticastWithOptions count every
+	// message in the batch against the limit. When nil, messages are sent without delay.
+	RateLimiter *RateLimiter
+}
+
+func (opts *SendOptions) rateLimiter() *RateLimiter {
+	if opts == nil {
+		return nil
+	}
+	return opts.RateLimiter
+}
+
+// RateLimiter limits the rate at which messages are sent, using a token bucket.
+//
+// Messages can be sent in bursts of up to the specified size, after which the sends are delayed so that
+// the long term rate does not exceed the specified number of messages per second. While waiting, the
+// sends respect the cancellation of their context. A RateLimiter is safe for concurrent use.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter that allows sending messagesPerSecond messages per second on
+// average, with bursts of up to burst messages. The burst should be at least as large as the batches
+// sent, since a batch larger than the burst always has to wait for the missing messages.
+func NewRateLimiter(messagesPerSecond float64, burst int) (*RateLimiter, error) {
+	if messagesPerSecond <= 0 || math.IsInf(messagesPerSecond, 0) || math.IsNaN(messagesPerSecond) {
+		return nil, fmt.Errorf("messages per second must be a positive number; got %v", messagesPerSecond)
+	}
+	if burst <= 0 {
+		return nil, fmt.Errorf("burst must be a positive integer; got %d", burst)
+	}
+	return &RateLimiter{
+		rate:   messagesPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}, nil
+}
+
+// wait blocks until n messages can be sent, or until ctx is done. A nil RateLimiter never blocks.
+func (l *RateLimiter) wait(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give back the messages that were not sent, so that they do not delay other sends.
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// applyTo returns the given messages with the options applied. The returned slice shares the
+// messages that need no changes with the input.
+func (opts *SendOptions) applyTo(messages []*Message) ([]*Message, error) {
+	if opts == nil || opts.AnalyticsLabel == "" {
+		return messages, nil
+	}
+	if !analyticsLabelPattern.MatchString(opts.AnalyticsLabel) {
+		return nil, fmt.Errorf(
+			"analytics label must consist of 1 to 50 characters from [a-zA-Z0-9-_.~%%]; got %q", opts.AnalyticsLabel)
+	}
+
+	result := make([]*Message, len(messages))
+	for i, m := range messages {
+		result[i] = m
+		if m == nil || (m.FCMOptions != nil && m.FCMOptions.AnalyticsLabel != "") {
+			continue
+		}
+		var fcmOptions FCMOptions
+		if m.FCMOptions != nil {
+			fcmOptions = *m.FCMOptions
+		}
+		fcmOptions.AnalyticsLabel = opts.AnalyticsLabel
+		temp := *m
+		temp.FCMOptions = &fcmOptions
+		result[i] = &temp
+	}
+	return result, nil
+}
+
+// SendWithOptions sends a Message to Firebase Cloud Messaging using the given options.
+//
+// SendWithOptions behaves similar to Send, but allows customizing how ambiguous failures such as
+// network timeouts are retried. See SendOptions for details. Passing nil options is equivalent to
+// passing an empty SendOptions, which disables retries on timeouts. The Send function always
+// retries timed out requests.
+func (c *fcmClient) SendWithOptions(ctx context.Context, message *Message, opts *SendOptions) (string, error) {
+	messages, err := opts.applyTo([]*Message{message})
+	if err != nil {
+		return "", err
+	}
+	payload := &fcmRequest{
+		Message: messages[0],
+	}
+	return c.makeSendRequest(ctx, payload, c.httpClientWithOptions(opts), opts.rateLimiter())
 }
 
 // SendDryRun sends a Message to Firebase Cloud Messaging in the dry run (validation only) mode.
@@ -679,22 +1490,83 @@ func (c *fcmClient) SendDryRun(ctx context.Context, message *Message) (string, e
 		ValidateOnly: true,
 		Message:      message,
 	}
-	return c.makeSendRequest(ctx, payload)
+	return c.makeSendRequest(ctx, payload, c.httpClient, nil)
+}
+
+// credentialCheckTopic is the topic targeted by the dry run message sent from ValidateCredentials.
+const credentialCheckTopic = "firebase-admin-credential-check"
+
+// ValidateCredentials checks that the Client is able to make authorized calls to Firebase Cloud
+// Messaging.
+//
+// It sends a data-only message to a throwaway topic in the dry run mode, so nothing is ever
+// delivered to any device. This makes it suitable as a fail-fast check at application startup.
+// If the credentials are rejected, or the service account lacks the permission to send messages
+// in the project, the returned error says so, while preserving the error code of the underlying
+// failure (e.g. IsMismatchedCredential still reports true).
+func (c *fcmClient) ValidateCredentials(ctx context.Context) error {
+	message := &Message{
+		Data:  map[string]string{"check": "credentials"},
+		Topic: credentialCheckTopic,
+	}
+	_, err := c.SendDryRun(ctx, message)
+	if err == nil {
+		return nil
+	}
+
+	fe, ok := err.(*internal.FirebaseError)
+	if !ok {
+		return fmt.Errorf("failed to validate messaging credentials for project %q: %v", c.project, err)
+	}
+	if fe.Code == mismatchedCredential || fe.Code == invalidAPNSCredentials {
+		return internal.Errorf(
+			fe.Code,
+			"credentials are not authorized to send messages in project %q; make sure the service "+
+				"account has the cloudmessaging.messages.create permission: %s",
+			c.project, fe.String)
+	}
+	return internal.Errorf(fe.Code, "failed to validate messaging credentials for project %q: %s", c.project, fe.String)
 }
 
-func (c *fcmClient) makeSendRequest(ctx context.Context, req *fcmRequest) (string, error) {
+func (c *fcmClient) httpClientWithOptions(opts *SendOptions) *internal.HTTPClient {
+	if (opts != nil && opts.AllowRetryOnTimeout) || c.httpClient.RetryConfig == nil {
+		return c.httpClient
+	}
+
+	rc := *c.httpClient.RetryConfig
+	checkForRetry := rc.CheckForRetry
+	rc.CheckForRetry = func(resp *http.Response, networkErr error) bool {
+		if ne, ok := networkErr.(net.Error); ok && ne.Timeout() {
+			return false
+		}
+		if checkForRetry == nil {
+			return networkErr != nil || resp.StatusCode >= 500
+		}
+		return checkForRetry(resp, networkErr)
+	}
+
+	hc := *c.httpClient
+	hc.RetryConfig = &rc
+	return &hc
+}
+
+func (c *fcmClient) makeSendRequest(
+	ctx context.Context, req *fcmRequest, hc *internal.HTTPClient, limiter *RateLimiter) (string, error) {
 	if err := validateMessage(req.Message); err != nil {
 		return "", err
 	}
+	if err := limiter.wait(ctx, 1); err != nil {
+		return "", err
+	}
 
 	request := &internal.Request{
 		Method: http.MethodPost,
-		URL:    fmt.Sprintf("%s/projects/%s/messages:send", c.fcmEndpoint, c.project),
+		URL:    c.SendURL(),
 		Body:   internal.NewJSONEntity(req),
 	}
 
 	var result fcmResponse
-	_, err := c.httpClient.DoAndUnmarshal(ctx, request, &result)
+	_, err := hc.DoAndUnmarshal(ctx, request, &result)
 	return result.Name, err
 }
 
@@ -748,6 +1620,19 @@ func IsUnknown(err error) bool {
 	return internal.HasErrorCode(err, unknownError)
 }
 
+// ShortMessageID extracts the message ID portion from the given FCM message name.
+//
+// FCM identifies sent messages by resource names of the form
+// projects/{project_id}/messages/{message_id}. ShortMessageID returns the trailing message ID
+// segment of such names. Names that do not contain any path segments are returned as is.
+func ShortMessageID(name string) string {
+	name = strings.TrimRight(strings.TrimSpace(name), "/")
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		return name[idx+1:]
+	}
+	return name
+}
+
 type fcmRequest struct {
 	ValidateOnly bool     `json:"validate_only,omitempty"`
 	Message      *Message `json:"message,omitempty"`
@@ -770,7 +1655,9 @@ type fcmError struct {
 
 func handleFCMError(resp *internal.Response) error {
 	var fe fcmError
-	json.Unmarshal(resp.Body, &fe) // ignore any json parse errors at this level
+	if err := json.Unmarshal(resp.Body, &fe); err != nil {
+		return handleNonJSONError(resp)
+	}
 	var serverCode string
 	for _, d := range fe.Error.Details {
 		if d.Type == "type.googleapis.com/google.firebase.fcm.v1.FcmError" {
@@ -795,3 +1682,39 @@ func handleFCMError(resp *internal.Response) error {
 	}
 	return internal.Errorf(clientCode, "http error status: %d; reason: %s", resp.Status, msg)
 }
+
+// maxErrorSnippetLength is the maximum number of bytes of a non-JSON error response included in an error.
+const maxErrorSnippetLength = 256
+
+// handleNonJSONError creates an error from a response whose body is not JSON, such as the HTML error page
+// served by a proxy or load balancer in front of the backend. The error code is inferred from the HTTP
+// status, and only a short snippet of the body is included in the error message.
+func handleNonJSONError(resp *internal.Response) error {
+	msg := "server responded with a non-JSON error"
+	clientCode := unknownError
+	switch resp.Status {
+	case http.StatusInternalServerError:
+		clientCode = internalError
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		clientCode = serverUnavailable
+	}
+	if clientCode != unknownError {
+		msg += "; code: " + clientCode
+	}
+	msg += "; response: " + errorSnippet(resp.Body)
+	return internal.Errorf(clientCode, "http error status: %d; reason: %s", resp.Status, msg)
+}
+
+// errorSnippet returns the given response body with all whitespace sequences collapsed into single spaces,
+// truncated to at most maxErrorSnippetLength bytes without splitting a UTF-8 sequence.
+func errorSnippet(body []byte) string {
+	s := strings.Join(strings.Fields(string(body)), " ")
+	if len(s) <= maxErrorSnippetLength {
+		return s
+	}
+	end := maxErrorSnippetLength
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "..."
+}
@@ -27,6 +27,7 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"time"
 
 	"firebase.google.com/go/internal"
 )
@@ -74,17 +75,94 @@ func (mm *MulticastMessage) toMessages() ([]*Message, error) {
 
 // SendResponse represents the status of an individual message that was sent as part of a batch
 // request.
+//
+// MessageID contains the full resource name of the sent message, in the same format as the value
+// returned by the `Send()` function (projects/{project_id}/messages/{message_id}).
+//
+// Failures due to quota limits on the message target (e.g. sending too many messages to the same device or
+// topic) can be detected with IsMessageRateExceeded, while failures due to the FCM backend being overloaded
+// or temporarily unavailable can be detected with IsServerUnavailable. When the backend suggests a delay
+// before retrying a failed message, it is made available in RetryAfter.
 type SendResponse struct {
-	Success   bool
-	MessageID string
-	Error     error
+	Success    bool
+	MessageID  string
+	Error      error
+	RetryAfter time.Duration
+}
+
+// ShortID returns the message ID portion of the MessageID field. Returns an empty string if the
+// message was not sent successfully.
+func (sr *SendResponse) ShortID() string {
+	return ShortMessageID(sr.MessageID)
 }
 
 // BatchResponse represents the response from the `SendAll()` and `SendMulticast()` APIs.
+//
+// DroppedCount and DroppableTokens are only populated when the batch is sent with the
+// SeparateDroppableTokens option (see SendOptions). Dropped messages are not included in
+// FailureCount.
 type BatchResponse struct {
-	SuccessCount int
-	FailureCount int
-	Responses    []*SendResponse
+	SuccessCount    int
+	FailureCount    int
+	DroppedCount    int
+	DroppableTokens []string
+	Responses       []*SendResponse
+}
+
+// separateDroppableTokens moves the failures caused by unregistered registration tokens from FailureCount
+// to DroppedCount. The messages must be the ones the BatchResponse was created for.
+//
+// Invalid argument errors are not considered, since FCM also reports them for malformed payloads, in
+// which case the token itself may well be valid.
+func (br *BatchResponse) separateDroppableTokens(messages []*Message) {
+	for i, r := range br.Responses {
+		if r.Success || i >= len(messages) {
+			continue
+		}
+		token := messages[i].registrationToken()
+		if token == "" || !IsRegistrationTokenNotRegistered(r.Error) {
+			continue
+		}
+		br.DroppableTokens = append(br.DroppableTokens, token)
+		br.DroppedCount++
+		br.FailureCount--
+	}
+}
+
+// RetryAfter returns the longest delay suggested by the backend for retrying any of the failed messages in
+// the batch, or 0 if no delay was suggested. It can be used to pace subsequent sends.
+func (br *BatchResponse) RetryAfter() time.Duration {
+	var max time.Duration
+	for _, r := range br.Responses {
+		if r.RetryAfter > max {
+			max = r.RetryAfter
+		}
+	}
+	return max
+}
+
+// FailedResponses returns the responses of the messages that could not be sent, in the order of the
+// input messages. Messages dropped due to the SeparateDroppableTokens option are included.
+func (br *BatchResponse) FailedResponses() []*SendResponse {
+	var failed []*SendResponse
+	for _, r := range br.Responses {
+		if !r.Success {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// FailedIndices returns the positions of the messages that could not be sent in the input of the batch
+// send, in ascending order. Like FailedResponses, this includes the dropped messages.
+func (br *BatchResponse) FailedIndices() []int {
+	var indices []int
+	for i, r := range br.Responses {
+		if !r.Success {
+			indices = append(indices, i)
+		}
+	}
+	return indices
 }
 
 // SendAll sends the messages in the given array via Firebase Cloud Messaging.
@@ -96,7 +174,31 @@ type BatchResponse struct {
 // SendAll indicates a total failure -- i.e. none of the messages in the array could be sent.
 // Partial failures are indicated by a `BatchResponse` return value.
 func (c *fcmClient) SendAll(ctx context.Context, messages []*Message) (*BatchResponse, error) {
-	return c.sendBatch(ctx, messages, false)
+	return c.sendBatch(ctx, messages, false, c.httpClient, nil)
+}
+
+// SendAllWithOptions sends the messages in the given array via Firebase Cloud Messaging using the
+// given options.
+//
+// SendAllWithOptions behaves similar to SendAll, but allows customizing how timed out requests are
+// retried, applying a shared analytics label to all the messages, and pacing the sends. See SendOptions
+// for details.
+// Passing nil options is equivalent to passing an empty SendOptions.
+func (c *fcmClient) SendAllWithOptions(
+	ctx context.Context, messages []*Message, opts *SendOptions) (*BatchResponse, error) {
+	messages, err := opts.applyTo(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	br, err := c.sendBatch(ctx, messages, false, c.httpClientWithOptions(opts), opts.rateLimiter())
+	if err != nil {
+		return nil, err
+	}
+	if opts != nil && opts.SeparateDroppableTokens {
+		br.separateDroppableTokens(messages)
+	}
+	return br, nil
 }
 
 // SendAllDryRun sends the messages in the given array via Firebase Cloud Messaging in the
@@ -112,7 +214,7 @@ func (c *fcmClient) SendAll(ctx context.Context, messages []*Message) (*BatchRes
 // SendAllDryRun indicates a total failure -- i.e. none of the messages in the array could be sent
 // for validation. Partial failures are indicated by a `BatchResponse` return value.
 func (c *fcmClient) SendAllDryRun(ctx context.Context, messages []*Message) (*BatchResponse, error) {
-	return c.sendBatch(ctx, messages, true)
+	return c.sendBatch(ctx, messages, true, c.httpClient, nil)
 }
 
 // SendMulticast sends the given multicast message to all the FCM registration tokens specified.
@@ -131,6 +233,21 @@ func (c *fcmClient) SendMulticast(ctx context.Context, message *MulticastMessage
 	return c.SendAll(ctx, messages)
 }
 
+// SendMulticastWithOptions sends the given multicast message to all the FCM registration tokens
+// specified using the given options.
+//
+// SendMulticastWithOptions behaves similar to SendMulticast, but uses the SendAllWithOptions function
+// to send the message. See SendOptions for details.
+func (c *fcmClient) SendMulticastWithOptions(
+	ctx context.Context, message *MulticastMessage, opts *SendOptions) (*BatchResponse, error) {
+	messages, err := toMessages(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendAllWithOptions(ctx, messages, opts)
+}
+
 // SendMulticastDryRun sends the given multicast message to all the specified FCM registration
 // tokens in the dry run (validation only) mode.
 //
@@ -151,6 +268,65 @@ func (c *fcmClient) SendMulticastDryRun(ctx context.Context, message *MulticastM
 	return c.SendAllDryRun(ctx, messages)
 }
 
+// SendToTokens sends the given Message to each of the FCM registration tokens specified in its
+// Tokens field.
+//
+// This is a shorthand for sending the same message to a handful of devices without building a
+// MulticastMessage. The Tokens field may contain up to 100 tokens, and the Token, Topic and
+// Condition fields must not be set. SendToTokens uses the `SendAll()` function to send the
+// message, and the responses list obtained from the return value corresponds to the order of the
+// input tokens. An error from SendToTokens indicates a total failure -- i.e. the message could not
+// be sent to any of the recipients. Partial failures are indicated by a `BatchResponse` return
+// value.
+func (c *fcmClient) SendToTokens(ctx context.Context, message *Message) (*BatchResponse, error) {
+	messages, err := fanOut(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendAll(ctx, messages)
+}
+
+// SendToTokensDryRun sends the given Message to each of the FCM registration tokens specified in
+// its Tokens field in the dry run (validation only) mode.
+//
+// This function does not actually deliver any messages to target devices. Instead, it performs all
+// the SDK-level and backend validations on the messages, and emulates the send operation.
+func (c *fcmClient) SendToTokensDryRun(ctx context.Context, message *Message) (*BatchResponse, error) {
+	messages, err := fanOut(message)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.SendAllDryRun(ctx, messages)
+}
+
+// fanOut returns a copy of the given Message for each of its Tokens, with the Token field set.
+func fanOut(message *Message) ([]*Message, error) {
+	if message == nil {
+		return nil, errors.New("message must not be nil")
+	}
+	if len(message.Tokens) == 0 {
+		return nil, errors.New("tokens must not be nil or empty")
+	}
+	if len(message.Tokens) > maxMessages {
+		return nil, fmt.Errorf("tokens must not contain more than %d elements", maxMessages)
+	}
+	if countNonEmpty(message.Token, message.Topic, message.Condition) != 0 {
+		return nil, errors.New("token, topic and condition must not be specified along with tokens")
+	}
+
+	var messages []*Message
+	for _, token := range message.Tokens {
+		temp := *message
+		temp.Token = token
+		temp.Tokens = nil
+		messages = append(messages, &temp)
+	}
+
+	return messages, nil
+}
+
 func toMessages(message *MulticastMessage) ([]*Message, error) {
 	if message == nil {
 		return nil, errors.New("message must not be nil")
@@ -160,8 +336,12 @@ func toMessages(message *MulticastMessage) ([]*Message, error) {
 }
 
 func (c *fcmClient) sendBatch(
-	ctx context.Context, messages []*Message, dryRun bool) (*BatchResponse, error) {
-
+	ctx context.Context,
+	messages []*Message,
+	dryRun bool,
+	hc *internal.HTTPClient,
+	limiter *RateLimiter,
+) (*BatchResponse, error) {
 	if len(messages) == 0 {
 		return nil, errors.New("messages must not be nil or empty")
 	}
@@ -174,8 +354,11 @@ func (c *fcmClient) sendBatch(
 	if err != nil {
 		return nil, err
 	}
+	if err := limiter.wait(ctx, len(messages)); err != nil {
+		return nil, err
+	}
 
-	resp, err := c.httpClient.Do(ctx, request)
+	resp, err := hc.Do(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -204,7 +387,7 @@ type multipartEntity struct {
 }
 
 func (c *fcmClient) newBatchRequest(messages []*Message, dryRun bool) (*internal.Request, error) {
-	url := fmt.Sprintf("%s/projects/%s/messages:send", c.fcmEndpoint, c.project)
+	url := c.SendURL()
 	headers := map[string]string{
 		apiFormatVersionHeader: apiFormatVersion,
 		firebaseClientHeader:   c.version,
@@ -290,10 +473,14 @@ func newSendResponse(part *multipart.Part) (*SendResponse, error) {
 			Header: hr.Header,
 			Body:   b,
 		}
-		return &SendResponse{
+		sr := &SendResponse{
 			Success: false,
 			Error:   handleFCMError(resp),
-		}, nil
+		}
+		if delay := internal.ParseRetryAfter(hr.Header); delay > 0 {
+			sr.RetryAfter = delay
+		}
+		return sr, nil
 	}
 
 	var result fcmResponse
@@ -26,7 +26,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/textproto"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var testMessages = []*Message{
@@ -214,6 +218,24 @@ func TestSendAllInvalidMessage(t *testing.T) {
 	}
 }
 
+func TestSendAllOversizedMessage(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []*Message{
+		{Topic: "topic"},
+		{Topic: "topic", Data: map[string]string{"k": strings.Repeat("a", maxPayloadSize)}},
+	}
+	want := "invalid message at index 1: message payload size of 4104 bytes exceeds the limit of 4096 bytes"
+	br, err := client.SendAll(ctx, messages)
+	if err == nil || err.Error() != want {
+		t.Errorf("SendAll() = (%v, %v); want = (nil, %q)", br, err, want)
+	}
+}
+
 func TestSendAll(t *testing.T) {
 	resp, err := createMultipartResponse(testSuccessResponse, nil)
 	if err != nil {
@@ -276,6 +298,143 @@ func TestSendAllDryRun(t *testing.T) {
 	}
 }
 
+func TestSendAllWithOptionsAnalyticsLabel(t *testing.T) {
+	resp, err := createMultipartResponse(testSuccessResponse, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	messages := []*Message{
+		{Topic: "topic1"},
+		{Topic: "topic2", FCMOptions: &FCMOptions{AnalyticsLabel: "own-label"}},
+	}
+	br, err := client.SendAllWithOptions(ctx, messages, &SendOptions{AnalyticsLabel: "campaign-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSuccessfulBatchResponse(br, req, false); err != nil {
+		t.Errorf("SendAllWithOptions() = %v", err)
+	}
+	for _, want := range []string{`"analytics_label":"campaign-1"`, `"analytics_label":"own-label"`} {
+		if !strings.Contains(string(req), want) {
+			t.Errorf("SendAllWithOptions() request does not contain %s", want)
+		}
+	}
+	if messages[0].FCMOptions != nil {
+		t.Errorf("SendAllWithOptions() modified the input message: FCMOptions = %v", messages[0].FCMOptions)
+	}
+}
+
+func TestSendAllWithOptionsInvalidAnalyticsLabel(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, label := range []string{"invalid label", "l@bel", strings.Repeat("a", 51)} {
+		want := fmt.Sprintf(
+			"analytics label must consist of 1 to 50 characters from [a-zA-Z0-9-_.~%%]; got %q", label)
+		br, err := client.SendAllWithOptions(ctx, testMessages, &SendOptions{AnalyticsLabel: label})
+		if br != nil || err == nil || err.Error() != want {
+			t.Errorf("SendAllWithOptions(%q) = (%v, %v); want = (nil, %q)", label, br, err, want)
+		}
+	}
+}
+
+func TestSendAllWithOptionsNil(t *testing.T) {
+	resp, err := createMultipartResponse(testSuccessResponse, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	br, err := client.SendAllWithOptions(ctx, testMessages, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSuccessfulBatchResponse(br, req, false); err != nil {
+		t.Errorf("SendAllWithOptions() = %v", err)
+	}
+	if strings.Contains(string(req), "analytics_label") {
+		t.Errorf("SendAllWithOptions() request contains an analytics label")
+	}
+}
+
+func TestSendAllWithOptionsRateLimiter(t *testing.T) {
+	resp, err := createMultipartResponse(testSuccessResponse, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(context.Background(), testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	// The burst covers exactly one batch, and refilling it takes far longer than the test.
+	limiter, err := NewRateLimiter(0.001, len(testMessages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &SendOptions{RateLimiter: limiter}
+
+	br, err := client.SendAllWithOptions(context.Background(), testMessages, opts)
+	if err != nil || br.SuccessCount != len(testMessages) {
+		t.Fatalf("SendAllWithOptions() = (%v, %v); want = (%d successes, nil)", br, err, len(testMessages))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	br, err = client.SendMulticastWithOptions(ctx, testMulticastMessage, opts)
+	if br != nil || err != context.DeadlineExceeded {
+		t.Errorf("SendMulticastWithOptions() = (%v, %v); want = (nil, %v)", br, err, context.DeadlineExceeded)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("SendAllWithOptions() made %d requests; want = 1", got)
+	}
+}
+
 func TestSendAllPartialFailure(t *testing.T) {
 	success := []fcmResponse{
 		{
@@ -320,6 +479,170 @@ func TestSendAllPartialFailure(t *testing.T) {
 	}
 }
 
+func TestSendMulticastWithOptionsDroppableTokens(t *testing.T) {
+	success := []fcmResponse{
+		{
+			Name: "projects/test-project/messages/1",
+		},
+	}
+	failures := []string{
+		`{"error": {"status": "INVALID_ARGUMENT", "message": "test error", "details": [` +
+			`{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`,
+		`{"error": {"status": "INVALID_ARGUMENT", "message": "Invalid value at 'message.android.ttl'"}}`,
+		`{"error": {"status": "UNAVAILABLE", "message": "test error"}}`,
+	}
+	resp, err := createMultipartResponse(success, failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	mm := &MulticastMessage{Tokens: []string{"token1", "token2", "token3", "token4"}}
+	br, err := client.SendMulticastWithOptions(ctx, mm, &SendOptions{SeparateDroppableTokens: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.SuccessCount != 1 || br.FailureCount != 2 || br.DroppedCount != 1 {
+		t.Errorf("SendMulticastWithOptions() = {Success: %d, Failure: %d, Dropped: %d}; want = {1, 2, 1}",
+			br.SuccessCount, br.FailureCount, br.DroppedCount)
+	}
+	wantTokens := []string{"token2"}
+	if !reflect.DeepEqual(br.DroppableTokens, wantTokens) {
+		t.Errorf("SendMulticastWithOptions().DroppableTokens = %v; want = %v", br.DroppableTokens, wantTokens)
+	}
+	if !IsRegistrationTokenNotRegistered(br.Responses[1].Error) {
+		t.Errorf("SendMulticastWithOptions().Responses[1].Error = %v; want = unregistered", br.Responses[1].Error)
+	}
+	// Invalid payloads are reported as invalid arguments, and do not make the token droppable.
+	if !IsInvalidArgument(br.Responses[2].Error) {
+		t.Errorf("SendMulticastWithOptions().Responses[2].Error = %v; want = invalid argument", br.Responses[2].Error)
+	}
+
+	br, err = client.SendMulticastWithOptions(ctx, mm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.FailureCount != 3 || br.DroppedCount != 0 || br.DroppableTokens != nil {
+		t.Errorf("SendMulticastWithOptions(nil) = {Failure: %d, Dropped: %d, Tokens: %v}; want = {3, 0, []}",
+			br.FailureCount, br.DroppedCount, br.DroppableTokens)
+	}
+
+	// Messages sent to topics are never dropped.
+	topics := []*Message{{Topic: "t1"}, {Topic: "t2"}, {Topic: "t3"}, {Topic: "t4"}}
+	br, err = client.SendAllWithOptions(ctx, topics, &SendOptions{SeparateDroppableTokens: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.FailureCount != 3 || br.DroppedCount != 0 {
+		t.Errorf("SendAllWithOptions(topics) = {Failure: %d, Dropped: %d}; want = {3, 0}",
+			br.FailureCount, br.DroppedCount)
+	}
+}
+
+func TestBatchResponseFailures(t *testing.T) {
+	failure := &SendResponse{Error: fmt.Errorf("failure")}
+	br := &BatchResponse{
+		SuccessCount: 2,
+		FailureCount: 2,
+		Responses: []*SendResponse{
+			failure,
+			{Success: true, MessageID: "projects/test-project/messages/1"},
+			{Success: true, MessageID: "projects/test-project/messages/2"},
+			failure,
+		},
+	}
+
+	if got := br.FailedResponses(); len(got) != 2 || got[0] != failure || got[1] != failure {
+		t.Errorf("FailedResponses() = %v; want = [failure failure]", got)
+	}
+	if got, want := br.FailedIndices(), []int{0, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FailedIndices() = %v; want = %v", got, want)
+	}
+
+	empty := &BatchResponse{}
+	if got := empty.FailedResponses(); len(got) != 0 {
+		t.Errorf("FailedResponses() = %v; want = []", got)
+	}
+	if got := empty.FailedIndices(); len(got) != 0 {
+		t.Errorf("FailedIndices() = %v; want = []", got)
+	}
+}
+
+func TestSendAllRateLimited(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	writer.SetBoundary(multipartBoundary)
+	parts := []string{
+		"HTTP/1.1 429 Too Many Requests\r\nContent-Type: application/json\r\nRetry-After: 30\r\n\r\n" +
+			`{"error": {"status": "RESOURCE_EXHAUSTED", "details": [{` +
+			`"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "QUOTA_EXCEEDED"}]}}`,
+		"HTTP/1.1 503 Service Unavailable\r\nContent-Type: application/json\r\nRetry-After: 10\r\n\r\n" +
+			`{"error": {"status": "UNAVAILABLE"}}`,
+		"HTTP/1.1 500 Internal Server Error\r\nContent-Type: application/json\r\n\r\n" +
+			`{"error": {"status": "INTERNAL"}}`,
+	}
+	for idx, p := range parts {
+		if err := writeResponsePart(writer, []byte(p), idx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(buffer.Bytes())
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	br, err := client.SendAll(ctx, []*Message{{Topic: "t1"}, {Topic: "t2"}, {Topic: "t3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if br.FailureCount != 3 || len(br.Responses) != 3 {
+		t.Fatalf("SendAll() = %#v; want = 3 failures", br)
+	}
+
+	cases := []struct {
+		check      func(error) bool
+		retryAfter time.Duration
+	}{
+		{IsMessageRateExceeded, 30 * time.Second},
+		{IsServerUnavailable, 10 * time.Second},
+		{IsInternal, 0},
+	}
+	for idx, tc := range cases {
+		r := br.Responses[idx]
+		if !tc.check(r.Error) {
+			t.Errorf("Responses[%d].Error = %v; want = a different error code", idx, r.Error)
+		}
+		if r.RetryAfter != tc.retryAfter {
+			t.Errorf("Responses[%d].RetryAfter = %v; want = %v", idx, r.RetryAfter, tc.retryAfter)
+		}
+	}
+	if br.RetryAfter() != 30*time.Second {
+		t.Errorf("RetryAfter() = %v; want = %v", br.RetryAfter(), 30*time.Second)
+	}
+}
+
 func TestSendAllTotalFailure(t *testing.T) {
 	var resp string
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -594,6 +917,124 @@ func TestSendMulticastPartialFailure(t *testing.T) {
 	}
 }
 
+func TestSendToTokensInvalid(t *testing.T) {
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tooMany []string
+	for i := 0; i < 101; i++ {
+		tooMany = append(tooMany, fmt.Sprintf("token%d", i))
+	}
+	cases := []struct {
+		name string
+		req  *Message
+		want string
+	}{
+		{
+			name: "NilMessage",
+			want: "message must not be nil",
+		},
+		{
+			name: "NoTokens",
+			req:  &Message{Token: "token"},
+			want: "tokens must not be nil or empty",
+		},
+		{
+			name: "TooManyTokens",
+			req:  &Message{Tokens: tooMany},
+			want: "tokens must not contain more than 100 elements",
+		},
+		{
+			name: "TokensAndTopic",
+			req:  &Message{Tokens: []string{"token1", "token2"}, Topic: "topic"},
+			want: "token, topic and condition must not be specified along with tokens",
+		},
+		{
+			name: "EmptyToken",
+			req:  &Message{Tokens: []string{"token1", ""}},
+			want: "invalid message at index 1: exactly one of token, tokens, topic or condition must be specified",
+		},
+	}
+	for _, tc := range cases {
+		br, err := client.SendToTokens(ctx, tc.req)
+		if br != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("SendToTokens(%s) = (%v, %v); want = (nil, %q)", tc.name, br, err, tc.want)
+		}
+	}
+}
+
+func TestSendToTokens(t *testing.T) {
+	resp, err := createMultipartResponse(testSuccessResponse, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	message := &Message{Tokens: []string{"token1", "token2"}}
+	br, err := client.SendToTokens(ctx, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSuccessfulBatchResponse(br, req, false); err != nil {
+		t.Errorf("SendToTokens() = %v", err)
+	}
+	if !strings.Contains(string(req), `"token":"token1"`) || !strings.Contains(string(req), `"token":"token2"`) {
+		t.Errorf("SendToTokens() request = %s; want both tokens", string(req))
+	}
+	if message.Token != "" || len(message.Tokens) != 2 {
+		t.Errorf("SendToTokens() modified the input message: %#v", message)
+	}
+}
+
+func TestSendToTokensDryRun(t *testing.T) {
+	resp, err := createMultipartResponse(testSuccessResponse, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", wantMime)
+		w.Write(resp)
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.batchEndpoint = ts.URL
+
+	br, err := client.SendToTokensDryRun(ctx, &Message{Tokens: []string{"token1", "token2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSuccessfulBatchResponse(br, req, true); err != nil {
+		t.Errorf("SendToTokensDryRun() = %v", err)
+	}
+}
+
 func checkSuccessfulBatchResponse(br *BatchResponse, req []byte, dryRun bool) error {
 	if br.SuccessCount != 2 {
 		return fmt.Errorf("SuccessCount = %d; want = 2", br.SuccessCount)
@@ -646,6 +1087,9 @@ func checkPartialErrorBatchResponse(br *BatchResponse, tc struct {
 	if r.MessageID != "" {
 		return fmt.Errorf("Responses[1]: MessageID = %q; want = %q", r.MessageID, "")
 	}
+	if r.ShortID() != "" {
+		return fmt.Errorf("Responses[1]: ShortID() = %q; want = %q", r.ShortID(), "")
+	}
 
 	return nil
 }
@@ -660,6 +1104,9 @@ func checkSuccessfulSendResponse(r *SendResponse, wantID string) error {
 	if r.MessageID != wantID {
 		return fmt.Errorf("MessageID = %q; want = %q", r.MessageID, wantID)
 	}
+	if r.ShortID() != ShortMessageID(wantID) {
+		return fmt.Errorf("ShortID() = %q; want = %q", r.ShortID(), ShortMessageID(wantID))
+	}
 	return nil
 }
 
@@ -17,11 +17,15 @@ package messaging
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,6 +63,11 @@ var validMessages = []struct {
 		req:  &Message{Token: "test-token"},
 		want: map[string]interface{}{"token": "test-token"},
 	},
+	{
+		name: "SingleElementTokens",
+		req:  &Message{Tokens: []string{"test-token"}},
+		want: map[string]interface{}{"token": "test-token"},
+	},
 	{
 		name: "TopicOnly",
 		req:  &Message{Topic: "test-topic"},
@@ -71,8 +80,8 @@ var validMessages = []struct {
 	},
 	{
 		name: "ConditionOnly",
-		req:  &Message{Condition: "test-condition"},
-		want: map[string]interface{}{"condition": "test-condition"},
+		req:  &Message{Condition: "'test-topic' in topics"},
+		want: map[string]interface{}{"condition": "'test-topic' in topics"},
 	},
 	{
 		name: "DataMessage",
@@ -113,6 +122,13 @@ var validMessages = []struct {
 				"body":  "b",
 				"image": "http://image.jpg",
 			},
+			"apns": map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "alert"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{"mutable-content": float64(1)},
+				},
+				"fcm_options": map[string]interface{}{"image": "http://image.jpg"},
+			},
 			"topic": "test-topic",
 		},
 	},
@@ -190,6 +206,48 @@ var validMessages = []struct {
 			"topic": "test-topic",
 		},
 	},
+	{
+		name: "AndroidVibrateTimings",
+		req: &Message{
+			Android: &AndroidConfig{
+				Notification: &AndroidNotification{
+					Ticker:               "ticker",
+					VibrateTimings:       []time.Duration{0, 3500 * time.Millisecond, time.Second},
+					DefaultLightSettings: true,
+				},
+			},
+			Topic: "test-topic",
+		},
+		want: map[string]interface{}{
+			"android": map[string]interface{}{
+				"notification": map[string]interface{}{
+					"ticker":                 "ticker",
+					"vibrate_timings":        []interface{}{"0s", "3.500000000s", "1s"},
+					"default_light_settings": true,
+				},
+			},
+			"topic": "test-topic",
+		},
+	},
+	{
+		name: "AndroidDefaultVibrateTimings",
+		req: &Message{
+			Android: &AndroidConfig{
+				Notification: &AndroidNotification{
+					DefaultVibrateTimings: true,
+				},
+			},
+			Topic: "test-topic",
+		},
+		want: map[string]interface{}{
+			"android": map[string]interface{}{
+				"notification": map[string]interface{}{
+					"default_vibrate_timings": true,
+				},
+			},
+			"topic": "test-topic",
+		},
+	},
 	{
 		name: "AndroidNoTTL",
 		req: &Message{
@@ -332,7 +390,7 @@ var validMessages = []struct {
 		},
 		want: map[string]interface{}{
 			"apns": map[string]interface{}{
-				"headers": map[string]interface{}{"h1": "v1", "h2": "v2"},
+				"headers": map[string]interface{}{"h1": "v1", "h2": "v2", "apns-push-type": "alert"},
 				"payload": map[string]interface{}{
 					"aps": map[string]interface{}{
 						"alert":             "a",
@@ -386,7 +444,7 @@ var validMessages = []struct {
 		},
 		want: map[string]interface{}{
 			"apns": map[string]interface{}{
-				"headers": map[string]interface{}{"h1": "v1", "h2": "v2"},
+				"headers": map[string]interface{}{"h1": "v1", "h2": "v2", "apns-push-type": "alert"},
 				"payload": map[string]interface{}{
 					"aps": map[string]interface{}{
 						"alert":    "a",
@@ -428,6 +486,7 @@ var validMessages = []struct {
 		},
 		want: map[string]interface{}{
 			"apns": map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "background"},
 				"payload": map[string]interface{}{
 					"aps": map[string]interface{}{
 						"badge":             float64(badgeZero),
@@ -444,6 +503,72 @@ var validMessages = []struct {
 			"topic": "test-topic",
 		},
 	},
+	{
+		name: "APNSInterruptionLevel",
+		req: &Message{
+			APNS: &APNSConfig{
+				Payload: &APNSPayload{
+					Aps: &Aps{
+						AlertString:       "a",
+						TargetContentID:   "tcid",
+						InterruptionLevel: "time-sensitive",
+					},
+				},
+			},
+			Topic: "test-topic",
+		},
+		want: map[string]interface{}{
+			"apns": map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "alert"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert":              "a",
+						"target-content-id":  "tcid",
+						"interruption-level": "time-sensitive",
+					},
+				},
+			},
+			"topic": "test-topic",
+		},
+	},
+	{
+		name: "APNSLiveActivity",
+		req: &Message{
+			APNS: &APNSConfig{
+				LiveActivityToken: "live-activity-token",
+				Payload: &APNSPayload{
+					Aps: &Aps{
+						Event: "update",
+						ContentState: map[string]interface{}{
+							"driverName": "Anne",
+							"etaMinutes": float64(5),
+						},
+						Timestamp:     1700000000,
+						DismissalDate: 1700003600,
+					},
+				},
+			},
+			Token: "test-token",
+		},
+		want: map[string]interface{}{
+			"apns": map[string]interface{}{
+				"headers":             map[string]interface{}{"apns-push-type": "liveactivity"},
+				"live_activity_token": "live-activity-token",
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"event": "update",
+						"content-state": map[string]interface{}{
+							"driverName": "Anne",
+							"etaMinutes": float64(5),
+						},
+						"timestamp":      float64(1700000000),
+						"dismissal-date": float64(1700003600),
+					},
+				},
+			},
+			"token": "test-token",
+		},
+	},
 	{
 		name: "APNSAlertObject",
 		req: &Message{
@@ -470,6 +595,7 @@ var validMessages = []struct {
 		},
 		want: map[string]interface{}{
 			"apns": map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "alert"},
 				"payload": map[string]interface{}{
 					"aps": map[string]interface{}{
 						"alert": map[string]interface{}{
@@ -506,7 +632,7 @@ var invalidMessages = []struct {
 	{
 		name: "NoTargets",
 		req:  &Message{},
-		want: "exactly one of token, topic or condition must be specified",
+		want: "exactly one of token, tokens, topic or condition must be specified",
 	},
 	{
 		name: "MultipleTargets",
@@ -514,21 +640,64 @@ var invalidMessages = []struct {
 			Token: "token",
 			Topic: "topic",
 		},
-		want: "exactly one of token, topic or condition must be specified",
+		want: "exactly one of token, tokens, topic or condition must be specified",
+	},
+	{
+		name: "TokenAndTokens",
+		req: &Message{
+			Token:  "token",
+			Tokens: []string{"token1"},
+		},
+		want: "exactly one of token, tokens, topic or condition must be specified",
+	},
+	{
+		name: "MultipleTokens",
+		req: &Message{
+			Tokens: []string{"token1", "token2"},
+		},
+		want: "message with more than one token must be sent with SendToTokens",
+	},
+	{
+		name: "EmptyTokens",
+		req: &Message{
+			Tokens: []string{""},
+		},
+		want: "tokens must not contain empty strings",
+	},
+	{
+		name: "MalformedCondition",
+		req: &Message{
+			Condition: "test-condition",
+		},
+		want: `malformed condition "test-condition": expected a topic, '(' or '!' at offset 0; got 't'`,
+	},
+	{
+		name: "ConditionWithTooManyTopics",
+		req: &Message{
+			Condition: "'a' in topics || 'b' in topics || 'c' in topics || 'd' in topics || 'e' in topics || 'f' in topics",
+		},
+		want: "condition must not refer to more than 5 topics; got 6",
 	},
 	{
 		name: "InvalidPrefixedTopicName",
 		req: &Message{
 			Topic: "/topics/",
 		},
-		want: "malformed topic name",
+		want: `invalid topic name "/topics/": topic name must not be empty`,
 	},
 	{
 		name: "InvalidTopicName",
 		req: &Message{
 			Topic: "foo*bar",
 		},
-		want: "malformed topic name",
+		want: `invalid topic name "foo*bar": topic names must only contain ASCII letters, digits and the characters "-_.~%"`,
+	},
+	{
+		name: "NonASCIITopicName",
+		req: &Message{
+			Topic: "/topics/caf\u00e9",
+		},
+		want: `invalid topic name "/topics/café": topic names must only contain ASCII letters, digits and the characters "-_.~%"`,
 	},
 	{
 		name: "InvalidNotificationImage",
@@ -540,6 +709,46 @@ var invalidMessages = []struct {
 		},
 		want: `invalid image URL: "image.jpg"`,
 	},
+	{
+		name: "OversizedData",
+		req: &Message{
+			Data:  map[string]string{"k": strings.Repeat("a", maxPayloadSize)},
+			Topic: "topic",
+		},
+		want: "message payload size of 4104 bytes exceeds the limit of 4096 bytes",
+	},
+	{
+		name: "OversizedAndroidData",
+		req: &Message{
+			Android: &AndroidConfig{
+				Data: map[string]string{"k": strings.Repeat("a", maxPayloadSize)},
+			},
+			Topic: "topic",
+		},
+		want: "android payload size of 4104 bytes exceeds the limit of 4096 bytes",
+	},
+	{
+		name: "OversizedAPNSPayload",
+		req: &Message{
+			APNS: &APNSConfig{
+				Payload: &APNSPayload{
+					CustomData: map[string]interface{}{"k": strings.Repeat("a", maxPayloadSize)},
+				},
+			},
+			Topic: "topic",
+		},
+		want: "apns payload size of 4104 bytes exceeds the limit of 4096 bytes",
+	},
+	{
+		name: "OversizedWebpushNotification",
+		req: &Message{
+			Webpush: &WebpushConfig{
+				Notification: &WebpushNotification{Body: strings.Repeat("a", maxPayloadSize)},
+			},
+			Topic: "topic",
+		},
+		want: "webpush payload size of 4107 bytes exceeds the limit of 4096 bytes",
+	},
 	{
 		name: "InvalidAndroidTTL",
 		req: &Message{
@@ -550,6 +759,18 @@ var invalidMessages = []struct {
 		},
 		want: "ttl duration must not be negative",
 	},
+	{
+		name: "InvalidAndroidVibrateTimings",
+		req: &Message{
+			Android: &AndroidConfig{
+				Notification: &AndroidNotification{
+					VibrateTimings: []time.Duration{time.Second, -time.Second},
+				},
+			},
+			Topic: "topic",
+		},
+		want: "vibrate timings must not be negative",
+	},
 	{
 		name: "InvalidAndroidPriority",
 		req: &Message{
@@ -637,6 +858,109 @@ var invalidMessages = []struct {
 		},
 		want: `multiple specifications for the key "aps"`,
 	},
+	{
+		name: "InvalidAPNSTTL",
+		req: &Message{
+			APNS: &APNSConfig{
+				TTL: &invalidTTL,
+			},
+			Topic: "topic",
+		},
+		want: "apns ttl duration must not be negative",
+	},
+	{
+		name: "InvalidMessageTTL",
+		req: &Message{
+			TTL:   &invalidTTL,
+			Topic: "topic",
+		},
+		want: "message ttl duration must not be negative",
+	},
+	{
+		name: "CollapseIDTooLongForWebpush",
+		req: &Message{
+			CollapseID: strings.Repeat("a", 33),
+			Topic:      "topic",
+		},
+		want: "collapse id must consist of at most 32 characters from [a-zA-Z0-9-_] for webpush; got \"" +
+			strings.Repeat("a", 33) + "\"",
+	},
+	{
+		name: "InvalidCollapseIDForWebpush",
+		req: &Message{
+			CollapseID: "score update",
+			Topic:      "topic",
+		},
+		want: "collapse id must consist of at most 32 characters from [a-zA-Z0-9-_] for webpush; got \"score update\"",
+	},
+	{
+		name: "CollapseIDTooLongForAPNS",
+		req: &Message{
+			CollapseID: strings.Repeat("a", 65),
+			Webpush:    &WebpushConfig{Headers: map[string]string{"Topic": "t"}},
+			Topic:      "topic",
+		},
+		want: "collapse id must not be longer than 64 bytes for apns; got 65 bytes",
+	},
+	{
+		name: "InvalidAPNSInterruptionLevel",
+		req: &Message{
+			APNS: &APNSConfig{
+				Payload: &APNSPayload{
+					Aps: &Aps{
+						InterruptionLevel: "urgent",
+					},
+				},
+			},
+			Topic: "topic",
+		},
+		want: "interruptionLevel must be 'passive', 'active', 'time-sensitive' or 'critical'",
+	},
+	{
+		name: "InvalidAPNSLiveActivityEvent",
+		req: &Message{
+			APNS: &APNSConfig{
+				LiveActivityToken: "live-activity-token",
+				Payload: &APNSPayload{
+					Aps: &Aps{
+						Event: "pause",
+					},
+				},
+			},
+			Token: "token",
+		},
+		want: "event must be 'start', 'update' or 'end'",
+	},
+	{
+		name: "InvalidAPNSLiveActivityTimestamp",
+		req: &Message{
+			APNS: &APNSConfig{
+				Payload: &APNSPayload{
+					Aps: &Aps{
+						Event:     "end",
+						Timestamp: -1,
+					},
+				},
+			},
+			Token: "token",
+		},
+		want: "timestamp and dismissal date must not be negative",
+	},
+	{
+		name: "APNSMultipleFieldSpecificationsInterruptionLevel",
+		req: &Message{
+			APNS: &APNSConfig{
+				Payload: &APNSPayload{
+					Aps: &Aps{
+						InterruptionLevel: "active",
+						CustomData:        map[string]interface{}{"interruption-level": "passive"},
+					},
+				},
+			},
+			Topic: "topic",
+		},
+		want: `multiple specifications for the key "interruption-level"`,
+	},
 	{
 		name: "APNSMultipleAlerts",
 		req: &Message{
@@ -838,9 +1162,23 @@ func TestNoProjectID(t *testing.T) {
 	}
 }
 
+func TestProjectIDAndSendURL(t *testing.T) {
+	client, err := NewClient(context.Background(), testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.ProjectID(); got != "test-project" {
+		t.Errorf("ProjectID() = %q; want = %q", got, "test-project")
+	}
+	want := "https://fcm.googleapis.com/v1/projects/test-project/messages:send"
+	if got := client.SendURL(); got != want {
+		t.Errorf("SendURL() = %q; want = %q", got, want)
+	}
+}
+
 func TestJSONUnmarshal(t *testing.T) {
 	for _, tc := range validMessages {
-		if tc.name == "PrefixedTopicOnly" {
+		if tc.name == "PrefixedTopicOnly" || tc.name == "SingleElementTokens" {
 			continue
 		}
 		b, err := json.Marshal(tc.req)
@@ -851,6 +1189,7 @@ func TestJSONUnmarshal(t *testing.T) {
 		if err := json.Unmarshal(b, &target); err != nil {
 			t.Errorf("Unmarshal(%s) = %v; want = nil", tc.name, err)
 		}
+		removeComputedAPNSFields(tc.req, &target)
 		if !reflect.DeepEqual(tc.req, &target) {
 			log.Printf("%#v\n", *tc.req.APNS.Payload.Aps)
 			log.Printf("%#v\n", *target.APNS.Payload.Aps)
@@ -859,65 +1198,1218 @@ func TestJSONUnmarshal(t *testing.T) {
 	}
 }
 
-func TestInvalidJSONUnmarshal(t *testing.T) {
+// removeComputedAPNSFields removes the APNS headers, the image URL and the mutable-content flag computed during
+// serialization from the target, so that it can be compared with the original message.
+func removeComputedAPNSFields(original, target *Message) {
+	if target.APNS == nil {
+		return
+	}
+	if !original.APNS.hasImageURL() && target.APNS.hasImageURL() {
+		target.APNS.FCMOptions.ImageURL = ""
+		if reflect.DeepEqual(target.APNS.FCMOptions, &APNSFCMOptions{}) &&
+			(original.APNS == nil || original.APNS.FCMOptions == nil) {
+			target.APNS.FCMOptions = nil
+		}
+	}
+	var headers map[string]string
+	if original.APNS != nil {
+		headers = original.APNS.Headers
+	}
+	for _, h := range []string{apnsExpirationHeader, apnsPushTypeHeader} {
+		if _, ok := headers[h]; !ok {
+			delete(target.APNS.Headers, h)
+		}
+	}
+	if len(target.APNS.Headers) == 0 && headers == nil {
+		target.APNS.Headers = nil
+	}
+
+	if original.APNS.hasMutableContent() || !target.APNS.hasMutableContent() {
+		return
+	}
+	target.APNS.Payload.Aps.MutableContent = false
+	if reflect.DeepEqual(target.APNS.Payload.Aps, &Aps{}) &&
+		(original.APNS == nil || original.APNS.Payload == nil || original.APNS.Payload.Aps == nil) {
+		target.APNS.Payload.Aps = nil
+	}
+	if reflect.DeepEqual(target.APNS.Payload, &APNSPayload{}) && (original.APNS == nil || original.APNS.Payload == nil) {
+		target.APNS.Payload = nil
+	}
+	if reflect.DeepEqual(target.APNS, &APNSConfig{}) && original.APNS == nil {
+		target.APNS = nil
+	}
+}
+
+func TestCriticalSoundJSON(t *testing.T) {
 	cases := []struct {
-		name   string
-		req    map[string]interface{}
-		target interface{}
+		name  string
+		sound *CriticalSound
+		want  interface{}
 	}{
 		{
-			name: "InvalidTTLSegments",
-			req: map[string]interface{}{
-				"ttl": "1.2.3s",
-			},
-			target: &AndroidConfig{},
+			name:  "NameOnly",
+			sound: &CriticalSound{Name: "n"},
+			want:  "n",
 		},
 		{
-			name: "IncorrectTTLSeconds",
-			req: map[string]interface{}{
-				"ttl": "abcs",
-			},
-			target: &AndroidConfig{},
+			name:  "Critical",
+			sound: &CriticalSound{Critical: true, Name: "n"},
+			want:  map[string]interface{}{"critical": float64(1), "name": "n"},
 		},
 		{
-			name: "IncorrectTTLNanoseconds",
-			req: map[string]interface{}{
-				"ttl": "10.abcs",
-			},
-			target: &AndroidConfig{},
+			name:  "Volume",
+			sound: &CriticalSound{Name: "n", Volume: 0.5},
+			want:  map[string]interface{}{"name": "n", "volume": float64(0.5)},
 		},
-		{
-			name: "InvalidApsAlert",
-			req: map[string]interface{}{
-				"alert": 10,
-			},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := json.Marshal(&Aps{CriticalSound: tc.sound})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m map[string]interface{}
+			if err := json.Unmarshal(b, &m); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m["sound"], tc.want) {
+				t.Errorf("Marshal(%s) sound = %#v; want = %#v", tc.name, m["sound"], tc.want)
+			}
+
+			var aps Aps
+			if err := json.Unmarshal(b, &aps); err != nil {
+				t.Fatal(err)
+			}
+			got := aps.CriticalSound
+			if got == nil {
+				got = &CriticalSound{Name: aps.Sound}
+			}
+			if !reflect.DeepEqual(got, tc.sound) {
+				t.Errorf("Unmarshal(%s) sound = %#v; want = %#v", tc.name, got, tc.sound)
+			}
+		})
+	}
+}
+
+func TestMutableContentForImage(t *testing.T) {
+	cases := []struct {
+		name string
+		req  *Message
+		want map[string]interface{}
+	}{
+		{
+			name: "NotificationImageWithAps",
+			req: &Message{
+				Notification: &Notification{ImageURL: "http://image.jpg"},
+				APNS: &APNSConfig{
+					Payload: &APNSPayload{
+						Aps: &Aps{Category: "c"},
+					},
+				},
+			},
+			want: map[string]interface{}{"category": "c", "mutable-content": float64(1)},
+		},
+		{
+			name: "APNSImage",
+			req: &Message{
+				APNS: &APNSConfig{
+					FCMOptions: &APNSFCMOptions{ImageURL: "http://image.jpg"},
+				},
+			},
+			want: map[string]interface{}{"mutable-content": float64(1)},
+		},
+		{
+			name: "NoImage",
+			req: &Message{
+				Notification: &Notification{Title: "t"},
+				APNS: &APNSConfig{
+					Payload: &APNSPayload{
+						Aps: &Aps{Category: "c"},
+					},
+				},
+			},
+			want: map[string]interface{}{"category": "c"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := json.Marshal(tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				APNS struct {
+					Payload struct {
+						Aps map[string]interface{} `json:"aps"`
+					} `json:"payload"`
+				} `json:"apns"`
+			}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.APNS.Payload.Aps, tc.want) {
+				t.Errorf("Marshal(%s) aps = %v; want = %v", tc.name, got.APNS.Payload.Aps, tc.want)
+			}
+			if tc.req.APNS.hasMutableContent() {
+				t.Errorf("Marshal(%s) modified the original message", tc.name)
+			}
+		})
+	}
+}
+
+func TestAPNSImageURLFromNotification(t *testing.T) {
+	cases := []struct {
+		name string
+		req  *Message
+		want map[string]interface{}
+	}{
+		{
+			name: "NoAPNSConfig",
+			req: &Message{
+				Notification: &Notification{ImageURL: "http://image.jpg"},
+			},
+			want: map[string]interface{}{"image": "http://image.jpg"},
+		},
+		{
+			name: "AnalyticsLabel",
+			req: &Message{
+				Notification: &Notification{ImageURL: "http://image.jpg"},
+				APNS: &APNSConfig{
+					FCMOptions: &APNSFCMOptions{AnalyticsLabel: "a"},
+				},
+			},
+			want: map[string]interface{}{"analytics_label": "a", "image": "http://image.jpg"},
+		},
+		{
+			name: "APNSImageWins",
+			req: &Message{
+				Notification: &Notification{ImageURL: "http://image.jpg"},
+				APNS: &APNSConfig{
+					FCMOptions: &APNSFCMOptions{ImageURL: "http://ios-image.jpg"},
+				},
+			},
+			want: map[string]interface{}{"image": "http://ios-image.jpg"},
+		},
+		{
+			name: "NoImage",
+			req: &Message{
+				Notification: &Notification{Title: "t"},
+			},
+			want: nil,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var before *APNSFCMOptions
+			if tc.req.APNS != nil && tc.req.APNS.FCMOptions != nil {
+				copied := *tc.req.APNS.FCMOptions
+				before = &copied
+			}
+
+			b, err := json.Marshal(tc.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				APNS struct {
+					FCMOptions map[string]interface{} `json:"fcm_options"`
+				} `json:"apns"`
+			}
+			if err := json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.APNS.FCMOptions, tc.want) {
+				t.Errorf("Marshal(%s) fcm_options = %v; want = %v", tc.name, got.APNS.FCMOptions, tc.want)
+			}
+			if tc.req.APNS != nil && !reflect.DeepEqual(tc.req.APNS.FCMOptions, before) {
+				t.Errorf("Marshal(%s) modified the original message", tc.name)
+			}
+		})
+	}
+}
+
+func TestApsBadge(t *testing.T) {
+	cleared := &Aps{Badge: &badge}
+	cleared.ClearBadge()
+	if cleared.Badge == nil || *cleared.Badge != 0 {
+		t.Fatalf("ClearBadge() = %v; want = 0", cleared.Badge)
+	}
+
+	cases := []struct {
+		name string
+		aps  *Aps
+		want interface{}
+	}{
+		{"Unchanged", &Aps{Category: "c"}, nil},
+		{"Cleared", cleared, float64(0)},
+		{"Set", &Aps{Badge: &badge}, float64(badge)},
+	}
+	for _, tc := range cases {
+		b, err := json.Marshal(tc.aps)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := parsed["badge"]
+		if tc.want == nil {
+			if ok {
+				t.Errorf("Aps(%s) badge = %v; want none", tc.name, got)
+			}
+		} else if got != tc.want {
+			t.Errorf("Aps(%s) badge = %v; want = %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAPNSPushType(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  *Message
+		want map[string]interface{}
+	}{
+		{
+			name: "Background",
+			msg: &Message{
+				APNS: &APNSConfig{
+					Payload: &APNSPayload{Aps: &Aps{ContentAvailable: true}},
+				},
+			},
+			want: map[string]interface{}{"apns-push-type": "background"},
+		},
+		{
+			name: "AlertString",
+			msg: &Message{
+				APNS: &APNSConfig{
+					Payload: &APNSPayload{Aps: &Aps{AlertString: "a", ContentAvailable: true}},
+				},
+			},
+			want: map[string]interface{}{"apns-push-type": "alert"},
+		},
+		{
+			name: "AlertObject",
+			msg: &Message{
+				APNS: &APNSConfig{
+					Payload: &APNSPayload{Aps: &Aps{Alert: &ApsAlert{Title: "t"}}},
+				},
+			},
+			want: map[string]interface{}{"apns-push-type": "alert"},
+		},
+		{
+			name: "TopLevelNotification",
+			msg: &Message{
+				Notification: &Notification{Title: "t"},
+				APNS: &APNSConfig{
+					Payload: &APNSPayload{Aps: &Aps{ContentAvailable: true}},
+				},
+			},
+			want: map[string]interface{}{"apns-push-type": "alert"},
+		},
+		{
+			name: "ExplicitHeaderWins",
+			msg: &Message{
+				APNS: &APNSConfig{
+					Headers: map[string]string{"apns-push-type": "voip"},
+					Payload: &APNSPayload{Aps: &Aps{ContentAvailable: true}},
+				},
+			},
+			want: map[string]interface{}{"apns-push-type": "voip"},
+		},
+		{
+			name: "ExplicitHeaderWinsCaseInsensitive",
+			msg: &Message{
+				APNS: &APNSConfig{
+					Headers: map[string]string{"APNS-Push-Type": "voip"},
+					Payload: &APNSPayload{Aps: &Aps{Alert: &ApsAlert{Title: "t"}}},
+				},
+			},
+			want: map[string]interface{}{"APNS-Push-Type": "voip"},
+		},
+		{
+			name: "NoAps",
+			msg: &Message{
+				APNS: &APNSConfig{
+					Headers: map[string]string{"h1": "v1"},
+				},
+			},
+			want: map[string]interface{}{"h1": "v1"},
+		},
+	}
+	for _, tc := range cases {
+		tc.msg.Topic = "topic"
+		before := len(tc.msg.APNS.Headers)
+		b, err := json.Marshal(tc.msg)
+		if err != nil {
+			t.Fatalf("Marshal(%s) = %v; want = nil", tc.name, err)
+		}
+		var parsed struct {
+			APNS struct {
+				Headers map[string]interface{} `json:"headers"`
+			} `json:"apns"`
+		}
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed.APNS.Headers, tc.want) {
+			t.Errorf("Marshal(%s) headers = %v; want = %v", tc.name, parsed.APNS.Headers, tc.want)
+		}
+		if len(tc.msg.APNS.Headers) != before {
+			t.Errorf("Marshal(%s) modified caller headers: %v", tc.name, tc.msg.APNS.Headers)
+		}
+	}
+}
+
+func TestInvalidJSONUnmarshal(t *testing.T) {
+	cases := []struct {
+		name   string
+		req    map[string]interface{}
+		target interface{}
+	}{
+		{
+			name: "InvalidTTLSegments",
+			req: map[string]interface{}{
+				"ttl": "1.2.3s",
+			},
+			target: &AndroidConfig{},
+		},
+		{
+			name: "IncorrectTTLSeconds",
+			req: map[string]interface{}{
+				"ttl": "abcs",
+			},
+			target: &AndroidConfig{},
+		},
+		{
+			name: "IncorrectTTLNanoseconds",
+			req: map[string]interface{}{
+				"ttl": "10.abcs",
+			},
+			target: &AndroidConfig{},
+		},
+		{
+			name: "InvalidApsAlert",
+			req: map[string]interface{}{
+				"alert": 10,
+			},
 			target: &Aps{},
 		},
 		{
-			name: "InvalidApsSound",
-			req: map[string]interface{}{
-				"sound": 10,
+			name: "InvalidApsSound",
+			req: map[string]interface{}{
+				"sound": 10,
+			},
+			target: &Aps{},
+		},
+	}
+	for _, tc := range cases {
+		b, err := json.Marshal(tc.req)
+		if err != nil {
+			t.Errorf("Marshal(%s) = %v; want = nil", tc.name, err)
+		}
+		if err := json.Unmarshal(b, tc.target); err == nil {
+			t.Errorf("Unmarshal(%s) = %v; want = error", tc.name, err)
+		}
+	}
+}
+
+func TestValidateCondition(t *testing.T) {
+	valid := []string{
+		"'a' in topics",
+		`"a" in topics`,
+		"'a' in topics && 'b' in topics",
+		"'a' in topics&&'b' in topics",
+		"'a' in topics && ('b' in topics || 'c' in topics)",
+		"!('a' in topics) && !'b' in topics",
+		"(('a' in topics || 'b' in topics) && ('c' in topics || 'd' in topics)) || 'e' in topics",
+		"  'stock-GOOG' in topics || 'industry-tech' in topics  ",
+		"'a' in topics &&\n'b' in topics",
+		"('a' in topics ||\r\n 'b' in topics)\n\t&& 'c'\nin\ntopics\n",
+	}
+	for _, c := range valid {
+		if err := validateCondition(c); err != nil {
+			t.Errorf("validateCondition(%q) = %v; want = nil", c, err)
+		}
+	}
+
+	invalid := []struct {
+		condition string
+		want      string
+	}{
+		{"", "expected a topic, '(' or '!' at end of input"},
+		{"'a' in topics &&", "expected a topic, '(' or '!' at end of input"},
+		{"&& 'a' in topics", "expected a topic, '(' or '!' at offset 0; got '&'"},
+		{"'a' in topics && && 'b' in topics", "expected a topic, '(' or '!' at offset 17; got '&'"},
+		{"'a' in topics & 'b' in topics", "unexpected '&' at offset 14"},
+		{"'a' in topics 'b' in topics", "unexpected '\\'' at offset 14"},
+		{"('a' in topics", "missing ')' at offset 14"},
+		{"('a' in topics || ('b' in topics)", "missing ')' at offset 33"},
+		{"'a' in topics)", "unexpected ')' at offset 13"},
+		{"()", "expected a topic, '(' or '!' at offset 1; got ')'"},
+		{"('a' in topics !)", "missing ')' at offset 15"},
+		{"'a in topics", "unterminated topic name at offset 0"},
+		{"'a*b' in topics", `invalid topic name "a*b" at offset 0`},
+		{"'a' topics", `expected "in" after topic name "a" at offset 4`},
+		{"'a' in", `expected "topics" after topic name "a" at offset 6`},
+		{"'a' intopics", `expected "in" after topic name "a" at offset 4`},
+	}
+	for _, tc := range invalid {
+		want := fmt.Sprintf("malformed condition %q: %s", tc.condition, tc.want)
+		if err := validateCondition(tc.condition); err == nil || err.Error() != want {
+			t.Errorf("validateCondition(%q) = %v; want = %q", tc.condition, err, want)
+		}
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	client, err := NewClient(context.Background(), testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() = %v; want = nil", err)
+	}
+	if err := (&Client{}).Close(); err != nil {
+		t.Errorf("Close() = %v; want = nil", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var tr *http.Request
+	var b []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr = r
+		b, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+
+	for _, tc := range validMessages {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := client.Send(ctx, tc.req)
+			if name != testMessageID || err != nil {
+				t.Errorf("Send(%s) = (%q, %v); want = (%q, nil)", tc.name, name, err, testMessageID)
+			}
+			checkFCMRequest(t, b, tr, tc.want, false)
+		})
+	}
+}
+
+func TestSendDryRun(t *testing.T) {
+	var tr *http.Request
+	var b []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr = r
+		b, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+
+	for _, tc := range validMessages {
+		t.Run(tc.name, func(t *testing.T) {
+			name, err := client.SendDryRun(ctx, tc.req)
+			if name != testMessageID || err != nil {
+				t.Errorf("SendDryRun(%s) = (%q, %v); want = (%q, nil)", tc.name, name, err, testMessageID)
+			}
+			checkFCMRequest(t, b, tr, tc.want, true)
+		})
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	var tr *http.Request
+	var b []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr = r
+		b, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+
+	if err := client.ValidateCredentials(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"data":  map[string]interface{}{"check": "credentials"},
+		"topic": credentialCheckTopic,
+	}
+	checkFCMRequest(t, b, tr, want, true)
+}
+
+func TestValidateCredentialsError(t *testing.T) {
+	var status int
+	var resp string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+	client.fcmClient.httpClient.RetryConfig = nil
+
+	cases := []struct {
+		name   string
+		status int
+		resp   string
+		want   string
+		check  func(error) bool
+	}{
+		{
+			name:   "PermissionDenied",
+			status: http.StatusForbidden,
+			resp:   `{"error": {"status": "PERMISSION_DENIED", "message": "caller lacks permission"}}`,
+			want: "credentials are not authorized to send messages in project \"test-project\"; make sure " +
+				"the service account has the cloudmessaging.messages.create permission: http error status: 403; " +
+				"reason: sender id does not match regisration token; code: mismatched-credential; " +
+				"details: caller lacks permission",
+			check: IsMismatchedCredential,
+		},
+		{
+			name:   "ServerError",
+			status: http.StatusInternalServerError,
+			resp:   `{"error": {"status": "INTERNAL", "message": "test error"}}`,
+			want: "failed to validate messaging credentials for project \"test-project\": http error status: 500; " +
+				"reason: backend servers encountered an unknown internl error; code: internal-error; " +
+				"details: test error",
+			check: IsInternal,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			status, resp = tc.status, tc.resp
+			err := client.ValidateCredentials(ctx)
+			if err == nil || err.Error() != tc.want || !tc.check(err) {
+				t.Errorf("ValidateCredentials() = %v; want = %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSendWithOptions(t *testing.T) {
+	var tr *http.Request
+	var b []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr = r
+		b, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+
+	for _, opts := range []*SendOptions{nil, {}, {AllowRetryOnTimeout: true}} {
+		for _, tc := range validMessages {
+			t.Run(tc.name, func(t *testing.T) {
+				name, err := client.SendWithOptions(ctx, tc.req, opts)
+				if name != testMessageID || err != nil {
+					t.Errorf("SendWithOptions(%s) = (%q, %v); want = (%q, nil)", tc.name, name, err, testMessageID)
+				}
+				checkFCMRequest(t, b, tr, tc.want, false)
+			})
+		}
+	}
+}
+
+func TestSendWithOptionsAnalyticsLabel(t *testing.T) {
+	var tr *http.Request
+	var b []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr = r
+		b, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+
+	cases := []struct {
+		name string
+		req  *Message
+		want map[string]interface{}
+	}{
+		{
+			name: "NoFCMOptions",
+			req:  &Message{Topic: "topic"},
+			want: map[string]interface{}{
+				"topic":       "topic",
+				"fcm_options": map[string]interface{}{"analytics_label": "campaign-1"},
+			},
+		},
+		{
+			name: "OwnLabel",
+			req: &Message{
+				Topic:      "topic",
+				FCMOptions: &FCMOptions{AnalyticsLabel: "own-label"},
+			},
+			want: map[string]interface{}{
+				"topic":       "topic",
+				"fcm_options": map[string]interface{}{"analytics_label": "own-label"},
+			},
+		},
+	}
+	opts := &SendOptions{AnalyticsLabel: "campaign-1"}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := *tc.req
+			name, err := client.SendWithOptions(ctx, tc.req, opts)
+			if name != testMessageID || err != nil {
+				t.Errorf("SendWithOptions(%s) = (%q, %v); want = (%q, nil)", tc.name, name, err, testMessageID)
+			}
+			checkFCMRequest(t, b, tr, tc.want, false)
+			if !reflect.DeepEqual(*tc.req, before) {
+				t.Errorf("SendWithOptions(%s) modified the input message", tc.name)
+			}
+		})
+	}
+
+	name, err := client.SendWithOptions(ctx, &Message{Topic: "topic"}, &SendOptions{AnalyticsLabel: "bad label"})
+	if name != "" || err == nil {
+		t.Errorf("SendWithOptions(bad label) = (%q, %v); want = (%q, error)", name, err, "")
+	}
+}
+
+func TestNewRateLimiterError(t *testing.T) {
+	cases := []struct {
+		rate  float64
+		burst int
+		want  string
+	}{
+		{0, 1, "messages per second must be a positive number; got 0"},
+		{-1, 1, "messages per second must be a positive number; got -1"},
+		{math.Inf(1), 1, "messages per second must be a positive number; got +Inf"},
+		{math.NaN(), 1, "messages per second must be a positive number; got NaN"},
+		{1, 0, "burst must be a positive integer; got 0"},
+		{1, -1, "burst must be a positive integer; got -1"},
+	}
+	for _, tc := range cases {
+		l, err := NewRateLimiter(tc.rate, tc.burst)
+		if l != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("NewRateLimiter(%v, %d) = (%v, %v); want = (nil, %q)", tc.rate, tc.burst, l, err, tc.want)
+		}
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l, err := NewRateLimiter(20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	start := time.Now()
+	if err := l.wait(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("wait(burst) took %v; want no delay", elapsed)
+	}
+
+	start = time.Now()
+	if err := l.wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("wait(1) took %v; want about %v", elapsed, 50*time.Millisecond)
+	}
+
+	var nilLimiter *RateLimiter
+	if err := nilLimiter.wait(ctx, 100); err != nil {
+		t.Errorf("wait(nil) = %v; want = nil", err)
+	}
+}
+
+func TestRateLimiterWaitCanceled(t *testing.T) {
+	l, err := NewRateLimiter(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.wait(ctx, 1); err != context.DeadlineExceeded {
+		t.Errorf("wait() = %v; want = %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("wait() took %v; want it to return when the context is done", elapsed)
+	}
+
+	// The canceled wait must not delay the next send by more than the regular interval.
+	l.mu.Lock()
+	tokens := l.tokens
+	l.mu.Unlock()
+	if tokens < -1 {
+		t.Errorf("tokens = %v; want >= -1", tokens)
+	}
+}
+
+func TestSendWithOptionsRateLimiter(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(context.Background(), testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+
+	limiter, err := NewRateLimiter(0.001, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &SendOptions{RateLimiter: limiter}
+	message := &Message{Topic: "topic"}
+
+	name, err := client.SendWithOptions(context.Background(), message, opts)
+	if name != testMessageID || err != nil {
+		t.Fatalf("SendWithOptions() = (%q, %v); want = (%q, nil)", name, err, testMessageID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	name, err = client.SendWithOptions(ctx, message, opts)
+	if name != "" || err != context.DeadlineExceeded {
+		t.Errorf("SendWithOptions() = (%q, %v); want = (\"\", %v)", name, err, context.DeadlineExceeded)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("SendWithOptions() made %d requests; want = 1", got)
+	}
+
+	// Invalid messages are rejected without waiting for the limiter.
+	if _, err := client.SendWithOptions(context.Background(), &Message{}, opts); err == nil {
+		t.Errorf("SendWithOptions(invalid) = nil; want = error")
+	}
+}
+
+func TestSendWithOptionsTimeout(t *testing.T) {
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+	client.fcmClient.httpClient.Client.Timeout = 10 * time.Millisecond
+	client.fcmClient.httpClient.RetryConfig.MaxRetries = 1
+	client.fcmClient.httpClient.RetryConfig.ExpBackoffFactor = 0
+
+	cases := []struct {
+		opts *SendOptions
+		want int32
+	}{
+		{nil, 1},
+		{&SendOptions{}, 1},
+		{&SendOptions{AllowRetryOnTimeout: true}, 2},
+	}
+	for _, tc := range cases {
+		atomic.StoreInt32(&count, 0)
+		name, err := client.SendWithOptions(ctx, &Message{Topic: "topic"}, tc.opts)
+		if name != "" || err == nil {
+			t.Errorf("SendWithOptions(%v) = (%q, %v); want = (%q, error)", tc.opts, name, err, "")
+		}
+		if got := atomic.LoadInt32(&count); got != tc.want {
+			t.Errorf("SendWithOptions(%v) attempts = %d; want = %d", tc.opts, got, tc.want)
+		}
+	}
+
+	atomic.StoreInt32(&count, 0)
+	if _, err := client.Send(ctx, &Message{Topic: "topic"}); err == nil {
+		t.Errorf("Send() = nil; want = error")
+	}
+	if got := atomic.LoadInt32(&count); got != 2 {
+		t.Errorf("Send() attempts = %d; want = 2", got)
+	}
+}
+
+func TestAPNSTTL(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	apnsClock = &internal.MockClock{Timestamp: now}
+	defer func() {
+		apnsClock = internal.SystemClock
+	}()
+	zero := time.Duration(0)
+
+	cases := []struct {
+		name   string
+		config *APNSConfig
+		want   map[string]interface{}
+	}{
+		{
+			name: "Computed",
+			config: &APNSConfig{
+				TTL: &ttl,
+			},
+			want: map[string]interface{}{"apns-expiration": "1500000010"},
+		},
+		{
+			name: "ComputedWithOtherHeaders",
+			config: &APNSConfig{
+				Headers: map[string]string{"apns-priority": "10"},
+				TTL:     &ttl,
+			},
+			want: map[string]interface{}{"apns-priority": "10", "apns-expiration": "1500000010"},
+		},
+		{
+			name: "ZeroTTL",
+			config: &APNSConfig{
+				TTL: &zero,
+			},
+			want: map[string]interface{}{"apns-expiration": "0"},
+		},
+		{
+			name: "ExplicitHeaderWins",
+			config: &APNSConfig{
+				Headers: map[string]string{"apns-expiration": "123"},
+				TTL:     &ttl,
+			},
+			want: map[string]interface{}{"apns-expiration": "123"},
+		},
+		{
+			name: "ExplicitHeaderWinsCaseInsensitive",
+			config: &APNSConfig{
+				Headers: map[string]string{"APNS-Expiration": "123"},
+				TTL:     &ttl,
+			},
+			want: map[string]interface{}{"APNS-Expiration": "123"},
+		},
+	}
+	for _, tc := range cases {
+		before := len(tc.config.Headers)
+		b, err := json.Marshal(&Message{APNS: tc.config, Topic: "topic"})
+		if err != nil {
+			t.Fatalf("Marshal(%s) = %v; want = nil", tc.name, err)
+		}
+		var parsed struct {
+			APNS struct {
+				Headers map[string]interface{} `json:"headers"`
+			} `json:"apns"`
+		}
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parsed.APNS.Headers, tc.want) {
+			t.Errorf("Marshal(%s) headers = %v; want = %v", tc.name, parsed.APNS.Headers, tc.want)
+		}
+		if len(tc.config.Headers) != before {
+			t.Errorf("Marshal(%s) modified caller headers: %v", tc.name, tc.config.Headers)
+		}
+	}
+}
+
+func TestMessageTTL(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	apnsClock = &internal.MockClock{Timestamp: now}
+	defer func() {
+		apnsClock = internal.SystemClock
+	}()
+	zero := time.Duration(0)
+	androidTTL := 20 * time.Second
+	apnsTTL := 30 * time.Second
+
+	cases := []struct {
+		name        string
+		msg         *Message
+		wantAndroid interface{}
+		wantAPNS    interface{}
+	}{
+		{
+			name:        "NoPlatformConfigs",
+			msg:         &Message{TTL: &ttl},
+			wantAndroid: "10s",
+			wantAPNS:    "1500000010",
+		},
+		{
+			name:        "ZeroTTL",
+			msg:         &Message{TTL: &zero},
+			wantAndroid: "0s",
+			wantAPNS:    "0",
+		},
+		{
+			name: "PlatformTTLsWin",
+			msg: &Message{
+				TTL:     &ttl,
+				Android: &AndroidConfig{TTL: &androidTTL},
+				APNS:    &APNSConfig{TTL: &apnsTTL},
 			},
-			target: &Aps{},
+			wantAndroid: "20s",
+			wantAPNS:    "1500000030",
+		},
+		{
+			name: "ExplicitHeaderWins",
+			msg: &Message{
+				TTL: &ttl,
+				APNS: &APNSConfig{
+					Headers: map[string]string{"apns-expiration": "123"},
+				},
+			},
+			wantAndroid: "10s",
+			wantAPNS:    "123",
+		},
+		{
+			name: "WithNotification",
+			msg: &Message{
+				TTL:          &ttl,
+				Notification: &Notification{Title: "t"},
+				APNS:         &APNSConfig{},
+			},
+			wantAndroid: "10s",
+			wantAPNS:    "1500000010",
 		},
 	}
 	for _, tc := range cases {
-		b, err := json.Marshal(tc.req)
+		android, apns := tc.msg.Android, tc.msg.APNS
+		tc.msg.Topic = "topic"
+		b, err := json.Marshal(tc.msg)
 		if err != nil {
-			t.Errorf("Marshal(%s) = %v; want = nil", tc.name, err)
+			t.Fatalf("Marshal(%s) = %v; want = nil", tc.name, err)
 		}
-		if err := json.Unmarshal(b, tc.target); err == nil {
-			t.Errorf("Unmarshal(%s) = %v; want = error", tc.name, err)
+		var parsed struct {
+			Android map[string]interface{} `json:"android"`
+			APNS    struct {
+				Headers map[string]interface{} `json:"headers"`
+			} `json:"apns"`
+		}
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Android["ttl"] != tc.wantAndroid {
+			t.Errorf("Marshal(%s) android.ttl = %v; want = %v", tc.name, parsed.Android["ttl"], tc.wantAndroid)
+		}
+		if parsed.APNS.Headers["apns-expiration"] != tc.wantAPNS {
+			t.Errorf("Marshal(%s) apns-expiration = %v; want = %v",
+				tc.name, parsed.APNS.Headers["apns-expiration"], tc.wantAPNS)
+		}
+		if tc.msg.Android != android || tc.msg.APNS != apns ||
+			(android != nil && android.TTL == &ttl) || (apns != nil && apns.TTL == &ttl) {
+			t.Errorf("Marshal(%s) modified the message", tc.name)
 		}
 	}
 }
 
-func TestSend(t *testing.T) {
-	var tr *http.Request
-	var b []byte
+func TestMessageCollapseID(t *testing.T) {
+	cases := []struct {
+		name        string
+		msg         *Message
+		wantAndroid interface{}
+		wantWebpush interface{}
+		wantAPNS    interface{}
+	}{
+		{
+			name:        "NoPlatformConfigs",
+			msg:         &Message{CollapseID: "score"},
+			wantAndroid: "score",
+			wantWebpush: "score",
+			wantAPNS:    "score",
+		},
+		{
+			name: "PlatformSettingsWin",
+			msg: &Message{
+				CollapseID: "score",
+				Android:    &AndroidConfig{CollapseKey: "android"},
+				Webpush:    &WebpushConfig{Headers: map[string]string{"topic": "webpush"}},
+				APNS:       &APNSConfig{Headers: map[string]string{"apns-collapse-id": "apns"}},
+			},
+			wantAndroid: "android",
+			wantWebpush: nil,
+			wantAPNS:    "apns",
+		},
+		{
+			name: "LongIDWithPlatformOverrides",
+			msg: &Message{
+				CollapseID: strings.Repeat("a", 40),
+				Webpush:    &WebpushConfig{Headers: map[string]string{"Topic": "webpush"}},
+			},
+			wantAndroid: strings.Repeat("a", 40),
+			wantWebpush: "webpush",
+			wantAPNS:    strings.Repeat("a", 40),
+		},
+		{
+			name: "WithNotification",
+			msg: &Message{
+				CollapseID:   "score",
+				Notification: &Notification{Title: "t"},
+				APNS:         &APNSConfig{Headers: map[string]string{"apns-priority": "5"}},
+			},
+			wantAndroid: "score",
+			wantWebpush: "score",
+			wantAPNS:    "score",
+		},
+	}
+	for _, tc := range cases {
+		tc.msg.Topic = "topic"
+		if err := validateMessage(tc.msg); err != nil {
+			t.Fatalf("validateMessage(%s) = %v; want = nil", tc.name, err)
+		}
+		var apnsHeaders map[string]string
+		if tc.msg.APNS != nil {
+			apnsHeaders = make(map[string]string)
+			for k, v := range tc.msg.APNS.Headers {
+				apnsHeaders[k] = v
+			}
+		}
+		android, webpush, apns := tc.msg.Android, tc.msg.Webpush, tc.msg.APNS
+		b, err := json.Marshal(tc.msg)
+		if err != nil {
+			t.Fatalf("Marshal(%s) = %v; want = nil", tc.name, err)
+		}
+		var parsed struct {
+			Android map[string]interface{} `json:"android"`
+			Webpush struct {
+				Headers map[string]interface{} `json:"headers"`
+			} `json:"webpush"`
+			APNS struct {
+				Headers map[string]interface{} `json:"headers"`
+			} `json:"apns"`
+		}
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if parsed.Android["collapse_key"] != tc.wantAndroid {
+			t.Errorf("Marshal(%s) android.collapse_key = %v; want = %v",
+				tc.name, parsed.Android["collapse_key"], tc.wantAndroid)
+		}
+		if parsed.Webpush.Headers["Topic"] != tc.wantWebpush {
+			t.Errorf("Marshal(%s) webpush Topic = %v; want = %v", tc.name, parsed.Webpush.Headers["Topic"], tc.wantWebpush)
+		}
+		if parsed.APNS.Headers["apns-collapse-id"] != tc.wantAPNS {
+			t.Errorf("Marshal(%s) apns-collapse-id = %v; want = %v",
+				tc.name, parsed.APNS.Headers["apns-collapse-id"], tc.wantAPNS)
+		}
+		if tc.msg.Android != android || tc.msg.Webpush != webpush || tc.msg.APNS != apns ||
+			(apns != nil && !reflect.DeepEqual(apns.Headers, apnsHeaders)) {
+			t.Errorf("Marshal(%s) modified the message", tc.name)
+		}
+	}
+}
+
+func TestLocalization(t *testing.T) {
+	l := &Localization{
+		TitleLocKey:  "title.key",
+		TitleLocArgs: []string{"t1"},
+		BodyLocKey:   "body.key",
+		BodyLocArgs:  []string{"b1", "b2"},
+	}
+	cases := []struct {
+		name string
+		msg  *Message
+	}{
+		{"Empty", &Message{Topic: "topic"}},
+		{"Existing", &Message{
+			Topic: "topic",
+			Android: &AndroidConfig{
+				Notification: &AndroidNotification{Color: "#112233"},
+			},
+			APNS: &APNSConfig{
+				Payload: &APNSPayload{
+					Aps: &Aps{AlertString: "alert"},
+				},
+			},
+		}},
+	}
+	for _, tc := range cases {
+		l.Apply(tc.msg)
+		if err := validateMessage(tc.msg); err != nil {
+			t.Errorf("validateMessage(%s) = %v; want = nil", tc.name, err)
+		}
+
+		an := tc.msg.Android.Notification
+		if an.TitleLocKey != "title.key" || !reflect.DeepEqual(an.TitleLocArgs, []string{"t1"}) ||
+			an.BodyLocKey != "body.key" || !reflect.DeepEqual(an.BodyLocArgs, []string{"b1", "b2"}) {
+			t.Errorf("Apply(%s) AndroidNotification = %#v", tc.name, an)
+		}
+		alert := tc.msg.APNS.Payload.Aps.Alert
+		if alert.TitleLocKey != "title.key" || !reflect.DeepEqual(alert.TitleLocArgs, []string{"t1"}) ||
+			alert.LocKey != "body.key" || !reflect.DeepEqual(alert.LocArgs, []string{"b1", "b2"}) {
+			t.Errorf("Apply(%s) ApsAlert = %#v", tc.name, alert)
+		}
+	}
+
+	existing := cases[1].msg
+	if existing.Android.Notification.Color != "#112233" {
+		t.Errorf("Apply() Color = %q; want = %q", existing.Android.Notification.Color, "#112233")
+	}
+	if aps := existing.APNS.Payload.Aps; aps.AlertString != "" || aps.Alert.Body != "alert" {
+		t.Errorf("Apply() Aps = %#v; want AlertString moved into Alert.Body", aps)
+	}
+
+	l.BodyLocArgs[0] = "changed"
+	if got := existing.Android.Notification.BodyLocArgs[0]; got != "b1" {
+		t.Errorf("Apply() BodyLocArgs[0] = %q; want = %q", got, "b1")
+	}
+}
+
+type metadataTransport struct {
+	rt http.RoundTripper
+	md []map[string]string
+}
+
+func (m *metadataTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	m.md = append(m.md, MetadataFromContext(r.Context()))
+	return m.rt.RoundTrip(r)
+}
+
+func TestSendWithMetadata(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tr = r
-		b, _ = ioutil.ReadAll(r.Body)
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
 	}))
@@ -929,44 +2421,306 @@ func TestSend(t *testing.T) {
 		t.Fatal(err)
 	}
 	client.fcmEndpoint = ts.URL
+	tr := &metadataTransport{rt: client.fcmClient.httpClient.Client.Transport}
+	client.fcmClient.httpClient.Client.Transport = tr
 
-	for _, tc := range validMessages {
-		t.Run(tc.name, func(t *testing.T) {
-			name, err := client.Send(ctx, tc.req)
-			if name != testMessageID || err != nil {
-				t.Errorf("Send(%s) = (%q, %v); want = (%q, nil)", tc.name, name, err, testMessageID)
-			}
-			checkFCMRequest(t, b, tr, tc.want, false)
-		})
+	ctx = WithMetadata(ctx, map[string]string{"correlation-id": "abc", "user": "u1"})
+	ctx = WithMetadata(ctx, map[string]string{"correlation-id": "xyz"})
+	if _, err := client.Send(ctx, &Message{Topic: "topic"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []map[string]string{{"correlation-id": "xyz", "user": "u1"}}
+	if !reflect.DeepEqual(tr.md, want) {
+		t.Errorf("MetadataFromContext() = %v; want = %v", tr.md, want)
 	}
 }
 
-func TestSendDryRun(t *testing.T) {
-	var tr *http.Request
-	var b []byte
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tr = r
-		b, _ = ioutil.ReadAll(r.Body)
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte("{ \"name\":\"" + testMessageID + "\" }"))
-	}))
-	defer ts.Close()
+func TestMetadataFromContextEmpty(t *testing.T) {
+	if md := MetadataFromContext(context.Background()); md != nil {
+		t.Errorf("MetadataFromContext() = %v; want = nil", md)
+	}
+}
 
-	ctx := context.Background()
-	client, err := NewClient(ctx, testMessagingConfig)
+func TestHighPriorityDataMessage(t *testing.T) {
+	original := &Message{
+		Data:  map[string]string{"k": "v"},
+		Token: "token",
+		Android: &AndroidConfig{
+			CollapseKey: "ck",
+		},
+		APNS: &APNSConfig{
+			Headers: map[string]string{"apns-collapse-id": "id"},
+		},
+	}
+	m, err := HighPriorityDataMessage(original)
 	if err != nil {
 		t.Fatal(err)
 	}
-	client.fcmEndpoint = ts.URL
+	if err := validateMessage(m); err != nil {
+		t.Errorf("validateMessage() = %v; want = nil", err)
+	}
 
-	for _, tc := range validMessages {
-		t.Run(tc.name, func(t *testing.T) {
-			name, err := client.SendDryRun(ctx, tc.req)
-			if name != testMessageID || err != nil {
-				t.Errorf("SendDryRun(%s) = (%q, %v); want = (%q, nil)", tc.name, name, err, testMessageID)
-			}
-			checkFCMRequest(t, b, tr, tc.want, true)
-		})
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"data":  map[string]interface{}{"k": "v"},
+		"token": "token",
+		"android": map[string]interface{}{
+			"collapse_key": "ck",
+			"priority":     "high",
+		},
+		"apns": map[string]interface{}{
+			"headers": map[string]interface{}{
+				"apns-collapse-id": "id",
+				"apns-push-type":   "background",
+				"apns-priority":    "5",
+			},
+			"payload": map[string]interface{}{
+				"aps": map[string]interface{}{"content-available": float64(1)},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("HighPriorityDataMessage() = %v; want = %v", got, want)
+	}
+
+	if original.Android.Priority != "" || len(original.APNS.Headers) != 1 || original.APNS.Payload != nil {
+		t.Errorf("HighPriorityDataMessage() modified the original message: %#v", original)
+	}
+}
+
+func TestNewAPNSConfig(t *testing.T) {
+	badge := 3
+	cases := []struct {
+		name string
+		n    *APNSNotification
+		want map[string]interface{}
+	}{
+		{
+			name: "Alert",
+			n:    &APNSNotification{Title: "t", Body: "b", Badge: &badge, Sound: "default"},
+			want: map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "alert", "apns-priority": "10"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert": map[string]interface{}{"title": "t", "body": "b"},
+						"badge": float64(3),
+						"sound": "default",
+					},
+				},
+			},
+		},
+		{
+			name: "BadgeOnly",
+			n:    &APNSNotification{Badge: &badge},
+			want: map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "alert", "apns-priority": "10"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{"badge": float64(3)},
+				},
+			},
+		},
+		{
+			name: "AlertWithContentAvailable",
+			n:    &APNSNotification{Body: "b", ContentAvailable: true},
+			want: map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "alert", "apns-priority": "10"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{
+						"alert":             map[string]interface{}{"body": "b"},
+						"content-available": float64(1),
+					},
+				},
+			},
+		},
+		{
+			name: "ContentAvailableOnly",
+			n:    &APNSNotification{ContentAvailable: true},
+			want: map[string]interface{}{
+				"headers": map[string]interface{}{"apns-push-type": "background", "apns-priority": "5"},
+				"payload": map[string]interface{}{
+					"aps": map[string]interface{}{"content-available": float64(1)},
+				},
+			},
+		},
+	}
+	for _, tc := range cases {
+		config, err := NewAPNSConfig(tc.n)
+		if err != nil {
+			t.Fatalf("NewAPNSConfig(%s) = %v", tc.name, err)
+		}
+		m := &Message{APNS: config, Token: "token"}
+		if err := validateMessage(m); err != nil {
+			t.Errorf("validateMessage(%s) = %v; want = nil", tc.name, err)
+		}
+
+		b, err := json.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("NewAPNSConfig(%s) = %v; want = %v", tc.name, got, tc.want)
+		}
+	}
+
+	// The badge is copied, so that later changes to the notification do not affect the config.
+	n := &APNSNotification{Badge: &badge}
+	config, err := NewAPNSConfig(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badge = 5
+	if *config.Payload.Aps.Badge != 3 {
+		t.Errorf("NewAPNSConfig().Badge = %d; want = 3", *config.Payload.Aps.Badge)
+	}
+}
+
+func TestNewAPNSConfigError(t *testing.T) {
+	cases := []struct {
+		n    *APNSNotification
+		want string
+	}{
+		{nil, "apns notification must not be nil"},
+		{
+			&APNSNotification{},
+			"apns notification must specify at least one of title, body, badge, sound or content-available",
+		},
+	}
+	for _, tc := range cases {
+		config, err := NewAPNSConfig(tc.n)
+		if config != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("NewAPNSConfig(%v) = (%v, %v); want = (nil, %q)", tc.n, config, err, tc.want)
+		}
+	}
+}
+
+func TestHighPriorityDataMessageError(t *testing.T) {
+	cases := []struct {
+		name string
+		msg  *Message
+		want string
+	}{
+		{
+			name: "Nil",
+			want: "message must not be nil",
+		},
+		{
+			name: "Notification",
+			msg:  &Message{Notification: &Notification{Title: "t"}},
+			want: "data-only message must not specify a notification",
+		},
+		{
+			name: "AndroidNotification",
+			msg:  &Message{Android: &AndroidConfig{Notification: &AndroidNotification{Title: "t"}}},
+			want: "data-only message must not specify an android notification",
+		},
+		{
+			name: "WebpushNotification",
+			msg:  &Message{Webpush: &WebpushConfig{Notification: &WebpushNotification{Title: "t"}}},
+			want: "data-only message must not specify a webpush notification",
+		},
+		{
+			name: "ApsAlert",
+			msg: &Message{APNS: &APNSConfig{
+				Payload: &APNSPayload{Aps: &Aps{AlertString: "a"}},
+			}},
+			want: "data-only message must not specify an alert, sound or badge in the aps dictionary",
+		},
+		{
+			name: "ApsBadge",
+			msg: &Message{APNS: &APNSConfig{
+				Payload: &APNSPayload{Aps: &Aps{Badge: &badge}},
+			}},
+			want: "data-only message must not specify an alert, sound or badge in the aps dictionary",
+		},
+		{
+			name: "APNSPushType",
+			msg: &Message{APNS: &APNSConfig{
+				Headers: map[string]string{"apns-push-type": "alert"},
+			}},
+			want: `data-only message must not specify apns-push-type header other than "background"`,
+		},
+		{
+			name: "APNSPriority",
+			msg: &Message{APNS: &APNSConfig{
+				Headers: map[string]string{"APNS-Priority": "10"},
+			}},
+			want: `data-only message must not specify apns-priority header other than "5"`,
+		},
+	}
+	for _, tc := range cases {
+		m, err := HighPriorityDataMessage(tc.msg)
+		if m != nil || err == nil || err.Error() != tc.want {
+			t.Errorf("HighPriorityDataMessage(%s) = (%v, %v); want = (nil, %q)", tc.name, m, err, tc.want)
+		}
+	}
+}
+
+func TestPayloadSizeAtLimit(t *testing.T) {
+	// {"k":"..."} adds 8 bytes to the value.
+	value := strings.Repeat("a", maxPayloadSize-8)
+	message := &Message{
+		Data:    map[string]string{"k": value},
+		Android: &AndroidConfig{Priority: "high"},
+		APNS:    &APNSConfig{Headers: map[string]string{"apns-priority": "5"}},
+		Webpush: &WebpushConfig{Headers: map[string]string{"Urgency": "high"}},
+		Topic:   "topic",
+	}
+	if err := validateMessage(message); err != nil {
+		t.Errorf("validateMessage() = %v; want = nil", err)
+	}
+
+	message.Data["k"] = value + "a"
+	want := "message payload size of 4097 bytes exceeds the limit of 4096 bytes"
+	if err := validateMessage(message); err == nil || err.Error() != want {
+		t.Errorf("validateMessage() = %v; want = %q", err, want)
+	}
+
+	// Platform overrides replace the common fields rather than adding to them.
+	half := strings.Repeat("a", maxPayloadSize/2)
+	message.Data = map[string]string{"k": half}
+	message.Android.Data = map[string]string{"k": half}
+	message.Webpush.Data = map[string]string{"k": half}
+	message.Notification = &Notification{Title: half}
+	message.Android.Notification = &AndroidNotification{Title: "t"}
+	message.Webpush.Notification = &WebpushNotification{Title: "t"}
+	message.APNS.Payload = &APNSPayload{Aps: &Aps{Alert: &ApsAlert{Title: "t"}}}
+	want = "message payload size of 4116 bytes exceeds the limit of 4096 bytes"
+	if err := validateMessage(message); err == nil || err.Error() != want {
+		t.Errorf("validateMessage() = %v; want = %q", err, want)
+	}
+	message.Notification = nil
+	if err := validateMessage(message); err != nil {
+		t.Errorf("validateMessage() = %v; want = nil", err)
+	}
+}
+
+func TestShortMessageID(t *testing.T) {
+	cases := []struct {
+		name, want string
+	}{
+		{testMessageID, "msg_id"},
+		{"projects/test-project/messages/msg_id/", "msg_id"},
+		{" projects/test-project/messages/msg_id ", "msg_id"},
+		{"messages/msg_id", "msg_id"},
+		{"msg_id", "msg_id"},
+		{"", ""},
+	}
+	for _, tc := range cases {
+		if got := ShortMessageID(tc.name); got != tc.want {
+			t.Errorf("ShortMessageID(%q) = %q; want = %q", tc.name, got, tc.want)
+		}
 	}
 }
 
@@ -996,6 +2750,51 @@ func TestSendError(t *testing.T) {
 	}
 }
 
+func TestSendHTMLError(t *testing.T) {
+	body := "<html>\n<head><title>502 Bad Gateway</title></head>\n<body>" + strings.Repeat("x", 500) + "</body>\n</html>"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.fcmEndpoint = ts.URL
+	client.fcmClient.httpClient.RetryConfig = nil
+
+	name, err := client.Send(ctx, &Message{Topic: "topic"})
+	want := "http error status: 502; reason: server responded with a non-JSON error; code: server-unavailable; " +
+		"response: <html> <head><title>502 Bad Gateway</title></head> <body>xxx"
+	if name != "" || err == nil || !strings.HasPrefix(err.Error(), want) || !IsServerUnavailable(err) {
+		t.Fatalf("Send() = (%q, %v); want = (%q, %q...)", name, err, "", want)
+	}
+	if !strings.HasSuffix(err.Error(), "...") || strings.Contains(err.Error(), "</html>") {
+		t.Errorf("Send() = %q; want a truncated response snippet", err.Error())
+	}
+}
+
+func TestErrorSnippet(t *testing.T) {
+	cases := []struct {
+		body, want string
+	}{
+		{"", ""},
+		{"  short\n\tbody  ", "short body"},
+		{strings.Repeat("a", maxErrorSnippetLength), strings.Repeat("a", maxErrorSnippetLength)},
+		{strings.Repeat("a", maxErrorSnippetLength+1), strings.Repeat("a", maxErrorSnippetLength) + "..."},
+		{strings.Repeat("a", maxErrorSnippetLength-1) + "\u00e9", strings.Repeat("a", maxErrorSnippetLength-1) + "..."},
+	}
+	for _, tc := range cases {
+		if got := errorSnippet([]byte(tc.body)); got != tc.want {
+			t.Errorf("errorSnippet(%q) = %q; want = %q", tc.body, got, tc.want)
+		}
+	}
+}
+
 func TestInvalidMessage(t *testing.T) {
 	ctx := context.Background()
 	client, err := NewClient(ctx, testMessagingConfig)
@@ -1108,7 +2907,7 @@ var httpErrors = []struct {
 	},
 	{
 		resp:  "not json",
-		want:  "http error status: 500; reason: server responded with an unknown error; response: not json",
-		check: IsUnknown,
+		want:  "http error status: 500; reason: server responded with a non-JSON error; code: internal-error; response: not json",
+		check: IsInternal,
 	},
 }
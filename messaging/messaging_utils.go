@@ -15,32 +15,60 @@
 package messaging
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
-	bareTopicNamePattern = regexp.MustCompile("^[a-zA-Z0-9-_.~%]+$")
-	colorPattern         = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+	bareTopicNamePattern  = regexp.MustCompile("^[a-zA-Z0-9-_.~%]+$")
+	colorPattern          = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+	analyticsLabelPattern = regexp.MustCompile("^[a-zA-Z0-9-_.~%]{1,50}$")
+	webpushTopicPattern   = regexp.MustCompile("^[a-zA-Z0-9-_]{1,32}$")
 )
 
+const maxAPNSCollapseIDBytes = 64
+
 func validateMessage(message *Message) error {
 	if message == nil {
 		return fmt.Errorf("message must not be nil")
 	}
 
 	targets := countNonEmpty(message.Token, message.Condition, message.Topic)
+	if len(message.Tokens) > 0 {
+		targets++
+	}
 	if targets != 1 {
-		return fmt.Errorf("exactly one of token, topic or condition must be specified")
+		return fmt.Errorf("exactly one of token, tokens, topic or condition must be specified")
+	}
+	if len(message.Tokens) > 1 {
+		return fmt.Errorf("message with more than one token must be sent with SendToTokens")
+	}
+	if len(message.Tokens) == 1 && message.Tokens[0] == "" {
+		return fmt.Errorf("tokens must not contain empty strings")
+	}
+	if message.TTL != nil && message.TTL.Seconds() < 0 {
+		return fmt.Errorf("message ttl duration must not be negative")
+	}
+	if err := validateCollapseID(message); err != nil {
+		return err
 	}
 
 	// validate topic
 	if message.Topic != "" {
-		bt := strings.TrimPrefix(message.Topic, "/topics/")
-		if !bareTopicNamePattern.MatchString(bt) {
-			return fmt.Errorf("malformed topic name")
+		if err := validateTopic(message.Topic); err != nil {
+			return err
+		}
+	}
+
+	// validate condition
+	if message.Condition != "" {
+		if err := validateCondition(message.Condition); err != nil {
+			return err
 		}
 	}
 
@@ -60,7 +88,128 @@ func validateMessage(message *Message) error {
 	}
 
 	// validate APNSConfig
-	return validateAPNSConfig(message.APNS)
+	if err := validateAPNSConfig(message.APNS); err != nil {
+		return err
+	}
+
+	return validatePayloadSize(message)
+}
+
+// maxPayloadSize is the maximum size in bytes of the payload FCM delivers to a device.
+const maxPayloadSize = 4096
+
+// validatePayloadSize checks the estimated size of the payload delivered to each platform against the FCM limit.
+//
+// The estimate is the size of the JSON-serialized data and notification a device of each platform receives,
+// taking platform-specific overrides into account. Fields that are not set are not counted, and neither are
+// the keys of the request that only describe where the fields go. The estimate may therefore be slightly
+// smaller than what FCM computes, so that messages accepted by FCM are never rejected locally.
+func validatePayloadSize(message *Message) error {
+	var fields []interface{}
+	if len(message.Data) > 0 {
+		fields = append(fields, message.Data)
+	}
+	if message.Notification != nil {
+		fields = append(fields, message.Notification)
+	}
+	if err := checkPayloadSize("message", fields); err != nil {
+		return err
+	}
+
+	if message.Android != nil {
+		fields = nil
+		if message.Android.Data != nil {
+			fields = append(fields, message.Android.Data)
+		} else if len(message.Data) > 0 {
+			fields = append(fields, message.Data)
+		}
+		if message.Android.Notification != nil {
+			fields = append(fields, message.Android.Notification)
+		} else if message.Notification != nil {
+			fields = append(fields, message.Notification)
+		}
+		if err := checkPayloadSize("android", fields); err != nil {
+			return err
+		}
+	}
+
+	if message.APNS != nil {
+		// Data and custom data are delivered as top-level keys of the APNs payload, next to "aps".
+		payload := make(map[string]interface{})
+		for k, v := range message.Data {
+			payload[k] = v
+		}
+		if message.APNS.Payload != nil {
+			for k, v := range message.APNS.Payload.CustomData {
+				payload[k] = v
+			}
+			if message.APNS.Payload.Aps != nil {
+				payload["aps"] = message.APNS.Payload.Aps
+			}
+		}
+		if _, ok := payload["aps"]; !ok && message.Notification != nil {
+			payload["aps"] = map[string]interface{}{"alert": message.Notification}
+		}
+		if err := checkPayloadSize("apns", []interface{}{payload}); err != nil {
+			return err
+		}
+	}
+
+	if message.Webpush != nil {
+		fields = nil
+		if message.Webpush.Data != nil {
+			fields = append(fields, message.Webpush.Data)
+		} else if len(message.Data) > 0 {
+			fields = append(fields, message.Data)
+		}
+		if message.Webpush.Notification != nil {
+			fields = append(fields, message.Webpush.Notification)
+		} else if message.Notification != nil {
+			fields = append(fields, message.Notification)
+		}
+		if err := checkPayloadSize("webpush", fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPayloadSize checks the total size of the given payload fields once serialized. Each field is measured
+// on its own, without the object that encloses it in the request.
+func checkPayloadSize(platform string, fields []interface{}) error {
+	size := 0
+	for _, field := range fields {
+		b, err := json.Marshal(field)
+		if err != nil {
+			return err
+		}
+		size += len(b)
+	}
+	if size > maxPayloadSize {
+		return fmt.Errorf("%s payload size of %d bytes exceeds the limit of %d bytes", platform, size, maxPayloadSize)
+	}
+	return nil
+}
+
+// validateCollapseID checks the CollapseID of the message against the limits of the platforms it is sent to,
+// i.e. the platforms that do not override it.
+func validateCollapseID(message *Message) error {
+	if message.CollapseID == "" {
+		return nil
+	}
+	if message.APNS == nil || !hasHeader(message.APNS.Headers, apnsCollapseIDHeader) {
+		if len(message.CollapseID) > maxAPNSCollapseIDBytes {
+			return fmt.Errorf("collapse id must not be longer than %d bytes for apns; got %d bytes",
+				maxAPNSCollapseIDBytes, len(message.CollapseID))
+		}
+	}
+	if message.Webpush == nil || !hasHeader(message.Webpush.Headers, webpushTopicHeader) {
+		if !webpushTopicPattern.MatchString(message.CollapseID) {
+			return fmt.Errorf("collapse id must consist of at most 32 characters from [a-zA-Z0-9-_] for "+
+				"webpush; got %q", message.CollapseID)
+		}
+	}
+	return nil
 }
 
 func validateNotification(notification *Notification) error {
@@ -112,11 +261,19 @@ func validateAndroidNotification(notification *AndroidNotification) error {
 			return fmt.Errorf("invalid image URL: %q", image)
 		}
 	}
+	for _, t := range notification.VibrateTimings {
+		if t < 0 {
+			return fmt.Errorf("vibrate timings must not be negative")
+		}
+	}
 	return nil
 }
 
 func validateAPNSConfig(config *APNSConfig) error {
 	if config != nil {
+		if config.TTL != nil && config.TTL.Seconds() < 0 {
+			return fmt.Errorf("apns ttl duration must not be negative")
+		}
 		// validate FCMOptions
 		if config.FCMOptions != nil {
 			image := config.FCMOptions.ImageURL
@@ -149,6 +306,16 @@ func validateAps(aps *Aps) error {
 		if aps.Alert != nil && aps.AlertString != "" {
 			return fmt.Errorf("multiple alert specifications")
 		}
+		if aps.InterruptionLevel != "" && aps.InterruptionLevel != "passive" && aps.InterruptionLevel != "active" &&
+			aps.InterruptionLevel != "time-sensitive" && aps.InterruptionLevel != "critical" {
+			return fmt.Errorf("interruptionLevel must be 'passive', 'active', 'time-sensitive' or 'critical'")
+		}
+		if aps.Event != "" && aps.Event != "start" && aps.Event != "update" && aps.Event != "end" {
+			return fmt.Errorf("event must be 'start', 'update' or 'end'")
+		}
+		if aps.Timestamp < 0 || aps.DismissalDate < 0 {
+			return fmt.Errorf("timestamp and dismissal date must not be negative")
+		}
 		if aps.CriticalSound != nil {
 			if aps.Sound != "" {
 				return fmt.Errorf("multiple sound specifications")
@@ -210,6 +377,156 @@ func validateWebpushConfig(webpush *WebpushConfig) error {
 	return nil
 }
 
+const maxConditionTopics = 5
+
+// validateTopic checks that the given topic name, with or without the "/topics/" prefix, only
+// contains the characters allowed by FCM. Names containing any other characters, including
+// non-ASCII letters, are rejected by the backend with an error that does not mention the topic.
+func validateTopic(topic string) error {
+	return validateTopicName(topic, strings.TrimPrefix(topic, "/topics/"))
+}
+
+// validateTopicName checks the bare name extracted from the given topic, and reports errors with the
+// topic as specified by the caller.
+func validateTopicName(topic, name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid topic name %q: topic name must not be empty", topic)
+	}
+	if !bareTopicNamePattern.MatchString(name) {
+		return fmt.Errorf(
+			"invalid topic name %q: topic names must only contain ASCII letters, digits and the characters \"-_.~%%\"",
+			topic)
+	}
+	return nil
+}
+
+// validateCondition checks that the given condition is a well-formed FCM topic condition, such as
+// "'TopicA' in topics && ('TopicB' in topics || !('TopicC' in topics))", referring to at most 5 topics.
+//
+// The grammar accepted is:
+//
+//	expr  = term { ("&&" | "||") term }
+//	term  = "!" term | "(" expr ")" | quoted-topic-name "in" "topics"
+//
+// Tokens may be separated by any white space, including line breaks.
+func validateCondition(condition string) error {
+	p := &conditionParser{input: condition}
+	if err := p.parseExpr(); err != nil {
+		return fmt.Errorf("malformed condition %q: %v", condition, err)
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return fmt.Errorf("malformed condition %q: unexpected %q at offset %d", condition, p.input[p.pos], p.pos)
+	}
+	if p.topics > maxConditionTopics {
+		return fmt.Errorf("condition must not refer to more than %d topics; got %d", maxConditionTopics, p.topics)
+	}
+	return nil
+}
+
+type conditionParser struct {
+	input  string
+	pos    int
+	topics int
+}
+
+func (p *conditionParser) parseExpr() error {
+	if err := p.parseTerm(); err != nil {
+		return err
+	}
+	for {
+		p.skipSpace()
+		if !p.consume("&&") && !p.consume("||") {
+			return nil
+		}
+		if err := p.parseTerm(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *conditionParser) parseTerm() error {
+	p.skipSpace()
+	if p.pos == len(p.input) {
+		return fmt.Errorf("expected a topic, '(' or '!' at end of input")
+	}
+	switch c := p.input[p.pos]; c {
+	case '!':
+		p.pos++
+		return p.parseTerm()
+	case '(':
+		p.pos++
+		if err := p.parseExpr(); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if !p.consume(")") {
+			return fmt.Errorf("missing ')' at offset %d", p.pos)
+		}
+		return nil
+	case '\'', '"':
+		return p.parseTopic(c)
+	default:
+		return fmt.Errorf("expected a topic, '(' or '!' at offset %d; got %q", p.pos, c)
+	}
+}
+
+func (p *conditionParser) parseTopic(quote byte) error {
+	start := p.pos + 1
+	end := strings.IndexByte(p.input[start:], quote)
+	if end == -1 {
+		return fmt.Errorf("unterminated topic name at offset %d", p.pos)
+	}
+	name := p.input[start : start+end]
+	if !bareTopicNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid topic name %q at offset %d", name, p.pos)
+	}
+	p.pos = start + end + 1
+	p.topics++
+
+	for _, keyword := range []string{"in", "topics"} {
+		p.skipSpace()
+		if !p.consumeKeyword(keyword) {
+			return fmt.Errorf("expected %q after topic name %q at offset %d", keyword, name, p.pos)
+		}
+	}
+	return nil
+}
+
+func (p *conditionParser) consume(token string) bool {
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+// consumeKeyword consumes the given keyword, provided it is not immediately followed by another letter.
+func (p *conditionParser) consumeKeyword(keyword string) bool {
+	rest := p.input[p.pos:]
+	if !strings.HasPrefix(rest, keyword) {
+		return false
+	}
+	if len(rest) > len(keyword) {
+		if c := rest[len(keyword)]; c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			return false
+		}
+	}
+	p.pos += len(keyword)
+	return true
+}
+
+// skipSpace skips any white space, including line breaks, so that long conditions can be wrapped.
+func (p *conditionParser) skipSpace() {
+	for p.pos < len(p.input) {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if !unicode.IsSpace(r) {
+			return
+		}
+		p.pos += size
+	}
+}
+
 func countNonEmpty(strings ...string) int {
 	count := 0
 	for _, s := range strings {
@@ -89,10 +89,12 @@ type iidClient struct {
 	httpClient  *internal.HTTPClient
 }
 
-func newIIDClient(hc *http.Client) *iidClient {
+func newIIDClient(hc *http.Client, conf *internal.MessagingConfig) *iidClient {
 	client := internal.WithDefaultRetryConfig(hc)
 	client.CreateErrFn = handleIIDError
 	client.SuccessFn = internal.HasSuccessStatus
+	client.Logger = conf.Logger
+	client.RetryObserver = conf.RetryObserver
 	client.Opts = []internal.HTTPOption{internal.WithHeader("access_token_auth", "true")}
 	return &iidClient{
 		iidEndpoint: iidEndpoint,
@@ -102,7 +104,9 @@ func newIIDClient(hc *http.Client) *iidClient {
 
 // SubscribeToTopic subscribes a list of registration tokens to a topic.
 //
-// The tokens list must not be empty, and have at most 1000 tokens.
+// The tokens list must not be empty, and have at most 1000 tokens. The topic name may optionally be
+// prefixed with "/topics/" (and the legacy "private/" segment), and must otherwise only contain
+// characters from [a-zA-Z0-9-_.~%].
 func (c *iidClient) SubscribeToTopic(ctx context.Context, tokens []string, topic string) (*TopicManagementResponse, error) {
 	req := &iidRequest{
 		Topic:  topic,
@@ -114,7 +118,9 @@ func (c *iidClient) SubscribeToTopic(ctx context.Context, tokens []string, topic
 
 // UnsubscribeFromTopic unsubscribes a list of registration tokens from a topic.
 //
-// The tokens list must not be empty, and have at most 1000 tokens.
+// The tokens list must not be empty, and have at most 1000 tokens. The topic name may optionally be
+// prefixed with "/topics/" (and the legacy "private/" segment), and must otherwise only contain
+// characters from [a-zA-Z0-9-_.~%].
 func (c *iidClient) UnsubscribeFromTopic(ctx context.Context, tokens []string, topic string) (*TopicManagementResponse, error) {
 	req := &iidRequest{
 		Topic:  topic,
@@ -154,8 +160,10 @@ func (c *iidClient) makeTopicManagementRequest(ctx context.Context, req *iidRequ
 	if req.Topic == "" {
 		return nil, fmt.Errorf("topic name not specified")
 	}
-	if !topicNamePattern.MatchString(req.Topic) {
-		return nil, fmt.Errorf("invalid topic name: %q", req.Topic)
+	// Topic management also accepts the legacy "private/" segment after the optional "/topics/" prefix.
+	name := strings.TrimPrefix(strings.TrimPrefix(req.Topic, "/topics/"), "private/")
+	if err := validateTopicName(req.Topic, name); err != nil {
+		return nil, err
 	}
 
 	if !strings.HasPrefix(req.Topic, "/topics/") {
@@ -177,7 +185,9 @@ func (c *iidClient) makeTopicManagementRequest(ctx context.Context, req *iidRequ
 
 func handleIIDError(resp *internal.Response) error {
 	var ie iidError
-	json.Unmarshal(resp.Body, &ie) // ignore any json parse errors at this level
+	if err := json.Unmarshal(resp.Body, &ie); err != nil {
+		return handleNonJSONError(resp)
+	}
 	var clientCode, msg string
 	info, ok := iidErrorCodes[ie.Error]
 	if ok {
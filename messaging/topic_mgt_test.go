@@ -51,6 +51,36 @@ func TestSubscribe(t *testing.T) {
 	checkTopicMgtResponse(t, resp)
 }
 
+func TestSubscribeLegacyPrivateTopic(t *testing.T) {
+	var b []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ = ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{\"results\": [{}]}"))
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	client, err := NewClient(ctx, testMessagingConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.iidEndpoint = ts.URL
+
+	for _, topic := range []string{"private/test-topic", "/topics/private/test-topic"} {
+		if _, err := client.SubscribeToTopic(ctx, []string{"id1"}, topic); err != nil {
+			t.Fatalf("SubscribeToTopic(%q) = %v; want = nil", topic, err)
+		}
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		if parsed["to"] != "/topics/private/test-topic" {
+			t.Errorf("SubscribeToTopic(%q).to = %v; want = %q", topic, parsed["to"], "/topics/private/test-topic")
+		}
+	}
+}
+
 func TestInvalidSubscribe(t *testing.T) {
 	ctx := context.Background()
 	client, err := NewClient(ctx, testMessagingConfig)
@@ -149,8 +179,8 @@ func TestTopicManagementError(t *testing.T) {
 		},
 		{
 			resp:  "not json",
-			want:  "http error status: 500; reason: client encountered an unknown error; response: not json",
-			check: IsUnknown,
+			want:  "http error status: 500; reason: server responded with a non-JSON error; code: internal-error; response: not json",
+			check: IsInternal,
 		},
 	}
 	for _, tc := range cases {
@@ -232,7 +262,25 @@ var invalidTopicMgtArgs = []struct {
 		name:   "InvalidTopicName",
 		tokens: []string{"token1"},
 		topic:  "foo*bar",
-		want:   "invalid topic name: \"foo*bar\"",
+		want:   `invalid topic name "foo*bar": topic names must only contain ASCII letters, digits and the characters "-_.~%"`,
+	},
+	{
+		name:   "EmptyTopicName",
+		tokens: []string{"token1"},
+		topic:  "/topics/",
+		want:   `invalid topic name "/topics/": topic name must not be empty`,
+	},
+	{
+		name:   "EmptyPrivateTopicName",
+		tokens: []string{"token1"},
+		topic:  "/topics/private/",
+		want:   `invalid topic name "/topics/private/": topic name must not be empty`,
+	},
+	{
+		name:   "NonASCIITopicName",
+		tokens: []string{"token1"},
+		topic:  "/topics/caf\u00e9",
+		want:   `invalid topic name "/topics/café": topic names must only contain ASCII letters, digits and the characters "-_.~%"`,
 	},
 	{
 		name:   "TooManyTokens",